@@ -37,7 +37,7 @@ func main() {
 		panic(fmt.Sprintf("Failed to init storage: %v", err))
 	}
 
-	deps, err := InitializeAPIComponents(db, cfg.Auth.JWTSecret, &cfg.LLM, store)
+	deps, err := InitializeAPIComponents(db, cfg.Auth.JWTSecret, &cfg.LLM, &cfg.Memory, &cfg.GC, &cfg.Points, &cfg.Payment, &cfg.Subscription, store)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to initialize components: %v", err))
 	}
@@ -64,14 +64,19 @@ func main() {
 			protected.GET("/books/:id/content-zip", deps.BookHandler.DownloadContentZip)
 			protected.GET("/books/:id/content-db", deps.BookHandler.DownloadContentDBZip)
 			protected.GET("/books/:id/progress", deps.BookHandler.GetProgress)
+			protected.POST("/books/:id/progress", deps.BookHandler.UpdateReadingProgress)
 			protected.DELETE("/books/:id", deps.BookHandler.DeleteBook)
 			protected.POST("/books/sync-local", deps.BookHandler.SyncLocalBook)
 			protected.POST("/books/upload-zip", deps.BookHandler.SyncLocalBookZip)
 			protected.POST("/chapters/content", deps.BookHandler.GetChaptersContent)
 			protected.POST("/chapters/trim", deps.BookHandler.GetChaptersTrimmed)
 			protected.POST("/contents/trim", deps.BookHandler.GetContentsTrimmed)
+			protected.POST("/trim/estimate", deps.TrimHandler.Estimate)
 			protected.GET("/trim/stream/by-md5", deps.TrimHandler.TrimStreamByMD5)
 			protected.GET("/trim/stream/by-id", deps.TrimHandler.TrimStreamByChapterID)
+			protected.GET("/trim/stream/sse/by-id", deps.TrimHandler.TrimSSEByChapterID)
+			protected.POST("/trim/stream/sse/by-md5", deps.TrimHandler.TrimSSEByMD5)
+			protected.GET("/tasks/full-trim/quote", deps.TaskHandler.QuoteFullTrim)
 			protected.POST("/tasks/full-trim", deps.TaskHandler.SubmitFullTrimTask)
 			protected.GET("/tasks/progress", deps.TaskHandler.GetTasksProgress)
 			protected.GET("/tasks/active", deps.TaskHandler.GetActiveTasks)
@@ -80,16 +85,56 @@ func main() {
 			protected.GET("/chapters/trim-status", deps.ChapterTrimHandler.GetChapterTrimStatus)
 			protected.GET("/users/me/points", deps.PointsHandler.GetBalance)
 			protected.GET("/users/me/points/ledger", deps.PointsHandler.GetLedger)
+			protected.POST("/users/me/points/check-in", deps.PointsHandler.CheckIn)
+			protected.POST("/users/me/points/redeem", deps.RedeemHandler.Redeem)
+			protected.GET("/users/me/invite", deps.PointsHandler.GetInvite)
+			protected.GET("/subscription/plans", deps.SubscriptionHandler.ListPlans)
+			protected.GET("/users/me/subscription", deps.SubscriptionHandler.GetUsage)
+			protected.GET("/recharge/packages", deps.RechargeHandler.ListPackages)
+			protected.POST("/recharge/orders", deps.RechargeHandler.CreateOrder)
+			protected.GET("/recharge/orders", deps.RechargeHandler.ListOrders)
+			protected.GET("/recharge/orders/:order_no", deps.RechargeHandler.GetOrder)
+			if cfg.Payment.Fake.Enabled && cfg.Payment.Fake.Secret != "" {
+				protected.POST("/payments/fake/pay", deps.RechargeHandler.FakePay)
+			}
+			protected.GET("/users/me/reading-history", deps.BookHandler.ListReadingHistory)
+			protected.GET("/annotations", deps.AnnotationHandler.List)
+			protected.POST("/annotations", deps.AnnotationHandler.Create)
+			protected.PUT("/annotations/:id", deps.AnnotationHandler.Update)
+			protected.DELETE("/annotations/:id", deps.AnnotationHandler.Delete)
+			protected.GET("/books/:id/annotations/export", deps.AnnotationHandler.ExportMarkdown)
+			protected.POST("/reading-sessions", deps.ReadingHandler.StartSession)
+			protected.POST("/reading-sessions/:id/stop", deps.ReadingHandler.StopSession)
+			protected.GET("/users/me/reading-stats", deps.ReadingHandler.GetStats)
+			protected.GET("/books/:id/encyclopedia", deps.EncyclopediaHandler.Get)
+			protected.GET("/books/:id/recap", deps.SummaryHandler.Recap)
 			protected.POST("/chapters/status", deps.ContentHandler.GetChapterTrimStatus)
 			protected.POST("/contents/status", deps.ContentHandler.GetContentTrimStatus)
 		}
 
+		admin := api.Group("/admin")
+		admin.Use(middleware.Auth(deps.AuthService), middleware.Admin(cfg.Auth.AdminUserIDs))
+		{
+			admin.POST("/redeem/campaigns", deps.RedeemHandler.CreateCampaign)
+			admin.GET("/redeem/campaigns", deps.RedeemHandler.ListCampaigns)
+			admin.GET("/redeem/campaigns/:id", deps.RedeemHandler.GetCampaign)
+			admin.GET("/redeem/campaigns/:id/codes", deps.RedeemHandler.ListCodes)
+			admin.PUT("/redeem/campaigns/:id/status", deps.RedeemHandler.SetCampaignStatus)
+			admin.POST("/recharge/orders/:order_no/refund", deps.RechargeHandler.Refund)
+			admin.POST("/subscriptions", deps.SubscriptionHandler.Grant)
+			admin.GET("/users/:id/subscription", deps.SubscriptionHandler.GetUserUsage)
+			admin.GET("/system/llm-stats", deps.SystemHandler.LlmStats)
+			admin.GET("/system/gc-stats", deps.SystemHandler.GCStats)
+		}
+
+		api.POST("/payments/callback/:provider", deps.RechargeHandler.Callback)
 		api.GET("/common/prompts", deps.BookHandler.ListPrompts)
 		api.GET("/common/parser-rules", commonHandler.GetParserRules)
 		api.GET("/common/ping", commonHandler.Ping)
 	}
 
 	deps.TaskService.Start()
+	deps.GCService.Start()
 
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -112,6 +157,9 @@ func main() {
 	defer cancel()
 
 	deps.TaskService.Stop()
+	deps.SummaryService.Stop()
+	deps.EncyclopediaService.Stop()
+	deps.GCService.Stop()
 
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Error().Msg(fmt.Sprintf("Server forced to shutdown: %v", err))
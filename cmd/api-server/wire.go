@@ -14,15 +14,26 @@ import (
 )
 
 type APIComponents struct {
-	AuthHandler        *handler.AuthHandler
-	BookHandler        *handler.BookHandler
-	TrimHandler        *handler.TrimHandler
-	TaskHandler        *handler.TaskHandler
-	ChapterTrimHandler *handler.ChapterTrimHandler
-	ContentHandler     *handler.ContentHandler
-	PointsHandler      *handler.PointsHandler
-	AuthService        service.AuthServiceInterface
-	TaskService        service.TaskServiceInterface
+	AuthHandler         *handler.AuthHandler
+	BookHandler         *handler.BookHandler
+	TrimHandler         *handler.TrimHandler
+	TaskHandler         *handler.TaskHandler
+	ChapterTrimHandler  *handler.ChapterTrimHandler
+	ContentHandler      *handler.ContentHandler
+	PointsHandler       *handler.PointsHandler
+	AnnotationHandler   *handler.AnnotationHandler
+	ReadingHandler      *handler.ReadingStatsHandler
+	EncyclopediaHandler *handler.EncyclopediaHandler
+	SummaryHandler      *handler.SummaryHandler
+	SystemHandler       *handler.SystemHandler
+	RedeemHandler       *handler.RedeemHandler
+	RechargeHandler     *handler.RechargeHandler
+	SubscriptionHandler *handler.SubscriptionHandler
+	AuthService         service.AuthServiceInterface
+	TaskService         service.TaskServiceInterface
+	SummaryService      service.SummaryServiceInterface
+	EncyclopediaService service.EncyclopediaServiceInterface
+	GCService           service.GCServiceInterface
 }
 
 func NewAPIComponents(
@@ -33,19 +44,41 @@ func NewAPIComponents(
 	chapterTrimHandler *handler.ChapterTrimHandler,
 	contentHandler *handler.ContentHandler,
 	pointsHandler *handler.PointsHandler,
+	annotationHandler *handler.AnnotationHandler,
+	readingHandler *handler.ReadingStatsHandler,
+	encyclopediaHandler *handler.EncyclopediaHandler,
+	summaryHandler *handler.SummaryHandler,
+	systemHandler *handler.SystemHandler,
+	redeemHandler *handler.RedeemHandler,
+	rechargeHandler *handler.RechargeHandler,
+	subscriptionHandler *handler.SubscriptionHandler,
 	authService service.AuthServiceInterface,
 	taskService service.TaskServiceInterface,
+	summaryService service.SummaryServiceInterface,
+	encyclopediaService service.EncyclopediaServiceInterface,
+	gcService service.GCServiceInterface,
 ) *APIComponents {
 	return &APIComponents{
-		AuthHandler:        authHandler,
-		BookHandler:        bookHandler,
-		TrimHandler:        trimHandler,
-		TaskHandler:        taskHandler,
-		ChapterTrimHandler: chapterTrimHandler,
-		ContentHandler:     contentHandler,
-		PointsHandler:      pointsHandler,
-		AuthService:        authService,
-		TaskService:        taskService,
+		AuthHandler:         authHandler,
+		BookHandler:         bookHandler,
+		TrimHandler:         trimHandler,
+		TaskHandler:         taskHandler,
+		ChapterTrimHandler:  chapterTrimHandler,
+		ContentHandler:      contentHandler,
+		PointsHandler:       pointsHandler,
+		AnnotationHandler:   annotationHandler,
+		ReadingHandler:      readingHandler,
+		EncyclopediaHandler: encyclopediaHandler,
+		SummaryHandler:      summaryHandler,
+		SystemHandler:       systemHandler,
+		RedeemHandler:       redeemHandler,
+		RechargeHandler:     rechargeHandler,
+		SubscriptionHandler: subscriptionHandler,
+		AuthService:         authService,
+		TaskService:         taskService,
+		SummaryService:      summaryService,
+		EncyclopediaService: encyclopediaService,
+		GCService:           gcService,
 	}
 }
 
@@ -56,11 +89,12 @@ func provideTaskService(
 	bookRepo repository.BookRepositoryInterface,
 	trimService service.TrimServiceInterface,
 	pointsService service.PointsServiceInterface,
+	subscriptions service.SubscriptionServiceInterface,
 ) *service.TaskService {
-	return service.NewTaskService(repo, taskItemRepo, bookRepo, trimService, pointsService, 4)
+	return service.NewTaskService(repo, taskItemRepo, bookRepo, trimService, pointsService, subscriptions, 4)
 }
 
-func InitializeAPIComponents(db *gorm.DB, jwtSecret string, llm *config.LLM, store storage.Storage) (*APIComponents, error) {
+func InitializeAPIComponents(db *gorm.DB, jwtSecret string, llm *config.LLM, memory *config.MemoryConfig, gc *config.GCConfig, points *config.PointsConfig, payment *config.PaymentConfig, subscription *config.SubscriptionConfig, store storage.Storage) (*APIComponents, error) {
 	wire.Build(
 		// Repositories
 		repository.NewAuthRepository,
@@ -73,12 +107,36 @@ func InitializeAPIComponents(db *gorm.DB, jwtSecret string, llm *config.LLM, sto
 		wire.Bind(new(repository.TaskItemRepositoryInterface), new(*repository.TaskItemRepository)),
 		repository.NewPointsRepository,
 		wire.Bind(new(repository.PointsRepositoryInterface), new(*repository.PointsRepository)),
+		repository.NewReferralRepository,
+		wire.Bind(new(repository.ReferralRepositoryInterface), new(*repository.ReferralRepository)),
+		repository.NewRedeemRepository,
+		wire.Bind(new(repository.RedeemRepositoryInterface), new(*repository.RedeemRepository)),
+		repository.NewRechargeRepository,
+		wire.Bind(new(repository.RechargeRepositoryInterface), new(*repository.RechargeRepository)),
+		repository.NewSubscriptionRepository,
+		wire.Bind(new(repository.SubscriptionRepositoryInterface), new(*repository.SubscriptionRepository)),
 		repository.NewContentRepository,
 		wire.Bind(new(repository.ContentRepositoryInterface), new(*repository.ContentRepository)),
+		repository.NewAnnotationRepository,
+		wire.Bind(new(repository.AnnotationRepositoryInterface), new(*repository.AnnotationRepository)),
+		repository.NewReadingStatsRepository,
+		wire.Bind(new(repository.ReadingStatsRepositoryInterface), new(*repository.ReadingStatsRepository)),
+		repository.NewMemoryRepository,
+		wire.Bind(new(repository.MemoryRepositoryInterface), new(*repository.MemoryRepository)),
+		repository.NewGCRepository,
+		wire.Bind(new(repository.GCRepositoryInterface), new(*repository.GCRepository)),
 
 		// Services
 		service.NewPointsService,
 		wire.Bind(new(service.PointsServiceInterface), new(*service.PointsService)),
+		service.NewEarningService,
+		wire.Bind(new(service.EarningServiceInterface), new(*service.EarningService)),
+		service.NewRedeemService,
+		wire.Bind(new(service.RedeemServiceInterface), new(*service.RedeemService)),
+		service.NewRechargeService,
+		wire.Bind(new(service.RechargeServiceInterface), new(*service.RechargeService)),
+		service.NewSubscriptionService,
+		wire.Bind(new(service.SubscriptionServiceInterface), new(*service.SubscriptionService)),
 		service.NewAuthService,
 		wire.Bind(new(service.AuthServiceInterface), new(*service.AuthService)),
 		service.NewBookService,
@@ -91,6 +149,16 @@ func InitializeAPIComponents(db *gorm.DB, jwtSecret string, llm *config.LLM, sto
 		wire.Bind(new(service.LlmServiceInterface), new(*service.LlmService)),
 		service.NewContentService,
 		wire.Bind(new(service.ContentServiceInterface), new(*service.ContentService)),
+		service.NewAnnotationService,
+		wire.Bind(new(service.AnnotationServiceInterface), new(*service.AnnotationService)),
+		service.NewEncyclopediaService,
+		wire.Bind(new(service.EncyclopediaServiceInterface), new(*service.EncyclopediaService)),
+		service.NewSummaryService,
+		wire.Bind(new(service.SummaryServiceInterface), new(*service.SummaryService)),
+		service.NewReadingStatsService,
+		wire.Bind(new(service.ReadingStatsServiceInterface), new(*service.ReadingStatsService)),
+		service.NewGCService,
+		wire.Bind(new(service.GCServiceInterface), new(*service.GCService)),
 
 		// Handlers
 		handler.NewAuthHandler,
@@ -100,6 +168,14 @@ func InitializeAPIComponents(db *gorm.DB, jwtSecret string, llm *config.LLM, sto
 		handler.NewChapterTrimHandler,
 		handler.NewContentHandler,
 		handler.NewPointsHandler,
+		handler.NewAnnotationHandler,
+		handler.NewReadingStatsHandler,
+		handler.NewEncyclopediaHandler,
+		handler.NewSummaryHandler,
+		handler.NewSystemHandler,
+		handler.NewRedeemHandler,
+		handler.NewRechargeHandler,
+		handler.NewSubscriptionHandler,
 
 		// Components
 		NewAPIComponents,
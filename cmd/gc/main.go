@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/internal/service"
+	"github.com/zqr233qr/story-trim/internal/storage"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+)
+
+// 手动执行一次孤立章节内容与精简结果回收。
+func main() {
+	configPath := flag.String("config", "config.yaml", "配置文件路径")
+	dryRun := flag.Bool("dry-run", false, "只统计不删除")
+	graceHours := flag.Int("grace-hours", -1, "宽限期（小时），默认取配置")
+	batchSize := flag.Int("batch", 0, "每类最多回收数量，默认取配置")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load config: %v", err))
+	}
+
+	logger.Init(cfg.Log)
+
+	db, err := repository.NewDB(cfg.Database)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to init database: %v", err))
+	}
+
+	store, err := storage.NewStorage(cfg.Storage)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to init storage: %v", err))
+	}
+
+	gcService := service.NewGCService(repository.NewGCRepository(db, store), &cfg.GC)
+	opts := gcService.DefaultOptions()
+	opts.DryRun = opts.DryRun || *dryRun
+	if *graceHours >= 0 {
+		opts.GracePeriod = time.Duration(*graceHours) * time.Hour
+	}
+	if *batchSize > 0 {
+		opts.BatchSize = *batchSize
+	}
+
+	report, err := gcService.Run(context.Background(), opts)
+	if err != nil {
+		logger.Error().Err(err).Msg("孤立内容回收失败")
+		os.Exit(1)
+	}
+
+	data, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(data))
+}
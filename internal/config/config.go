@@ -8,14 +8,18 @@ import (
 )
 
 type Config struct {
-	FileStorage FileStorageConfig   `mapstructure:"file_storage"`
-	Storage     StorageConfig       `mapstructure:"storage"`
-	LLM         LLM                 `mapstructure:"llm"`
-	Database    DatabaseConfig      `mapstructure:"database"`
-	Memory      MemoryConfig        `mapstructure:"memory"`
-	Log         pkgconfig.LogConfig `mapstructure:"log"`
-	Auth        AuthConfig          `mapstructure:"auth"`
-	Parser      ParserConfig        `mapstructure:"parser"`
+	FileStorage  FileStorageConfig   `mapstructure:"file_storage"`
+	Storage      StorageConfig       `mapstructure:"storage"`
+	LLM          LLM                 `mapstructure:"llm"`
+	Database     DatabaseConfig      `mapstructure:"database"`
+	Memory       MemoryConfig        `mapstructure:"memory"`
+	Log          pkgconfig.LogConfig `mapstructure:"log"`
+	Auth         AuthConfig          `mapstructure:"auth"`
+	Parser       ParserConfig        `mapstructure:"parser"`
+	GC           GCConfig            `mapstructure:"gc"`
+	Points       PointsConfig        `mapstructure:"points"`
+	Payment      PaymentConfig       `mapstructure:"payment"`
+	Subscription SubscriptionConfig  `mapstructure:"subscription"`
 }
 
 type ParserConfig struct {
@@ -52,13 +56,110 @@ type FileStorageConfig struct {
 }
 
 type AuthConfig struct {
-	JWTSecret string `mapstructure:"jwt_secret"`
+	JWTSecret    string `mapstructure:"jwt_secret"`
+	AdminUserIDs []uint `mapstructure:"admin_user_ids"` // 可访问管理接口的用户
 }
 
 type MemoryConfig struct {
-	SummaryLimit         int `mapstructure:"summary_limit"`
-	EncyclopediaInterval int `mapstructure:"encyclopedia_interval"`
-	MockStreamSpeed      int `mapstructure:"mock_stream_speed"`
+	Enabled              bool `mapstructure:"enabled"` // 开启精简+摘要模式，精简时注入前情提要
+	SummaryLimit         int  `mapstructure:"summary_limit"`
+	EncyclopediaInterval int  `mapstructure:"encyclopedia_interval"`
+	MockStreamSpeed      int  `mapstructure:"mock_stream_speed"`
+}
+
+// GCConfig 定义孤立内容回收任务配置。
+type GCConfig struct {
+	Enabled         bool `mapstructure:"enabled"`
+	IntervalMinutes int  `mapstructure:"interval_minutes"` // 执行间隔（分钟）
+	GraceHours      int  `mapstructure:"grace_hours"`      // 孤立后保留的宽限期（小时）
+	BatchSize       int  `mapstructure:"batch_size"`       // 单轮每类最多回收数量
+	DryRun          bool `mapstructure:"dry_run"`
+}
+
+// PointsConfig 定义积分相关配置。
+type PointsConfig struct {
+	Pricing PricingConfig `mapstructure:"pricing"`
+	Earning EarningConfig `mapstructure:"earning"`
+}
+
+// EarningConfig 积分获取规则。未配置的规则不发放积分，Register 未配置时沿用注册赠送 100 积分。
+type EarningConfig struct {
+	DailyCap int       `mapstructure:"daily_cap"` // 每人每天通过签到、阅读、邀请获得的积分合计上限，0 表示不限
+	Register *EarnRule `mapstructure:"register"`  // 注册赠送
+	CheckIn  *EarnRule `mapstructure:"check_in"`  // 每日签到，支持连续签到加成
+	Reading  *EarnRule `mapstructure:"reading"`   // 当日累计阅读时长达到里程碑时奖励
+	Referrer *EarnRule `mapstructure:"referrer"`  // 邀请人奖励
+	Invitee  *EarnRule `mapstructure:"invitee"`   // 被邀请人奖励
+}
+
+// EarnRule 单条积分获取规则。
+type EarnRule struct {
+	Enabled     bool            `mapstructure:"enabled"`
+	Reason      string          `mapstructure:"reason"`       // 流水原因，为空时使用默认值
+	Points      int             `mapstructure:"points"`       // 每次奖励积分
+	StreakBonus int             `mapstructure:"streak_bonus"` // 签到：连续签到每多一天额外奖励
+	StreakCap   int             `mapstructure:"streak_cap"`   // 签到：额外奖励最多累计的天数，0 表示不限
+	Milestones  []EarnMilestone `mapstructure:"milestones"`   // 阅读：当日累计阅读分钟数达到时奖励
+	DailyCap    int             `mapstructure:"daily_cap"`    // 每人每天该规则最多获得积分，0 表示不限
+	DailyTimes  int             `mapstructure:"daily_times"`  // 每人每天该规则最多触发次数，0 表示不限
+	TotalTimes  int             `mapstructure:"total_times"`  // 每人该规则累计最多触发次数，0 表示不限
+}
+
+// EarnMilestone 阅读时长里程碑。
+type EarnMilestone struct {
+	Minutes int `mapstructure:"minutes"`
+	Points  int `mapstructure:"points"`
+}
+
+// PaymentConfig 充值支付配置。
+type PaymentConfig struct {
+	DefaultProvider    string            `mapstructure:"default_provider"`     // 下单未指定支付方式时使用
+	OrderExpireMinutes int               `mapstructure:"order_expire_minutes"` // 待支付订单有效期（分钟），默认 30
+	Packages           []RechargePackage `mapstructure:"packages"`             // 可购买的充值套餐
+	Fake               FakePaymentConfig `mapstructure:"fake"`
+}
+
+// RechargePackage 充值套餐。
+type RechargePackage struct {
+	ID     string `mapstructure:"id"`
+	Name   string `mapstructure:"name"`
+	Amount int    `mapstructure:"amount"` // 价格（分）
+	Points int    `mapstructure:"points"` // 到账积分，含赠送
+}
+
+// FakePaymentConfig 本地模拟支付渠道，仅用于开发与测试，生产环境不要开启。
+type FakePaymentConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Secret  string `mapstructure:"secret"` // 回调签名密钥，为空时不启用模拟支付
+}
+
+// SubscriptionConfig 订阅套餐配置。
+type SubscriptionConfig struct {
+	Plans []SubscriptionPlan `mapstructure:"plans"`
+}
+
+// SubscriptionPlan 订阅套餐：每个计费周期（一个月）可精简 MonthlyChapters 章，
+// 额度用完或使用 PromptIDs 之外的提示词时按积分计费。
+type SubscriptionPlan struct {
+	ID              string `mapstructure:"id"`
+	Name            string `mapstructure:"name"`
+	MonthlyChapters int    `mapstructure:"monthly_chapters"` // 每期可精简章节数，0 表示不限
+	PromptIDs       []uint `mapstructure:"prompt_ids"`       // 适用的提示词，为空表示全部
+}
+
+// PricingConfig 精简计价规则。按顺序匹配 Rules，未命中时使用 Default；均未配置时每章 1 积分。
+type PricingConfig struct {
+	Default PriceRule   `mapstructure:"default"`
+	Rules   []PriceRule `mapstructure:"rules"`
+}
+
+// PriceRule 单条计价规则：积分 = PerChapter + 字数/1000*Per1kChars，向上取整后限制在 [Min, Max]。
+type PriceRule struct {
+	PromptIDs  []uint  `mapstructure:"prompt_ids"`   // 适用的提示词，Default 中忽略
+	PerChapter int     `mapstructure:"per_chapter"`  // 每章基础积分
+	Per1kChars float64 `mapstructure:"per_1k_chars"` // 每千字积分
+	Min        int     `mapstructure:"min"`          // 单章最少积分
+	Max        int     `mapstructure:"max"`          // 单章最多积分，0 表示不封顶
 }
 
 type DatabaseConfig struct {
@@ -83,16 +184,43 @@ type MySQLConfig struct {
 }
 
 type LLM struct {
-	Use       string               `mapstructure:"use"`
-	LLMConfig map[string]LLMConfig `mapstructure:"llm_config"`
+	Use       string          `mapstructure:"use"`
+	Fallbacks []string        `mapstructure:"fallbacks"` // Use 不可用时按顺序尝试的配置键名
+	Routes    []LLMRoute      `mapstructure:"routes"`    // 按提示词与输入长度选择调用链，按顺序匹配
+	Health    LLMHealthConfig `mapstructure:"health"`
+
+	MaxConcurrency     int                  `mapstructure:"max_concurrency"`     // 所有供应商共享的并发上限，默认 16
+	InteractiveReserve int                  `mapstructure:"interactive_reserve"` // 为流式等交互调用保留的并发数，后台任务不能占用
+	LLMConfig          map[string]LLMConfig `mapstructure:"llm_config"`
+}
+
+// LLMRoute 命中条件时使用 Chain 代替默认的 Use + Fallbacks。
+type LLMRoute struct {
+	PromptIDs []uint   `mapstructure:"prompt_ids"` // 匹配的提示词 ID，为空表示不限
+	MinChars  int      `mapstructure:"min_chars"`  // 输入字数下限，0 表示不限
+	Chain     []string `mapstructure:"chain"`      // 依次尝试的配置键名
+}
+
+// LLMHealthConfig 供应商健康检查，连续失败达到阈值后暂时剔除。
+type LLMHealthConfig struct {
+	FailureThreshold int `mapstructure:"failure_threshold"` // 连续失败次数阈值，默认 3
+	EjectSeconds     int `mapstructure:"eject_seconds"`     // 剔除时长（秒），默认 60
 }
 
 type LLMConfig struct {
-	BaseURL     string  `mapstructure:"base_url"`
-	APIKey      string  `mapstructure:"api_key"`
-	Model       string  `mapstructure:"model"`
-	InputPrice  float64 `mapstructure:"input_price"`  // 输入价格(百万token)元
-	OutputPrice float64 `mapstructure:"output_price"` // 输出价格(百万token)元
+	BaseURL       string  `mapstructure:"base_url"`
+	APIKey        string  `mapstructure:"api_key"`
+	Model         string  `mapstructure:"model"`
+	InputPrice    float64 `mapstructure:"input_price"`     // 输入价格(百万token)元
+	OutputPrice   float64 `mapstructure:"output_price"`    // 输出价格(百万token)元
+	MaxInputChars int     `mapstructure:"max_input_chars"` // 可处理的最大输入字数，0 表示不限
+	Timeout       int     `mapstructure:"timeout"`         // 非流式单次调用超时（秒），默认 120
+	StreamTimeout int     `mapstructure:"stream_timeout"`  // 流式调用总超时（秒），默认 600
+	MaxRetries    int     `mapstructure:"max_retries"`     // 可重试错误的最大重试次数，默认 2，-1 表示不重试
+	RetryBaseMs   int     `mapstructure:"retry_base_ms"`   // 退避基准间隔（毫秒），默认 500
+	RetryMaxMs    int     `mapstructure:"retry_max_ms"`    // 单次等待上限（毫秒），默认 10000，Retry-After 超出时直接切换供应商
+	RPM           int     `mapstructure:"rpm"`             // 每分钟请求数上限，0 表示不限
+	TPM           int     `mapstructure:"tpm"`             // 每分钟 token 数上限，0 表示不限
 }
 
 func Load(path string) (*Config, error) {
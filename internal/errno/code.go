@@ -16,6 +16,7 @@ var (
 	AuthErrCodeToken    = 1003
 	AuthErrCodeExpired  = 1004
 	AuthErrCodeNoLogin  = 1005
+	AuthErrCodeNoAdmin  = 1006
 
 	BookErrCode         = 2000
 	BookErrCodeNotFound = 2001
@@ -30,14 +31,53 @@ var (
 	TrimErrCodeInvalid    = 4002
 	TrimErrCodeGenerating = 4003
 	TrimErrCodeDuplicate  = 4004
+	TrimErrCodeIncomplete = 4005
+	TrimErrCodeTruncated  = 4006
+	TrimErrCodeFiltered   = 4007
 
 	TaskErrCode         = 5000
 	TaskErrCodeNotFound = 5001
 	TaskErrCodeRunning  = 5002
 	TaskErrCodeFailed   = 5003
+	TaskErrCodeQuote    = 5004
 
 	PointsErrCode          = 6000
 	PointsErrCodeNotEnough = 6001
+	PointsErrCodeInvite    = 6002
+	PointsErrCodeDisabled  = 6003
+
+	RedeemErrCode          = 6100
+	RedeemErrCodeInvalid   = 6101
+	RedeemErrCodeExpired   = 6102
+	RedeemErrCodeExhausted = 6103
+	RedeemErrCodeLimited   = 6104
+	RedeemErrCodeExist     = 6105
+
+	RechargeErrCode          = 6200
+	RechargeErrCodeNotFound  = 6201
+	RechargeErrCodePackage   = 6202
+	RechargeErrCodeProvider  = 6203
+	RechargeErrCodeSignature = 6204
+	RechargeErrCodeAmount    = 6205
+	RechargeErrCodeStatus    = 6206
+
+	SubscriptionErrCode         = 6300
+	SubscriptionErrCodePlan     = 6301
+	SubscriptionErrCodeConflict = 6302
+	SubscriptionErrCodeNotFound = 6303
+
+	AnnotationErrCode         = 7000
+	AnnotationErrCodeNotFound = 7001
+	AnnotationErrCodeInvalid  = 7002
+
+	ReadingErrCode                = 8000
+	ReadingErrCodeSessionNotFound = 8001
+	ReadingErrCodeSessionClosed   = 8002
+
+	MemoryErrCode                     = 9000
+	MemoryErrCodeEncyclopediaNotFound = 9001
+	MemoryErrCodeNoProgress           = 9002
+	MemoryErrCodeRecapBusy            = 9003
 )
 
 var (
@@ -50,6 +90,7 @@ var (
 	ErrAuthToken    = &Code{Code: AuthErrCodeToken, Message: "无效的 Token"}
 	ErrAuthExpired  = &Code{Code: AuthErrCodeExpired, Message: "Token 已过期"}
 	ErrAuthNoLogin  = &Code{Code: AuthErrCodeNoLogin, Message: "未登录"}
+	ErrAuthNoAdmin  = &Code{Code: AuthErrCodeNoAdmin, Message: "无管理权限"}
 
 	ErrBookNotFound = &Code{Code: BookErrCodeNotFound, Message: "书籍不存在"}
 	ErrBookExist    = &Code{Code: BookErrCodeExist, Message: "书籍已存在"}
@@ -61,12 +102,46 @@ var (
 	ErrTrimInvalid    = &Code{Code: TrimErrCodeInvalid, Message: "无效的精简参数"}
 	ErrTrimGenerating = &Code{Code: TrimErrCodeGenerating, Message: "精简进行中"}
 	ErrTrimDuplicate  = &Code{Code: TrimErrCodeDuplicate, Message: "章节已精简或处理中"}
+	ErrTrimIncomplete = &Code{Code: TrimErrCodeIncomplete, Message: "精简生成中断，积分已退回"}
+	ErrTrimTruncated  = &Code{Code: TrimErrCodeTruncated, Message: "精简结果超出长度限制，积分已退回"}
+	ErrTrimFiltered   = &Code{Code: TrimErrCodeFiltered, Message: "内容被模型安全策略拦截，积分已退回"}
 
 	ErrTaskNotFound = &Code{Code: TaskErrCodeNotFound, Message: "任务不存在"}
 	ErrTaskRunning  = &Code{Code: TaskErrCodeRunning, Message: "任务进行中"}
 	ErrTaskFailed   = &Code{Code: TaskErrCodeFailed, Message: "任务失败"}
 
-	ErrPointsNotEnough = &Code{Code: PointsErrCodeNotEnough, Message: "积分不足"}
+	ErrTaskQuoteChanged = &Code{Code: TaskErrCodeQuote, Message: "报价已变化，请重新确认"}
+
+	ErrPointsNotEnough     = &Code{Code: PointsErrCodeNotEnough, Message: "积分不足"}
+	ErrPointsInviteInvalid = &Code{Code: PointsErrCodeInvite, Message: "邀请码无效"}
+	ErrPointsRuleDisabled  = &Code{Code: PointsErrCodeDisabled, Message: "该积分活动未开启"}
+
+	ErrRedeemInvalid   = &Code{Code: RedeemErrCodeInvalid, Message: "兑换码无效"}
+	ErrRedeemExpired   = &Code{Code: RedeemErrCodeExpired, Message: "兑换码不在有效期内"}
+	ErrRedeemExhausted = &Code{Code: RedeemErrCodeExhausted, Message: "兑换码已被领完"}
+	ErrRedeemLimited   = &Code{Code: RedeemErrCodeLimited, Message: "已达到该活动的兑换次数上限"}
+	ErrRedeemExist     = &Code{Code: RedeemErrCodeExist, Message: "兑换码已存在"}
+
+	ErrRechargeNotFound  = &Code{Code: RechargeErrCodeNotFound, Message: "充值订单不存在"}
+	ErrRechargePackage   = &Code{Code: RechargeErrCodePackage, Message: "充值套餐不存在"}
+	ErrRechargeProvider  = &Code{Code: RechargeErrCodeProvider, Message: "不支持的支付方式"}
+	ErrRechargeSignature = &Code{Code: RechargeErrCodeSignature, Message: "支付回调签名无效"}
+	ErrRechargeAmount    = &Code{Code: RechargeErrCodeAmount, Message: "支付金额与订单不符"}
+	ErrRechargeStatus    = &Code{Code: RechargeErrCodeStatus, Message: "订单当前状态不支持该操作"}
+
+	ErrSubscriptionPlan     = &Code{Code: SubscriptionErrCodePlan, Message: "订阅套餐不存在"}
+	ErrSubscriptionConflict = &Code{Code: SubscriptionErrCodeConflict, Message: "已有生效中的其他订阅"}
+	ErrSubscriptionNotFound = &Code{Code: SubscriptionErrCodeNotFound, Message: "当前没有生效的订阅"}
+
+	ErrAnnotationNotFound = &Code{Code: AnnotationErrCodeNotFound, Message: "笔记不存在"}
+	ErrAnnotationInvalid  = &Code{Code: AnnotationErrCodeInvalid, Message: "无效的笔记位置"}
+
+	ErrReadingSessionNotFound = &Code{Code: ReadingErrCodeSessionNotFound, Message: "阅读会话不存在"}
+	ErrReadingSessionClosed   = &Code{Code: ReadingErrCodeSessionClosed, Message: "阅读会话已结束"}
+
+	ErrEncyclopediaNotFound = &Code{Code: MemoryErrCodeEncyclopediaNotFound, Message: "书籍百科不存在"}
+	ErrRecapNoProgress      = &Code{Code: MemoryErrCodeNoProgress, Message: "尚无可回顾的阅读进度"}
+	ErrRecapBusy            = &Code{Code: MemoryErrCodeRecapBusy, Message: "前情回顾正在生成，请稍后再试"}
 )
 
 var codeMsgMap = map[int]string{
@@ -85,6 +160,7 @@ func init() {
 	register(ErrAuthToken)
 	register(ErrAuthExpired)
 	register(ErrAuthNoLogin)
+	register(ErrAuthNoAdmin)
 	register(ErrBookNotFound)
 	register(ErrBookExist)
 	register(ErrBookInvalid)
@@ -93,10 +169,37 @@ func init() {
 	register(ErrTrimInvalid)
 	register(ErrTrimGenerating)
 	register(ErrTrimDuplicate)
+	register(ErrTrimIncomplete)
+	register(ErrTrimTruncated)
+	register(ErrTrimFiltered)
 	register(ErrTaskNotFound)
 	register(ErrTaskRunning)
 	register(ErrTaskFailed)
+	register(ErrTaskQuoteChanged)
 	register(ErrPointsNotEnough)
+	register(ErrPointsInviteInvalid)
+	register(ErrPointsRuleDisabled)
+	register(ErrRedeemInvalid)
+	register(ErrRedeemExpired)
+	register(ErrRedeemExhausted)
+	register(ErrRedeemLimited)
+	register(ErrRedeemExist)
+	register(ErrRechargeNotFound)
+	register(ErrRechargePackage)
+	register(ErrRechargeProvider)
+	register(ErrRechargeSignature)
+	register(ErrRechargeAmount)
+	register(ErrRechargeStatus)
+	register(ErrSubscriptionPlan)
+	register(ErrSubscriptionConflict)
+	register(ErrSubscriptionNotFound)
+	register(ErrAnnotationNotFound)
+	register(ErrAnnotationInvalid)
+	register(ErrReadingSessionNotFound)
+	register(ErrReadingSessionClosed)
+	register(ErrEncyclopediaNotFound)
+	register(ErrRecapNoProgress)
+	register(ErrRecapBusy)
 }
 
 func GetMsg(code int) string {
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/response"
+	"github.com/zqr233qr/story-trim/internal/service"
+)
+
+// AnnotationHandler 书签与笔记相关接口。
+type AnnotationHandler struct {
+	svc service.AnnotationServiceInterface
+}
+
+// NewAnnotationHandler 创建笔记处理器。
+func NewAnnotationHandler(svc service.AnnotationServiceInterface) *AnnotationHandler {
+	return &AnnotationHandler{svc: svc}
+}
+
+// Create 创建书签、划线或笔记。
+func (h *AnnotationHandler) Create(c *gin.Context) {
+	var req service.CreateAnnotationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	annotation, err := h.svc.CreateAnnotation(c.Request.Context(), GetUserID(c), &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, annotation)
+}
+
+// Update 更新笔记。
+func (h *AnnotationHandler) Update(c *gin.Context) {
+	id := cast.ToUint(c.Param("id"))
+	if id == 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+	var req service.UpdateAnnotationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	annotation, err := h.svc.UpdateAnnotation(c.Request.Context(), GetUserID(c), id, &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, annotation)
+}
+
+// Delete 删除笔记。
+func (h *AnnotationHandler) Delete(c *gin.Context) {
+	id := cast.ToUint(c.Param("id"))
+	if id == 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+	if err := h.svc.DeleteAnnotation(c.Request.Context(), GetUserID(c), id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, nil)
+}
+
+// List 获取笔记列表，传入 since 时返回增量变更（含已删除记录）。
+func (h *AnnotationHandler) List(c *gin.Context) {
+	var req service.ListAnnotationsReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	items, err := h.svc.ListAnnotations(c.Request.Context(), GetUserID(c), &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, gin.H{"items": items})
+}
+
+// ExportMarkdown 导出整本书的笔记为 Markdown。
+func (h *AnnotationHandler) ExportMarkdown(c *gin.Context) {
+	bookID := cast.ToUint(c.Param("id"))
+	if bookID == 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode, "Invalid book ID")
+		return
+	}
+
+	content, err := h.svc.ExportMarkdown(c.Request.Context(), GetUserID(c), bookID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"book_%d_notes.md\"", bookID))
+	c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(content))
+}
+
+func (h *AnnotationHandler) writeError(c *gin.Context, err error) {
+	switch err {
+	case errno.ErrParam:
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+	case errno.ErrAnnotationInvalid:
+		response.Error(c, http.StatusBadRequest, errno.AnnotationErrCodeInvalid)
+	case errno.ErrAnnotationNotFound:
+		response.Error(c, http.StatusNotFound, errno.AnnotationErrCodeNotFound)
+	case errno.ErrBookNotFound:
+		response.Error(c, http.StatusNotFound, errno.BookErrCodeNotFound)
+	case errno.ErrChapterNotFound:
+		response.Error(c, http.StatusNotFound, errno.ChapterErrCodeNotFound)
+	case errno.ErrTrimNotFound:
+		response.Error(c, http.StatusNotFound, errno.TrimErrCodeNotFound)
+	default:
+		response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
+	}
+}
@@ -23,18 +23,27 @@ type authRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type registerRequest struct {
+	authRequest
+	InviteCode string `json:"invite_code"` // 可选，邀请人的邀请码
+}
+
 func (h *AuthHandler) Register(c *gin.Context) {
-	var req authRequest
+	var req registerRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
 		return
 	}
 
-	if err := h.svc.Register(c.Request.Context(), req.Username, req.Password); err != nil {
+	if err := h.svc.Register(c.Request.Context(), req.Username, req.Password, req.InviteCode); err != nil {
 		if errors.Is(err, errno.ErrBookExist) {
 			response.Error(c, http.StatusBadRequest, errno.BookErrCodeExist, "用户已存在")
 			return
 		}
+		if errors.Is(err, errno.ErrPointsInviteInvalid) {
+			response.Error(c, http.StatusBadRequest, errno.PointsErrCodeInvite)
+			return
+		}
 		response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
 		return
 	}
@@ -15,6 +15,9 @@ import (
 	"github.com/zqr233qr/story-trim/pkg/logger"
 )
 
+// maxBatchFetchSize 批量获取章节内容接口单次允许的最大数量。
+const maxBatchFetchSize = 100
+
 type BookHandler struct {
 	svc service.BookServiceInterface
 }
@@ -232,8 +235,8 @@ func (h *BookHandler) GetChaptersContent(c *gin.Context) {
 		return
 	}
 
-	if len(req.IDs) > 10 {
-		response.Error(c, http.StatusBadRequest, errno.ParamErrCode, "Too many IDs requested, max 10")
+	if len(req.IDs) > maxBatchFetchSize {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode, fmt.Sprintf("Too many IDs requested, max %d", maxBatchFetchSize))
 		return
 	}
 
@@ -257,8 +260,8 @@ func (h *BookHandler) GetChaptersTrimmed(c *gin.Context) {
 		return
 	}
 
-	if len(req.IDs) > 10 {
-		response.Error(c, http.StatusBadRequest, errno.ParamErrCode, "Too many IDs, max 10")
+	if len(req.IDs) > maxBatchFetchSize {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode, fmt.Sprintf("Too many IDs, max %d", maxBatchFetchSize))
 		return
 	}
 
@@ -281,8 +284,8 @@ func (h *BookHandler) GetContentsTrimmed(c *gin.Context) {
 		return
 	}
 
-	if len(req.MD5s) > 10 {
-		response.Error(c, http.StatusBadRequest, errno.ParamErrCode, "Too many MD5s, max 10")
+	if len(req.MD5s) > maxBatchFetchSize {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode, fmt.Sprintf("Too many MD5s, max %d", maxBatchFetchSize))
 		return
 	}
 
@@ -295,22 +298,46 @@ func (h *BookHandler) GetContentsTrimmed(c *gin.Context) {
 	response.Success(c, resp)
 }
 
+// UpdateReadingProgress 上报阅读进度。
 func (h *BookHandler) UpdateReadingProgress(c *gin.Context) {
-	bookID := cast.ToUint64(c.Param("id"))
-	var req struct {
-		ChapterID uint `json:"chapter_id" binding:"required"`
-		PromptID  uint `json:"prompt_id"`
+	bookID := cast.ToUint(c.Param("id"))
+	if bookID == 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode, "Invalid book ID")
+		return
 	}
+
+	var req service.ReadingProgressReq
 	if err := c.ShouldBindJSON(&req); err != nil {
 		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
 		return
 	}
 
 	userID := GetUserID(c)
-	err := h.svc.UpdateReadingProgress(c.Request.Context(), userID, uint(bookID), req.ChapterID, req.PromptID)
+	resp, err := h.svc.UpdateReadingProgress(c.Request.Context(), userID, bookID, &req)
+	if err != nil {
+		switch err {
+		case errno.ErrParam:
+			response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		case errno.ErrBookNotFound:
+			response.Error(c, http.StatusNotFound, errno.BookErrCodeNotFound)
+		case errno.ErrChapterNotFound:
+			response.Error(c, http.StatusNotFound, errno.ChapterErrCodeNotFound)
+		default:
+			response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
+		}
+		return
+	}
+	response.Success(c, resp)
+}
+
+// ListReadingHistory 获取最近阅读的书籍。
+func (h *BookHandler) ListReadingHistory(c *gin.Context) {
+	userID := GetUserID(c)
+	limit := cast.ToInt(c.Query("limit"))
+	items, err := h.svc.ListReadingHistory(c.Request.Context(), userID, limit)
 	if err != nil {
 		response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
 		return
 	}
-	response.Success(c, nil)
+	response.Success(c, gin.H{"items": items})
 }
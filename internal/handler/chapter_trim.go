@@ -32,23 +32,16 @@ func (h *ChapterTrimHandler) SubmitChapterTrimTask(c *gin.Context) {
 		return
 	}
 
+	ctx, ok := idempotentContext(c)
+	if !ok {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
 	userID := GetUserID(c)
-	taskID, err := h.svc.SubmitChapterTrimTask(c.Request.Context(), userID, req.BookID, req.PromptID, req.ChapterIDs)
+	taskID, err := h.svc.SubmitChapterTrimTask(ctx, userID, req.BookID, req.PromptID, req.ChapterIDs)
 	if err != nil {
-		switch err {
-		case errno.ErrParam:
-			response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
-		case errno.ErrBookNotFound:
-			response.Error(c, http.StatusNotFound, errno.BookErrCodeNotFound)
-		case errno.ErrChapterNotFound:
-			response.Error(c, http.StatusNotFound, errno.ChapterErrCodeNotFound)
-		case errno.ErrTrimDuplicate:
-			response.Error(c, http.StatusBadRequest, errno.TrimErrCodeDuplicate)
-		case errno.ErrPointsNotEnough:
-			response.Error(c, http.StatusBadRequest, errno.PointsErrCodeNotEnough)
-		default:
-			response.Error(c, http.StatusInternalServerError, errno.TaskErrCode, err.Error())
-		}
+		writeTaskError(c, err)
 		return
 	}
 
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/response"
+	"github.com/zqr233qr/story-trim/internal/service"
+)
+
+// EncyclopediaHandler 书籍百科相关接口。
+type EncyclopediaHandler struct {
+	svc service.EncyclopediaServiceInterface
+}
+
+// NewEncyclopediaHandler 创建书籍百科处理器。
+func NewEncyclopediaHandler(svc service.EncyclopediaServiceInterface) *EncyclopediaHandler {
+	return &EncyclopediaHandler{svc: svc}
+}
+
+// Get 获取读者当前进度可见的书籍百科，可通过 version 查看历史版本。
+func (h *EncyclopediaHandler) Get(c *gin.Context) {
+	bookID := cast.ToUint(c.Param("id"))
+	if bookID == 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode, "Invalid book ID")
+		return
+	}
+	version := cast.ToInt(c.Query("version"))
+	if version < 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	resp, err := h.svc.GetForReader(c.Request.Context(), GetUserID(c), bookID, version)
+	if err != nil {
+		switch err {
+		case errno.ErrBookNotFound:
+			response.Error(c, http.StatusNotFound, errno.BookErrCodeNotFound)
+		case errno.ErrEncyclopediaNotFound:
+			response.Error(c, http.StatusNotFound, errno.MemoryErrCodeEncyclopediaNotFound)
+		default:
+			response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
+		}
+		return
+	}
+	response.Success(c, resp)
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -12,12 +13,13 @@ import (
 
 // PointsHandler 积分相关接口。
 type PointsHandler struct {
-	svc service.PointsServiceInterface
+	svc     service.PointsServiceInterface
+	earning service.EarningServiceInterface
 }
 
 // NewPointsHandler 创建积分处理器。
-func NewPointsHandler(svc service.PointsServiceInterface) *PointsHandler {
-	return &PointsHandler{svc: svc}
+func NewPointsHandler(svc service.PointsServiceInterface, earning service.EarningServiceInterface) *PointsHandler {
+	return &PointsHandler{svc: svc, earning: earning}
 }
 
 // GetBalance 获取用户积分余额。
@@ -44,3 +46,27 @@ func (h *PointsHandler) GetLedger(c *gin.Context) {
 	}
 	response.Success(c, gin.H{"items": items})
 }
+
+// CheckIn 每日签到领取积分，当天重复签到返回 already。
+func (h *PointsHandler) CheckIn(c *gin.Context) {
+	result, err := h.earning.CheckIn(c.Request.Context(), GetUserID(c))
+	if err != nil {
+		if errors.Is(err, errno.ErrPointsRuleDisabled) {
+			response.Error(c, http.StatusForbidden, errno.PointsErrCodeDisabled)
+			return
+		}
+		response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
+		return
+	}
+	response.Success(c, result)
+}
+
+// GetInvite 获取用户的邀请码与已邀请人数。
+func (h *PointsHandler) GetInvite(c *gin.Context) {
+	info, err := h.earning.GetInviteInfo(c.Request.Context(), GetUserID(c))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
+		return
+	}
+	response.Success(c, info)
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/response"
+	"github.com/zqr233qr/story-trim/internal/service"
+)
+
+// ReadingStatsHandler 阅读会话与统计相关接口。
+type ReadingStatsHandler struct {
+	svc service.ReadingStatsServiceInterface
+}
+
+// NewReadingStatsHandler 创建阅读统计处理器。
+func NewReadingStatsHandler(svc service.ReadingStatsServiceInterface) *ReadingStatsHandler {
+	return &ReadingStatsHandler{svc: svc}
+}
+
+// StartSession 开始阅读会话。
+func (h *ReadingStatsHandler) StartSession(c *gin.Context) {
+	var req service.StartSessionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	session, err := h.svc.StartSession(c.Request.Context(), GetUserID(c), &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, session)
+}
+
+// StopSession 结束阅读会话。
+func (h *ReadingStatsHandler) StopSession(c *gin.Context) {
+	id := cast.ToUint(c.Param("id"))
+	if id == 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+	var req service.StopSessionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	session, err := h.svc.StopSession(c.Request.Context(), GetUserID(c), id, &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, session)
+}
+
+// GetStats 获取按日或按周的阅读统计。
+func (h *ReadingStatsHandler) GetStats(c *gin.Context) {
+	var req service.StatsReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	stats, err := h.svc.GetStats(c.Request.Context(), GetUserID(c), &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, stats)
+}
+
+func (h *ReadingStatsHandler) writeError(c *gin.Context, err error) {
+	switch err {
+	case errno.ErrParam:
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+	case errno.ErrBookNotFound:
+		response.Error(c, http.StatusNotFound, errno.BookErrCodeNotFound)
+	case errno.ErrChapterNotFound:
+		response.Error(c, http.StatusNotFound, errno.ChapterErrCodeNotFound)
+	case errno.ErrReadingSessionNotFound:
+		response.Error(c, http.StatusNotFound, errno.ReadingErrCodeSessionNotFound)
+	case errno.ErrReadingSessionClosed:
+		response.Error(c, http.StatusConflict, errno.ReadingErrCodeSessionClosed)
+	default:
+		response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
+	}
+}
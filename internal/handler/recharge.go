@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/response"
+	"github.com/zqr233qr/story-trim/internal/service"
+)
+
+// maxCallbackBodyBytes 支付回调报文的大小上限，回调接口无需登录，读取前先限制大小。
+const maxCallbackBodyBytes = 8 << 10
+
+// RechargeHandler 积分充值与支付回调接口。
+type RechargeHandler struct {
+	svc service.RechargeServiceInterface
+}
+
+// NewRechargeHandler 创建充值处理器。
+func NewRechargeHandler(svc service.RechargeServiceInterface) *RechargeHandler {
+	return &RechargeHandler{svc: svc}
+}
+
+// ListPackages 获取可购买的充值套餐。
+func (h *RechargeHandler) ListPackages(c *gin.Context) {
+	response.Success(c, gin.H{"items": h.svc.ListPackages()})
+}
+
+// CreateOrder 创建充值订单，支持 Idempotency-Key 请求头防止重复下单。
+func (h *RechargeHandler) CreateOrder(c *gin.Context) {
+	var req struct {
+		PackageID string `json:"package_id" binding:"required"`
+		Provider  string `json:"provider"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+	ctx, ok := idempotentContext(c)
+	if !ok {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	view, err := h.svc.CreateOrder(ctx, GetUserID(c), req.PackageID, req.Provider)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, view)
+}
+
+// GetOrder 获取充值订单，用于客户端支付后轮询到账状态。
+func (h *RechargeHandler) GetOrder(c *gin.Context) {
+	view, err := h.svc.GetOrder(c.Request.Context(), GetUserID(c), c.Param("order_no"))
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, view)
+}
+
+// ListOrders 分页获取充值订单。
+func (h *RechargeHandler) ListOrders(c *gin.Context) {
+	items, err := h.svc.ListOrders(c.Request.Context(), GetUserID(c), cast.ToInt(c.Query("page")), cast.ToInt(c.Query("size")))
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, gin.H{"items": items})
+}
+
+// Callback 接收支付渠道的支付通知。处理成功应答 success，否则渠道会按其策略重发。
+func (h *RechargeHandler) Callback(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCallbackBodyBytes)
+	body, err := c.GetRawData()
+	if err != nil {
+		c.String(http.StatusBadRequest, "fail")
+		return
+	}
+	err = h.svc.HandleCallback(c.Request.Context(), c.Param("provider"), c.Request.Header, body)
+	switch err {
+	case nil:
+		c.String(http.StatusOK, "success")
+	case errno.ErrRechargeProvider, errno.ErrRechargeSignature, errno.ErrRechargeNotFound, errno.ErrRechargeAmount, errno.ErrParam:
+		c.String(http.StatusBadRequest, "fail")
+	default:
+		c.String(http.StatusInternalServerError, "fail")
+	}
+}
+
+// FakePay 使用模拟支付渠道完成支付，仅在开启模拟支付时注册。
+func (h *RechargeHandler) FakePay(c *gin.Context) {
+	var req struct {
+		OrderNo string `json:"order_no" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	view, err := h.svc.SimulatePayment(c.Request.Context(), GetUserID(c), req.OrderNo)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, view)
+}
+
+// Refund 为订单全额退款，已到账的积分同时扣回。
+func (h *RechargeHandler) Refund(c *gin.Context) {
+	order, err := h.svc.Refund(c.Request.Context(), c.Param("order_no"))
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, order)
+}
+
+func (h *RechargeHandler) writeError(c *gin.Context, err error) {
+	switch err {
+	case errno.ErrParam:
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+	case errno.ErrRechargeNotFound:
+		response.Error(c, http.StatusNotFound, errno.RechargeErrCodeNotFound)
+	case errno.ErrRechargePackage:
+		response.Error(c, http.StatusBadRequest, errno.RechargeErrCodePackage)
+	case errno.ErrRechargeProvider:
+		response.Error(c, http.StatusBadRequest, errno.RechargeErrCodeProvider)
+	case errno.ErrRechargeAmount:
+		response.Error(c, http.StatusBadRequest, errno.RechargeErrCodeAmount)
+	case errno.ErrRechargeStatus:
+		response.Error(c, http.StatusConflict, errno.RechargeErrCodeStatus)
+	case errno.ErrPointsNotEnough:
+		response.Error(c, http.StatusBadRequest, errno.PointsErrCodeNotEnough)
+	default:
+		response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
+	}
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/response"
+	"github.com/zqr233qr/story-trim/internal/service"
+)
+
+// RedeemHandler 兑换码兑换与活动管理接口。
+type RedeemHandler struct {
+	svc service.RedeemServiceInterface
+}
+
+// NewRedeemHandler 创建兑换码处理器。
+func NewRedeemHandler(svc service.RedeemServiceInterface) *RedeemHandler {
+	return &RedeemHandler{svc: svc}
+}
+
+// Redeem 使用兑换码领取积分。
+func (h *RedeemHandler) Redeem(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	result, err := h.svc.Redeem(c.Request.Context(), GetUserID(c), req.Code)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, result)
+}
+
+// CreateCampaign 创建兑换码活动，返回生成的兑换码。
+func (h *RedeemHandler) CreateCampaign(c *gin.Context) {
+	var req service.CreateRedeemCampaignReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	view, err := h.svc.CreateCampaign(c.Request.Context(), GetUserID(c), &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, view)
+}
+
+// ListCampaigns 分页获取兑换码活动及统计。
+func (h *RedeemHandler) ListCampaigns(c *gin.Context) {
+	items, err := h.svc.ListCampaigns(c.Request.Context(), cast.ToInt(c.Query("page")), cast.ToInt(c.Query("size")))
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, gin.H{"items": items})
+}
+
+// GetCampaign 获取兑换码活动及统计。
+func (h *RedeemHandler) GetCampaign(c *gin.Context) {
+	id := cast.ToUint(c.Param("id"))
+	if id == 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	view, err := h.svc.GetCampaign(c.Request.Context(), id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, view)
+}
+
+// ListCodes 分页获取活动的兑换码。
+func (h *RedeemHandler) ListCodes(c *gin.Context) {
+	id := cast.ToUint(c.Param("id"))
+	if id == 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	items, err := h.svc.ListCodes(c.Request.Context(), id, cast.ToInt(c.Query("page")), cast.ToInt(c.Query("size")))
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, gin.H{"items": items})
+}
+
+// SetCampaignStatus 停用或重新启用活动。
+func (h *RedeemHandler) SetCampaignStatus(c *gin.Context) {
+	id := cast.ToUint(c.Param("id"))
+	var req struct {
+		Disabled bool `json:"disabled"`
+	}
+	if id == 0 || c.ShouldBindJSON(&req) != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	if err := h.svc.SetCampaignDisabled(c.Request.Context(), id, req.Disabled); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, nil)
+}
+
+func (h *RedeemHandler) writeError(c *gin.Context, err error) {
+	switch err {
+	case errno.ErrParam:
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+	case errno.ErrRedeemInvalid:
+		response.Error(c, http.StatusNotFound, errno.RedeemErrCodeInvalid)
+	case errno.ErrRedeemExpired:
+		response.Error(c, http.StatusBadRequest, errno.RedeemErrCodeExpired)
+	case errno.ErrRedeemExhausted:
+		response.Error(c, http.StatusBadRequest, errno.RedeemErrCodeExhausted)
+	case errno.ErrRedeemLimited:
+		response.Error(c, http.StatusBadRequest, errno.RedeemErrCodeLimited)
+	case errno.ErrRedeemExist:
+		response.Error(c, http.StatusConflict, errno.RedeemErrCodeExist)
+	default:
+		response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
+	}
+}
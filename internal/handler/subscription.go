@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/response"
+	"github.com/zqr233qr/story-trim/internal/service"
+)
+
+// SubscriptionHandler 订阅套餐与额度用量接口。
+type SubscriptionHandler struct {
+	svc service.SubscriptionServiceInterface
+}
+
+// NewSubscriptionHandler 创建订阅处理器。
+func NewSubscriptionHandler(svc service.SubscriptionServiceInterface) *SubscriptionHandler {
+	return &SubscriptionHandler{svc: svc}
+}
+
+// ListPlans 获取订阅套餐。
+func (h *SubscriptionHandler) ListPlans(c *gin.Context) {
+	response.Success(c, gin.H{"items": h.svc.ListPlans()})
+}
+
+// GetUsage 获取当前用户的订阅及各计费周期用量。
+func (h *SubscriptionHandler) GetUsage(c *gin.Context) {
+	h.writeUsage(c, GetUserID(c))
+}
+
+// GetUserUsage 管理员查看指定用户的订阅及各计费周期用量。
+func (h *SubscriptionHandler) GetUserUsage(c *gin.Context) {
+	userID := cast.ToUint(c.Param("id"))
+	if userID == 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+	h.writeUsage(c, userID)
+}
+
+// Grant 为用户开通或续期订阅。
+func (h *SubscriptionHandler) Grant(c *gin.Context) {
+	var req struct {
+		UserID uint   `json:"user_id" binding:"required"`
+		PlanID string `json:"plan_id" binding:"required"`
+		Months int    `json:"months" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	sub, err := h.svc.Grant(c.Request.Context(), GetUserID(c), req.UserID, req.PlanID, req.Months)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, sub)
+}
+
+func (h *SubscriptionHandler) writeUsage(c *gin.Context, userID uint) {
+	usage, err := h.svc.GetUsage(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	response.Success(c, usage)
+}
+
+func (h *SubscriptionHandler) writeError(c *gin.Context, err error) {
+	switch err {
+	case errno.ErrParam:
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+	case errno.ErrSubscriptionPlan:
+		response.Error(c, http.StatusBadRequest, errno.SubscriptionErrCodePlan)
+	case errno.ErrSubscriptionConflict:
+		response.Error(c, http.StatusConflict, errno.SubscriptionErrCodeConflict)
+	case errno.ErrSubscriptionNotFound:
+		response.Error(c, http.StatusNotFound, errno.SubscriptionErrCodeNotFound)
+	default:
+		response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
+	}
+}
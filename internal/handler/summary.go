@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/response"
+	"github.com/zqr233qr/story-trim/internal/service"
+)
+
+// SummaryHandler 章节摘要与前情回顾相关接口。
+type SummaryHandler struct {
+	svc service.SummaryServiceInterface
+}
+
+// NewSummaryHandler 创建摘要处理器。
+func NewSummaryHandler(svc service.SummaryServiceInterface) *SummaryHandler {
+	return &SummaryHandler{svc: svc}
+}
+
+// Recap 获取截至当前阅读进度的前情回顾，until_chapter 可进一步限定回顾范围。
+// 缺少摘要的章节在后台生成，missing 不为 0 时客户端可稍后重新获取。
+func (h *SummaryHandler) Recap(c *gin.Context) {
+	bookID := cast.ToUint(c.Param("id"))
+	if bookID == 0 {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode, "Invalid book ID")
+		return
+	}
+	var req service.RecapReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	resp, err := h.svc.Recap(c.Request.Context(), GetUserID(c), bookID, &req)
+	if err != nil {
+		switch err {
+		case errno.ErrBookNotFound:
+			response.Error(c, http.StatusNotFound, errno.BookErrCodeNotFound)
+		case errno.ErrChapterNotFound:
+			response.Error(c, http.StatusNotFound, errno.ChapterErrCodeNotFound)
+		case errno.ErrRecapNoProgress:
+			response.Error(c, http.StatusBadRequest, errno.MemoryErrCodeNoProgress)
+		case errno.ErrRecapBusy:
+			response.Error(c, http.StatusTooManyRequests, errno.MemoryErrCodeRecapBusy)
+		default:
+			response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
+		}
+		return
+	}
+	response.Success(c, resp)
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zqr233qr/story-trim/internal/response"
+	"github.com/zqr233qr/story-trim/internal/service"
+)
+
+// SystemHandler 服务运行状态相关接口。
+type SystemHandler struct {
+	llmService service.LlmServiceInterface
+	gcService  service.GCServiceInterface
+}
+
+// NewSystemHandler 创建运行状态处理器。
+func NewSystemHandler(llmService service.LlmServiceInterface, gcService service.GCServiceInterface) *SystemHandler {
+	return &SystemHandler{llmService: llmService, gcService: gcService}
+}
+
+// LlmStats 获取 LLM 全局并发与排队统计。
+func (h *SystemHandler) LlmStats(c *gin.Context) {
+	response.Success(c, h.llmService.Stats())
+}
+
+// GCStats 获取进程启动以来定时回收的累计指标，包括回收的对象数与字节数。
+func (h *SystemHandler) GCStats(c *gin.Context) {
+	response.Success(c, h.gcService.Stats())
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -17,26 +18,101 @@ func NewTaskHandler(svc service.TaskServiceInterface) *TaskHandler {
 	return &TaskHandler{svc: svc}
 }
 
+// QuoteFullTrim 获取全书精简报价，确认后携带 quote_token 提交任务。
+func (h *TaskHandler) QuoteFullTrim(c *gin.Context) {
+	var req struct {
+		BookID   uint `form:"book_id" binding:"required"`
+		PromptID uint `form:"prompt_id" binding:"required"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	quote, err := h.svc.QuoteFullTrim(c.Request.Context(), GetUserID(c), req.BookID, req.PromptID)
+	if err != nil {
+		writeTaskError(c, err)
+		return
+	}
+	response.Success(c, quote)
+}
+
+// SubmitFullTrimTask 提交全书精简任务。未携带 quote_token 时只返回报价，不创建任务。
 func (h *TaskHandler) SubmitFullTrimTask(c *gin.Context) {
 	var req struct {
-		BookID   uint `json:"book_id" binding:"required"`
-		PromptID uint `json:"prompt_id" binding:"required"`
+		BookID     uint   `json:"book_id" binding:"required"`
+		PromptID   uint   `json:"prompt_id" binding:"required"`
+		QuoteToken string `json:"quote_token"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
 		return
 	}
 
+	ctx, ok := idempotentContext(c)
+	if !ok {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
 	userID := GetUserID(c)
-	taskID, err := h.svc.SubmitFullTrimTask(c.Request.Context(), userID, req.BookID, req.PromptID)
+	if req.QuoteToken == "" {
+		quote, err := h.svc.QuoteFullTrim(c.Request.Context(), userID, req.BookID, req.PromptID)
+		if err != nil {
+			writeTaskError(c, err)
+			return
+		}
+		response.Success(c, gin.H{"quote": quote})
+		return
+	}
+
+	taskID, err := h.svc.SubmitFullTrimTask(ctx, userID, req.BookID, req.PromptID, req.QuoteToken)
 	if err != nil {
-		response.Error(c, http.StatusInternalServerError, errno.TaskErrCode, err.Error())
+		writeTaskError(c, err)
 		return
 	}
 
 	response.Success(c, gin.H{"task_id": taskID})
 }
 
+// maxIdempotencyKeyLen 客户端幂等键的最大长度。
+const maxIdempotencyKeyLen = 64
+
+// idempotentContext 读取可选的 Idempotency-Key 请求头，相同键的重复提交返回首次创建的任务。
+// 键过长时返回 false。
+func idempotentContext(c *gin.Context) (context.Context, bool) {
+	key := c.GetHeader("Idempotency-Key")
+	if len(key) > maxIdempotencyKeyLen {
+		return nil, false
+	}
+	if key == "" {
+		return c.Request.Context(), true
+	}
+	return service.WithIdempotencyKey(c.Request.Context(), key), true
+}
+
+// writeTaskError 把提交精简任务的错误映射为响应。
+func writeTaskError(c *gin.Context, err error) {
+	switch err {
+	case errno.ErrParam:
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+	case errno.ErrBookNotFound:
+		response.Error(c, http.StatusNotFound, errno.BookErrCodeNotFound)
+	case errno.ErrChapterNotFound:
+		response.Error(c, http.StatusNotFound, errno.ChapterErrCodeNotFound)
+	case errno.ErrTrimInvalid:
+		response.Error(c, http.StatusBadRequest, errno.TrimErrCodeInvalid)
+	case errno.ErrTrimDuplicate:
+		response.Error(c, http.StatusBadRequest, errno.TrimErrCodeDuplicate)
+	case errno.ErrPointsNotEnough:
+		response.Error(c, http.StatusBadRequest, errno.PointsErrCodeNotEnough)
+	case errno.ErrTaskQuoteChanged:
+		response.Error(c, http.StatusConflict, errno.TaskErrCodeQuote)
+	default:
+		response.Error(c, http.StatusInternalServerError, errno.TaskErrCode, err.Error())
+	}
+}
+
 func (h *TaskHandler) GetTasksProgress(c *gin.Context) {
 	var req struct {
 		TaskIDs []string `json:"task_ids" binding:"required"`
@@ -5,6 +5,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/response"
 	"github.com/zqr233qr/story-trim/internal/service"
 )
 
@@ -22,6 +24,13 @@ var wsUpgrader = websocket.Upgrader{
 	},
 }
 
+// writeStreamError 发送带错误码的错误帧，客户端据此区分生成失败与正常结束。
+func writeStreamError(ws *websocket.Conn, err error) {
+	code := streamErrorCode(err)
+	_ = ws.WriteJSON(gin.H{"error": code.Message, "code": code.Code})
+	_ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, ""))
+}
+
 type TrimStreamByMD5Request struct {
 	Content      string `json:"content" binding:"required"`
 	MD5          string `json:"md5" binding:"required"`
@@ -30,6 +39,9 @@ type TrimStreamByMD5Request struct {
 	ChapterTitle string `json:"chapter_title" binding:"required"`
 	ChapterIndex int    `json:"chapter_index"`
 	PromptID     uint   `json:"prompt_id" binding:"required"`
+	ResumeFrom   int    `json:"resume_from"` // 断线重连时已收到的字符数
+	Instant      bool   `json:"instant"`     // 命中缓存时一次性返回全文
+	Protocol     int    `json:"protocol"`    // 事件协议版本，未设置时使用旧的帧格式
 }
 
 func (h *TrimHandler) TrimStreamByMD5(c *gin.Context) {
@@ -56,25 +68,18 @@ func (h *TrimHandler) TrimStreamByMD5(c *gin.Context) {
 		req.ChapterTitle,
 		req.Content,
 		req.PromptID,
+		service.TrimStreamOptions{ResumeFrom: req.ResumeFrom, Instant: req.Instant},
 	)
-	if err != nil {
-		_ = ws.WriteJSON(gin.H{"error": err.Error()})
-		return
-	}
-
-	for text := range stream {
-		if err := ws.WriteJSON(gin.H{"c": text}); err != nil {
-			break
-		}
-	}
-
-	_ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	writeTrimStreamWS(ws, req.Protocol, stream, err)
 }
 
 type TrimStreamByChapterIDRequest struct {
-	BookID    uint `json:"book_id" binding:"required"`
-	ChapterID uint `json:"chapter_id" binding:"required"`
-	PromptID  uint `json:"prompt_id" binding:"required"`
+	BookID     uint `json:"book_id" binding:"required"`
+	ChapterID  uint `json:"chapter_id" binding:"required"`
+	PromptID   uint `json:"prompt_id" binding:"required"`
+	ResumeFrom int  `json:"resume_from"` // 断线重连时已收到的字符数
+	Instant    bool `json:"instant"`     // 命中缓存时一次性返回全文
+	Protocol   int  `json:"protocol"`    // 事件协议版本，未设置时使用旧的帧格式
 }
 
 func (h *TrimHandler) TrimStreamByChapterID(c *gin.Context) {
@@ -98,17 +103,32 @@ func (h *TrimHandler) TrimStreamByChapterID(c *gin.Context) {
 		req.BookID,
 		req.ChapterID,
 		req.PromptID,
+		service.TrimStreamOptions{ResumeFrom: req.ResumeFrom, Instant: req.Instant},
 	)
-	if err != nil {
-		_ = ws.WriteJSON(gin.H{"error": err.Error()})
+	writeTrimStreamWS(ws, req.Protocol, stream, err)
+}
+
+// Estimate 估算精简指定章节或全书的 token、成本与积分。
+func (h *TrimHandler) Estimate(c *gin.Context) {
+	var req service.TrimEstimateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
 		return
 	}
 
-	for text := range stream {
-		if err := ws.WriteJSON(gin.H{"c": text}); err != nil {
-			break
+	estimate, err := h.svc.EstimateTrim(c.Request.Context(), GetUserID(c), &req)
+	if err != nil {
+		switch err {
+		case errno.ErrBookNotFound:
+			response.Error(c, http.StatusNotFound, errno.BookErrCodeNotFound)
+		case errno.ErrChapterNotFound:
+			response.Error(c, http.StatusNotFound, errno.ChapterErrCodeNotFound)
+		case errno.ErrTrimInvalid:
+			response.Error(c, http.StatusBadRequest, errno.TrimErrCodeInvalid)
+		default:
+			response.Error(c, http.StatusInternalServerError, errno.InternalServerErrCode)
 		}
+		return
 	}
-
-	_ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	response.Success(c, estimate)
 }
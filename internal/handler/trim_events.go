@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/response"
+	"github.com/zqr233qr/story-trim/internal/service"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+)
+
+// trimProtocolVersion 流式精简事件协议版本。客户端未声明版本时沿用旧的 {"c": text} 帧。
+const trimProtocolVersion = 2
+
+// 流式精简事件类型。一次流依次为 meta、若干 delta，最后以 usage + done 或 error 结束。
+const (
+	TrimEventMeta  = "meta"
+	TrimEventDelta = "delta"
+	TrimEventUsage = "usage"
+	TrimEventDone  = "done"
+	TrimEventError = "error"
+)
+
+// TrimEvent 流式精简事件。Offset 为本事件之后客户端累计收到的字符数，断线重连时作为 resume_from。
+type TrimEvent struct {
+	V      int    `json:"v"`
+	Type   string `json:"type"`
+	Seq    int    `json:"seq"`
+	Offset int    `json:"offset"`
+	Text   string `json:"text,omitempty"`
+	Data   any    `json:"data,omitempty"`
+}
+
+// TrimMetaData meta 事件的内容。
+type TrimMetaData struct {
+	ChapterMD5 string `json:"chapter_md5"`
+	PromptID   uint   `json:"prompt_id"`
+	Cached     bool   `json:"cached"`
+	Charged    bool   `json:"charged"`
+	ResumeFrom int    `json:"resume_from"`
+}
+
+// TrimUsageData usage 事件的内容，命中缓存时为生成该结果时的消耗。
+type TrimUsageData struct {
+	Cached           bool    `json:"cached"`
+	LlmName          string  `json:"llm_name"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	TotalCost        float64 `json:"total_cost"` // 分
+}
+
+// TrimDoneData done 事件的内容。
+type TrimDoneData struct {
+	TrimWords int     `json:"trim_words"`
+	TrimRate  float64 `json:"trim_rate"`
+	TakeTime  float64 `json:"take_time"`
+}
+
+// TrimErrorData error 事件的内容。
+type TrimErrorData struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// trimEventEmitter 按顺序为事件编号并交给具体的传输层发送。
+type trimEventEmitter struct {
+	seq    int
+	offset int
+	send   func(ev *TrimEvent) error
+}
+
+func (e *trimEventEmitter) emit(typ string, text string, data any) error {
+	e.offset += utf8.RuneCountInString(text)
+	ev := &TrimEvent{V: trimProtocolVersion, Type: typ, Seq: e.seq, Offset: e.offset, Text: text, Data: data}
+	e.seq++
+	return e.send(ev)
+}
+
+func (e *trimEventEmitter) emitError(err error) error {
+	code := streamErrorCode(err)
+	return e.emit(TrimEventError, "", TrimErrorData{Code: code.Code, Msg: code.Message})
+}
+
+// streamErrorCode 返回推送给客户端的错误码，非业务错误只返回通用错误并记录原因，避免泄露内部信息。
+func streamErrorCode(err error) *errno.Code {
+	var code *errno.Code
+	if errors.As(err, &code) {
+		return code
+	}
+	logger.Error().Err(err).Msg("流式精简失败")
+	return errno.ErrInternalServer
+}
+
+// pumpTrimEvents 把流式精简的输出转为事件发送，发送失败（客户端断开）时提前返回。
+func pumpTrimEvents(stream *service.TrimStream, send func(ev *TrimEvent) error) error {
+	e := &trimEventEmitter{offset: stream.ResumeFrom, send: send}
+	if err := e.emit(TrimEventMeta, "", TrimMetaData{
+		ChapterMD5: stream.ChapterMD5,
+		PromptID:   stream.PromptID,
+		Cached:     stream.Cached,
+		Charged:    stream.Charged,
+		ResumeFrom: stream.ResumeFrom,
+	}); err != nil {
+		return err
+	}
+
+	for text := range stream.C {
+		if err := e.emit(TrimEventDelta, text, nil); err != nil {
+			return err
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return e.emitError(err)
+	}
+
+	if result := stream.Result(); result != nil {
+		if err := e.emit(TrimEventUsage, "", TrimUsageData{
+			Cached:           stream.Cached,
+			LlmName:          result.LlmName,
+			PromptTokens:     result.PromptTokens,
+			CompletionTokens: result.CompletionTokens,
+			TotalTokens:      result.TotalTokens,
+			TotalCost:        result.TotalCost,
+		}); err != nil {
+			return err
+		}
+		return e.emit(TrimEventDone, "", TrimDoneData{
+			TrimWords: result.TrimContentWords,
+			TrimRate:  result.TrimRate,
+			TakeTime:  result.TakeTime,
+		})
+	}
+	return e.emit(TrimEventDone, "", nil)
+}
+
+// writeTrimStreamWS 通过 websocket 推送精简输出，protocol 低于 2 时使用旧的帧格式。
+func writeTrimStreamWS(ws *websocket.Conn, protocol int, stream *service.TrimStream, err error) {
+	if protocol < trimProtocolVersion {
+		if err != nil {
+			writeStreamError(ws, err)
+			return
+		}
+		for text := range stream.C {
+			if err := ws.WriteJSON(gin.H{"c": text}); err != nil {
+				return
+			}
+		}
+		if err := stream.Err(); err != nil {
+			writeStreamError(ws, err)
+			return
+		}
+		_ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		return
+	}
+
+	failed := false
+	send := func(ev *TrimEvent) error {
+		failed = ev.Type == TrimEventError
+		return ws.WriteJSON(ev)
+	}
+	if err != nil {
+		_ = (&trimEventEmitter{send: send}).emitError(err)
+	} else if pumpTrimEvents(stream, send) != nil {
+		return
+	}
+	closeCode := websocket.CloseNormalClosure
+	if failed {
+		closeCode = websocket.CloseInternalServerErr
+	}
+	_ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, ""))
+}
+
+// writeTrimStreamSSE 通过 Server-Sent Events 推送精简事件，事件 id 为累计字符数，
+// 客户端重连时可通过 Last-Event-ID 续传。
+func writeTrimStreamSSE(c *gin.Context, stream *service.TrimStream, err error) {
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	send := func(ev *TrimEvent) error {
+		c.Render(-1, sse.Event{Id: strconv.Itoa(ev.Offset), Event: ev.Type, Data: ev})
+		c.Writer.Flush()
+		return c.Request.Context().Err()
+	}
+	if err != nil {
+		_ = (&trimEventEmitter{send: send}).emitError(err)
+		return
+	}
+	_ = pumpTrimEvents(stream, send)
+}
+
+// sseResumeFrom 优先使用 Last-Event-ID 作为续传位置。
+func sseResumeFrom(c *gin.Context, resumeFrom int) int {
+	if id, err := strconv.Atoi(c.GetHeader("Last-Event-ID")); err == nil && id > resumeFrom {
+		return id
+	}
+	return resumeFrom
+}
+
+type TrimSSEByChapterIDRequest struct {
+	BookID     uint `form:"book_id" binding:"required"`
+	ChapterID  uint `form:"chapter_id" binding:"required"`
+	PromptID   uint `form:"prompt_id" binding:"required"`
+	ResumeFrom int  `form:"resume_from"`
+	Instant    bool `form:"instant"`
+}
+
+// TrimSSEByChapterID 以 SSE 方式按章节 ID 流式精简，供无法使用 websocket 的客户端。
+func (h *TrimHandler) TrimSSEByChapterID(c *gin.Context) {
+	var req TrimSSEByChapterIDRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	stream, err := h.svc.TrimStreamByChapterID(c.Request.Context(), GetUserID(c), req.BookID, req.ChapterID, req.PromptID, service.TrimStreamOptions{
+		ResumeFrom: sseResumeFrom(c, req.ResumeFrom),
+		Instant:    req.Instant,
+	})
+	writeTrimStreamSSE(c, stream, err)
+}
+
+// TrimSSEByMD5 以 SSE 方式按章节 MD5 流式精简，章节正文较大，参数通过请求体传入。
+func (h *TrimHandler) TrimSSEByMD5(c *gin.Context) {
+	var req TrimStreamByMD5Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, errno.ParamErrCode)
+		return
+	}
+
+	stream, err := h.svc.TrimStreamByMD5(c.Request.Context(), GetUserID(c), req.MD5, req.BookMD5, req.BookTitle, req.ChapterTitle, req.Content, req.PromptID, service.TrimStreamOptions{
+		ResumeFrom: sseResumeFrom(c, req.ResumeFrom),
+		Instant:    req.Instant,
+	})
+	writeTrimStreamSSE(c, stream, err)
+}
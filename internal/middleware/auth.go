@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -38,3 +39,17 @@ func Auth(svc service.AuthServiceInterface) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// Admin 只允许配置中的管理员访问，需放在 Auth 之后。
+func Admin(adminIDs []uint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := c.Get("userID")
+		id, ok := userID.(uint)
+		if !ok || !slices.Contains(adminIDs, id) {
+			response.Error(c, http.StatusForbidden, errno.AuthErrCodeNoAdmin)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
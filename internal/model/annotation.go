@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AnnotationTypeBookmark  = "bookmark"
+	AnnotationTypeHighlight = "highlight"
+	AnnotationTypeNote      = "note"
+)
+
+// Annotation 书签、划线与笔记，按章节内字符偏移锚定。
+// PromptID 大于 0 时锚定在对应模式的精简文本上，精简结果重新生成后依据
+// 原文片段与前后文重新定位，无法定位时标记为 Stale。
+type Annotation struct {
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	UserID       uint           `json:"user_id" gorm:"index:idx_annotation_user_book,priority:1;not null"`
+	BookID       uint           `json:"book_id" gorm:"index:idx_annotation_user_book,priority:2;not null"`
+	ChapterID    uint           `json:"chapter_id" gorm:"index;not null"`
+	PromptID     uint           `json:"prompt_id" gorm:"not null;default:0"` // 0 表示原文
+	Type         string         `json:"type" gorm:"size:20;not null"`
+	StartOffset  int            `json:"start_offset" gorm:"not null"`
+	EndOffset    int            `json:"end_offset" gorm:"not null"`
+	SelectedText string         `json:"selected_text" gorm:"type:text"`
+	AnchorPrefix string         `json:"-" gorm:"size:255"`
+	AnchorSuffix string         `json:"-" gorm:"size:255"`
+	AnchorHash   string         `json:"-" gorm:"size:32"` // 锚定时文本的 MD5，用于发现精简结果重新生成
+	Stale        bool           `json:"stale" gorm:"not null;default:false"`
+	Note         string         `json:"note" gorm:"type:text"`
+	Color        string         `json:"color" gorm:"size:20"`
+	CreatedAt    time.Time      `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time      `json:"updated_at" gorm:"autoUpdateTime;index"`
+	DeletedAt    gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
+}
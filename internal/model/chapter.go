@@ -7,7 +7,7 @@ type Chapter struct {
 	BookID     uint      `json:"book_id" gorm:"index:idx_bookid_index,unique;not null"`
 	Index      int       `json:"index" gorm:"index:idx_bookid_index,unique;not null"`
 	Title      string    `json:"title" gorm:"size:255;not null"`
-	ChapterMD5 string    `json:"chapter_md5" gorm:"size:32;not null"`
+	ChapterMD5 string    `json:"chapter_md5" gorm:"size:32;not null;index"`
 	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
 }
 
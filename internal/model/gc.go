@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+const (
+	GCKindChapterContent = "chapter_content"
+	GCKindTrimResult     = "trim_result"
+)
+
+// GCMark 记录被判定为孤立的对象及首次标记时间，超过宽限期后才会回收。
+type GCMark struct {
+	ID       uint      `json:"id" gorm:"primaryKey"`
+	Kind     string    `json:"kind" gorm:"size:20;uniqueIndex:idx_gc_kind_key;not null"`
+	RefKey   string    `json:"ref_key" gorm:"size:64;uniqueIndex:idx_gc_kind_key;not null"`
+	Size     int64     `json:"size" gorm:"not null;default:0"`
+	MarkedAt time.Time `json:"marked_at" gorm:"index;not null"`
+}
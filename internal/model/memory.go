@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// ChapterSummary 章节剧情摘要，按章节内容 MD5 去重，用作后续章节精简的前情提要。
+type ChapterSummary struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ChapterMD5   string    `json:"chapter_md5" gorm:"uniqueIndex;size:32;not null"`
+	PromptID     uint      `json:"prompt_id" gorm:"not null"` // 生成摘要所用的提示词（精简提示词或摘要提示词）
+	Summary      string    `json:"summary" gorm:"type:text;not null"`
+	SummaryWords int       `json:"summary_words" gorm:"not null"`
+	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// EncyclopediaVersion 书籍百科（人物、地点、势力、物品）的一个版本。
+// 每个版本只依据 UpToIndex 及之前章节的摘要生成，用于防剧透。
+type EncyclopediaVersion struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	BookID           uint      `json:"book_id" gorm:"uniqueIndex:idx_book_version;not null"`
+	Version          int       `json:"version" gorm:"uniqueIndex:idx_book_version;not null"`
+	UpToIndex        int       `json:"up_to_index" gorm:"not null"` // 覆盖到的章节序号（含）
+	CoveredMD5s      string    `json:"-" gorm:"type:longtext"`      // 已并入的章节摘要 MD5，逗号分隔
+	Content          string    `json:"content" gorm:"type:longtext;not null"`
+	TotalTokens      int       `json:"total_tokens" gorm:"not null"`
+	PromptTokens     int       `json:"prompt_tokens" gorm:"not null"`
+	CompletionTokens int       `json:"completion_tokens" gorm:"not null"`
+	TotalCost        float64   `json:"total_cost" gorm:"not null"` // 分
+	LlmName          string    `json:"llm_name" gorm:"size:64"`
+	CreatedAt        time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// RecapSegment 前情回顾的分层缓存。Level 为 0 表示最终回顾，其余为按章节区间合并的中间层。
+type RecapSegment struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	BookID     uint      `json:"book_id" gorm:"uniqueIndex:idx_recap_range;not null"`
+	Level      int       `json:"level" gorm:"uniqueIndex:idx_recap_range;not null"`
+	StartIndex int       `json:"start_index" gorm:"uniqueIndex:idx_recap_range;not null"`
+	EndIndex   int       `json:"end_index" gorm:"uniqueIndex:idx_recap_range;not null"`
+	Content    string    `json:"content" gorm:"type:text;not null"`
+	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -7,20 +7,45 @@ type UserPoints struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	UserID    uint      `json:"user_id" gorm:"uniqueIndex;not null"`
 	Balance   int       `json:"balance" gorm:"not null;default:0"`
+	Frozen    int       `json:"frozen" gorm:"not null;default:0"` // 预占中的积分，不计入可用余额
+	Version   int64     `json:"-" gorm:"not null;default:0"`      // 每次变更递增，用于比较并交换更新
 	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
 // PointsLedger 积分流水记录表。
 type PointsLedger struct {
-	ID           uint      `json:"id" gorm:"primaryKey"`
-	UserID       uint      `json:"user_id" gorm:"index;not null"`
-	Change       int       `json:"change" gorm:"not null"`
-	BalanceAfter int       `json:"balance_after" gorm:"not null"`
-	Type         string    `json:"type" gorm:"size:20;not null"`
-	Reason       string    `json:"reason" gorm:"size:50;not null"`
-	RefType      string    `json:"ref_type" gorm:"size:20"`
-	RefID        string    `json:"ref_id" gorm:"size:64"`
-	Extra        string    `json:"extra" gorm:"type:text"`
-	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime"`
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	UserID         uint      `json:"user_id" gorm:"index;not null"`
+	Change         int       `json:"change" gorm:"not null"`
+	BalanceAfter   int       `json:"balance_after" gorm:"not null"`
+	Type           string    `json:"type" gorm:"size:20;not null"`
+	Reason         string    `json:"reason" gorm:"size:50;not null"`
+	RefType        string    `json:"ref_type" gorm:"size:20"`
+	RefID          string    `json:"ref_id" gorm:"size:64"`
+	Extra          string    `json:"extra" gorm:"type:text"`
+	IdempotencyKey *string   `json:"-" gorm:"size:128;uniqueIndex"` // 相同幂等键的变更只生效一次，为空时不限制
+	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// 积分预占状态。
+const (
+	PointsHoldHeld     = "held"
+	PointsHoldSettled  = "settled"
+	PointsHoldReleased = "released"
+)
+
+// PointsHold 积分预占记录。提交时从可用余额中预扣，成功后结算，失败或取消时释放退回。
+type PointsHold struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	UserID         uint      `json:"user_id" gorm:"index;not null"`
+	Amount         int       `json:"amount" gorm:"not null"`
+	Status         string    `json:"status" gorm:"size:20;not null;index:idx_hold_status_expire,priority:1"`
+	RefType        string    `json:"ref_type" gorm:"size:20;index:idx_hold_ref,priority:1"`
+	RefID          string    `json:"ref_id" gorm:"size:64;index:idx_hold_ref,priority:2"`
+	Extra          string    `json:"extra" gorm:"type:text"`
+	ExpiresAt      time.Time `json:"expires_at" gorm:"not null;index:idx_hold_status_expire,priority:2"`
+	IdempotencyKey *string   `json:"-" gorm:"size:128;uniqueIndex"` // 预占中或已结算时相同键不再重复预占，释放后清空以便重新扣费
+	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 }
@@ -1,5 +1,10 @@
 package model
 
+const (
+	PromptTypeTrim    = 0 // 精简提示词
+	PromptTypeSummary = 1 // 摘要提示词
+)
+
 type Prompt struct {
 	ID               uint    `json:"id" gorm:"primaryKey"`
 	Name             string  `json:"name" gorm:"size:50;not null"`
@@ -9,6 +14,7 @@ type Prompt struct {
 	TargetRatioMax   float64 `json:"-" gorm:"not null"`
 	BoundaryRatioMin float64 `json:"-" gorm:"not null"`
 	BoundaryRatioMax float64 `json:"-" gorm:"not null"`
+	Type             int     `json:"-" gorm:"not null;default:0"`
 	IsSystem         bool    `json:"-" gorm:"not null;default:false"`
 	IsDefault        bool    `json:"is_default" gorm:"not null;default:false"`
 }
@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// ReadingSession 单章阅读会话，用于统计阅读时长与精简节省的字数。
+type ReadingSession struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	UserID       uint       `json:"user_id" gorm:"index:idx_session_user_started,priority:1;not null"`
+	BookID       uint       `json:"book_id" gorm:"index;not null"`
+	ChapterID    uint       `json:"chapter_id" gorm:"not null"`
+	PromptID     uint       `json:"prompt_id" gorm:"not null;default:0"`
+	ViewMode     string     `json:"view_mode" gorm:"size:20;not null"`
+	DeviceID     string     `json:"device_id" gorm:"size:64"`
+	StartedAt    time.Time  `json:"started_at" gorm:"index:idx_session_user_started,priority:2;not null"`
+	EndedAt      *time.Time `json:"ended_at"`
+	Duration     int        `json:"duration" gorm:"not null;default:0"`      // 有效阅读秒数
+	WordsRead    int        `json:"words_read" gorm:"not null;default:0"`    // 实际阅读字数
+	SkippedWords int        `json:"skipped_words" gorm:"not null;default:0"` // 因精简少读的原文字数
+	Finished     bool       `json:"finished" gorm:"not null;default:false"`  // 是否读完本章
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// 充值订单状态：pending → paid → credited，已支付或已到账的订单可退款为 refunded。
+const (
+	RechargeOrderPending  = "pending"
+	RechargeOrderPaid     = "paid"
+	RechargeOrderCredited = "credited"
+	RechargeOrderRefunded = "refunded"
+)
+
+// RechargeOrder 积分充值订单。
+type RechargeOrder struct {
+	ID             uint       `json:"-" gorm:"primaryKey"`
+	OrderNo        string     `json:"order_no" gorm:"size:32;uniqueIndex;not null"`
+	UserID         uint       `json:"-" gorm:"index;not null"`
+	PackageID      string     `json:"package_id" gorm:"size:32;not null"`
+	Amount         int        `json:"amount" gorm:"not null"` // 金额（分）
+	Points         int        `json:"points" gorm:"not null"` // 到账积分
+	Provider       string     `json:"provider" gorm:"size:16;not null"`
+	TradeNo        string     `json:"trade_no" gorm:"size:64"` // 支付渠道交易号
+	Status         string     `json:"status" gorm:"size:16;index;not null;default:pending"`
+	ExpiresAt      time.Time  `json:"expires_at" gorm:"not null"` // 超时后不再拉起支付，已发生的支付仍会入账
+	PaidAt         *time.Time `json:"paid_at"`
+	CreditedAt     *time.Time `json:"credited_at"`
+	RefundedAt     *time.Time `json:"refunded_at"`
+	IdempotencyKey *string    `json:"-" gorm:"size:128;uniqueIndex"` // 客户端下单时的幂等键（按用户隔离），重复提交返回同一订单
+	CreatedAt      time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// RedeemCampaign 兑换码活动，一批兑换码共享面额、有效期与使用限制。
+type RedeemCampaign struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	Name         string     `json:"name" gorm:"size:64;not null"`
+	Points       int        `json:"points" gorm:"not null"`
+	MaxUses      int        `json:"max_uses" gorm:"not null;default:1"`       // 每个兑换码可被使用的次数，0 表示不限
+	PerUserLimit int        `json:"per_user_limit" gorm:"not null;default:1"` // 每个用户在本活动最多兑换次数，0 表示不限
+	StartsAt     *time.Time `json:"starts_at"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	Disabled     bool       `json:"disabled" gorm:"not null;default:false"`
+	CreatedBy    uint       `json:"created_by" gorm:"not null"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// RedeemCode 兑换码。
+type RedeemCode struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CampaignID uint      `json:"campaign_id" gorm:"index;not null"`
+	Code       string    `json:"code" gorm:"size:32;uniqueIndex;not null"`
+	UsedCount  int       `json:"used_count" gorm:"not null;default:0"`
+	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// Redemption 兑换记录，同一用户对同一兑换码只能兑换一次。
+type Redemption struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CampaignID uint      `json:"campaign_id" gorm:"index:idx_redemption_campaign_user,priority:1;not null"`
+	CodeID     uint      `json:"code_id" gorm:"uniqueIndex:idx_redemption_code_user,priority:1;not null"`
+	UserID     uint      `json:"user_id" gorm:"uniqueIndex:idx_redemption_code_user,priority:2;index:idx_redemption_campaign_user,priority:2;not null"`
+	Points     int       `json:"points" gorm:"not null"`
+	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
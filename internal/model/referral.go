@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// InviteCode 用户的邀请码，首次查询时生成。
+type InviteCode struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex;not null"`
+	Code      string    `json:"code" gorm:"size:16;uniqueIndex;not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// Referral 邀请关系，每个用户只能被邀请一次。
+type Referral struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ReferrerID uint      `json:"referrer_id" gorm:"index;not null"`
+	InviteeID  uint      `json:"invitee_id" gorm:"uniqueIndex;not null"`
+	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// 订阅额度使用记录状态，流转方式与积分预占一致。
+const (
+	QuotaUsageHeld     = "held"
+	QuotaUsageSettled  = "settled"
+	QuotaUsageReleased = "released"
+)
+
+// Subscription 用户订阅，自 StartsAt 起每满一个月为一个计费周期。
+type Subscription struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"index;not null"`
+	PlanID    string    `json:"plan_id" gorm:"size:32;not null"`
+	StartsAt  time.Time `json:"starts_at" gorm:"not null"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index;not null"`
+	CreatedBy uint      `json:"created_by" gorm:"not null"` // 开通的管理员
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// QuotaUsage 订阅额度的单章使用记录：精简成功后结算，失败时释放，释放的记录不计入用量。
+type QuotaUsage struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	SubscriptionID uint      `json:"subscription_id" gorm:"index:idx_quota_usage_period,priority:1;not null"`
+	PeriodStart    time.Time `json:"period_start" gorm:"index:idx_quota_usage_period,priority:2;not null"` // 所属计费周期的开始时间
+	UserID         uint      `json:"user_id" gorm:"index;not null"`
+	PromptID       uint      `json:"prompt_id" gorm:"not null"`
+	Status         string    `json:"status" gorm:"size:16;not null;index:idx_quota_usage_status_expire,priority:1"`
+	RefType        string    `json:"ref_type" gorm:"size:32;index:idx_quota_usage_ref,priority:1"`
+	RefID          string    `json:"ref_id" gorm:"size:64;index:idx_quota_usage_ref,priority:2"`
+	ExpiresAt      time.Time `json:"expires_at" gorm:"not null;index:idx_quota_usage_status_expire,priority:2"` // 超过该时间仍未结算时由对账释放
+	IdempotencyKey *string   `json:"-" gorm:"size:128;uniqueIndex"`                                             // 使用中或已结算时相同键不再重复占用额度，释放后清空
+	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
@@ -22,6 +22,17 @@ type TrimResult struct {
 	CreatedAt        time.Time `json:"created_at" gorm:"autoCreateTime"`
 }
 
+// TrimPartial 生成中的精简输出分段，随生成按序追加，完成或失败后删除。
+// 服务重启后据此恢复已推送给客户端的文本并续写，断线重连仍可从字符偏移继续。
+type TrimPartial struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ChapterMD5 string    `json:"chapter_md5" gorm:"uniqueIndex:idx_trim_partial_seq,priority:1;size:32;not null"`
+	PromptID   uint      `json:"prompt_id" gorm:"uniqueIndex:idx_trim_partial_seq,priority:2;not null"`
+	Seq        int       `json:"seq" gorm:"uniqueIndex:idx_trim_partial_seq,priority:3;not null"`
+	Content    string    `json:"content" gorm:"type:text;not null"` // 模型原始输出
+	CreatedAt  time.Time `json:"created_at" gorm:"index;autoCreateTime"`
+}
+
 type UserProcessedChapter struct {
 	ID         uint      `json:"id" gorm:"primaryKey"`
 	UserID     uint      `json:"user_id" gorm:"index;not null;index:idx_user_prompt_md5,priority:1"`
@@ -29,15 +40,28 @@ type UserProcessedChapter struct {
 	ChapterID  uint      `json:"chapter_id" gorm:"index;not null"`
 	PromptID   uint      `json:"prompt_id" gorm:"index;not null;index:idx_user_prompt_md5,priority:2"`
 	BookMD5    string    `json:"book_md5" gorm:"size:32;index:idx_user_prompt_md5,priority:3"`
-	ChapterMD5 string    `json:"chapter_md5" gorm:"size:32;not null;index:idx_user_prompt_md5,priority:4"`
+	ChapterMD5 string    `json:"chapter_md5" gorm:"size:32;not null;index;index:idx_user_prompt_md5,priority:4"`
 	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
 }
 
+const (
+	ViewModeRaw     = "raw"     // 原文
+	ViewModeTrim    = "trim"    // 精简版
+	ViewModeCompare = "compare" // 对照
+)
+
+// ReadingHistory 用户在某本书上的阅读进度，多端按客户端时间戳后写者胜出。
 type ReadingHistory struct {
-	ID            uint      `json:"id" gorm:"primaryKey"`
-	UserID        uint      `json:"user_id" gorm:"uniqueIndex:idx_user_book;not null"`
-	BookID        uint      `json:"book_id" gorm:"uniqueIndex:idx_user_book;not null"`
-	LastChapterID uint      `json:"last_chapter_id" gorm:"not null"`
-	LastPromptID  uint      `json:"last_prompt_id" gorm:"not null"`
-	UpdatedAt     time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	UserID          uint       `json:"user_id" gorm:"uniqueIndex:idx_user_book;index:idx_user_updated,priority:1;not null"`
+	BookID          uint       `json:"book_id" gorm:"uniqueIndex:idx_user_book;not null"`
+	LastChapterID   uint       `json:"last_chapter_id" gorm:"not null"`
+	LastPromptID    uint       `json:"last_prompt_id" gorm:"not null"`
+	ChapterOffset   int        `json:"chapter_offset" gorm:"not null;default:0"`  // 章内字符偏移，精简/对照模式下以 LastPromptID 的精简文本为准
+	ParagraphIndex  int        `json:"paragraph_index" gorm:"not null;default:0"` // 章内段落序号
+	ViewMode        string     `json:"view_mode" gorm:"size:20;not null;default:raw"`
+	DeviceID        string     `json:"device_id" gorm:"size:64"`
+	ClientUpdatedAt time.Time  `json:"client_updated_at"`
+	FinishedAt      *time.Time `json:"finished_at"` // 读完最后一章的时间
+	UpdatedAt       time.Time  `json:"updated_at" gorm:"autoUpdateTime;index:idx_user_updated,priority:2"`
 }
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+	"gorm.io/gorm"
+)
+
+// AnnotationRepository 书签与笔记数据访问层。
+type AnnotationRepository struct {
+	db *gorm.DB
+}
+
+// NewAnnotationRepository 创建笔记仓库。
+func NewAnnotationRepository(db *gorm.DB) *AnnotationRepository {
+	return &AnnotationRepository{db: db}
+}
+
+// AnnotationFilter 笔记查询条件。
+type AnnotationFilter struct {
+	BookID    uint
+	ChapterID uint
+	Type      string
+	// Since 非零时返回该时间之后变更的记录，并包含已删除记录用于多端同步。
+	Since time.Time
+}
+
+// CreateAnnotation 创建笔记。
+func (r *AnnotationRepository) CreateAnnotation(ctx context.Context, annotation *model.Annotation) error {
+	return r.db.WithContext(ctx).Create(annotation).Error
+}
+
+// GetAnnotation 获取用户的笔记。
+func (r *AnnotationRepository) GetAnnotation(ctx context.Context, userID uint, id uint) (*model.Annotation, error) {
+	var annotation model.Annotation
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID), &annotation)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return &annotation, nil
+}
+
+// UpdateAnnotation 更新笔记内容与锚点。
+func (r *AnnotationRepository) UpdateAnnotation(ctx context.Context, annotation *model.Annotation) error {
+	return r.db.WithContext(ctx).Model(&model.Annotation{}).Where("id = ?", annotation.ID).Updates(map[string]interface{}{
+		"start_offset":  annotation.StartOffset,
+		"end_offset":    annotation.EndOffset,
+		"selected_text": annotation.SelectedText,
+		"anchor_prefix": annotation.AnchorPrefix,
+		"anchor_suffix": annotation.AnchorSuffix,
+		"anchor_hash":   annotation.AnchorHash,
+		"stale":         annotation.Stale,
+		"note":          annotation.Note,
+		"color":         annotation.Color,
+		"updated_at":    time.Now(),
+	}).Error
+}
+
+// DeleteAnnotation 删除笔记（软删除，保留同步墓碑）。
+func (r *AnnotationRepository) DeleteAnnotation(ctx context.Context, userID uint, id uint) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&model.Annotation{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListAnnotations 按条件获取用户笔记。
+func (r *AnnotationRepository) ListAnnotations(ctx context.Context, userID uint, filter AnnotationFilter) ([]model.Annotation, error) {
+	query := r.db.WithContext(ctx).Where("user_id = ?", userID)
+	if !filter.Since.IsZero() {
+		query = query.Unscoped().Where("updated_at > ? OR deleted_at > ?", filter.Since, filter.Since)
+	}
+	if filter.BookID > 0 {
+		query = query.Where("book_id = ?", filter.BookID)
+	}
+	if filter.ChapterID > 0 {
+		query = query.Where("chapter_id = ?", filter.ChapterID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+
+	var annotations []model.Annotation
+	if err := query.Order("chapter_id ASC, start_offset ASC").Find(&annotations).Error; err != nil {
+		return nil, err
+	}
+	return annotations, nil
+}
+
+// AnnotationRepositoryInterface 笔记仓库接口。
+type AnnotationRepositoryInterface interface {
+	CreateAnnotation(ctx context.Context, annotation *model.Annotation) error
+	GetAnnotation(ctx context.Context, userID uint, id uint) (*model.Annotation, error)
+	UpdateAnnotation(ctx context.Context, annotation *model.Annotation) error
+	DeleteAnnotation(ctx context.Context, userID uint, id uint) error
+	ListAnnotations(ctx context.Context, userID uint, filter AnnotationFilter) ([]model.Annotation, error)
+}
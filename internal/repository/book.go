@@ -6,6 +6,7 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/zqr233qr/story-trim/internal/model"
 	"github.com/zqr233qr/story-trim/internal/storage"
@@ -134,7 +135,7 @@ func (r *BookRepository) GetChaptersByBookID(ctx context.Context, bookID uint) (
 
 func (r *BookRepository) GetChaptersByBookIDAndIndexes(ctx context.Context, bookID uint, indexes []int) ([]model.Chapter, error) {
 	var dbChaps []model.Chapter
-	if err := r.db.WithContext(ctx).Where("book_id = ? and index in (?)", bookID, indexes).Order("`index` ASC").Find(&dbChaps).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("book_id = ? AND `index` IN ?", bookID, indexes).Order("`index` ASC").Find(&dbChaps).Error; err != nil {
 		return nil, err
 	}
 	return dbChaps, nil
@@ -166,6 +167,10 @@ func (r *BookRepository) SaveRawContent(ctx context.Context, content *model.Chap
 	}
 
 	objectKey := buildChapterObjectKey(content.ChapterMD5)
+	// 先取消已有内容的回收标记，再确认对象是否存在
+	if _, err := r.claimContents(ctx, []string{content.ChapterMD5}); err != nil {
+		return err
+	}
 	exists, err := r.storage.Exists(ctx, objectKey)
 	if err != nil {
 		return err
@@ -205,15 +210,9 @@ func (r *BookRepository) BatchSaveRawContents(ctx context.Context, contents []*m
 		md5s = append(md5s, c.ChapterMD5)
 	}
 
-	existing := make(map[string]struct{})
-	if len(md5s) > 0 {
-		var existsRows []model.ChapterContent
-		if err := r.db.WithContext(ctx).Where("chapter_md5 IN ?", md5s).Select("chapter_md5").Find(&existsRows).Error; err != nil {
-			return err
-		}
-		for _, row := range existsRows {
-			existing[row.ChapterMD5] = struct{}{}
-		}
+	existing, err := r.claimContents(ctx, md5s)
+	if err != nil {
+		return err
 	}
 
 	missing := make([]*model.ChapterContent, 0)
@@ -243,6 +242,35 @@ func (r *BookRepository) BatchSaveRawContents(ctx context.Context, contents []*m
 		CreateInBatches(dbContents, 100).Error
 }
 
+// claimContents 锁定已存在的章节内容并取消其回收标记，返回已存在的 MD5。
+// 与 GCRepository.DeleteOrphanContent 锁定同一行：回收先完成时内容已不存在，调用方需重新上传；
+// 复用先完成时标记已取消，回收会跳过该内容，重新标记后需再等待完整的宽限期。
+func (r *BookRepository) claimContents(ctx context.Context, md5s []string) (map[string]struct{}, error) {
+	existing := make(map[string]struct{})
+	if len(md5s) == 0 {
+		return existing, nil
+	}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var rows []model.ChapterContent
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("chapter_md5 IN ?", md5s).Select("chapter_md5").Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		keys := make([]string, 0, len(rows))
+		for _, row := range rows {
+			existing[row.ChapterMD5] = struct{}{}
+			keys = append(keys, row.ChapterMD5)
+		}
+		return tx.Where("kind = ? AND ref_key IN ?", model.GCKindChapterContent, keys).Delete(&model.GCMark{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
 // GetRawContent 根据章节 MD5 获取原文内容。
 func (r *BookRepository) GetRawContent(ctx context.Context, md5 string) (*model.ChapterContent, error) {
 	var c model.ChapterContent
@@ -270,6 +298,61 @@ func (r *BookRepository) GetRawContent(ctx context.Context, md5 string) (*model.
 	return &c, nil
 }
 
+// GetRawContentsByMD5s 批量获取章节原文，元信息一次查询，对象存储读取并发受限。
+// 不存在的 MD5 不会出现在结果中。
+func (r *BookRepository) GetRawContentsByMD5s(ctx context.Context, md5s []string) (map[string]*model.ChapterContent, error) {
+	metas, err := r.GetContentMetasByMD5s(ctx, md5s)
+	if err != nil {
+		return nil, err
+	}
+
+	const maxConcurrent = 8
+	sem := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	result := make(map[string]*model.ChapterContent, len(metas))
+
+	for _, m := range metas {
+		meta := m
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			data, err := r.readObject(ctx, meta.ObjectKey)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			meta.Content = string(data)
+			result[meta.ChapterMD5] = &meta
+		}()
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+func (r *BookRepository) readObject(ctx context.Context, objectKey string) ([]byte, error) {
+	reader, err := r.storage.Get(ctx, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	return io.ReadAll(reader)
+}
+
 // uploadMissingContents 并发写入缺失的章节内容。
 func (r *BookRepository) uploadMissingContents(ctx context.Context, contents []*model.ChapterContent) error {
 	const maxConcurrent = 6
@@ -338,6 +421,23 @@ func (r *BookRepository) GetTrimResult(ctx context.Context, md5 string, promptID
 	return &t, nil
 }
 
+// GetTrimResultsByMD5s 批量获取指定提示词下的精简结果。
+func (r *BookRepository) GetTrimResultsByMD5s(ctx context.Context, md5s []string, promptID uint) (map[string]*model.TrimResult, error) {
+	result := make(map[string]*model.TrimResult)
+	if len(md5s) == 0 {
+		return result, nil
+	}
+
+	var trims []*model.TrimResult
+	if err := r.db.WithContext(ctx).Where("chapter_md5 IN ? AND prompt_id = ?", md5s, promptID).Find(&trims).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range trims {
+		result[t.ChapterMD5] = t
+	}
+	return result, nil
+}
+
 func (r *BookRepository) ExistTrimResultWithoutObject(ctx context.Context, md5 string, promptID uint) (bool, error) {
 	return ExistWithoutObject(r.db.WithContext(ctx).Model(&model.TrimResult{}).Where("chapter_md5 = ? AND prompt_id = ?", md5, promptID))
 }
@@ -349,18 +449,82 @@ func (r *BookRepository) SaveTrimResult(ctx context.Context, res *model.TrimResu
 	}).Create(res).Error
 }
 
-func (r *BookRepository) UpsertReadingHistory(ctx context.Context, history *model.ReadingHistory) error {
-	dbHist := model.ReadingHistory{
-		UserID:        history.UserID,
-		BookID:        history.BookID,
-		LastChapterID: history.LastChapterID,
-		LastPromptID:  history.LastPromptID,
-		UpdatedAt:     history.UpdatedAt,
+// AppendTrimPartial 追加一段生成中的精简输出。
+func (r *BookRepository) AppendTrimPartial(ctx context.Context, partial *model.TrimPartial) error {
+	return r.db.WithContext(ctx).Create(partial).Error
+}
+
+// GetTrimPartial 按序拼接生成中的精简输出，返回已保存的文本与下一个分段序号。
+func (r *BookRepository) GetTrimPartial(ctx context.Context, md5 string, promptID uint) (string, int, error) {
+	var partials []model.TrimPartial
+	if err := r.db.WithContext(ctx).Where("chapter_md5 = ? AND prompt_id = ?", md5, promptID).
+		Order("seq ASC").Find(&partials).Error; err != nil {
+		return "", 0, err
 	}
-	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "user_id"}, {Name: "book_id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"last_chapter_id", "last_prompt_id", "updated_at"}),
-	}).Create(&dbHist).Error
+	var sb strings.Builder
+	for _, p := range partials {
+		sb.WriteString(p.Content)
+	}
+	next := 0
+	if len(partials) > 0 {
+		next = partials[len(partials)-1].Seq + 1
+	}
+	return sb.String(), next, nil
+}
+
+// DeleteTrimPartials 删除生成中的精简输出，精简完成或失败后调用。
+func (r *BookRepository) DeleteTrimPartials(ctx context.Context, md5 string, promptID uint) error {
+	return r.db.WithContext(ctx).Where("chapter_md5 = ? AND prompt_id = ?", md5, promptID).Delete(&model.TrimPartial{}).Error
+}
+
+// SaveReadingProgress 按客户端时间戳后写者胜出保存阅读进度。
+// 返回是否写入成功；被更新的进度覆盖时 history 会回填为当前生效的记录。
+func (r *BookRepository) SaveReadingProgress(ctx context.Context, history *model.ReadingHistory) (bool, error) {
+	updates := map[string]interface{}{
+		"last_chapter_id":   history.LastChapterID,
+		"last_prompt_id":    history.LastPromptID,
+		"chapter_offset":    history.ChapterOffset,
+		"paragraph_index":   history.ParagraphIndex,
+		"view_mode":         history.ViewMode,
+		"device_id":         history.DeviceID,
+		"client_updated_at": history.ClientUpdatedAt,
+		"updated_at":        time.Now(),
+	}
+
+	// 记录可能在首次写入时被另一端抢先创建，最多重试一次条件更新。
+	for attempt := 0; attempt < 2; attempt++ {
+		result := r.db.WithContext(ctx).Model(&model.ReadingHistory{}).
+			Where("user_id = ? AND book_id = ?", history.UserID, history.BookID).
+			Where("client_updated_at IS NULL OR client_updated_at <= ?", history.ClientUpdatedAt).
+			Updates(updates)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		if result.RowsAffected > 0 {
+			return true, nil
+		}
+
+		current, err := r.GetReadingHistory(ctx, history.UserID, history.BookID)
+		if err != nil {
+			return false, err
+		}
+		if current != nil {
+			*history = *current
+			return false, nil
+		}
+
+		dbHist := *history
+		dbHist.ID = 0
+		created := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&dbHist)
+		if created.Error != nil {
+			return false, created.Error
+		}
+		if created.RowsAffected > 0 {
+			history.ID = dbHist.ID
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("reading progress conflict")
 }
 
 func (r *BookRepository) GetReadingHistory(ctx context.Context, userID, bookID uint) (*model.ReadingHistory, error) {
@@ -375,6 +539,45 @@ func (r *BookRepository) GetReadingHistory(ctx context.Context, userID, bookID u
 	return &h, nil
 }
 
+// ReadingHistoryWithDetail 最近阅读记录及书籍、章节信息。
+type ReadingHistoryWithDetail struct {
+	BookID          uint      `json:"book_id" gorm:"column:book_id"`
+	BookTitle       string    `json:"book_title" gorm:"column:book_title"`
+	BookMD5         string    `json:"book_md5" gorm:"column:book_md5"`
+	TotalChapters   int       `json:"total_chapters" gorm:"column:total_chapters"`
+	LastChapterID   uint      `json:"last_chapter_id" gorm:"column:last_chapter_id"`
+	ChapterTitle    string    `json:"chapter_title" gorm:"column:chapter_title"`
+	ChapterIndex    int       `json:"chapter_index" gorm:"column:chapter_index"`
+	LastPromptID    uint      `json:"last_prompt_id" gorm:"column:last_prompt_id"`
+	ChapterOffset   int       `json:"chapter_offset" gorm:"column:chapter_offset"`
+	ParagraphIndex  int       `json:"paragraph_index" gorm:"column:paragraph_index"`
+	ViewMode        string    `json:"view_mode" gorm:"column:view_mode"`
+	DeviceID        string    `json:"device_id" gorm:"column:device_id"`
+	ClientUpdatedAt time.Time `json:"client_updated_at" gorm:"column:client_updated_at"`
+	UpdatedAt       time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+// ListReadingHistory 获取用户最近阅读的书籍。
+func (r *BookRepository) ListReadingHistory(ctx context.Context, userID uint, limit int) ([]*ReadingHistoryWithDetail, error) {
+	var items []*ReadingHistoryWithDetail
+	err := r.db.WithContext(ctx).
+		Table("reading_histories h").
+		Select("h.book_id, b.title as book_title, b.book_md5, b.total_chapters, "+
+			"h.last_chapter_id, c.title as chapter_title, c.`index` as chapter_index, "+
+			"h.last_prompt_id, h.chapter_offset, h.paragraph_index, h.view_mode, "+
+			"h.device_id, h.client_updated_at, h.updated_at").
+		Joins("JOIN books b ON h.book_id = b.id").
+		Joins("LEFT JOIN chapters c ON h.last_chapter_id = c.id").
+		Where("h.user_id = ?", userID).
+		Order("h.updated_at DESC").
+		Limit(limit).
+		Scan(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 func (r *BookRepository) RecordUserTrim(ctx context.Context, action *model.UserProcessedChapter) error {
 	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
 		DoUpdates: clause.AssignmentColumns([]string{"created_at"}),
@@ -418,6 +621,24 @@ func (r *BookRepository) GetProcessedChapterMD5s(ctx context.Context, userID, pr
 	return md5s, nil
 }
 
+// GetUserChapterMD5s 返回 md5s 中属于用户书籍章节的内容 MD5。
+func (r *BookRepository) GetUserChapterMD5s(ctx context.Context, userID uint, md5s []string) ([]string, error) {
+	if len(md5s) == 0 {
+		return []string{}, nil
+	}
+	var owned []string
+	err := r.db.WithContext(ctx).
+		Table("chapters c").
+		Joins("JOIN books b ON b.id = c.book_id").
+		Where("b.user_id = ? AND c.chapter_md5 IN ?", userID, md5s).
+		Distinct().
+		Pluck("c.chapter_md5", &owned).Error
+	if err != nil {
+		return nil, err
+	}
+	return owned, nil
+}
+
 // GetTrimmedChapterMD5sByPrompt 获取指定模式已精简章节 MD5。
 func (r *BookRepository) GetTrimmedChapterMD5sByPrompt(ctx context.Context, userID, promptID uint, bookID uint, bookMD5 string) ([]string, error) {
 	query := r.db.WithContext(ctx).
@@ -467,7 +688,7 @@ func (r *BookRepository) GetPromptByID(ctx context.Context, id uint) (*model.Pro
 
 func (r *BookRepository) ListSystemPrompts(ctx context.Context) ([]model.Prompt, error) {
 	var dbPs []model.Prompt
-	if err := r.db.WithContext(ctx).Where("is_system = ?", true).Find(&dbPs).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("is_system = ? AND type = ?", true, model.PromptTypeTrim).Find(&dbPs).Error; err != nil {
 		return nil, err
 	}
 	return dbPs, nil
@@ -475,7 +696,7 @@ func (r *BookRepository) ListSystemPrompts(ctx context.Context) ([]model.Prompt,
 
 func (r *BookRepository) GetSummaryPrompt(ctx context.Context) (*model.Prompt, error) {
 	var p model.Prompt
-	if err := r.db.WithContext(ctx).Where("type = ?", 1).First(&p).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("type = ?", model.PromptTypeSummary).First(&p).Error; err != nil {
 		return nil, err
 	}
 	return &p, nil
@@ -489,6 +710,16 @@ func (r *BookRepository) DeleteBook(ctx context.Context, userID uint, id uint) e
 		if err := tx.Where("book_id = ?", id).Delete(&model.Chapter{}).Error; err != nil {
 			return err
 		}
+		if err := tx.Where("book_id = ?", id).Delete(&model.EncyclopediaVersion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("book_id = ?", id).Delete(&model.RecapSegment{}).Error; err != nil {
+			return err
+		}
+		// 笔记软删除，保留墓碑供其他设备同步
+		if err := tx.Where("book_id = ? AND user_id = ?", id, userID).Delete(&model.Annotation{}).Error; err != nil {
+			return err
+		}
 		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&model.Book{})
 		if result.Error != nil {
 			return result.Error
@@ -514,15 +745,22 @@ type BookRepositoryInterface interface {
 	SaveRawContent(ctx context.Context, content *model.ChapterContent) error
 	BatchSaveRawContents(ctx context.Context, contents []*model.ChapterContent) error
 	GetRawContent(ctx context.Context, md5 string) (*model.ChapterContent, error)
+	GetRawContentsByMD5s(ctx context.Context, md5s []string) (map[string]*model.ChapterContent, error)
 	GetContentMetasByMD5s(ctx context.Context, md5s []string) (map[string]model.ChapterContent, error)
 	GetContentStream(ctx context.Context, objectKey string) (io.ReadCloser, error)
 	GetTrimResult(ctx context.Context, md5 string, promptID uint) (*model.TrimResult, error)
+	GetTrimResultsByMD5s(ctx context.Context, md5s []string, promptID uint) (map[string]*model.TrimResult, error)
 	SaveTrimResult(ctx context.Context, res *model.TrimResult) error
-	UpsertReadingHistory(ctx context.Context, history *model.ReadingHistory) error
+	AppendTrimPartial(ctx context.Context, partial *model.TrimPartial) error
+	GetTrimPartial(ctx context.Context, md5 string, promptID uint) (string, int, error)
+	DeleteTrimPartials(ctx context.Context, md5 string, promptID uint) error
+	SaveReadingProgress(ctx context.Context, history *model.ReadingHistory) (bool, error)
+	ListReadingHistory(ctx context.Context, userID uint, limit int) ([]*ReadingHistoryWithDetail, error)
 	GetReadingHistory(ctx context.Context, userID, bookID uint) (*model.ReadingHistory, error)
 	RecordUserTrim(ctx context.Context, action *model.UserProcessedChapter) error
 	HasUserProcessedChapter(ctx context.Context, userID uint, promptID uint, bookID uint, bookMD5 string, chapterMD5 string) (bool, error)
 	GetProcessedChapterMD5s(ctx context.Context, userID, promptID uint, bookID uint, bookMD5 string, chapterMD5s []string) ([]string, error)
+	GetUserChapterMD5s(ctx context.Context, userID uint, md5s []string) ([]string, error)
 	GetTrimmedChapterMD5sByPrompt(ctx context.Context, userID, promptID uint, bookID uint, bookMD5 string) ([]string, error)
 	GetAllBookTrimmedPromptIDs(ctx context.Context, userID, bookID uint) (map[uint][]uint, error)
 	GetPromptByID(ctx context.Context, id uint) (*model.Prompt, error)
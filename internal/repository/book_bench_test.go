@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+const (
+	benchChapters       = 100
+	benchStorageLatency = 2 * time.Millisecond // 模拟对象存储单次读取的网络延迟
+)
+
+// latencyStorage 带固定延迟的内存对象存储。
+type latencyStorage struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+func (s *latencyStorage) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *latencyStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	time.Sleep(benchStorageLatency)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("object not found: %s", key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *latencyStorage) Exists(ctx context.Context, key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.objects[key]
+	return ok, nil
+}
+
+func (s *latencyStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+var benchDBSeq atomic.Int64
+
+func setupBenchRepo(b *testing.B) (*BookRepository, []string) {
+	b.Helper()
+	// 基准测试会以不同 b.N 多次执行，每次使用独立的内存库，保证与内存存储一致。
+	dsn := fmt.Sprintf("file:bench_%d?mode=memory&cache=shared", benchDBSeq.Add(1))
+	db, err := NewDB(config.DatabaseConfig{Type: "sqlite", Source: dsn})
+	if err != nil {
+		b.Fatal(err)
+	}
+	repo := NewBookRepository(db, &latencyStorage{objects: make(map[string][]byte)})
+
+	ctx := context.Background()
+	contents := make([]*model.ChapterContent, 0, benchChapters)
+	md5s := make([]string, 0, benchChapters)
+	for i := 0; i < benchChapters; i++ {
+		md5 := fmt.Sprintf("%032d", i)
+		text := strings.Repeat("章节内容", 500)
+		contents = append(contents, &model.ChapterContent{ChapterMD5: md5, Content: text, WordsCount: 2000})
+		md5s = append(md5s, md5)
+		if err := repo.SaveTrimResult(ctx, &model.TrimResult{ChapterMD5: md5, PromptID: 1, TrimContent: text[:len(text)/2]}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := repo.BatchSaveRawContents(ctx, contents); err != nil {
+		b.Fatal(err)
+	}
+	return repo, md5s
+}
+
+func BenchmarkRawContents_Sequential(b *testing.B) {
+	repo, md5s := setupBenchRepo(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, md5 := range md5s {
+			if _, err := repo.GetRawContent(ctx, md5); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkRawContents_Batched(b *testing.B) {
+	repo, md5s := setupBenchRepo(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		contents, err := repo.GetRawContentsByMD5s(ctx, md5s)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(contents) != len(md5s) {
+			b.Fatalf("got %d contents, want %d", len(contents), len(md5s))
+		}
+	}
+}
+
+func BenchmarkTrimResults_Sequential(b *testing.B) {
+	repo, md5s := setupBenchRepo(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, md5 := range md5s {
+			if _, err := repo.GetTrimResult(ctx, md5, 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkTrimResults_Batched(b *testing.B) {
+	repo, md5s := setupBenchRepo(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trims, err := repo.GetTrimResultsByMD5s(ctx, md5s, 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(trims) != len(md5s) {
+			b.Fatalf("got %d trims, want %d", len(trims), len(md5s))
+		}
+	}
+}
@@ -34,11 +34,13 @@ func NewDB(cfg config.DatabaseConfig) (*gorm.DB, error) {
 			return nil, buildErr
 		}
 		db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
-			Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+			Logger:         gormlogger.Default.LogMode(gormlogger.Silent),
+			TranslateError: true,
 		})
 	default:
 		db, err = gorm.Open(sqlite.Open(cfg.Source), &gorm.Config{
-			Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+			Logger:         gormlogger.Default.LogMode(gormlogger.Silent),
+			TranslateError: true,
 		})
 	}
 	if err != nil {
@@ -69,8 +71,24 @@ func NewDB(cfg config.DatabaseConfig) (*gorm.DB, error) {
 		&model.UserProcessedChapter{},
 		&model.UserPoints{},
 		&model.PointsLedger{},
+		&model.PointsHold{},
+		&model.InviteCode{},
+		&model.Referral{},
+		&model.RedeemCampaign{},
+		&model.RedeemCode{},
+		&model.Redemption{},
+		&model.RechargeOrder{},
+		&model.Subscription{},
+		&model.QuotaUsage{},
 		&model.ReadingHistory{},
 		&model.User{},
+		&model.GCMark{},
+		&model.Annotation{},
+		&model.ReadingSession{},
+		&model.ChapterSummary{},
+		&model.EncyclopediaVersion{},
+		&model.RecapSegment{},
+		&model.TrimPartial{},
 	)
 
 	if err != nil {
@@ -232,6 +250,17 @@ func promptSeeder(db *gorm.DB) error {
     * ❌ 错误（小说写法）：萧炎看着石碑，痛苦地握紧了拳头，指甲刺破了手掌。众人大笑道：“废物！”
     * ✅ 正确（速报写法）：萧炎测验结果仅为三段，因实力大跌而遭到族人公开嘲讽。`,
 		},
+		{
+			ID:          100,
+			Name:        "剧情摘要",
+			Description: "生成章节剧情摘要，用作后续章节的前情提要",
+			Type:        model.PromptTypeSummary,
+			IsSystem:    true,
+			PromptContent: `1.  **篇幅**：控制在 150-300 字，使用第三人称陈述句，不使用对话、修辞和评价。
+2.  **要素**：依次交代本章出场的关键人物、发生的主要事件、事件结果，以及人物关系、实力、身份、持有物品的变化。
+3.  **伏笔**：若本章出现尚未揭晓的悬念或伏笔，用一句话简要标明，不做推测。
+4.  **忠实原文**：只概括本章已发生的内容，严禁补充原文未出现的信息或预测后续剧情。`,
+		},
 	}
 
 	for _, p := range prompts {
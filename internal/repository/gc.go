@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GCRepository 孤立内容回收的数据访问层。
+type GCRepository struct {
+	db      *gorm.DB
+	storage storage.Storage
+}
+
+// NewGCRepository 创建回收仓库。
+func NewGCRepository(db *gorm.DB, storage storage.Storage) *GCRepository {
+	return &GCRepository{db: db, storage: storage}
+}
+
+// GCCandidate 描述一个孤立对象。
+type GCCandidate struct {
+	RefKey string
+	Size   int64
+}
+
+// contentReferencedSQL 章节内容仍被任意章节引用。
+const contentReferencedSQL = "EXISTS (SELECT 1 FROM chapters c WHERE c.chapter_md5 = %s)"
+
+// trimReferencedSQL 精简结果仍被章节或有效的用户处理记录引用。
+// 仅按 MD5 精简（本地书籍）的处理记录 book_id 为 0，也视为有效引用。
+const trimReferencedSQL = `(EXISTS (SELECT 1 FROM chapters c WHERE c.chapter_md5 = %[1]s)
+	OR EXISTS (SELECT 1 FROM user_processed_chapters upc WHERE upc.chapter_md5 = %[1]s
+		AND (upc.book_id = 0 OR EXISTS (SELECT 1 FROM books b WHERE b.id = upc.book_id))))`
+
+// FindOrphanContents 查找未被任何章节引用的章节内容。
+func (r *GCRepository) FindOrphanContents(ctx context.Context) ([]GCCandidate, error) {
+	var rows []GCCandidate
+	err := r.db.WithContext(ctx).
+		Table("chapter_contents cc").
+		Select("cc.chapter_md5 AS ref_key, cc.size AS size").
+		Where("NOT " + fmt.Sprintf(contentReferencedSQL, "cc.chapter_md5")).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// FindOrphanTrimResults 查找未被引用的精简结果。
+func (r *GCRepository) FindOrphanTrimResults(ctx context.Context) ([]GCCandidate, error) {
+	var rows []GCCandidate
+	err := r.db.WithContext(ctx).
+		Table("trim_results tr").
+		Select("CAST(tr.id AS CHAR) AS ref_key, LENGTH(tr.trim_content) AS size").
+		Where("NOT " + fmt.Sprintf(trimReferencedSQL, "tr.chapter_md5")).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// ListMarks 获取指定类型的全部标记。
+func (r *GCRepository) ListMarks(ctx context.Context, kind string) ([]model.GCMark, error) {
+	var marks []model.GCMark
+	if err := r.db.WithContext(ctx).Where("kind = ?", kind).Find(&marks).Error; err != nil {
+		return nil, err
+	}
+	return marks, nil
+}
+
+// CreateMarks 批量写入标记，已存在的标记保留原标记时间。
+func (r *GCRepository) CreateMarks(ctx context.Context, marks []model.GCMark) error {
+	if len(marks) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		CreateInBatches(marks, 100).Error
+}
+
+// DeleteMarks 删除指定类型的标记。
+func (r *GCRepository) DeleteMarks(ctx context.Context, kind string, refKeys []string) error {
+	if len(refKeys) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).
+		Where("kind = ? AND ref_key IN ?", kind, refKeys).
+		Delete(&model.GCMark{}).Error
+}
+
+// DeleteOrphanContent 在确认仍未被引用后删除章节内容对象及元信息，返回是否删除。
+// 锁定内容行后在同一事务内复核标记与引用：上传复用该内容时会先在同一行上加锁并取消标记
+// （见 BookRepository.claimContents），因此不会删除刚被复用的内容。
+func (r *GCRepository) DeleteOrphanContent(ctx context.Context, md5 string) (bool, error) {
+	removed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var content model.ChapterContent
+		exist, err := FirstRecodeIgnoreError(tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("chapter_md5 = ?", md5), &content)
+		if err != nil || !exist {
+			return err
+		}
+		marked, err := ExistWithoutObject(tx.Model(&model.GCMark{}).Where("kind = ? AND ref_key = ?", model.GCKindChapterContent, md5))
+		if err != nil || !marked {
+			return err
+		}
+		referenced, err := ExistWithoutObject(tx.Model(&model.Chapter{}).Where("chapter_md5 = ?", md5))
+		if err != nil || referenced {
+			return err
+		}
+
+		if err := tx.Where("chapter_md5 = ?", md5).Delete(&model.ChapterContent{}).Error; err != nil {
+			return err
+		}
+		// 摘要随原文内容一并回收。
+		if err := tx.Where("chapter_md5 = ?", md5).Delete(&model.ChapterSummary{}).Error; err != nil {
+			return err
+		}
+		// 最后删除对象，删除失败时事务回滚，保留记录以便下次重试。
+		if err := r.storage.Delete(ctx, content.ObjectKey); err != nil {
+			return err
+		}
+		removed = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed, nil
+}
+
+// DeleteOrphanTrimResult 在确认仍未被引用后删除精简结果，返回是否删除。
+func (r *GCRepository) DeleteOrphanTrimResult(ctx context.Context, id uint) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("id = ?", id).
+		Where("NOT " + fmt.Sprintf(trimReferencedSQL, "trim_results.chapter_md5")).
+		Delete(&model.TrimResult{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteStaleTrimPartials 删除早于 before 的生成中精简输出，清理重启后无人续写的残留。
+func (r *GCRepository) DeleteStaleTrimPartials(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("created_at < ?", before).Delete(&model.TrimPartial{})
+	return result.RowsAffected, result.Error
+}
+
+// GCRepositoryInterface 回收仓库接口。
+type GCRepositoryInterface interface {
+	FindOrphanContents(ctx context.Context) ([]GCCandidate, error)
+	FindOrphanTrimResults(ctx context.Context) ([]GCCandidate, error)
+	ListMarks(ctx context.Context, kind string) ([]model.GCMark, error)
+	CreateMarks(ctx context.Context, marks []model.GCMark) error
+	DeleteMarks(ctx context.Context, kind string, refKeys []string) error
+	DeleteOrphanContent(ctx context.Context, md5 string) (bool, error)
+	DeleteOrphanTrimResult(ctx context.Context, id uint) (bool, error)
+	DeleteStaleTrimPartials(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MemoryRepository 章节摘要等上下文记忆数据访问层。
+type MemoryRepository struct {
+	db *gorm.DB
+}
+
+// NewMemoryRepository 创建上下文记忆仓库。
+func NewMemoryRepository(db *gorm.DB) *MemoryRepository {
+	return &MemoryRepository{db: db}
+}
+
+// SaveChapterSummary 保存章节摘要，同一章节内容已有摘要时保留原摘要。
+func (r *MemoryRepository) SaveChapterSummary(ctx context.Context, summary *model.ChapterSummary) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(summary).Error
+}
+
+// GetChapterSummaries 按章节内容 MD5 批量获取摘要。
+func (r *MemoryRepository) GetChapterSummaries(ctx context.Context, md5s []string) (map[string]*model.ChapterSummary, error) {
+	result := make(map[string]*model.ChapterSummary)
+	if len(md5s) == 0 {
+		return result, nil
+	}
+
+	var summaries []*model.ChapterSummary
+	if err := r.db.WithContext(ctx).Where("chapter_md5 IN ?", md5s).Find(&summaries).Error; err != nil {
+		return nil, err
+	}
+	for _, s := range summaries {
+		result[s.ChapterMD5] = s
+	}
+	return result, nil
+}
+
+// CreateEncyclopediaVersion 写入新的百科版本，版本号冲突时返回 false。
+func (r *MemoryRepository) CreateEncyclopediaVersion(ctx context.Context, version *model.EncyclopediaVersion) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(version)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// GetLatestEncyclopedia 获取覆盖章节不超过 maxIndex 的最新百科版本，maxIndex 小于 0 时不限制。
+func (r *MemoryRepository) GetLatestEncyclopedia(ctx context.Context, bookID uint, maxIndex int) (*model.EncyclopediaVersion, error) {
+	query := r.db.WithContext(ctx).Where("book_id = ?", bookID)
+	if maxIndex >= 0 {
+		query = query.Where("up_to_index <= ?", maxIndex)
+	}
+
+	var version model.EncyclopediaVersion
+	exist, err := FirstRecodeIgnoreError(query.Order("version DESC"), &version)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return &version, nil
+}
+
+// GetEncyclopediaVersion 获取指定版本的百科。
+func (r *MemoryRepository) GetEncyclopediaVersion(ctx context.Context, bookID uint, version int) (*model.EncyclopediaVersion, error) {
+	var v model.EncyclopediaVersion
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).Where("book_id = ? AND version = ?", bookID, version), &v)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return &v, nil
+}
+
+// ListEncyclopediaVersions 获取覆盖章节不超过 maxIndex 的百科版本列表（不含内容）。
+func (r *MemoryRepository) ListEncyclopediaVersions(ctx context.Context, bookID uint, maxIndex int) ([]model.EncyclopediaVersion, error) {
+	var versions []model.EncyclopediaVersion
+	err := r.db.WithContext(ctx).
+		Select("id, book_id, version, up_to_index, created_at").
+		Where("book_id = ? AND up_to_index <= ?", bookID, maxIndex).
+		Order("version DESC").
+		Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// GetRecapSegment 获取已缓存的前情回顾片段。
+func (r *MemoryRepository) GetRecapSegment(ctx context.Context, bookID uint, level, startIndex, endIndex int) (*model.RecapSegment, error) {
+	var segment model.RecapSegment
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).
+		Where("book_id = ? AND level = ? AND start_index = ? AND end_index = ?", bookID, level, startIndex, endIndex), &segment)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return &segment, nil
+}
+
+// SaveRecapSegment 缓存前情回顾片段。
+func (r *MemoryRepository) SaveRecapSegment(ctx context.Context, segment *model.RecapSegment) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(segment).Error
+}
+
+// MemoryRepositoryInterface 上下文记忆仓库接口。
+type MemoryRepositoryInterface interface {
+	SaveChapterSummary(ctx context.Context, summary *model.ChapterSummary) error
+	GetChapterSummaries(ctx context.Context, md5s []string) (map[string]*model.ChapterSummary, error)
+	CreateEncyclopediaVersion(ctx context.Context, version *model.EncyclopediaVersion) (bool, error)
+	GetLatestEncyclopedia(ctx context.Context, bookID uint, maxIndex int) (*model.EncyclopediaVersion, error)
+	GetEncyclopediaVersion(ctx context.Context, bookID uint, version int) (*model.EncyclopediaVersion, error)
+	ListEncyclopediaVersions(ctx context.Context, bookID uint, maxIndex int) ([]model.EncyclopediaVersion, error)
+	GetRecapSegment(ctx context.Context, bookID uint, level, startIndex, endIndex int) (*model.RecapSegment, error)
+	SaveRecapSegment(ctx context.Context, segment *model.RecapSegment) error
+}
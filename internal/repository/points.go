@@ -3,10 +3,13 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/zqr233qr/story-trim/internal/errno"
 	"github.com/zqr233qr/story-trim/internal/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // PointsRepository 积分数据访问层。
@@ -39,12 +42,13 @@ func (r *PointsRepository) CreateUserPoints(ctx context.Context, points *model.U
 
 // PointsChange 积分变更请求。
 type PointsChange struct {
-	Change   int
-	Type     string
-	Reason   string
-	RefType  string
-	RefID    string
-	ExtraMap map[string]string
+	Change         int
+	Type           string
+	Reason         string
+	RefType        string
+	RefID          string
+	ExtraMap       map[string]string
+	IdempotencyKey string // 非空时相同键的变更只生效一次
 }
 
 // ChangeBalance 变更积分余额并写入流水。
@@ -66,60 +70,376 @@ func (r *PointsRepository) ChangeBalanceBatch(ctx context.Context, userID uint,
 	}
 
 	var balance int
-	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		var points model.UserPoints
-		if err := tx.Where("user_id = ?", userID).First(&points).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				points = model.UserPoints{UserID: userID, Balance: 0}
-				if err := tx.Create(&points).Error; err != nil {
-					return err
-				}
-			} else {
-				return err
-			}
+	err := retryOnConflict(func() error {
+		return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			balance, err = applyChanges(tx, userID, changes, 0)
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+// maxBalanceAttempts 余额写入冲突时事务的最多执行次数。
+const maxBalanceAttempts = 3
+
+// errBalanceConflict 余额记录在读取后被其他事务修改，比较并交换更新未生效。
+var errBalanceConflict = errors.New("user points modified concurrently")
+
+// retryOnConflict 重试因并发冲突失败的余额事务：幂等键或用户积分记录的唯一约束冲突说明
+// 并发请求已先一步提交，版本冲突说明余额已被修改，重新执行时会读到最新状态。
+func retryOnConflict(fn func() error) error {
+	var err error
+	for i := 0; i < maxBalanceAttempts; i++ {
+		err = fn()
+		if !errors.Is(err, gorm.ErrDuplicatedKey) && !errors.Is(err, errBalanceConflict) {
+			return err
 		}
+	}
+	return err
+}
 
-		current := points.Balance
-		ledgers := make([]model.PointsLedger, 0, len(changes))
-		for _, change := range changes {
-			nextBalance := current + change.Change
-			if nextBalance < 0 {
-				return errno.ErrPointsNotEnough
-			}
-			extraJSON := ""
-			if change.ExtraMap != nil {
-				if data, err := json.Marshal(change.ExtraMap); err == nil {
-					extraJSON = string(data)
-				}
-			}
-			ledgers = append(ledgers, model.PointsLedger{
-				UserID:       userID,
-				Change:       change.Change,
-				BalanceAfter: nextBalance,
-				Type:         change.Type,
-				Reason:       change.Reason,
-				RefType:      change.RefType,
-				RefID:        change.RefID,
-				Extra:        extraJSON,
-			})
-			current = nextBalance
+// idempotencyKeyPtr 空键存为 NULL，不参与唯一约束。
+func idempotencyKeyPtr(key string) *string {
+	if key == "" {
+		return nil
+	}
+	return &key
+}
+
+// userPointsForUpdate 加行锁获取用户积分记录，不存在时创建。
+// SQLite 不支持行锁，写事务本身串行执行，由版本号兜底。
+func userPointsForUpdate(tx *gorm.DB, userID uint) (*model.UserPoints, error) {
+	var points model.UserPoints
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&points).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
 		}
+		points = model.UserPoints{UserID: userID, Balance: 0}
+		if err := tx.Create(&points).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &points, nil
+}
 
-		if err := tx.Model(&model.UserPoints{}).Where("user_id = ?", userID).Update("balance", current).Error; err != nil {
-			return err
+// applyChanges 在事务内变更余额与预占积分并写入流水，返回变更后的余额。
+func applyChanges(tx *gorm.DB, userID uint, changes []PointsChange, frozenDelta int) (int, error) {
+	points, err := userPointsForUpdate(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := appliedLedgerKeys(tx, changes)
+	if err != nil {
+		return 0, err
+	}
+
+	current := points.Balance
+	ledgers := make([]model.PointsLedger, 0, len(changes))
+	for _, change := range changes {
+		if applied[change.IdempotencyKey] {
+			continue
+		}
+		nextBalance := current + change.Change
+		if nextBalance < 0 {
+			return 0, errno.ErrPointsNotEnough
 		}
+		ledgers = append(ledgers, model.PointsLedger{
+			UserID:         userID,
+			Change:         change.Change,
+			BalanceAfter:   nextBalance,
+			Type:           change.Type,
+			Reason:         change.Reason,
+			RefType:        change.RefType,
+			RefID:          change.RefID,
+			Extra:          marshalExtra(change.ExtraMap),
+			IdempotencyKey: idempotencyKeyPtr(change.IdempotencyKey),
+		})
+		current = nextBalance
+	}
+	if len(ledgers) == 0 && frozenDelta == 0 {
+		return current, nil
+	}
+
+	if err := updateUserPoints(tx, points, current, max(points.Frozen+frozenDelta, 0)); err != nil {
+		return 0, err
+	}
+	if len(ledgers) > 0 {
 		if err := tx.Create(&ledgers).Error; err != nil {
-			return err
+			return 0, err
 		}
+	}
+	return current, nil
+}
 
-		balance = current
-		return nil
+// updateUserPoints 以读取时的版本号比较并交换写入余额与冻结积分。
+func updateUserPoints(tx *gorm.DB, points *model.UserPoints, balance, frozen int) error {
+	result := tx.Model(&model.UserPoints{}).
+		Where("user_id = ? AND version = ?", points.UserID, points.Version).
+		Updates(map[string]interface{}{
+			"balance": balance,
+			"frozen":  frozen,
+			"version": gorm.Expr("version + 1"),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errBalanceConflict
+	}
+	return nil
+}
+
+// appliedLedgerKeys 查询已写入流水的幂等键。
+func appliedLedgerKeys(tx *gorm.DB, changes []PointsChange) (map[string]bool, error) {
+	keys := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if change.IdempotencyKey != "" {
+			keys = append(keys, change.IdempotencyKey)
+		}
+	}
+	applied := make(map[string]bool, len(keys))
+	if len(keys) == 0 {
+		return applied, nil
+	}
+	var existing []string
+	if err := tx.Model(&model.PointsLedger{}).Where("idempotency_key IN ?", keys).Pluck("idempotency_key", &existing).Error; err != nil {
+		return nil, err
+	}
+	for _, key := range existing {
+		applied[key] = true
+	}
+	return applied, nil
+}
+
+func marshalExtra(extra map[string]string) string {
+	if extra == nil {
+		return ""
+	}
+	data, err := json.Marshal(extra)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// PointsHoldRequest 积分预占请求，流水记录为 Type/Reason 的扣除。
+type PointsHoldRequest struct {
+	Amount         int
+	Type           string
+	Reason         string
+	RefType        string
+	RefID          string
+	ExtraMap       map[string]string
+	ExpiresAt      time.Time
+	IdempotencyKey string // 非空时相同键在预占中或已结算期间不再重复预占
+}
+
+// CreateHolds 预占积分：扣减可用余额、计入冻结并写入流水，余额不足时全部不生效。
+// 幂等键已有预占（预占中或已结算）的请求直接返回原预占，返回结果与 reqs 顺序一致。
+func (r *PointsRepository) CreateHolds(ctx context.Context, userID uint, reqs []PointsHoldRequest) ([]model.PointsHold, error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+
+	var holds []model.PointsHold
+	err := retryOnConflict(func() error {
+		return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			holds, err = createHolds(tx, userID, reqs)
+			return err
+		})
 	})
+	if err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
 
+func createHolds(tx *gorm.DB, userID uint, reqs []PointsHoldRequest) ([]model.PointsHold, error) {
+	existing, err := holdsByKey(tx, reqs)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return balance, nil
+
+	holds := make([]model.PointsHold, len(reqs))
+	created := make([]model.PointsHold, 0, len(reqs))
+	index := make([]int, 0, len(reqs))
+	changes := make([]PointsChange, 0, len(reqs))
+	frozen := 0
+	for i, req := range reqs {
+		if hold, ok := existing[req.IdempotencyKey]; ok {
+			holds[i] = hold
+			continue
+		}
+		created = append(created, model.PointsHold{
+			UserID:         userID,
+			Amount:         req.Amount,
+			Status:         model.PointsHoldHeld,
+			RefType:        req.RefType,
+			RefID:          req.RefID,
+			Extra:          marshalExtra(req.ExtraMap),
+			ExpiresAt:      req.ExpiresAt,
+			IdempotencyKey: idempotencyKeyPtr(req.IdempotencyKey),
+		})
+		index = append(index, i)
+		changes = append(changes, PointsChange{
+			Change:   -req.Amount,
+			Type:     req.Type,
+			Reason:   req.Reason,
+			RefType:  req.RefType,
+			RefID:    req.RefID,
+			ExtraMap: req.ExtraMap,
+		})
+		frozen += req.Amount
+	}
+	if len(created) == 0 {
+		return holds, nil
+	}
+
+	if _, err := applyChanges(tx, userID, changes, frozen); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&created).Error; err != nil {
+		return nil, err
+	}
+	for i, hold := range created {
+		holds[index[i]] = hold
+	}
+	return holds, nil
+}
+
+// holdsByKey 查询幂等键对应的现有预占。
+func holdsByKey(tx *gorm.DB, reqs []PointsHoldRequest) (map[string]model.PointsHold, error) {
+	keys := make([]string, 0, len(reqs))
+	for _, req := range reqs {
+		if req.IdempotencyKey != "" {
+			keys = append(keys, req.IdempotencyKey)
+		}
+	}
+	existing := make(map[string]model.PointsHold, len(keys))
+	if len(keys) == 0 {
+		return existing, nil
+	}
+	var holds []model.PointsHold
+	if err := tx.Where("idempotency_key IN ?", keys).Find(&holds).Error; err != nil {
+		return nil, err
+	}
+	for _, hold := range holds {
+		existing[*hold.IdempotencyKey] = hold
+	}
+	return existing, nil
+}
+
+// SettleHold 结算预占，积分正式扣除。预占已结算或已释放时返回 false。
+func (r *PointsRepository) SettleHold(ctx context.Context, holdID uint) (bool, error) {
+	settled := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		hold, ok, err := transitHold(tx, holdID, model.PointsHoldSettled)
+		if err != nil || !ok {
+			return err
+		}
+		settled = true
+		return tx.Model(&model.UserPoints{}).Where("user_id = ? AND frozen >= ?", hold.UserID, hold.Amount).
+			Updates(map[string]interface{}{
+				"frozen":  gorm.Expr("frozen - ?", hold.Amount),
+				"version": gorm.Expr("version + 1"),
+			}).Error
+	})
+	return settled, err
+}
+
+// ReleaseHold 释放预占，积分退回可用余额并写入流水。预占已结算或已释放时返回 false。
+func (r *PointsRepository) ReleaseHold(ctx context.Context, holdID uint, changeType, reason string) (bool, error) {
+	released := false
+	err := retryOnConflict(func() error {
+		released = false
+		return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			hold, ok, err := transitHold(tx, holdID, model.PointsHoldReleased)
+			if err != nil || !ok {
+				return err
+			}
+			extra := map[string]string{}
+			if hold.Extra != "" {
+				_ = json.Unmarshal([]byte(hold.Extra), &extra)
+			}
+			released = true
+			_, err = applyChanges(tx, hold.UserID, []PointsChange{{
+				Change:   hold.Amount,
+				Type:     changeType,
+				Reason:   reason,
+				RefType:  hold.RefType,
+				RefID:    hold.RefID,
+				ExtraMap: extra,
+			}}, -hold.Amount)
+			return err
+		})
+	})
+	return released, err
+}
+
+// transitHold 把处于预占中的记录切换到目标状态，以条件更新保证只生效一次。
+// 释放时清空幂等键，之后相同键的请求可以重新预占。
+func transitHold(tx *gorm.DB, holdID uint, status string) (*model.PointsHold, bool, error) {
+	updates := map[string]interface{}{"status": status}
+	if status == model.PointsHoldReleased {
+		updates["idempotency_key"] = nil
+	}
+	result := tx.Model(&model.PointsHold{}).
+		Where("id = ? AND status = ?", holdID, model.PointsHoldHeld).
+		Updates(updates)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, false, nil
+	}
+	var hold model.PointsHold
+	if err := tx.First(&hold, holdID).Error; err != nil {
+		return nil, false, err
+	}
+	return &hold, true, nil
+}
+
+// ListExpiredHolds 获取已过期仍未结算的预占。
+func (r *PointsRepository) ListExpiredHolds(ctx context.Context, before time.Time, limit int) ([]model.PointsHold, error) {
+	var holds []model.PointsHold
+	if err := r.db.WithContext(ctx).
+		Where("status = ? AND expires_at < ?", model.PointsHoldHeld, before).
+		Order("id").
+		Limit(limit).
+		Find(&holds).Error; err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+// ListHeldByRef 获取关联对象上仍未结算的预占。
+func (r *PointsRepository) ListHeldByRef(ctx context.Context, refType string, refIDs []string) ([]model.PointsHold, error) {
+	if len(refIDs) == 0 {
+		return nil, nil
+	}
+	var holds []model.PointsHold
+	if err := r.db.WithContext(ctx).
+		Where("status = ? AND ref_type = ? AND ref_id IN ?", model.PointsHoldHeld, refType, refIDs).
+		Find(&holds).Error; err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+// ExtendHeldByRef 将关联对象上仍未结算的预占有效期顺延至 expiresAt，返回顺延数量。
+func (r *PointsRepository) ExtendHeldByRef(ctx context.Context, refType string, refIDs []string, expiresAt time.Time) (int, error) {
+	if len(refIDs) == 0 {
+		return 0, nil
+	}
+	result := r.db.WithContext(ctx).Model(&model.PointsHold{}).
+		Where("status = ? AND ref_type = ? AND ref_id IN ? AND expires_at < ?", model.PointsHoldHeld, refType, refIDs, expiresAt).
+		Update("expires_at", expiresAt)
+	return int(result.RowsAffected), result.Error
 }
 
 // ListLedger 获取积分流水。
@@ -136,6 +456,134 @@ func (r *PointsRepository) ListLedger(ctx context.Context, userID uint, limit, o
 	return ledgers, nil
 }
 
+// EarnLimit 积分获取限制：统计 Since 之后原因属于 Reasons 的入账流水。Since 为零值时统计全部。
+type EarnLimit struct {
+	Reasons   []string
+	Since     time.Time
+	MaxPoints int // 最多获得积分，0 表示不限
+	MaxTimes  int // 最多入账次数，0 表示不限
+}
+
+// Earn 在限制内发放积分，与余额变更在同一加锁事务内校验，并发请求不会突破上限。
+// 积分额度不足时按剩余额度发放；次数用尽、额度为 0 或幂等键已生效时不发放。
+// 返回实际发放的积分与变更后的余额。
+func (r *PointsRepository) Earn(ctx context.Context, userID uint, change PointsChange, limits []EarnLimit) (int, int, error) {
+	var earned, balance int
+	err := retryOnConflict(func() error {
+		return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			points, err := userPointsForUpdate(tx, userID)
+			if err != nil {
+				return err
+			}
+			earned, balance = 0, points.Balance
+			applied, err := appliedLedgerKeys(tx, []PointsChange{change})
+			if err != nil || applied[change.IdempotencyKey] {
+				return err
+			}
+
+			amount := change.Change
+			for _, limit := range limits {
+				got, times, err := sumEarned(tx, userID, limit)
+				if err != nil {
+					return err
+				}
+				if limit.MaxTimes > 0 && times >= limit.MaxTimes {
+					return nil
+				}
+				if limit.MaxPoints > 0 {
+					amount = min(amount, limit.MaxPoints-got)
+				}
+			}
+			if amount <= 0 {
+				return nil
+			}
+
+			change.Change = amount
+			balance, err = applyChanges(tx, userID, []PointsChange{change}, 0)
+			if err != nil {
+				return err
+			}
+			earned = amount
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return earned, balance, nil
+}
+
+// sumEarned 统计限制范围内已入账的积分与次数。
+func sumEarned(tx *gorm.DB, userID uint, limit EarnLimit) (int, int, error) {
+	var row struct {
+		Points int
+		Times  int
+	}
+	query := tx.Model(&model.PointsLedger{}).
+		Select("COALESCE(SUM(`change`), 0) AS points, COUNT(*) AS times").
+		Where("user_id = ? AND reason IN ? AND `change` > 0", userID, limit.Reasons)
+	if !limit.Since.IsZero() {
+		query = query.Where("created_at >= ?", limit.Since)
+	}
+	if err := query.Scan(&row).Error; err != nil {
+		return 0, 0, err
+	}
+	return row.Points, row.Times, nil
+}
+
+// LastLedger 获取用户指定原因的最近一条流水，不存在时返回 nil。
+func (r *PointsRepository) LastLedger(ctx context.Context, userID uint, reason string) (*model.PointsLedger, error) {
+	var ledger model.PointsLedger
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).Where("user_id = ? AND reason = ?", userID, reason).Order("id DESC"), &ledger)
+	if err != nil || !exist {
+		return nil, err
+	}
+	return &ledger, nil
+}
+
+// ListPointsUserIDs 按用户 ID 升序分页获取有积分记录的用户。
+func (r *PointsRepository) ListPointsUserIDs(ctx context.Context, afterUserID uint, limit int) ([]uint, error) {
+	var ids []uint
+	if err := r.db.WithContext(ctx).Model(&model.UserPoints{}).
+		Where("user_id > ?", afterUserID).
+		Order("user_id").
+		Limit(limit).
+		Pluck("user_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListLedgerForReplay 按写入顺序获取用户的全部积分流水，用于对账重放。
+func (r *PointsRepository) ListLedgerForReplay(ctx context.Context, userID uint) ([]model.PointsLedger, error) {
+	var ledgers []model.PointsLedger
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&ledgers).Error; err != nil {
+		return nil, err
+	}
+	return ledgers, nil
+}
+
+// SumHeld 统计用户预占中的积分。
+func (r *PointsRepository) SumHeld(ctx context.Context, userID uint) (int, error) {
+	var sum int
+	if err := r.db.WithContext(ctx).Model(&model.PointsHold{}).
+		Where("user_id = ? AND status = ?", userID, model.PointsHoldHeld).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&sum).Error; err != nil {
+		return 0, err
+	}
+	return sum, nil
+}
+
+// RepairUserPoints 修正余额与冻结积分，仅当记录自 points 读取后未被修改时生效，否则返回 false。
+func (r *PointsRepository) RepairUserPoints(ctx context.Context, points *model.UserPoints, balance, frozen int) (bool, error) {
+	err := updateUserPoints(r.db.WithContext(ctx), points, balance, frozen)
+	if errors.Is(err, errBalanceConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // PointsRepositoryInterface 积分仓库接口。
 type PointsRepositoryInterface interface {
 	GetUserPoints(ctx context.Context, userID uint) (*model.UserPoints, error)
@@ -143,4 +591,16 @@ type PointsRepositoryInterface interface {
 	ChangeBalance(ctx context.Context, userID uint, change int, changeType, reason, refType, refID string, extra map[string]string) (int, error)
 	ChangeBalanceBatch(ctx context.Context, userID uint, changes []PointsChange) (int, error)
 	ListLedger(ctx context.Context, userID uint, limit, offset int) ([]model.PointsLedger, error)
+	CreateHolds(ctx context.Context, userID uint, reqs []PointsHoldRequest) ([]model.PointsHold, error)
+	SettleHold(ctx context.Context, holdID uint) (bool, error)
+	ReleaseHold(ctx context.Context, holdID uint, changeType, reason string) (bool, error)
+	ListExpiredHolds(ctx context.Context, before time.Time, limit int) ([]model.PointsHold, error)
+	ListHeldByRef(ctx context.Context, refType string, refIDs []string) ([]model.PointsHold, error)
+	ExtendHeldByRef(ctx context.Context, refType string, refIDs []string, expiresAt time.Time) (int, error)
+	ListPointsUserIDs(ctx context.Context, afterUserID uint, limit int) ([]uint, error)
+	ListLedgerForReplay(ctx context.Context, userID uint) ([]model.PointsLedger, error)
+	SumHeld(ctx context.Context, userID uint) (int, error)
+	RepairUserPoints(ctx context.Context, points *model.UserPoints, balance, frozen int) (bool, error)
+	Earn(ctx context.Context, userID uint, change PointsChange, limits []EarnLimit) (int, int, error)
+	LastLedger(ctx context.Context, userID uint, reason string) (*model.PointsLedger, error)
 }
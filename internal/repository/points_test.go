@@ -0,0 +1,321 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	// 使用文件库以便并发用例在多个连接间串行化写事务
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_txlock=immediate", filepath.Join(t.TempDir(), "points.db"))
+	db, err := NewDB(config.DatabaseConfig{Type: "sqlite", Source: dsn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func setupPointsRepo(t *testing.T) *PointsRepository {
+	t.Helper()
+	return NewPointsRepository(setupTestDB(t))
+}
+
+func holdRequests(n int) []PointsHoldRequest {
+	reqs := make([]PointsHoldRequest, 0, n)
+	for i := 0; i < n; i++ {
+		reqs = append(reqs, PointsHoldRequest{
+			Amount:    1,
+			Type:      "spend",
+			Reason:    "trim_use",
+			RefType:   "task_item",
+			RefID:     fmt.Sprintf("%d", i+1),
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+	}
+	return reqs
+}
+
+func TestPointsHold_SettleAndRelease(t *testing.T) {
+	repo := setupPointsRepo(t)
+	ctx := context.Background()
+	if _, err := repo.ChangeBalance(ctx, 1, 3, "earn", "register_bonus", "user", "1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.CreateHolds(ctx, 1, holdRequests(4)); !errors.Is(err, errno.ErrPointsNotEnough) {
+		t.Fatalf("over-reserve err = %v, want ErrPointsNotEnough", err)
+	}
+	holds, err := repo.CreateHolds(ctx, 1, holdRequests(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, _ := repo.GetUserPoints(ctx, 1)
+	if points.Balance != 1 || points.Frozen != 2 {
+		t.Fatalf("after reserve balance=%d frozen=%d, want 1/2", points.Balance, points.Frozen)
+	}
+
+	if ok, err := repo.SettleHold(ctx, holds[0].ID); err != nil || !ok {
+		t.Fatalf("settle = %v, %v", ok, err)
+	}
+	if ok, _ := repo.ReleaseHold(ctx, holds[0].ID, "earn", "trim_refund"); ok {
+		t.Error("settled holds should not be released")
+	}
+	for i := 0; i < 2; i++ {
+		ok, err := repo.ReleaseHold(ctx, holds[1].ID, "earn", "trim_refund")
+		if err != nil || ok != (i == 0) {
+			t.Fatalf("release #%d = %v, %v", i, ok, err)
+		}
+	}
+
+	points, _ = repo.GetUserPoints(ctx, 1)
+	if points.Balance != 2 || points.Frozen != 0 {
+		t.Fatalf("final balance=%d frozen=%d, want 2/0", points.Balance, points.Frozen)
+	}
+	ledgers, _ := repo.ListLedger(ctx, 1, 10, 0)
+	if len(ledgers) != 4 {
+		t.Fatalf("ledgers = %d, want 4 (bonus, 2 holds, 1 refund)", len(ledgers))
+	}
+	for _, ledger := range ledgers {
+		if ledger.Reason == "trim_refund" && (ledger.RefType != "task_item" || ledger.RefID != "2") {
+			t.Errorf("refund should reference the task item, got %s/%s", ledger.RefType, ledger.RefID)
+		}
+	}
+}
+
+func TestPointsHold_ListExpired(t *testing.T) {
+	repo := setupPointsRepo(t)
+	ctx := context.Background()
+	if _, err := repo.ChangeBalance(ctx, 1, 2, "earn", "register_bonus", "user", "1", nil); err != nil {
+		t.Fatal(err)
+	}
+	reqs := holdRequests(2)
+	reqs[0].ExpiresAt = time.Now().Add(-time.Minute)
+	if _, err := repo.CreateHolds(ctx, 1, reqs); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := repo.ListExpiredHolds(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].RefID != "1" {
+		t.Fatalf("expired = %+v, want only the first hold", expired)
+	}
+
+	// 仍在处理中的章节顺延后不再被对账释放
+	if n, err := repo.ExtendHeldByRef(ctx, expired[0].RefType, []string{"1"}, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("extended = %d, %v", n, err)
+	}
+	if expired, err = repo.ListExpiredHolds(ctx, time.Now(), 10); err != nil || len(expired) != 0 {
+		t.Fatalf("expired after extend = %+v, %v", expired, err)
+	}
+}
+
+// runConcurrently 并发执行 n 次 fn，模拟多端同时提交的重复请求。
+func runConcurrently(n int, fn func() error) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fn()
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestPointsChange_ConcurrentDuplicates(t *testing.T) {
+	repo := setupPointsRepo(t)
+	ctx := context.Background()
+	change := PointsChange{Change: 5, Type: "earn", Reason: "register_bonus", RefType: "user", RefID: "1", IdempotencyKey: "register_bonus:1"}
+
+	for i, err := range runConcurrently(8, func() error {
+		_, err := repo.ChangeBalanceBatch(ctx, 1, []PointsChange{change})
+		return err
+	}) {
+		if err != nil {
+			t.Fatalf("change #%d: %v", i, err)
+		}
+	}
+
+	points, _ := repo.GetUserPoints(ctx, 1)
+	if points.Balance != 5 {
+		t.Fatalf("balance = %d, want 5", points.Balance)
+	}
+	ledgers, _ := repo.ListLedger(ctx, 1, 10, 0)
+	if len(ledgers) != 1 {
+		t.Fatalf("ledgers = %d, want 1", len(ledgers))
+	}
+}
+
+func TestPointsHold_ConcurrentDuplicates(t *testing.T) {
+	repo := setupPointsRepo(t)
+	ctx := context.Background()
+	if _, err := repo.ChangeBalance(ctx, 1, 10, "earn", "register_bonus", "user", "1", nil); err != nil {
+		t.Fatal(err)
+	}
+	reqs := holdRequests(1)
+	reqs[0].Amount = 3
+	reqs[0].IdempotencyKey = "trim:1:1:book:chapter"
+
+	ids := make(chan uint, 8)
+	for i, err := range runConcurrently(8, func() error {
+		holds, err := repo.CreateHolds(ctx, 1, reqs)
+		if err == nil {
+			ids <- holds[0].ID
+		}
+		return err
+	}) {
+		if err != nil {
+			t.Fatalf("hold #%d: %v", i, err)
+		}
+	}
+	close(ids)
+	first := <-ids
+	for id := range ids {
+		if id != first {
+			t.Fatalf("duplicate holds returned different ids %d and %d", first, id)
+		}
+	}
+	points, _ := repo.GetUserPoints(ctx, 1)
+	if points.Balance != 7 || points.Frozen != 3 {
+		t.Fatalf("after duplicates balance=%d frozen=%d, want 7/3", points.Balance, points.Frozen)
+	}
+
+	// 释放后相同键可以重新预占，结算后重复请求复用原预占
+	if ok, err := repo.ReleaseHold(ctx, first, "earn", "trim_refund"); err != nil || !ok {
+		t.Fatalf("release = %v, %v", ok, err)
+	}
+	holds, err := repo.CreateHolds(ctx, 1, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holds[0].ID == first {
+		t.Fatal("released hold should not be reused")
+	}
+	if ok, err := repo.SettleHold(ctx, holds[0].ID); err != nil || !ok {
+		t.Fatalf("settle = %v, %v", ok, err)
+	}
+	again, err := repo.CreateHolds(ctx, 1, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].ID != holds[0].ID {
+		t.Fatalf("settled hold id = %d, want %d", again[0].ID, holds[0].ID)
+	}
+	points, _ = repo.GetUserPoints(ctx, 1)
+	if points.Balance != 7 || points.Frozen != 0 {
+		t.Fatalf("final balance=%d frozen=%d, want 7/0", points.Balance, points.Frozen)
+	}
+}
+
+func TestPointsChange_ConcurrentSpends(t *testing.T) {
+	repo := setupPointsRepo(t)
+	ctx := context.Background()
+	if _, err := repo.ChangeBalance(ctx, 1, 10, "earn", "register_bonus", "user", "1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := runConcurrently(12, func() error {
+		_, err := repo.ChangeBalance(ctx, 1, -1, "spend", "trim_use", "chapter_id", "1", nil)
+		return err
+	})
+	failed := 0
+	for _, err := range errs {
+		if errors.Is(err, errno.ErrPointsNotEnough) {
+			failed++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if failed != 2 {
+		t.Fatalf("not enough = %d, want 2", failed)
+	}
+
+	points, _ := repo.GetUserPoints(ctx, 1)
+	if points.Balance != 0 || points.Version != 11 {
+		t.Fatalf("balance=%d version=%d, want 0/11", points.Balance, points.Version)
+	}
+	ledgers, _ := repo.ListLedgerForReplay(ctx, 1)
+	prev := 0
+	for _, ledger := range ledgers {
+		if ledger.BalanceAfter != prev+ledger.Change {
+			t.Fatalf("ledger %d breaks the balance chain", ledger.ID)
+		}
+		prev = ledger.BalanceAfter
+	}
+}
+
+func TestRepairUserPoints_RejectsStaleVersion(t *testing.T) {
+	repo := setupPointsRepo(t)
+	ctx := context.Background()
+	if _, err := repo.ChangeBalance(ctx, 1, 5, "earn", "register_bonus", "user", "1", nil); err != nil {
+		t.Fatal(err)
+	}
+	stale, _ := repo.GetUserPoints(ctx, 1)
+	if _, err := repo.ChangeBalance(ctx, 1, 1, "earn", "register_bonus", "user", "1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := repo.RepairUserPoints(ctx, stale, 0, 0); err != nil || ok {
+		t.Fatalf("stale repair = %v, %v, want rejected", ok, err)
+	}
+	fresh, _ := repo.GetUserPoints(ctx, 1)
+	if ok, err := repo.RepairUserPoints(ctx, fresh, 6, 0); err != nil || !ok {
+		t.Fatalf("repair = %v, %v", ok, err)
+	}
+}
+
+func TestPointsEarn_Limits(t *testing.T) {
+	repo := setupPointsRepo(t)
+	ctx := context.Background()
+	today := time.Now().Add(-time.Hour)
+	totalTimes := EarnLimit{Reasons: []string{"reading_reward", "check_in"}, MaxTimes: 4}
+	limits := []EarnLimit{{Reasons: []string{"reading_reward"}, Since: today, MaxPoints: 12}, totalTimes}
+
+	// 并发领取不同里程碑，总额不超过每日上限，最后一笔按剩余额度发放
+	var total atomic.Int64
+	for i, err := range runConcurrently(4, func() error {
+		earned, _, err := repo.Earn(ctx, 1, PointsChange{Change: 5, Type: "earn", Reason: "reading_reward"}, limits)
+		total.Add(int64(earned))
+		return err
+	}) {
+		if err != nil {
+			t.Fatalf("earn #%d: %v", i, err)
+		}
+	}
+	if total.Load() != 12 {
+		t.Fatalf("earned = %d, want capped at 12", total.Load())
+	}
+
+	key := PointsChange{Change: 3, Type: "earn", Reason: "check_in", IdempotencyKey: "earn:check_in:1:20260301"}
+	limits = []EarnLimit{totalTimes}
+	if earned, _, err := repo.Earn(ctx, 1, key, limits); err != nil || earned != 3 {
+		t.Fatalf("check in = %d, %v", earned, err)
+	}
+	if earned, _, err := repo.Earn(ctx, 1, key, limits); err != nil || earned != 0 {
+		t.Fatalf("duplicate check in = %d, %v, want 0", earned, err)
+	}
+	key.IdempotencyKey = "earn:check_in:1:20260302"
+	if earned, _, err := repo.Earn(ctx, 1, key, limits); err != nil || earned != 0 {
+		t.Fatalf("check in over total times = %d, %v, want 0", earned, err)
+	}
+
+	points, _ := repo.GetUserPoints(ctx, 1)
+	if points.Balance != 15 {
+		t.Fatalf("balance = %d, want 15", points.Balance)
+	}
+}
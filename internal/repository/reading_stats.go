@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReadingStatsRepository 阅读会话与统计数据访问层。
+type ReadingStatsRepository struct {
+	db *gorm.DB
+}
+
+// NewReadingStatsRepository 创建阅读统计仓库。
+func NewReadingStatsRepository(db *gorm.DB) *ReadingStatsRepository {
+	return &ReadingStatsRepository{db: db}
+}
+
+// CreateSession 创建阅读会话。
+func (r *ReadingStatsRepository) CreateSession(ctx context.Context, session *model.ReadingSession) error {
+	return r.db.WithContext(ctx).Create(session).Error
+}
+
+// GetSession 获取用户的阅读会话。
+func (r *ReadingStatsRepository) GetSession(ctx context.Context, userID uint, id uint) (*model.ReadingSession, error) {
+	var session model.ReadingSession
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID), &session)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// FinishSession 结束阅读会话，仅对未结束的会话生效，返回是否更新成功。
+func (r *ReadingStatsRepository) FinishSession(ctx context.Context, session *model.ReadingSession) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&model.ReadingSession{}).
+		Where("id = ? AND ended_at IS NULL", session.ID).
+		Updates(map[string]interface{}{
+			"ended_at":      session.EndedAt,
+			"duration":      session.Duration,
+			"words_read":    session.WordsRead,
+			"skipped_words": session.SkippedWords,
+			"finished":      session.Finished,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ListFinishedSessions 获取时间范围内已结束的阅读会话。
+func (r *ReadingStatsRepository) ListFinishedSessions(ctx context.Context, userID uint, from, to time.Time) ([]model.ReadingSession, error) {
+	var sessions []model.ReadingSession
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND started_at >= ? AND started_at < ? AND ended_at IS NOT NULL", userID, from, to).
+		Order("started_at ASC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// MarkBookFinished 标记书籍已读完，已标记过的不会覆盖首次读完时间。
+// 用户从未上报过进度时补建一条阅读记录。
+func (r *ReadingStatsRepository) MarkBookFinished(ctx context.Context, history *model.ReadingHistory) error {
+	result := r.db.WithContext(ctx).Model(&model.ReadingHistory{}).
+		Where("user_id = ? AND book_id = ? AND finished_at IS NULL", history.UserID, history.BookID).
+		Update("finished_at", history.FinishedAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(history).Error
+}
+
+// ListFinishedTimes 获取时间范围内读完书籍的时间，按时间升序。
+func (r *ReadingStatsRepository) ListFinishedTimes(ctx context.Context, userID uint, from, to time.Time) ([]time.Time, error) {
+	var histories []model.ReadingHistory
+	err := r.db.WithContext(ctx).
+		Select("finished_at").
+		Where("user_id = ? AND finished_at >= ? AND finished_at < ?", userID, from, to).
+		Order("finished_at ASC").
+		Find(&histories).Error
+	if err != nil {
+		return nil, err
+	}
+	times := make([]time.Time, 0, len(histories))
+	for _, h := range histories {
+		if h.FinishedAt != nil {
+			times = append(times, *h.FinishedAt)
+		}
+	}
+	return times, nil
+}
+
+// CountAllFinishedBooks 统计用户累计读完的书籍数量。
+func (r *ReadingStatsRepository) CountAllFinishedBooks(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&model.ReadingHistory{}).
+		Where("user_id = ? AND finished_at IS NOT NULL", userID).
+		Count(&count).Error
+	return count, err
+}
+
+// ReadingStatsRepositoryInterface 阅读统计仓库接口。
+type ReadingStatsRepositoryInterface interface {
+	CreateSession(ctx context.Context, session *model.ReadingSession) error
+	GetSession(ctx context.Context, userID uint, id uint) (*model.ReadingSession, error)
+	FinishSession(ctx context.Context, session *model.ReadingSession) (bool, error)
+	ListFinishedSessions(ctx context.Context, userID uint, from, to time.Time) ([]model.ReadingSession, error)
+	MarkBookFinished(ctx context.Context, history *model.ReadingHistory) error
+	ListFinishedTimes(ctx context.Context, userID uint, from, to time.Time) ([]time.Time, error)
+	CountAllFinishedBooks(ctx context.Context, userID uint) (int64, error)
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RechargeRepository 充值订单数据访问层。
+type RechargeRepository struct {
+	db *gorm.DB
+}
+
+// NewRechargeRepository 创建充值订单仓库。
+func NewRechargeRepository(db *gorm.DB) *RechargeRepository {
+	return &RechargeRepository{db: db}
+}
+
+// CreateOrder 创建充值订单。
+func (r *RechargeRepository) CreateOrder(ctx context.Context, order *model.RechargeOrder) error {
+	return r.db.WithContext(ctx).Create(order).Error
+}
+
+// GetOrder 按订单号获取订单，不存在时返回 nil。
+func (r *RechargeRepository) GetOrder(ctx context.Context, orderNo string) (*model.RechargeOrder, error) {
+	var order model.RechargeOrder
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).Where("order_no = ?", orderNo), &order)
+	if err != nil || !exist {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// GetOrderByIdempotencyKey 按幂等键获取订单，不存在时返回 nil。
+func (r *RechargeRepository) GetOrderByIdempotencyKey(ctx context.Context, key string) (*model.RechargeOrder, error) {
+	var order model.RechargeOrder
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).Where("idempotency_key = ?", key), &order)
+	if err != nil || !exist {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// ListOrders 按创建时间倒序获取用户的订单。
+func (r *RechargeRepository) ListOrders(ctx context.Context, userID uint, limit, offset int) ([]model.RechargeOrder, error) {
+	var orders []model.RechargeOrder
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id DESC").Limit(limit).Offset(offset).Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// MarkPaid 把待支付订单标记为已支付，订单已不是待支付状态时返回 false。
+func (r *RechargeRepository) MarkPaid(ctx context.Context, orderNo, tradeNo string, paidAt time.Time) (bool, error) {
+	var ok bool
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		_, ok, err = transitOrder(tx, orderNo, model.RechargeOrderPending, model.RechargeOrderPaid, map[string]interface{}{
+			"trade_no": tradeNo,
+			"paid_at":  paidAt,
+		})
+		return err
+	})
+	return ok, err
+}
+
+// CreditOrder 已支付订单入账：切换为已到账并增加积分在同一事务内完成，
+// 每个订单只入账一次。订单不是已支付状态时返回 false。
+func (r *RechargeRepository) CreditOrder(ctx context.Context, orderNo string, now time.Time) (bool, error) {
+	credited := false
+	err := retryOnConflict(func() error {
+		credited = false
+		return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			order, ok, err := transitOrder(tx, orderNo, model.RechargeOrderPaid, model.RechargeOrderCredited, map[string]interface{}{
+				"credited_at": now,
+			})
+			if err != nil || !ok {
+				return err
+			}
+			credited = true
+			_, err = applyChanges(tx, order.UserID, []PointsChange{{
+				Change:         order.Points,
+				Type:           "earn",
+				Reason:         "recharge",
+				RefType:        "recharge_order",
+				RefID:          order.OrderNo,
+				ExtraMap:       rechargeExtra(order),
+				IdempotencyKey: "recharge:" + order.OrderNo,
+			}}, 0)
+			return err
+		})
+	})
+	return credited, err
+}
+
+// RefundOrder 把已支付或已到账的订单标记为已退款，已到账的积分同时扣回，余额不足时不退款。
+// 订单已退款时返回原订单与 false，便于调用方重试渠道退款。
+func (r *RechargeRepository) RefundOrder(ctx context.Context, orderNo string, now time.Time) (*model.RechargeOrder, bool, error) {
+	var (
+		order    *model.RechargeOrder
+		refunded bool
+	)
+	err := retryOnConflict(func() error {
+		refunded = false
+		return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var current model.RechargeOrder
+			exist, err := FirstRecodeIgnoreError(tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_no = ?", orderNo), &current)
+			if err != nil {
+				return err
+			}
+			if !exist {
+				return errno.ErrRechargeNotFound
+			}
+			order = &current
+			switch current.Status {
+			case model.RechargeOrderRefunded:
+				return nil
+			case model.RechargeOrderCredited:
+				if _, err := applyChanges(tx, current.UserID, []PointsChange{{
+					Change:         -current.Points,
+					Type:           "spend",
+					Reason:         "recharge_refund",
+					RefType:        "recharge_order",
+					RefID:          current.OrderNo,
+					ExtraMap:       rechargeExtra(&current),
+					IdempotencyKey: "recharge_refund:" + current.OrderNo,
+				}}, 0); err != nil {
+					return err
+				}
+			case model.RechargeOrderPaid:
+			default:
+				return errno.ErrRechargeStatus
+			}
+			order, refunded, err = transitOrder(tx, orderNo, current.Status, model.RechargeOrderRefunded, map[string]interface{}{
+				"refunded_at": now,
+			})
+			return err
+		})
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return order, refunded, nil
+}
+
+// transitOrder 把处于 from 状态的订单切换到目标状态，以条件更新保证只生效一次。
+func transitOrder(tx *gorm.DB, orderNo, from, to string, updates map[string]interface{}) (*model.RechargeOrder, bool, error) {
+	updates["status"] = to
+	result := tx.Model(&model.RechargeOrder{}).
+		Where("order_no = ? AND status = ?", orderNo, from).
+		Updates(updates)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, false, nil
+	}
+	var order model.RechargeOrder
+	if err := tx.Where("order_no = ?", orderNo).First(&order).Error; err != nil {
+		return nil, false, err
+	}
+	return &order, true, nil
+}
+
+func rechargeExtra(order *model.RechargeOrder) map[string]string {
+	return map[string]string{
+		"package_id": order.PackageID,
+		"amount":     fmt.Sprintf("%d", order.Amount),
+		"provider":   order.Provider,
+	}
+}
+
+// RechargeRepositoryInterface 充值订单仓库接口。
+type RechargeRepositoryInterface interface {
+	CreateOrder(ctx context.Context, order *model.RechargeOrder) error
+	GetOrder(ctx context.Context, orderNo string) (*model.RechargeOrder, error)
+	GetOrderByIdempotencyKey(ctx context.Context, key string) (*model.RechargeOrder, error)
+	ListOrders(ctx context.Context, userID uint, limit, offset int) ([]model.RechargeOrder, error)
+	MarkPaid(ctx context.Context, orderNo, tradeNo string, paidAt time.Time) (bool, error)
+	CreditOrder(ctx context.Context, orderNo string, now time.Time) (bool, error)
+	RefundOrder(ctx context.Context, orderNo string, now time.Time) (*model.RechargeOrder, bool, error)
+}
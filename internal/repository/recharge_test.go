@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+func createPaidOrder(t *testing.T, repo *RechargeRepository, orderNo string, points int) {
+	t.Helper()
+	ctx := context.Background()
+	order := &model.RechargeOrder{OrderNo: orderNo, UserID: 1, PackageID: "p6", Amount: 600, Points: points, Provider: "fake", Status: model.RechargeOrderPending, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repo.CreateOrder(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := repo.MarkPaid(ctx, orderNo, "T"+orderNo, time.Now()); err != nil || !ok {
+		t.Fatalf("mark paid = %v, %v", ok, err)
+	}
+}
+
+func TestCreditOrder_ConcurrentCallbacks(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRechargeRepository(db)
+	ctx := context.Background()
+	createPaidOrder(t, repo, "R1", 60)
+
+	// 渠道重复通知与用户轮询并发触发入账
+	var credited int32
+	for _, err := range runConcurrently(8, func() error {
+		ok, err := repo.CreditOrder(ctx, "R1", time.Now())
+		if ok {
+			atomic.AddInt32(&credited, 1)
+		}
+		return err
+	}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if credited != 1 {
+		t.Fatalf("credited = %d, want 1", credited)
+	}
+	if ok, _ := repo.MarkPaid(ctx, "R1", "T2", time.Now()); ok {
+		t.Fatal("credited order should not be marked paid again")
+	}
+
+	points, err := NewPointsRepository(db).GetUserPoints(ctx, 1)
+	if err != nil || points == nil || points.Balance != 60 {
+		t.Fatalf("points = %+v, %v", points, err)
+	}
+	var ledgers int64
+	db.Model(&model.PointsLedger{}).Where("reason = ?", "recharge").Count(&ledgers)
+	if ledgers != 1 {
+		t.Fatalf("recharge ledgers = %d, want 1", ledgers)
+	}
+	order, _ := repo.GetOrder(ctx, "R1")
+	if order.Status != model.RechargeOrderCredited || order.CreditedAt == nil {
+		t.Fatalf("order = %+v", order)
+	}
+}
+
+func TestRefundOrder(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRechargeRepository(db)
+	points := NewPointsRepository(db)
+	ctx := context.Background()
+	createPaidOrder(t, repo, "R1", 60)
+	if _, err := repo.CreditOrder(ctx, "R1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 到账积分已被使用时不能退款
+	if _, err := points.ChangeBalance(ctx, 1, -30, "spend", "trim_use", "", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.RefundOrder(ctx, "R1", time.Now()); !errors.Is(err, errno.ErrPointsNotEnough) {
+		t.Fatalf("refund with spent points err = %v", err)
+	}
+	if _, err := points.ChangeBalance(ctx, 1, 30, "earn", "check_in", "", "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	order, refunded, err := repo.RefundOrder(ctx, "R1", time.Now())
+	if err != nil || !refunded || order.Status != model.RechargeOrderRefunded {
+		t.Fatalf("refund = %+v, %v, %v", order, refunded, err)
+	}
+	if _, refunded, err := repo.RefundOrder(ctx, "R1", time.Now()); err != nil || refunded {
+		t.Fatalf("repeated refund = %v, %v", refunded, err)
+	}
+	if p, _ := points.GetUserPoints(ctx, 1); p.Balance != 0 {
+		t.Fatalf("balance = %d, want 0", p.Balance)
+	}
+	if ok, _ := repo.CreditOrder(ctx, "R1", time.Now()); ok {
+		t.Fatal("refunded order should not be credited")
+	}
+
+	// 待支付订单不能退款
+	if err := repo.CreateOrder(ctx, &model.RechargeOrder{OrderNo: "R2", UserID: 1, PackageID: "p6", Amount: 600, Points: 60, Provider: "fake", Status: model.RechargeOrderPending, ExpiresAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.RefundOrder(ctx, "R2", time.Now()); !errors.Is(err, errno.ErrRechargeStatus) {
+		t.Fatalf("refund pending err = %v", err)
+	}
+}
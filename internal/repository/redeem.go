@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// redeemCodeBatch 批量写入兑换码时每批的数量。
+const redeemCodeBatch = 500
+
+// RedeemRepository 兑换码活动数据访问层。
+type RedeemRepository struct {
+	db *gorm.DB
+}
+
+// NewRedeemRepository 创建兑换码仓库。
+func NewRedeemRepository(db *gorm.DB) *RedeemRepository {
+	return &RedeemRepository{db: db}
+}
+
+// CreateCampaign 创建活动及其兑换码，兑换码与已有的重复时返回 gorm.ErrDuplicatedKey 且不写入任何数据。
+func (r *RedeemRepository) CreateCampaign(ctx context.Context, campaign *model.RedeemCampaign, codes []string) ([]model.RedeemCode, error) {
+	var created []model.RedeemCode
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(campaign).Error; err != nil {
+			return err
+		}
+		created = make([]model.RedeemCode, 0, len(codes))
+		for _, code := range codes {
+			created = append(created, model.RedeemCode{CampaignID: campaign.ID, Code: code})
+		}
+		return tx.CreateInBatches(&created, redeemCodeBatch).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetCampaign 获取活动，不存在时返回 nil。
+func (r *RedeemRepository) GetCampaign(ctx context.Context, id uint) (*model.RedeemCampaign, error) {
+	var campaign model.RedeemCampaign
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).Where("id = ?", id), &campaign)
+	if err != nil || !exist {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// ListCampaigns 按创建时间倒序获取活动。
+func (r *RedeemRepository) ListCampaigns(ctx context.Context, limit, offset int) ([]model.RedeemCampaign, error) {
+	var campaigns []model.RedeemCampaign
+	if err := r.db.WithContext(ctx).Order("id DESC").Limit(limit).Offset(offset).Find(&campaigns).Error; err != nil {
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+// SetCampaignDisabled 停用或启用活动。
+func (r *RedeemRepository) SetCampaignDisabled(ctx context.Context, id uint, disabled bool) error {
+	return r.db.WithContext(ctx).Model(&model.RedeemCampaign{}).Where("id = ?", id).Update("disabled", disabled).Error
+}
+
+// ListCodes 获取活动的兑换码。
+func (r *RedeemRepository) ListCodes(ctx context.Context, campaignID uint, limit, offset int) ([]model.RedeemCode, error) {
+	var codes []model.RedeemCode
+	if err := r.db.WithContext(ctx).Where("campaign_id = ?", campaignID).Order("id").Limit(limit).Offset(offset).Find(&codes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RedeemStats 活动的兑换统计。
+type RedeemStats struct {
+	CampaignID   uint  `json:"campaign_id"`
+	Codes        int64 `json:"codes"`         // 兑换码总数
+	UsedCodes    int64 `json:"used_codes"`    // 至少被兑换过一次的兑换码数
+	Redemptions  int64 `json:"redemptions"`   // 兑换次数
+	Users        int64 `json:"users"`         // 兑换过的用户数
+	PointsIssued int64 `json:"points_issued"` // 已发放积分
+}
+
+// CampaignStats 统计活动的兑换情况，未出现在结果中的活动没有任何兑换码。
+func (r *RedeemRepository) CampaignStats(ctx context.Context, campaignIDs []uint) (map[uint]*RedeemStats, error) {
+	stats := make(map[uint]*RedeemStats, len(campaignIDs))
+	if len(campaignIDs) == 0 {
+		return stats, nil
+	}
+
+	var codes []struct {
+		CampaignID uint
+		Codes      int64
+		UsedCodes  int64
+	}
+	if err := r.db.WithContext(ctx).Model(&model.RedeemCode{}).
+		Select("campaign_id, COUNT(*) AS codes, SUM(CASE WHEN used_count > 0 THEN 1 ELSE 0 END) AS used_codes").
+		Where("campaign_id IN ?", campaignIDs).
+		Group("campaign_id").
+		Scan(&codes).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range codes {
+		stats[row.CampaignID] = &RedeemStats{CampaignID: row.CampaignID, Codes: row.Codes, UsedCodes: row.UsedCodes}
+	}
+
+	var redemptions []struct {
+		CampaignID   uint
+		Redemptions  int64
+		Users        int64
+		PointsIssued int64
+	}
+	if err := r.db.WithContext(ctx).Model(&model.Redemption{}).
+		Select("campaign_id, COUNT(*) AS redemptions, COUNT(DISTINCT user_id) AS users, COALESCE(SUM(points), 0) AS points_issued").
+		Where("campaign_id IN ?", campaignIDs).
+		Group("campaign_id").
+		Scan(&redemptions).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range redemptions {
+		s, ok := stats[row.CampaignID]
+		if !ok {
+			s = &RedeemStats{CampaignID: row.CampaignID}
+			stats[row.CampaignID] = s
+		}
+		s.Redemptions, s.Users, s.PointsIssued = row.Redemptions, row.Users, row.PointsIssued
+	}
+	return stats, nil
+}
+
+// Redeem 兑换积分：校验活动与限制、计入兑换码使用次数、记录兑换并入账，全部在同一事务内完成。
+// 返回兑换记录与变更后的余额。
+func (r *RedeemRepository) Redeem(ctx context.Context, userID uint, code string, now time.Time) (*model.Redemption, int, error) {
+	var (
+		redemption *model.Redemption
+		balance    int
+	)
+	err := retryOnConflict(func() error {
+		return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			redemption, balance, err = redeem(tx, userID, code, now)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return redemption, balance, nil
+}
+
+func redeem(tx *gorm.DB, userID uint, code string, now time.Time) (*model.Redemption, int, error) {
+	var redeemCode model.RedeemCode
+	exist, err := FirstRecodeIgnoreError(tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", code), &redeemCode)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !exist {
+		return nil, 0, errno.ErrRedeemInvalid
+	}
+	var campaign model.RedeemCampaign
+	if err := tx.First(&campaign, redeemCode.CampaignID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, 0, errno.ErrRedeemInvalid
+		}
+		return nil, 0, err
+	}
+	if campaign.Disabled {
+		return nil, 0, errno.ErrRedeemInvalid
+	}
+	if (campaign.StartsAt != nil && now.Before(*campaign.StartsAt)) || (campaign.ExpiresAt != nil && !now.Before(*campaign.ExpiresAt)) {
+		return nil, 0, errno.ErrRedeemExpired
+	}
+
+	// 先锁定用户积分行，串行化同一用户的兑换，避免并发兑换同一活动的不同兑换码时绕过每人限制
+	if _, err := userPointsForUpdate(tx, userID); err != nil {
+		return nil, 0, err
+	}
+	var used int64
+	if err := tx.Model(&model.Redemption{}).Where("code_id = ? AND user_id = ?", redeemCode.ID, userID).Count(&used).Error; err != nil {
+		return nil, 0, err
+	}
+	if used > 0 {
+		return nil, 0, errno.ErrRedeemLimited
+	}
+	if campaign.PerUserLimit > 0 {
+		var times int64
+		if err := tx.Model(&model.Redemption{}).Where("campaign_id = ? AND user_id = ?", campaign.ID, userID).Count(&times).Error; err != nil {
+			return nil, 0, err
+		}
+		if times >= int64(campaign.PerUserLimit) {
+			return nil, 0, errno.ErrRedeemLimited
+		}
+	}
+
+	// 以条件更新计入使用次数，多次使用的兑换码被并发兑换时不会超发
+	update := tx.Model(&model.RedeemCode{}).Where("id = ?", redeemCode.ID)
+	if campaign.MaxUses > 0 {
+		update = update.Where("used_count < ?", campaign.MaxUses)
+	}
+	result := update.Update("used_count", gorm.Expr("used_count + 1"))
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, 0, errno.ErrRedeemExhausted
+	}
+
+	redemption := &model.Redemption{CampaignID: campaign.ID, CodeID: redeemCode.ID, UserID: userID, Points: campaign.Points}
+	if err := tx.Create(redemption).Error; err != nil {
+		return nil, 0, err
+	}
+	balance, err := applyChanges(tx, userID, []PointsChange{{
+		Change:  campaign.Points,
+		Type:    "earn",
+		Reason:  "redeem",
+		RefType: "redemption",
+		RefID:   fmt.Sprintf("%d", redemption.ID),
+		ExtraMap: map[string]string{
+			"campaign_id":   fmt.Sprintf("%d", campaign.ID),
+			"campaign_name": campaign.Name,
+			"code":          redeemCode.Code,
+		},
+		IdempotencyKey: fmt.Sprintf("redeem:%d:%d", redeemCode.ID, userID),
+	}}, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return redemption, balance, nil
+}
+
+// RedeemRepositoryInterface 兑换码仓库接口。
+type RedeemRepositoryInterface interface {
+	CreateCampaign(ctx context.Context, campaign *model.RedeemCampaign, codes []string) ([]model.RedeemCode, error)
+	GetCampaign(ctx context.Context, id uint) (*model.RedeemCampaign, error)
+	ListCampaigns(ctx context.Context, limit, offset int) ([]model.RedeemCampaign, error)
+	SetCampaignDisabled(ctx context.Context, id uint, disabled bool) error
+	ListCodes(ctx context.Context, campaignID uint, limit, offset int) ([]model.RedeemCode, error)
+	CampaignStats(ctx context.Context, campaignIDs []uint) (map[uint]*RedeemStats, error)
+	Redeem(ctx context.Context, userID uint, code string, now time.Time) (*model.Redemption, int, error)
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+func TestRedeem_MultiUseCode(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRedeemRepository(db)
+	points := NewPointsRepository(db)
+	ctx := context.Background()
+	now := time.Now()
+
+	campaign := &model.RedeemCampaign{Name: "spring", Points: 10, MaxUses: 3, PerUserLimit: 1, CreatedBy: 1}
+	if _, err := repo.CreateCampaign(ctx, campaign, []string{"SPRING"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 5 个用户并发兑换只有 3 次可用的兑换码
+	users := make(chan uint, 5)
+	for i := uint(1); i <= 5; i++ {
+		users <- i
+	}
+	exhausted := 0
+	for _, err := range runConcurrently(5, func() error {
+		_, _, err := repo.Redeem(ctx, <-users, "SPRING", now)
+		return err
+	}) {
+		if errors.Is(err, errno.ErrRedeemExhausted) {
+			exhausted++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exhausted != 2 {
+		t.Fatalf("exhausted = %d, want 2", exhausted)
+	}
+
+	stats, err := repo.CampaignStats(ctx, []uint{campaign.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := stats[campaign.ID]
+	if got == nil || got.Codes != 1 || got.UsedCodes != 1 || got.Redemptions != 3 || got.Users != 3 || got.PointsIssued != 30 {
+		t.Fatalf("stats = %+v", got)
+	}
+	var ledgers int64
+	db.Model(&model.PointsLedger{}).Where("reason = ?", "redeem").Count(&ledgers)
+	if ledgers != 3 {
+		t.Fatalf("redeem ledgers = %d, want 3", ledgers)
+	}
+	total := 0
+	for i := uint(1); i <= 5; i++ {
+		if p, _ := points.GetUserPoints(ctx, i); p != nil {
+			total += p.Balance
+		}
+	}
+	if total != 30 {
+		t.Fatalf("total balance = %d, want 30", total)
+	}
+}
+
+func TestRedeem_PerUserLimitConcurrent(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRedeemRepository(db)
+	ctx := context.Background()
+	now := time.Now()
+
+	campaign := &model.RedeemCampaign{Name: "summer", Points: 10, PerUserLimit: 1, CreatedBy: 1}
+	if _, err := repo.CreateCampaign(ctx, campaign, []string{"SUMMER-A", "SUMMER-B"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一用户并发兑换同一活动的两个兑换码，只能成功一次
+	codes := make(chan string, 2)
+	codes <- "SUMMER-A"
+	codes <- "SUMMER-B"
+	limited := 0
+	for _, err := range runConcurrently(2, func() error {
+		_, _, err := repo.Redeem(ctx, 1, <-codes, now)
+		return err
+	}) {
+		if errors.Is(err, errno.ErrRedeemLimited) {
+			limited++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if limited != 1 {
+		t.Fatalf("limited = %d, want 1", limited)
+	}
+	balance, err := NewPointsRepository(db).GetUserPoints(ctx, 1)
+	if err != nil || balance == nil || balance.Balance != 10 {
+		t.Fatalf("balance = %+v, %v", balance, err)
+	}
+}
+
+func TestRedeem_Limits(t *testing.T) {
+	repo := NewRedeemRepository(setupTestDB(t))
+	ctx := context.Background()
+	now := time.Now()
+	expired := now.Add(-time.Hour)
+
+	campaign := &model.RedeemCampaign{Name: "batch", Points: 5, MaxUses: 0, PerUserLimit: 2, CreatedBy: 1}
+	if _, err := repo.CreateCampaign(ctx, campaign, []string{"CODE1", "CODE2", "CODE3"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateCampaign(ctx, &model.RedeemCampaign{Name: "dup", Points: 1, CreatedBy: 1}, []string{"CODE1"}); err == nil {
+		t.Fatal("duplicate code should be rejected")
+	}
+	if _, err := repo.CreateCampaign(ctx, &model.RedeemCampaign{Name: "old", Points: 1, ExpiresAt: &expired, CreatedBy: 1}, []string{"OLD"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		want error
+	}{
+		{"first redeem", "CODE1", nil},
+		{"same code twice", "CODE1", errno.ErrRedeemLimited},
+		{"second code", "CODE2", nil},
+		{"per user limit", "CODE3", errno.ErrRedeemLimited},
+		{"expired campaign", "OLD", errno.ErrRedeemExpired},
+		{"unknown code", "NOPE", errno.ErrRedeemInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := repo.Redeem(ctx, 1, tt.code, now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
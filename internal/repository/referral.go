@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+	"gorm.io/gorm"
+)
+
+// ReferralRepository 邀请码与邀请关系数据访问层。
+type ReferralRepository struct {
+	db *gorm.DB
+}
+
+// NewReferralRepository 创建邀请仓库。
+func NewReferralRepository(db *gorm.DB) *ReferralRepository {
+	return &ReferralRepository{db: db}
+}
+
+// GetInviteCodeByUser 获取用户的邀请码，不存在时返回 nil。
+func (r *ReferralRepository) GetInviteCodeByUser(ctx context.Context, userID uint) (*model.InviteCode, error) {
+	var code model.InviteCode
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).Where("user_id = ?", userID), &code)
+	if err != nil || !exist {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// GetInviteCode 按邀请码查询，不存在时返回 nil。
+func (r *ReferralRepository) GetInviteCode(ctx context.Context, code string) (*model.InviteCode, error) {
+	var invite model.InviteCode
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).Where("code = ?", code), &invite)
+	if err != nil || !exist {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// CreateInviteCode 创建邀请码，用户或邀请码已存在时返回 gorm.ErrDuplicatedKey。
+func (r *ReferralRepository) CreateInviteCode(ctx context.Context, code *model.InviteCode) error {
+	return r.db.WithContext(ctx).Create(code).Error
+}
+
+// CreateReferral 记录邀请关系，被邀请人已有邀请关系时返回 gorm.ErrDuplicatedKey。
+func (r *ReferralRepository) CreateReferral(ctx context.Context, referral *model.Referral) error {
+	return r.db.WithContext(ctx).Create(referral).Error
+}
+
+// CountReferrals 统计用户邀请的人数。
+func (r *ReferralRepository) CountReferrals(ctx context.Context, referrerID uint) (int64, error) {
+	var count int64
+	if err := r.db.WithContext(ctx).Model(&model.Referral{}).Where("referrer_id = ?", referrerID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ReferralRepositoryInterface 邀请仓库接口。
+type ReferralRepositoryInterface interface {
+	GetInviteCodeByUser(ctx context.Context, userID uint) (*model.InviteCode, error)
+	GetInviteCode(ctx context.Context, code string) (*model.InviteCode, error)
+	CreateInviteCode(ctx context.Context, code *model.InviteCode) error
+	CreateReferral(ctx context.Context, referral *model.Referral) error
+	CountReferrals(ctx context.Context, referrerID uint) (int64, error)
+}
@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SubscriptionRepository 订阅与额度使用数据访问层。
+type SubscriptionRepository struct {
+	db *gorm.DB
+}
+
+// NewSubscriptionRepository 创建订阅仓库。
+func NewSubscriptionRepository(db *gorm.DB) *SubscriptionRepository {
+	return &SubscriptionRepository{db: db}
+}
+
+// CreateSubscription 创建订阅。
+func (r *SubscriptionRepository) CreateSubscription(ctx context.Context, sub *model.Subscription) error {
+	return r.db.WithContext(ctx).Create(sub).Error
+}
+
+// ExtendSubscription 修改订阅到期时间。
+func (r *SubscriptionRepository) ExtendSubscription(ctx context.Context, id uint, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&model.Subscription{}).Where("id = ?", id).Update("expires_at", expiresAt).Error
+}
+
+// GetActiveSubscription 获取用户在 now 时生效的订阅，没有时返回 nil。
+func (r *SubscriptionRepository) GetActiveSubscription(ctx context.Context, userID uint, now time.Time) (*model.Subscription, error) {
+	var sub model.Subscription
+	exist, err := FirstRecodeIgnoreError(r.db.WithContext(ctx).
+		Where("user_id = ? AND starts_at <= ? AND expires_at > ?", userID, now, now).
+		Order("expires_at DESC"), &sub)
+	if err != nil || !exist {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// QuotaUsageRequest 占用一章订阅额度的请求。
+type QuotaUsageRequest struct {
+	PromptID       uint
+	RefType        string
+	RefID          string
+	ExpiresAt      time.Time
+	IdempotencyKey string // 非空时相同键在使用中或已结算期间不再重复占用
+}
+
+// QuotaPeriod 计费周期 [Start, End)。
+type QuotaPeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ReserveQuota 在计费周期内按请求顺序占用额度，quota 为 0 表示不限。返回结果与 reqs 顺序一致，
+// 额度不足未能占用的请求对应 ID 为 0 的记录；幂等键已有记录的请求直接返回原记录。
+func (r *SubscriptionRepository) ReserveQuota(ctx context.Context, sub *model.Subscription, period QuotaPeriod, quota int, reqs []QuotaUsageRequest) ([]model.QuotaUsage, error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+
+	var usages []model.QuotaUsage
+	err := retryOnConflict(func() error {
+		return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			usages, err = reserveQuota(tx, sub, period, quota, reqs)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
+
+func reserveQuota(tx *gorm.DB, sub *model.Subscription, period QuotaPeriod, quota int, reqs []QuotaUsageRequest) ([]model.QuotaUsage, error) {
+	// 锁住订阅记录，同一订阅的并发占用串行执行，避免超出额度
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&model.Subscription{}, sub.ID).Error; err != nil {
+		return nil, err
+	}
+	existing, err := usagesByKey(tx, reqs)
+	if err != nil {
+		return nil, err
+	}
+	used, held, err := countUsage(tx, sub.ID, period)
+	if err != nil {
+		return nil, err
+	}
+	available := quota - used - held
+
+	usages := make([]model.QuotaUsage, len(reqs))
+	created := make([]model.QuotaUsage, 0, len(reqs))
+	index := make([]int, 0, len(reqs))
+	for i, req := range reqs {
+		if usage, ok := existing[req.IdempotencyKey]; ok {
+			usages[i] = usage
+			continue
+		}
+		if quota > 0 && available <= 0 {
+			continue
+		}
+		available--
+		created = append(created, model.QuotaUsage{
+			SubscriptionID: sub.ID,
+			PeriodStart:    period.Start,
+			UserID:         sub.UserID,
+			PromptID:       req.PromptID,
+			Status:         model.QuotaUsageHeld,
+			RefType:        req.RefType,
+			RefID:          req.RefID,
+			ExpiresAt:      req.ExpiresAt,
+			IdempotencyKey: idempotencyKeyPtr(req.IdempotencyKey),
+		})
+		index = append(index, i)
+	}
+	if len(created) == 0 {
+		return usages, nil
+	}
+	if err := tx.Create(&created).Error; err != nil {
+		return nil, err
+	}
+	for i, usage := range created {
+		usages[index[i]] = usage
+	}
+	return usages, nil
+}
+
+// usagesByKey 查询幂等键对应的现有额度使用记录。
+func usagesByKey(tx *gorm.DB, reqs []QuotaUsageRequest) (map[string]model.QuotaUsage, error) {
+	keys := make([]string, 0, len(reqs))
+	for _, req := range reqs {
+		if req.IdempotencyKey != "" {
+			keys = append(keys, req.IdempotencyKey)
+		}
+	}
+	existing := make(map[string]model.QuotaUsage, len(keys))
+	if len(keys) == 0 {
+		return existing, nil
+	}
+	var usages []model.QuotaUsage
+	if err := tx.Where("idempotency_key IN ?", keys).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	for _, usage := range usages {
+		existing[*usage.IdempotencyKey] = usage
+	}
+	return existing, nil
+}
+
+// CountUsage 统计计费周期内已结算与使用中的章节数。
+func (r *SubscriptionRepository) CountUsage(ctx context.Context, subscriptionID uint, period QuotaPeriod) (int, int, error) {
+	return countUsage(r.db.WithContext(ctx), subscriptionID, period)
+}
+
+func countUsage(tx *gorm.DB, subscriptionID uint, period QuotaPeriod) (int, int, error) {
+	var rows []struct {
+		Status string
+		Total  int
+	}
+	if err := tx.Model(&model.QuotaUsage{}).
+		Select("status, COUNT(*) AS total").
+		Where("subscription_id = ? AND period_start >= ? AND period_start < ? AND status IN ?",
+			subscriptionID, period.Start, period.End, []string{model.QuotaUsageHeld, model.QuotaUsageSettled}).
+		Group("status").
+		Scan(&rows).Error; err != nil {
+		return 0, 0, err
+	}
+	used, held := 0, 0
+	for _, row := range rows {
+		if row.Status == model.QuotaUsageSettled {
+			used = row.Total
+		} else {
+			held = row.Total
+		}
+	}
+	return used, held, nil
+}
+
+// SettleUsage 结算额度使用记录。记录已结算或已释放时返回 false。
+func (r *SubscriptionRepository) SettleUsage(ctx context.Context, id uint) (bool, error) {
+	return r.transitUsage(ctx, id, model.QuotaUsageSettled)
+}
+
+// ReleaseUsage 释放额度使用记录，额度退回本周期。记录已结算或已释放时返回 false。
+func (r *SubscriptionRepository) ReleaseUsage(ctx context.Context, id uint) (bool, error) {
+	return r.transitUsage(ctx, id, model.QuotaUsageReleased)
+}
+
+// transitUsage 把使用中的记录切换到目标状态，以条件更新保证只生效一次。
+// 释放时清空幂等键，之后相同键的请求可以重新占用。
+func (r *SubscriptionRepository) transitUsage(ctx context.Context, id uint, status string) (bool, error) {
+	updates := map[string]interface{}{"status": status}
+	if status == model.QuotaUsageReleased {
+		updates["idempotency_key"] = nil
+	}
+	result := r.db.WithContext(ctx).Model(&model.QuotaUsage{}).
+		Where("id = ? AND status = ?", id, model.QuotaUsageHeld).
+		Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ListExpiredUsages 获取已过期仍未结算的额度使用记录。
+func (r *SubscriptionRepository) ListExpiredUsages(ctx context.Context, before time.Time, limit int) ([]model.QuotaUsage, error) {
+	var usages []model.QuotaUsage
+	if err := r.db.WithContext(ctx).
+		Where("status = ? AND expires_at < ?", model.QuotaUsageHeld, before).
+		Order("id").
+		Limit(limit).
+		Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
+
+// ListHeldUsagesByRef 获取关联对象上仍未结算的额度使用记录。
+func (r *SubscriptionRepository) ListHeldUsagesByRef(ctx context.Context, refType string, refIDs []string) ([]model.QuotaUsage, error) {
+	if len(refIDs) == 0 {
+		return nil, nil
+	}
+	var usages []model.QuotaUsage
+	if err := r.db.WithContext(ctx).
+		Where("status = ? AND ref_type = ? AND ref_id IN ?", model.QuotaUsageHeld, refType, refIDs).
+		Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
+
+// ExtendHeldUsagesByRef 将关联对象上仍未结算的额度使用记录有效期顺延至 expiresAt，返回顺延数量。
+func (r *SubscriptionRepository) ExtendHeldUsagesByRef(ctx context.Context, refType string, refIDs []string, expiresAt time.Time) (int, error) {
+	if len(refIDs) == 0 {
+		return 0, nil
+	}
+	result := r.db.WithContext(ctx).Model(&model.QuotaUsage{}).
+		Where("status = ? AND ref_type = ? AND ref_id IN ? AND expires_at < ?", model.QuotaUsageHeld, refType, refIDs, expiresAt).
+		Update("expires_at", expiresAt)
+	return int(result.RowsAffected), result.Error
+}
+
+// SubscriptionRepositoryInterface 订阅仓库接口。
+type SubscriptionRepositoryInterface interface {
+	CreateSubscription(ctx context.Context, sub *model.Subscription) error
+	ExtendSubscription(ctx context.Context, id uint, expiresAt time.Time) error
+	GetActiveSubscription(ctx context.Context, userID uint, now time.Time) (*model.Subscription, error)
+	ReserveQuota(ctx context.Context, sub *model.Subscription, period QuotaPeriod, quota int, reqs []QuotaUsageRequest) ([]model.QuotaUsage, error)
+	CountUsage(ctx context.Context, subscriptionID uint, period QuotaPeriod) (int, int, error)
+	SettleUsage(ctx context.Context, id uint) (bool, error)
+	ReleaseUsage(ctx context.Context, id uint) (bool, error)
+	ListExpiredUsages(ctx context.Context, before time.Time, limit int) ([]model.QuotaUsage, error)
+	ListHeldUsagesByRef(ctx context.Context, refType string, refIDs []string) ([]model.QuotaUsage, error)
+	ExtendHeldUsagesByRef(ctx context.Context, refType string, refIDs []string, expiresAt time.Time) (int, error)
+}
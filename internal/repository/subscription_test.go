@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+func TestReserveQuota_ConcurrentLimit(t *testing.T) {
+	repo := NewSubscriptionRepository(setupTestDB(t))
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	sub := &model.Subscription{UserID: 1, PlanID: "monthly", StartsAt: now, ExpiresAt: now.AddDate(0, 1, 0), CreatedBy: 1}
+	if err := repo.CreateSubscription(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	period := QuotaPeriod{Start: sub.StartsAt, End: sub.ExpiresAt}
+	request := func(ref int, key string) []QuotaUsageRequest {
+		return []QuotaUsageRequest{{PromptID: 1, RefType: "task_item", RefID: fmt.Sprintf("%d", ref), ExpiresAt: now.Add(time.Hour), IdempotencyKey: key}}
+	}
+
+	// 8 个请求并发占用只有 3 章的额度
+	var refs, reserved int32
+	for _, err := range runConcurrently(8, func() error {
+		usages, err := repo.ReserveQuota(ctx, sub, period, 3, request(int(atomic.AddInt32(&refs, 1)), ""))
+		if err == nil && usages[0].ID != 0 {
+			atomic.AddInt32(&reserved, 1)
+		}
+		return err
+	}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reserved != 3 {
+		t.Fatalf("reserved = %d, want 3", reserved)
+	}
+
+	held, err := repo.ListHeldUsagesByRef(ctx, "task_item", []string{"1", "2", "3", "4", "5", "6", "7", "8"})
+	if err != nil || len(held) != 3 {
+		t.Fatalf("held = %d, %v", len(held), err)
+	}
+	if ok, _ := repo.SettleUsage(ctx, held[0].ID); !ok {
+		t.Fatal("settle should succeed")
+	}
+	if ok, _ := repo.ReleaseUsage(ctx, held[0].ID); ok {
+		t.Fatal("settled usage should not be released")
+	}
+	// 释放的额度退回本周期
+	if ok, _ := repo.ReleaseUsage(ctx, held[1].ID); !ok {
+		t.Fatal("release should succeed")
+	}
+	usages, err := repo.ReserveQuota(ctx, sub, period, 3, request(9, "trim:9"))
+	if err != nil || usages[0].ID == 0 {
+		t.Fatalf("reserve after release = %+v, %v", usages, err)
+	}
+	again, err := repo.ReserveQuota(ctx, sub, period, 3, request(9, "trim:9"))
+	if err != nil || again[0].ID != usages[0].ID {
+		t.Fatalf("idempotent reserve = %+v, %v", again, err)
+	}
+
+	used, heldCount, err := repo.CountUsage(ctx, sub.ID, period)
+	if err != nil || used != 1 || heldCount != 2 {
+		t.Fatalf("usage = %d used, %d held, %v", used, heldCount, err)
+	}
+	// 下一计费周期额度重新计算
+	next := QuotaPeriod{Start: period.End, End: period.End.AddDate(0, 1, 0)}
+	if used, heldCount, _ := repo.CountUsage(ctx, sub.ID, next); used != 0 || heldCount != 0 {
+		t.Fatalf("next period usage = %d, %d", used, heldCount)
+	}
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 
 	"github.com/zqr233qr/story-trim/internal/model"
 	"gorm.io/gorm"
@@ -93,6 +94,27 @@ func (r *TaskRepository) GetActiveTasksCountByUserID(ctx context.Context, userID
 	return count, nil
 }
 
+// GetUnfinishedTasks 获取所有待处理或处理中的任务。
+func (r *TaskRepository) GetUnfinishedTasks(ctx context.Context) ([]*model.Task, error) {
+	var ts []*model.Task
+	if err := r.db.WithContext(ctx).Where("status IN ?", []string{"pending", "running"}).Find(&ts).Error; err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// GetTaskByIdempotencyKey 按幂等键获取任务，不存在时返回 nil。
+func (r *TaskRepository) GetTaskByIdempotencyKey(ctx context.Context, key string) (*model.Task, error) {
+	var t model.Task
+	if err := r.db.WithContext(ctx).Where("idempotency_key = ?", key).First(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
 type TaskRepositoryInterface interface {
 	CreateTask(ctx context.Context, task *model.Task) error
 	UpdateTask(ctx context.Context, task *model.Task) error
@@ -100,4 +122,6 @@ type TaskRepositoryInterface interface {
 	GetActiveTasksByUserID(ctx context.Context, userID uint) ([]*model.Task, error)
 	GetActiveTasksWithDetails(ctx context.Context, userID uint) ([]*TaskWithDetail, error)
 	GetActiveTasksCountByUserID(ctx context.Context, userID uint) (int64, error)
+	GetUnfinishedTasks(ctx context.Context) ([]*model.Task, error)
+	GetTaskByIdempotencyKey(ctx context.Context, key string) (*model.Task, error)
 }
@@ -0,0 +1,448 @@
+package service
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	annotationContextRunes = 16 // 锚点前后文长度
+	bookmarkExcerptRunes   = 50 // 书签摘录长度
+)
+
+// AnnotationService 书签、划线与笔记服务。
+type AnnotationService struct {
+	repo     repository.AnnotationRepositoryInterface
+	bookRepo repository.BookRepositoryInterface
+}
+
+// NewAnnotationService 创建笔记服务。
+func NewAnnotationService(repo repository.AnnotationRepositoryInterface, bookRepo repository.BookRepositoryInterface) *AnnotationService {
+	return &AnnotationService{repo: repo, bookRepo: bookRepo}
+}
+
+// CreateAnnotationReq 创建笔记参数。
+type CreateAnnotationReq struct {
+	BookID      uint   `json:"book_id" binding:"required"`
+	ChapterID   uint   `json:"chapter_id" binding:"required"`
+	PromptID    uint   `json:"prompt_id"`
+	Type        string `json:"type" binding:"required"`
+	StartOffset int    `json:"start_offset"`
+	EndOffset   int    `json:"end_offset"`
+	Note        string `json:"note"`
+	Color       string `json:"color"`
+}
+
+// UpdateAnnotationReq 更新笔记参数，偏移为空时保持原锚点。
+type UpdateAnnotationReq struct {
+	StartOffset *int    `json:"start_offset"`
+	EndOffset   *int    `json:"end_offset"`
+	Note        *string `json:"note"`
+	Color       *string `json:"color"`
+}
+
+// ListAnnotationsReq 笔记查询参数。
+type ListAnnotationsReq struct {
+	BookID    uint   `form:"book_id"`
+	ChapterID uint   `form:"chapter_id"`
+	Type      string `form:"type"`
+	Since     int64  `form:"since"` // 毫秒时间戳，用于增量同步
+}
+
+// anchorText 锚定所用的章节文本。
+type anchorText struct {
+	runes []rune
+	hash  string
+}
+
+// CreateAnnotation 创建书签、划线或笔记。
+func (s *AnnotationService) CreateAnnotation(ctx context.Context, userID uint, req *CreateAnnotationReq) (*model.Annotation, error) {
+	switch req.Type {
+	case model.AnnotationTypeBookmark:
+		req.EndOffset = req.StartOffset
+	case model.AnnotationTypeHighlight, model.AnnotationTypeNote:
+	default:
+		return nil, errno.ErrParam
+	}
+
+	book, err := s.bookRepo.GetBookByIDWithUser(ctx, userID, req.BookID)
+	if err != nil {
+		return nil, err
+	}
+	if book == nil {
+		return nil, errno.ErrBookNotFound
+	}
+	chapter, err := s.bookRepo.GetChapterByID(ctx, req.ChapterID)
+	if err != nil || chapter.BookID != req.BookID {
+		return nil, errno.ErrChapterNotFound
+	}
+
+	text, err := s.loadAnchorText(ctx, chapter.ChapterMD5, req.PromptID)
+	if err != nil {
+		return nil, err
+	}
+
+	annotation := &model.Annotation{
+		UserID:    userID,
+		BookID:    req.BookID,
+		ChapterID: req.ChapterID,
+		PromptID:  req.PromptID,
+		Type:      req.Type,
+		Note:      req.Note,
+		Color:     req.Color,
+	}
+	if err := applyAnchor(annotation, text, req.StartOffset, req.EndOffset); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.CreateAnnotation(ctx, annotation); err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+// UpdateAnnotation 更新笔记内容或位置。
+func (s *AnnotationService) UpdateAnnotation(ctx context.Context, userID uint, id uint, req *UpdateAnnotationReq) (*model.Annotation, error) {
+	annotation, err := s.repo.GetAnnotation(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if annotation == nil {
+		return nil, errno.ErrAnnotationNotFound
+	}
+
+	if req.StartOffset == nil && req.EndOffset == nil {
+		// 精简结果重新生成过时，随本次修改保存重新定位后的锚点
+		annotations := []model.Annotation{*annotation}
+		s.reanchorTrimmed(ctx, annotations)
+		annotation = &annotations[0]
+	} else {
+		start, end := annotation.StartOffset, annotation.EndOffset
+		if req.StartOffset != nil {
+			start = *req.StartOffset
+		}
+		if req.EndOffset != nil {
+			end = *req.EndOffset
+		}
+		if annotation.Type == model.AnnotationTypeBookmark {
+			end = start
+		}
+
+		chapter, err := s.bookRepo.GetChapterByID(ctx, annotation.ChapterID)
+		if err != nil {
+			return nil, errno.ErrChapterNotFound
+		}
+		text, err := s.loadAnchorText(ctx, chapter.ChapterMD5, annotation.PromptID)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyAnchor(annotation, text, start, end); err != nil {
+			return nil, err
+		}
+	}
+	if req.Note != nil {
+		annotation.Note = *req.Note
+	}
+	if req.Color != nil {
+		annotation.Color = *req.Color
+	}
+
+	if err := s.repo.UpdateAnnotation(ctx, annotation); err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+// DeleteAnnotation 删除笔记。
+func (s *AnnotationService) DeleteAnnotation(ctx context.Context, userID uint, id uint) error {
+	if err := s.repo.DeleteAnnotation(ctx, userID, id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errno.ErrAnnotationNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// ListAnnotations 获取笔记列表，精简结果重新生成过的在返回前重新锚定。
+func (s *AnnotationService) ListAnnotations(ctx context.Context, userID uint, req *ListAnnotationsReq) ([]model.Annotation, error) {
+	filter := repository.AnnotationFilter{
+		BookID:    req.BookID,
+		ChapterID: req.ChapterID,
+		Type:      req.Type,
+	}
+	if req.Since > 0 {
+		filter.Since = time.UnixMilli(req.Since)
+	}
+	annotations, err := s.repo.ListAnnotations(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	s.reanchorTrimmed(ctx, annotations)
+	return annotations, nil
+}
+
+// ExportMarkdown 将整本书的笔记导出为 Markdown。
+func (s *AnnotationService) ExportMarkdown(ctx context.Context, userID uint, bookID uint) (string, error) {
+	book, err := s.bookRepo.GetBookByIDWithUser(ctx, userID, bookID)
+	if err != nil {
+		return "", err
+	}
+	if book == nil {
+		return "", errno.ErrBookNotFound
+	}
+
+	annotations, err := s.repo.ListAnnotations(ctx, userID, repository.AnnotationFilter{BookID: bookID})
+	if err != nil {
+		return "", err
+	}
+	s.reanchorTrimmed(ctx, annotations)
+
+	chapters, err := s.bookRepo.GetChaptersByBookID(ctx, bookID)
+	if err != nil {
+		return "", err
+	}
+	chapterMap := make(map[uint]model.Chapter, len(chapters))
+	for _, chapter := range chapters {
+		chapterMap[chapter.ID] = chapter
+	}
+	promptNames := make(map[uint]string)
+	if prompts, err := s.bookRepo.ListSystemPrompts(ctx); err == nil {
+		for _, prompt := range prompts {
+			promptNames[prompt.ID] = prompt.Name
+		}
+	}
+
+	sort.SliceStable(annotations, func(i, j int) bool {
+		ci, cj := chapterMap[annotations[i].ChapterID], chapterMap[annotations[j].ChapterID]
+		if ci.Index != cj.Index {
+			return ci.Index < cj.Index
+		}
+		return annotations[i].StartOffset < annotations[j].StartOffset
+	})
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# %s\n\n", book.Title))
+	sb.WriteString(fmt.Sprintf("> 导出时间：%s，共 %d 条\n", time.Now().Format("2006-01-02 15:04:05"), len(annotations)))
+
+	var lastChapterID uint
+	for _, annotation := range annotations {
+		if annotation.ChapterID != lastChapterID {
+			lastChapterID = annotation.ChapterID
+			title := chapterMap[annotation.ChapterID].Title
+			if title == "" {
+				title = fmt.Sprintf("章节 %d", annotation.ChapterID)
+			}
+			sb.WriteString(fmt.Sprintf("\n## %s\n", title))
+		}
+
+		sb.WriteString("\n")
+		source := "原文"
+		if annotation.PromptID > 0 {
+			source = promptNames[annotation.PromptID]
+			if source == "" {
+				source = fmt.Sprintf("精简模式 %d", annotation.PromptID)
+			}
+		}
+		switch annotation.Type {
+		case model.AnnotationTypeBookmark:
+			sb.WriteString(fmt.Sprintf("- 🔖 书签（%s）：%s\n", source, annotation.SelectedText))
+		default:
+			for _, line := range strings.Split(strings.TrimSpace(annotation.SelectedText), "\n") {
+				sb.WriteString("> " + line + "\n")
+			}
+			sb.WriteString(fmt.Sprintf(">\n> —— %s\n", source))
+		}
+		if annotation.Note != "" {
+			sb.WriteString("\n" + annotation.Note + "\n")
+		}
+	}
+	return sb.String(), nil
+}
+
+// loadAnchorText 加载原文或精简文本用于锚定。
+func (s *AnnotationService) loadAnchorText(ctx context.Context, chapterMD5 string, promptID uint) (*anchorText, error) {
+	content := ""
+	if promptID > 0 {
+		trim, err := s.bookRepo.GetTrimResult(ctx, chapterMD5, promptID)
+		if err != nil {
+			return nil, err
+		}
+		if trim == nil {
+			return nil, errno.ErrTrimNotFound
+		}
+		content = trim.TrimContent
+	} else {
+		raw, err := s.bookRepo.GetRawContent(ctx, chapterMD5)
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			return nil, errno.ErrChapterNotFound
+		}
+		content = raw.Content
+	}
+	return &anchorText{runes: []rune(content), hash: textHash(content)}, nil
+}
+
+// reanchorTrimmed 对精简结果已变化的笔记在内存中重新定位，不写回数据库；
+// 新锚点随用户下一次修改笔记时保存。章节与精简结果按批加载。
+func (s *AnnotationService) reanchorTrimmed(ctx context.Context, annotations []model.Annotation) {
+	chapterIDs := make([]uint, 0)
+	seen := make(map[uint]struct{})
+	for _, annotation := range annotations {
+		if annotation.PromptID == 0 || annotation.DeletedAt.Valid {
+			continue
+		}
+		if _, ok := seen[annotation.ChapterID]; !ok {
+			seen[annotation.ChapterID] = struct{}{}
+			chapterIDs = append(chapterIDs, annotation.ChapterID)
+		}
+	}
+	if len(chapterIDs) == 0 {
+		return
+	}
+
+	chapters, err := s.bookRepo.GetChaptersByIDs(ctx, chapterIDs)
+	if err != nil {
+		logger.Warn().Err(err).Msg("笔记重新锚定加载章节失败")
+		return
+	}
+	md5s := make(map[uint]string, len(chapters))
+	for _, chapter := range chapters {
+		md5s[chapter.ID] = chapter.ChapterMD5
+	}
+	promptMD5s := make(map[uint][]string)
+	for _, annotation := range annotations {
+		if md5, ok := md5s[annotation.ChapterID]; ok && annotation.PromptID > 0 && !annotation.DeletedAt.Valid {
+			promptMD5s[annotation.PromptID] = append(promptMD5s[annotation.PromptID], md5)
+		}
+	}
+	texts := make(map[string]*anchorText)
+	for promptID, list := range promptMD5s {
+		trims, err := s.bookRepo.GetTrimResultsByMD5s(ctx, list, promptID)
+		if err != nil {
+			logger.Warn().Err(err).Uint("prompt_id", promptID).Msg("笔记重新锚定加载精简结果失败")
+			continue
+		}
+		for md5, trim := range trims {
+			texts[fmt.Sprintf("%s:%d", md5, promptID)] = &anchorText{runes: []rune(trim.TrimContent), hash: textHash(trim.TrimContent)}
+		}
+	}
+
+	for i := range annotations {
+		annotation := &annotations[i]
+		if annotation.PromptID == 0 || annotation.DeletedAt.Valid {
+			continue
+		}
+		text := texts[fmt.Sprintf("%s:%d", md5s[annotation.ChapterID], annotation.PromptID)]
+		if text == nil || text.hash == annotation.AnchorHash {
+			continue
+		}
+
+		start, ok := reanchor(text.runes, annotation.SelectedText, annotation.AnchorPrefix, annotation.AnchorSuffix, annotation.StartOffset)
+		if ok {
+			length := annotation.EndOffset - annotation.StartOffset
+			_ = applyAnchor(annotation, text, start, start+length)
+			annotation.Stale = false
+		} else {
+			annotation.AnchorHash = text.hash
+			annotation.Stale = true
+		}
+	}
+}
+
+// applyAnchor 校验偏移并写入选中文本与前后文。
+func applyAnchor(annotation *model.Annotation, text *anchorText, start, end int) error {
+	total := len(text.runes)
+	if start < 0 || end < start || end > total {
+		return errno.ErrAnnotationInvalid
+	}
+	if annotation.Type != model.AnnotationTypeBookmark && end == start {
+		return errno.ErrAnnotationInvalid
+	}
+
+	selectedEnd := end
+	if annotation.Type == model.AnnotationTypeBookmark {
+		selectedEnd = min(start+bookmarkExcerptRunes, total)
+	}
+	annotation.StartOffset = start
+	annotation.EndOffset = end
+	annotation.SelectedText = string(text.runes[start:selectedEnd])
+	annotation.AnchorPrefix = string(text.runes[max(0, start-annotationContextRunes):start])
+	annotation.AnchorSuffix = string(text.runes[selectedEnd:min(total, selectedEnd+annotationContextRunes)])
+	annotation.AnchorHash = text.hash
+	annotation.Stale = false
+	return nil
+}
+
+// reanchor 在新文本中查找选中片段，优先前后文匹配的位置，其次离原偏移最近的位置。
+func reanchor(content []rune, selected, prefix, suffix string, oldStart int) (int, bool) {
+	target := []rune(selected)
+	if len(target) == 0 || len(target) > len(content) {
+		return 0, false
+	}
+	prefixRunes := []rune(prefix)
+	suffixRunes := []rune(suffix)
+
+	best, bestScore, bestDistance := -1, -1, 0
+	for i := 0; i+len(target) <= len(content); i++ {
+		if !runesEqual(content[i:i+len(target)], target) {
+			continue
+		}
+		score := 0
+		if len(prefixRunes) > 0 && i >= len(prefixRunes) && runesEqual(content[i-len(prefixRunes):i], prefixRunes) {
+			score++
+		}
+		end := i + len(target)
+		if len(suffixRunes) > 0 && end+len(suffixRunes) <= len(content) && runesEqual(content[end:end+len(suffixRunes)], suffixRunes) {
+			score++
+		}
+		distance := i - oldStart
+		if distance < 0 {
+			distance = -distance
+		}
+		if score > bestScore || (score == bestScore && distance < bestDistance) {
+			best, bestScore, bestDistance = i, score, distance
+		}
+	}
+	return best, best >= 0
+}
+
+func runesEqual(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func textHash(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// AnnotationServiceInterface 笔记服务接口。
+type AnnotationServiceInterface interface {
+	CreateAnnotation(ctx context.Context, userID uint, req *CreateAnnotationReq) (*model.Annotation, error)
+	UpdateAnnotation(ctx context.Context, userID uint, id uint, req *UpdateAnnotationReq) (*model.Annotation, error)
+	DeleteAnnotation(ctx context.Context, userID uint, id uint) error
+	ListAnnotations(ctx context.Context, userID uint, req *ListAnnotationsReq) ([]model.Annotation, error)
+	ExportMarkdown(ctx context.Context, userID uint, bookID uint) (string, error)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+)
+
+func TestReanchorPrefersMatchingContext(t *testing.T) {
+	content := []rune("他拔出了剑。众人沉默。他拔出了剑，直指天空。")
+	start, ok := reanchor(content, "他拔出了剑", "众人沉默。", "，直指天空", 0)
+	if !ok {
+		t.Fatalf("Expected anchor to be found")
+	}
+	if start != 11 {
+		t.Errorf("Expected start 11, got %d", start)
+	}
+}
+
+func TestReanchorFallsBackToNearestOffset(t *testing.T) {
+	content := []rune("雨停了。雨停了。雨停了。")
+	start, ok := reanchor(content, "雨停了", "", "", 7)
+	if !ok {
+		t.Fatalf("Expected anchor to be found")
+	}
+	if start != 8 {
+		t.Errorf("Expected start 8, got %d", start)
+	}
+}
+
+func TestReanchorMissingText(t *testing.T) {
+	if _, ok := reanchor([]rune("全新的精简内容"), "旧片段", "", "", 0); ok {
+		t.Errorf("Expected anchor to be stale")
+	}
+}
+
+func TestListAnnotationsReanchorsWithoutWriting(t *testing.T) {
+	db := newTestDB(t)
+	bookRepo := repository.NewBookRepository(db, &memStorage{objects: make(map[string][]byte)})
+	annotationRepo := repository.NewAnnotationRepository(db)
+	s := NewAnnotationService(annotationRepo, bookRepo)
+	ctx := context.Background()
+
+	book := &model.Book{UserID: 1, Title: "测试", TotalChapters: 1}
+	if err := bookRepo.CreateBook(ctx, book, []model.Chapter{{Index: 0, Title: "第一章", ChapterMD5: "md5"}}); err != nil {
+		t.Fatal(err)
+	}
+	chapters, _ := bookRepo.GetChaptersByBookID(ctx, book.ID)
+	if err := bookRepo.SaveTrimResult(ctx, &model.TrimResult{ChapterMD5: "md5", PromptID: 1, TrimContent: "他拔出了剑。"}); err != nil {
+		t.Fatal(err)
+	}
+	created, err := s.CreateAnnotation(ctx, 1, &CreateAnnotationReq{BookID: book.ID, ChapterID: chapters[0].ID, PromptID: 1, Type: model.AnnotationTypeHighlight, StartOffset: 1, EndOffset: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 精简结果重新生成后，列表返回新位置但不修改数据库
+	if err := bookRepo.SaveTrimResult(ctx, &model.TrimResult{ChapterMD5: "md5", PromptID: 1, TrimContent: "夜里，他拔出了剑。"}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.ListAnnotations(ctx, 1, &ListAnnotationsReq{BookID: book.ID})
+	if err != nil || len(list) != 1 {
+		t.Fatalf("list = %v, %v", list, err)
+	}
+	if list[0].StartOffset != 4 || list[0].SelectedText != "拔出了剑" {
+		t.Errorf("reanchored = %d %q", list[0].StartOffset, list[0].SelectedText)
+	}
+	if stored, _ := annotationRepo.GetAnnotation(ctx, 1, created.ID); stored.StartOffset != 1 {
+		t.Errorf("stored offset = %d, list should not write", stored.StartOffset)
+	}
+
+	// 删除书籍时一并删除笔记
+	if err := bookRepo.DeleteBook(ctx, 1, book.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := s.ListAnnotations(ctx, 1, &ListAnnotationsReq{}); len(list) != 0 {
+		t.Errorf("annotations after delete = %d", len(list))
+	}
+}
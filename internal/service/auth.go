@@ -12,27 +12,35 @@ import (
 )
 
 type AuthService struct {
-	repo          repository.AuthRepositoryInterface
-	pointsService PointsServiceInterface
-	jwtSecret     []byte
+	repo      repository.AuthRepositoryInterface
+	earning   EarningServiceInterface
+	jwtSecret []byte
 }
 
 // NewAuthService 创建认证服务。
-func NewAuthService(repo repository.AuthRepositoryInterface, pointsService PointsServiceInterface, secret string) *AuthService {
+func NewAuthService(repo repository.AuthRepositoryInterface, earning EarningServiceInterface, secret string) *AuthService {
 	return &AuthService{
-		repo:          repo,
-		pointsService: pointsService,
-		jwtSecret:     []byte(secret),
+		repo:      repo,
+		earning:   earning,
+		jwtSecret: []byte(secret),
 	}
 }
 
-// Register 注册新用户并赠送积分。
-func (s *AuthService) Register(ctx context.Context, username, password string) error {
+// Register 注册新用户并赠送积分，填写邀请码时奖励邀请双方。
+func (s *AuthService) Register(ctx context.Context, username, password, inviteCode string) error {
 	existing, _ := s.repo.GetByUsername(ctx, username)
 	if existing != nil {
 		return errno.ErrBookExist
 	}
 
+	var referrerID uint
+	if inviteCode != "" {
+		var err error
+		if referrerID, err = s.earning.ResolveInviteCode(ctx, inviteCode); err != nil {
+			return err
+		}
+	}
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
@@ -47,7 +55,7 @@ func (s *AuthService) Register(ctx context.Context, username, password string) e
 		return err
 	}
 
-	if err := s.pointsService.GrantRegisterBonus(ctx, user.ID, 100); err != nil {
+	if err := s.earning.OnRegister(ctx, user.ID, referrerID); err != nil {
 		_ = s.repo.DeleteByID(ctx, user.ID)
 		return err
 	}
@@ -95,7 +103,7 @@ func (s *AuthService) ValidateToken(tokenString string) (uint, error) {
 }
 
 type AuthServiceInterface interface {
-	Register(ctx context.Context, username, password string) error
+	Register(ctx context.Context, username, password, inviteCode string) error
 	Login(ctx context.Context, username, password string) (string, error)
 	ValidateToken(tokenString string) (uint, error)
 }
@@ -21,6 +21,10 @@ import (
 	"gorm.io/gorm"
 )
 
+// maxProgressClockSkew 阅读进度客户端时间戳允许超前服务端的最大时长，超出部分截断，
+// 避免时钟错误的设备写入未来时间后其他设备的进度再也无法覆盖。
+const maxProgressClockSkew = 5 * time.Minute
+
 type BookService struct {
 	bookRepo repository.BookRepositoryInterface
 	taskRepo repository.TaskRepositoryInterface
@@ -120,17 +124,27 @@ func (s *BookService) GetBookDetailByID(ctx context.Context, bookID uint) (*Book
 	}, nil
 }
 
+// GetChaptersContent 批量获取章节原文，按请求顺序返回，缺失内容或不属于用户的章节会被跳过。
 func (s *BookService) GetChaptersContent(ctx context.Context, userID uint, ids []uint) ([]ChapterContentResp, error) {
-	chaps, err := s.bookRepo.GetChaptersByIDs(ctx, ids)
+	chaps, err := s.getChaptersInOrder(ctx, userID, ids)
 	if err != nil {
 		return nil, err
 	}
 
-	var res []ChapterContentResp
+	md5s := make([]string, 0, len(chaps))
 	for _, c := range chaps {
-		raw, err := s.bookRepo.GetRawContent(ctx, c.ChapterMD5)
-		if err != nil {
-			return nil, err
+		md5s = append(md5s, c.ChapterMD5)
+	}
+	contents, err := s.bookRepo.GetRawContentsByMD5s(ctx, md5s)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]ChapterContentResp, 0, len(chaps))
+	for _, c := range chaps {
+		raw, ok := contents[c.ChapterMD5]
+		if !ok {
+			continue
 		}
 		res = append(res, ChapterContentResp{
 			ChapterID:  c.ID,
@@ -141,6 +155,39 @@ func (s *BookService) GetChaptersContent(ctx context.Context, userID uint, ids [
 	return res, nil
 }
 
+// getChaptersInOrder 一次查询获取章节，过滤掉不属于用户书籍的章节，并按请求 ID 顺序去重排列。
+func (s *BookService) getChaptersInOrder(ctx context.Context, userID uint, ids []uint) ([]model.Chapter, error) {
+	chaps, err := s.bookRepo.GetChaptersByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[uint]bool)
+	byID := make(map[uint]model.Chapter, len(chaps))
+	for _, c := range chaps {
+		ok, checked := owned[c.BookID]
+		if !checked {
+			book, err := s.bookRepo.GetBookByIDWithUser(ctx, userID, c.BookID)
+			if err != nil {
+				return nil, err
+			}
+			ok = book != nil
+			owned[c.BookID] = ok
+		}
+		if ok {
+			byID[c.ID] = c
+		}
+	}
+	ordered := make([]model.Chapter, 0, len(chaps))
+	for _, id := range ids {
+		if c, ok := byID[id]; ok {
+			ordered = append(ordered, c)
+			delete(byID, id)
+		}
+	}
+	return ordered, nil
+}
+
 // WriteBookContentZip 将整本书内容写入压缩包。
 func (s *BookService) WriteBookContentZip(ctx context.Context, bookID uint, writer io.Writer) error {
 	book, err := s.bookRepo.GetBookByID(ctx, bookID)
@@ -506,37 +553,70 @@ func (s *BookService) GetReadingProgress(ctx context.Context, userID uint, bookI
 	return s.bookRepo.GetReadingHistory(ctx, userID, bookID)
 }
 
+// GetChaptersTrimmed 批量获取章节精简内容，未精简或不属于用户的章节会被跳过。
 func (s *BookService) GetChaptersTrimmed(ctx context.Context, userID uint, ids []uint, promptID uint) ([]ChapterTrimResp, error) {
-	chaps, err := s.bookRepo.GetChaptersByIDs(ctx, ids)
+	chaps, err := s.getChaptersInOrder(ctx, userID, ids)
 	if err != nil {
 		return nil, err
 	}
 
-	var res []ChapterTrimResp
+	md5s := make([]string, 0, len(chaps))
 	for _, c := range chaps {
-		trim, err := s.bookRepo.GetTrimResult(ctx, c.ChapterMD5, promptID)
-		if err == nil && trim != nil {
-			res = append(res, ChapterTrimResp{
-				ChapterID:      c.ID,
-				PromptID:       promptID,
-				TrimmedContent: trim.TrimContent,
-			})
+		md5s = append(md5s, c.ChapterMD5)
+	}
+	trims, err := s.bookRepo.GetTrimResultsByMD5s(ctx, md5s, promptID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]ChapterTrimResp, 0, len(chaps))
+	for _, c := range chaps {
+		trim, ok := trims[c.ChapterMD5]
+		if !ok {
+			continue
 		}
+		res = append(res, ChapterTrimResp{
+			ChapterID:      c.ID,
+			PromptID:       promptID,
+			TrimmedContent: trim.TrimContent,
+		})
 	}
 	return res, nil
 }
 
+// GetContentsTrimmed 按内容 MD5 批量获取精简内容，未精简的内容会被跳过。
+// 只返回用户书籍中的章节，或用户按 MD5 精简过的本地章节。
 func (s *BookService) GetContentsTrimmed(ctx context.Context, userID uint, md5s []string, promptID uint) ([]ContentTrimResp, error) {
-	var res []ContentTrimResp
+	owned, err := s.bookRepo.GetUserChapterMD5s(ctx, userID, md5s)
+	if err != nil {
+		return nil, err
+	}
+	processed, err := s.bookRepo.GetProcessedChapterMD5s(ctx, userID, promptID, 0, "", md5s)
+	if err != nil {
+		return nil, err
+	}
+	allowed := append(owned, processed...)
+	if len(allowed) == 0 {
+		return []ContentTrimResp{}, nil
+	}
+
+	trims, err := s.bookRepo.GetTrimResultsByMD5s(ctx, allowed, promptID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]ContentTrimResp, 0, len(trims))
 	for _, md5 := range md5s {
-		trim, err := s.bookRepo.GetTrimResult(ctx, md5, promptID)
-		if err == nil && trim != nil {
-			res = append(res, ContentTrimResp{
-				ChapterMD5:     md5,
-				PromptID:       promptID,
-				TrimmedContent: trim.TrimContent,
-			})
+		trim, ok := trims[md5]
+		if !ok {
+			continue
 		}
+		delete(trims, md5)
+		res = append(res, ContentTrimResp{
+			ChapterMD5:     md5,
+			PromptID:       promptID,
+			TrimmedContent: trim.TrimContent,
+		})
 	}
 	return res, nil
 }
@@ -848,14 +928,90 @@ func toSyncLocalChapters(chapters []SyncLocalZipChapter) []SyncLocalChapter {
 	return result
 }
 
-func (s *BookService) UpdateReadingProgress(ctx context.Context, userID uint, bookID uint, chapterID uint, promptID uint) error {
-	return s.bookRepo.UpsertReadingHistory(ctx, &model.ReadingHistory{
-		UserID:        userID,
-		BookID:        bookID,
-		LastChapterID: chapterID,
-		LastPromptID:  promptID,
-		UpdatedAt:     time.Now(),
-	})
+// UpdateReadingProgress 上报阅读进度，多端冲突时以客户端时间戳较新的为准。
+func (s *BookService) UpdateReadingProgress(ctx context.Context, userID uint, bookID uint, req *ReadingProgressReq) (*ReadingProgressResp, error) {
+	book, err := s.bookRepo.GetBookByIDWithUser(ctx, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if book == nil {
+		return nil, errno.ErrBookNotFound
+	}
+
+	chapter, err := s.bookRepo.GetChapterByID(ctx, req.ChapterID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errno.ErrChapterNotFound
+		}
+		return nil, err
+	}
+	if chapter.BookID != bookID {
+		return nil, errno.ErrChapterNotFound
+	}
+
+	viewMode := req.ViewMode
+	switch viewMode {
+	case "":
+		viewMode = model.ViewModeRaw
+		if req.PromptID > 0 {
+			viewMode = model.ViewModeTrim
+		}
+	case model.ViewModeRaw, model.ViewModeTrim, model.ViewModeCompare:
+	default:
+		return nil, errno.ErrParam
+	}
+	if req.ChapterOffset < 0 || req.ParagraphIndex < 0 {
+		return nil, errno.ErrParam
+	}
+
+	now := time.Now()
+	clientTime := progressClientTime(req.ClientTS, now)
+
+	history := &model.ReadingHistory{
+		UserID:          userID,
+		BookID:          bookID,
+		LastChapterID:   req.ChapterID,
+		LastPromptID:    req.PromptID,
+		ChapterOffset:   req.ChapterOffset,
+		ParagraphIndex:  req.ParagraphIndex,
+		ViewMode:        viewMode,
+		DeviceID:        req.DeviceID,
+		ClientUpdatedAt: clientTime,
+		UpdatedAt:       now,
+	}
+	accepted, err := s.bookRepo.SaveReadingProgress(ctx, history)
+	if err != nil {
+		return nil, err
+	}
+	if accepted {
+		if latest, err := s.bookRepo.GetReadingHistory(ctx, userID, bookID); err == nil && latest != nil {
+			history = latest
+		}
+	}
+	return &ReadingProgressResp{Accepted: accepted, Progress: history}, nil
+}
+
+// progressClientTime 返回进度的客户端时间，未上报时使用服务端时间，超前过多时截断。
+func progressClientTime(clientTS int64, now time.Time) time.Time {
+	if clientTS <= 0 {
+		return now
+	}
+	clientTime := time.UnixMilli(clientTS)
+	if limit := now.Add(maxProgressClockSkew); clientTime.After(limit) {
+		return limit
+	}
+	return clientTime
+}
+
+// ListReadingHistory 获取最近阅读的书籍列表。
+func (s *BookService) ListReadingHistory(ctx context.Context, userID uint, limit int) ([]*repository.ReadingHistoryWithDetail, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	return s.bookRepo.ListReadingHistory(ctx, userID, limit)
 }
 
 func (s *BookService) RegisterTrimStatusByMD5(ctx context.Context, userID uint, md5 string, promptID uint) error {
@@ -900,7 +1056,8 @@ type BookServiceInterface interface {
 	GetContentsTrimmed(ctx context.Context, userID uint, md5s []string, promptID uint) ([]ContentTrimResp, error)
 	SyncLocalBook(ctx context.Context, req *SyncLocalBookReq, userID uint) (*SyncLocalBookResp, error)
 	SyncLocalBookZip(ctx context.Context, req *SyncLocalBookZipReq, reader io.Reader, userID uint) (*SyncLocalBookResp, error)
-	UpdateReadingProgress(ctx context.Context, userID uint, bookID uint, chapterID uint, promptID uint) error
+	UpdateReadingProgress(ctx context.Context, userID uint, bookID uint, req *ReadingProgressReq) (*ReadingProgressResp, error)
+	ListReadingHistory(ctx context.Context, userID uint, limit int) ([]*repository.ReadingHistoryWithDetail, error)
 	RegisterTrimStatusByMD5(ctx context.Context, userID uint, md5 string, promptID uint) error
 	ListPrompts(ctx context.Context) ([]model.Prompt, error)
 }
@@ -953,6 +1110,23 @@ type SyncLocalBookZipReq struct {
 	TotalChapters int    `form:"total_chapters" binding:"required"`
 }
 
+// ReadingProgressReq 阅读进度上报参数。
+type ReadingProgressReq struct {
+	ChapterID      uint   `json:"chapter_id" binding:"required"`
+	PromptID       uint   `json:"prompt_id"`
+	ChapterOffset  int    `json:"chapter_offset"`
+	ParagraphIndex int    `json:"paragraph_index"`
+	ViewMode       string `json:"view_mode"`
+	DeviceID       string `json:"device_id" binding:"max=64"`
+	ClientTS       int64  `json:"client_ts"` // 客户端记录进度的毫秒时间戳，超前服务端过多时截断
+}
+
+// ReadingProgressResp 阅读进度上报结果，Accepted 为 false 表示已被其他设备更新的进度覆盖。
+type ReadingProgressResp struct {
+	Accepted bool                  `json:"accepted"`
+	Progress *model.ReadingHistory `json:"progress"`
+}
+
 type ChapterMapping struct {
 	LocalID uint `json:"local_id"`
 	CloudID uint `json:"cloud_id"`
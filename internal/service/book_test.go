@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+)
+
+func TestProgressClientTime(t *testing.T) {
+	now := time.UnixMilli(1_760_000_000_000)
+	tests := []struct {
+		name     string
+		clientTS int64
+		want     time.Time
+	}{
+		{"missing uses server time", 0, now},
+		{"past kept", now.Add(-time.Hour).UnixMilli(), now.Add(-time.Hour)},
+		{"small skew kept", now.Add(time.Minute).UnixMilli(), now.Add(time.Minute)},
+		{"far future clamped", now.AddDate(10, 0, 0).UnixMilli(), now.Add(maxProgressClockSkew)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := progressClientTime(tt.clientTS, now); !got.Equal(tt.want) {
+				t.Errorf("progressClientTime = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchFetchOnlyReturnsOwnChapters(t *testing.T) {
+	bookRepo := repository.NewBookRepository(newTestDB(t), &memStorage{objects: make(map[string][]byte)})
+	s := NewBookService(bookRepo, nil)
+	ctx := context.Background()
+
+	book := &model.Book{UserID: 1, Title: "测试", TotalChapters: 1}
+	if err := bookRepo.CreateBook(ctx, book, []model.Chapter{{Index: 0, Title: "第一章", ChapterMD5: "md5"}}); err != nil {
+		t.Fatal(err)
+	}
+	chapters, _ := bookRepo.GetChaptersByBookID(ctx, book.ID)
+	if err := bookRepo.SaveTrimResult(ctx, &model.TrimResult{ChapterMD5: "md5", PromptID: 1, TrimContent: "精简内容"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		userID uint
+		want   int
+	}{
+		{"owner", 1, 1},
+		{"other user", 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			byID, err := s.GetChaptersTrimmed(ctx, tt.userID, []uint{chapters[0].ID}, 1)
+			if err != nil || len(byID) != tt.want {
+				t.Errorf("by id = %d, %v, want %d", len(byID), err, tt.want)
+			}
+			byMD5, err := s.GetContentsTrimmed(ctx, tt.userID, []string{"md5"}, 1)
+			if err != nil || len(byMD5) != tt.want {
+				t.Errorf("by md5 = %d, %v, want %d", len(byMD5), err, tt.want)
+			}
+		})
+	}
+}
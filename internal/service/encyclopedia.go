@@ -0,0 +1,451 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+	"github.com/zqr233qr/story-trim/templates"
+)
+
+const (
+	encyclopediaTemplate = "encyclopediaPrompt.tmpl"
+
+	maxEncyclopediaPromptRunes = 3000 // 注入精简提示词的百科最大长度
+)
+
+// Encyclopedia 结构化的书籍百科。
+type Encyclopedia struct {
+	Characters []EncyclopediaCharacter `json:"characters"`
+	Places     []EncyclopediaEntry     `json:"places"`
+	Factions   []EncyclopediaEntry     `json:"factions"`
+	Items      []EncyclopediaItem      `json:"items"`
+}
+
+// EncyclopediaEntry 百科通用条目。
+type EncyclopediaEntry struct {
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases"`
+	Description string   `json:"description"`
+}
+
+// EncyclopediaCharacter 人物条目。
+type EncyclopediaCharacter struct {
+	Name          string                     `json:"name"`
+	Aliases       []string                   `json:"aliases"`
+	Description   string                     `json:"description"`
+	Faction       string                     `json:"faction"`
+	Relationships []EncyclopediaRelationship `json:"relationships"`
+}
+
+// EncyclopediaRelationship 人物关系。
+type EncyclopediaRelationship struct {
+	Target   string `json:"target"`
+	Relation string `json:"relation"`
+}
+
+// EncyclopediaItem 物品条目。
+type EncyclopediaItem struct {
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases"`
+	Description string   `json:"description"`
+	Owner       string   `json:"owner"`
+}
+
+// EncyclopediaResp 读者可见的书籍百科。
+type EncyclopediaResp struct {
+	ReaderIndex  int                         `json:"reader_index"` // 读者当前章节序号，-1 表示未开始阅读
+	Version      *model.EncyclopediaVersion  `json:"version"`
+	Encyclopedia *Encyclopedia               `json:"encyclopedia"`
+	Versions     []model.EncyclopediaVersion `json:"versions"` // 读者可见的历史版本
+}
+
+// EncyclopediaService 书籍百科的生成与查询服务。
+type EncyclopediaService struct {
+	memoryRepo repository.MemoryRepositoryInterface
+	bookRepo   repository.BookRepositoryInterface
+	llmService LlmServiceInterface
+	memory     *config.MemoryConfig
+	tmpl       *template.Template
+
+	mu       sync.Mutex
+	building map[uint]struct{} // 正在更新百科的书籍
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// NewEncyclopediaService 创建书籍百科服务。
+func NewEncyclopediaService(memoryRepo repository.MemoryRepositoryInterface, bookRepo repository.BookRepositoryInterface, llmService LlmServiceInterface, memory *config.MemoryConfig) *EncyclopediaService {
+	tmpl, err := template.ParseFS(templates.FS, encyclopediaTemplate)
+	if err != nil {
+		panic("failed to load templates: " + err.Error())
+	}
+	ctx, cancel := context.WithCancel(WithLlmPriority(context.Background(), LlmPriorityBatch))
+	return &EncyclopediaService{
+		memoryRepo: memoryRepo,
+		bookRepo:   bookRepo,
+		llmService: llmService,
+		memory:     memory,
+		tmpl:       tmpl,
+		building:   make(map[uint]struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Stop 取消并等待后台百科更新结束。
+func (s *EncyclopediaService) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// OnChapterSummarized 章节摘要保存后调用，每满 EncyclopediaInterval 章异步更新一次百科；
+// 已被百科覆盖的章节摘要晚到时，补入当前覆盖范围。
+func (s *EncyclopediaService) OnChapterSummarized(bookID uint, chapterIndex int) {
+	if s.memory == nil || s.memory.EncyclopediaInterval <= 0 || bookID == 0 || s.ctx.Err() != nil {
+		return
+	}
+	boundary := (chapterIndex+1)%s.memory.EncyclopediaInterval == 0
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		upToIndex := chapterIndex
+		if !boundary {
+			latest, err := s.memoryRepo.GetLatestEncyclopedia(s.ctx, bookID, -1)
+			if err != nil || latest == nil || latest.UpToIndex < chapterIndex {
+				return
+			}
+			upToIndex = latest.UpToIndex
+		}
+		if err := s.Update(s.ctx, bookID, upToIndex); err != nil {
+			logger.Error().Err(err).Uint("book_id", bookID).Int("chapter_index", chapterIndex).Msg("书籍百科更新失败")
+		}
+	}()
+}
+
+// Update 基于上一版本百科与尚未并入的章节摘要生成覆盖到 upToIndex 的新版本，
+// 包括上一版本覆盖范围内晚到的摘要。同一本书同时只会有一个更新在进行。
+func (s *EncyclopediaService) Update(ctx context.Context, bookID uint, upToIndex int) error {
+	s.mu.Lock()
+	if _, ok := s.building[bookID]; ok {
+		s.mu.Unlock()
+		return nil
+	}
+	s.building[bookID] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.building, bookID)
+		s.mu.Unlock()
+	}()
+
+	latest, err := s.memoryRepo.GetLatestEncyclopedia(ctx, bookID, -1)
+	if err != nil {
+		return err
+	}
+	version, base := 1, "{}"
+	var covered []string
+	if latest != nil {
+		version, base = latest.Version+1, latest.Content
+		upToIndex = max(upToIndex, latest.UpToIndex)
+		if latest.CoveredMD5s != "" {
+			covered = strings.Split(latest.CoveredMD5s, ",")
+		}
+	}
+
+	chapters, err := s.bookRepo.GetChaptersByBookID(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	for i, c := range chapters {
+		if c.Index > upToIndex {
+			chapters = chapters[:i]
+			break
+		}
+	}
+	pending := pendingEncyclopediaChapters(chapters, latest)
+	if len(pending) == 0 {
+		return nil
+	}
+	md5s := make([]string, 0, len(pending))
+	for _, c := range pending {
+		md5s = append(md5s, c.ChapterMD5)
+	}
+	summaries, err := s.memoryRepo.GetChapterSummaries(ctx, md5s)
+	if err != nil {
+		return err
+	}
+
+	var userPrompt strings.Builder
+	userPrompt.WriteString("【现有百科】\n")
+	userPrompt.WriteString(base)
+	userPrompt.WriteString("\n\n【新增摘要】\n")
+	found := 0
+	for _, c := range pending {
+		if summary, ok := summaries[c.ChapterMD5]; ok {
+			fmt.Fprintf(&userPrompt, "【%s】%s\n", c.Title, summary.Summary)
+			covered = append(covered, c.ChapterMD5)
+			found++
+		}
+	}
+	if found == 0 {
+		return nil
+	}
+
+	var systemPrompt bytes.Buffer
+	if err := s.tmpl.ExecuteTemplate(&systemPrompt, encyclopediaTemplate, nil); err != nil {
+		return err
+	}
+	llmResp, err := s.llmService.Llm(ctx, summaryPromptID(ctx, s.bookRepo), systemPrompt.String(), userPrompt.String())
+	if err != nil {
+		return err
+	}
+	if len(llmResp.Resp.Choices) == 0 {
+		return fmt.Errorf("empty encyclopedia output")
+	}
+	content, err := normalizeEncyclopedia(llmResp.Resp.Choices[0].Message.Content)
+	if err != nil {
+		return err
+	}
+
+	created, err := s.memoryRepo.CreateEncyclopediaVersion(ctx, &model.EncyclopediaVersion{
+		BookID:           bookID,
+		Version:          version,
+		UpToIndex:        upToIndex,
+		CoveredMD5s:      strings.Join(covered, ","),
+		Content:          content,
+		TotalTokens:      llmResp.TotalTokens,
+		PromptTokens:     llmResp.PromptTokens,
+		CompletionTokens: llmResp.CompletionTokens,
+		TotalCost:        llmResp.TotalCost,
+		LlmName:          llmResp.LlmName,
+	})
+	if err != nil {
+		return err
+	}
+	if created {
+		logger.Info().Uint("book_id", bookID).Int("version", version).Int("up_to_index", upToIndex).Msg("书籍百科已更新")
+	}
+	return nil
+}
+
+// pendingEncyclopediaChapters 返回尚未并入百科的章节，按章节顺序排列。
+// 未记录已并入摘要的旧版本视为覆盖范围内的章节均已并入。
+func pendingEncyclopediaChapters(chapters []model.Chapter, latest *model.EncyclopediaVersion) []model.Chapter {
+	covered := make(map[string]struct{})
+	if latest != nil {
+		for _, md5 := range strings.Split(latest.CoveredMD5s, ",") {
+			covered[md5] = struct{}{}
+		}
+	}
+	pending := make([]model.Chapter, 0, len(chapters))
+	for _, c := range chapters {
+		if latest != nil && latest.CoveredMD5s == "" && c.Index <= latest.UpToIndex {
+			continue
+		}
+		if _, ok := covered[c.ChapterMD5]; ok {
+			continue
+		}
+		covered[c.ChapterMD5] = struct{}{}
+		pending = append(pending, c)
+	}
+	return pending
+}
+
+// PromptText 返回注入精简提示词的百科文本，只使用当前章节之前生成的版本。
+func (s *EncyclopediaService) PromptText(ctx context.Context, bookID uint, chapterIndex int) string {
+	if chapterIndex <= 0 {
+		return "（无）"
+	}
+	version, err := s.memoryRepo.GetLatestEncyclopedia(ctx, bookID, chapterIndex-1)
+	if err != nil || version == nil {
+		return "（无）"
+	}
+	var e Encyclopedia
+	if err := json.Unmarshal([]byte(version.Content), &e); err != nil {
+		return "（无）"
+	}
+	text := formatEncyclopedia(&e)
+	if runes := []rune(text); len(runes) > maxEncyclopediaPromptRunes {
+		text = string(runes[:maxEncyclopediaPromptRunes])
+	}
+	if text == "" {
+		return "（无）"
+	}
+	return text
+}
+
+// GetForReader 获取读者可见的书籍百科，只返回读者当前章节之前生成的版本，读完全书后不再限制。
+// version 为 0 时返回可见的最新版本。
+func (s *EncyclopediaService) GetForReader(ctx context.Context, userID uint, bookID uint, version int) (*EncyclopediaResp, error) {
+	book, err := s.bookRepo.GetBookByIDWithUser(ctx, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if book == nil {
+		return nil, errno.ErrBookNotFound
+	}
+
+	readerIndex := -1
+	maxIndex := -1
+	history, err := s.bookRepo.GetReadingHistory(ctx, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if history != nil {
+		if chapter, err := s.bookRepo.GetChapterByID(ctx, history.LastChapterID); err == nil && chapter.BookID == bookID {
+			readerIndex = chapter.Index
+			maxIndex = chapter.Index - 1
+		}
+		if history.FinishedAt != nil {
+			maxIndex = math.MaxInt32
+		}
+	}
+
+	resp := &EncyclopediaResp{ReaderIndex: readerIndex, Versions: []model.EncyclopediaVersion{}}
+	if maxIndex < 0 {
+		if version > 0 {
+			return nil, errno.ErrEncyclopediaNotFound
+		}
+		return resp, nil
+	}
+
+	var current *model.EncyclopediaVersion
+	if version > 0 {
+		current, err = s.memoryRepo.GetEncyclopediaVersion(ctx, bookID, version)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || current.UpToIndex > maxIndex {
+			return nil, errno.ErrEncyclopediaNotFound
+		}
+	} else {
+		current, err = s.memoryRepo.GetLatestEncyclopedia(ctx, bookID, maxIndex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.Versions, err = s.memoryRepo.ListEncyclopediaVersions(ctx, bookID, maxIndex); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		var e Encyclopedia
+		if err := json.Unmarshal([]byte(current.Content), &e); err != nil {
+			return nil, err
+		}
+		resp.Version = current
+		resp.Encyclopedia = &e
+	}
+	return resp, nil
+}
+
+// normalizeEncyclopedia 从模型输出中提取百科 JSON 并按结构重新序列化。
+func normalizeEncyclopedia(output string) (string, error) {
+	start := strings.Index(output, "{")
+	end := strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("invalid encyclopedia output")
+	}
+
+	var e Encyclopedia
+	if err := json.Unmarshal([]byte(output[start:end+1]), &e); err != nil {
+		return "", fmt.Errorf("invalid encyclopedia output: %w", err)
+	}
+	data, err := json.Marshal(&e)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// formatEncyclopedia 将百科格式化为紧凑的提示词文本。
+func formatEncyclopedia(e *Encyclopedia) string {
+	var buf strings.Builder
+	writeName := func(name string, aliases []string) {
+		buf.WriteString(name)
+		if len(aliases) > 0 {
+			fmt.Fprintf(&buf, "（又称：%s）", strings.Join(aliases, "、"))
+		}
+	}
+
+	if len(e.Characters) > 0 {
+		buf.WriteString("人物：\n")
+		for _, c := range e.Characters {
+			buf.WriteString("- ")
+			writeName(c.Name, c.Aliases)
+			if c.Faction != "" {
+				fmt.Fprintf(&buf, "［%s］", c.Faction)
+			}
+			if c.Description != "" {
+				fmt.Fprintf(&buf, "：%s", c.Description)
+			}
+			if len(c.Relationships) > 0 {
+				relations := make([]string, 0, len(c.Relationships))
+				for _, r := range c.Relationships {
+					relations = append(relations, r.Target+"（"+r.Relation+"）")
+				}
+				fmt.Fprintf(&buf, "；关系：%s", strings.Join(relations, "、"))
+			}
+			buf.WriteString("\n")
+		}
+	}
+
+	sections := []struct {
+		title   string
+		entries []EncyclopediaEntry
+	}{
+		{"地点", e.Places},
+		{"势力", e.Factions},
+	}
+	for _, section := range sections {
+		if len(section.entries) == 0 {
+			continue
+		}
+		buf.WriteString(section.title + "：\n")
+		for _, entry := range section.entries {
+			buf.WriteString("- ")
+			writeName(entry.Name, entry.Aliases)
+			if entry.Description != "" {
+				fmt.Fprintf(&buf, "：%s", entry.Description)
+			}
+			buf.WriteString("\n")
+		}
+	}
+
+	if len(e.Items) > 0 {
+		buf.WriteString("物品：\n")
+		for _, item := range e.Items {
+			buf.WriteString("- ")
+			writeName(item.Name, item.Aliases)
+			if item.Owner != "" {
+				fmt.Fprintf(&buf, "［%s］", item.Owner)
+			}
+			if item.Description != "" {
+				fmt.Fprintf(&buf, "：%s", item.Description)
+			}
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String()
+}
+
+// EncyclopediaServiceInterface 书籍百科服务接口。
+type EncyclopediaServiceInterface interface {
+	OnChapterSummarized(bookID uint, chapterIndex int)
+	Update(ctx context.Context, bookID uint, upToIndex int) error
+	PromptText(ctx context.Context, bookID uint, chapterIndex int) string
+	GetForReader(ctx context.Context, userID uint, bookID uint, version int) (*EncyclopediaResp, error)
+	Stop()
+}
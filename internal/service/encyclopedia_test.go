@@ -0,0 +1,68 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+func TestNormalizeEncyclopediaStripsWrapper(t *testing.T) {
+	output := "```json\n{\"characters\":[{\"name\":\"萧炎\",\"aliases\":[\"炎帝\"],\"description\":\"萧家少爷\"}],\"items\":[]}\n```"
+	content, err := normalizeEncyclopedia(output)
+	if err != nil {
+		t.Fatalf("Expected valid encyclopedia, got %v", err)
+	}
+
+	var e Encyclopedia
+	if err := json.Unmarshal([]byte(content), &e); err != nil {
+		t.Fatalf("Expected normalized JSON, got %v", err)
+	}
+	if len(e.Characters) != 1 || e.Characters[0].Aliases[0] != "炎帝" {
+		t.Errorf("Unexpected characters: %+v", e.Characters)
+	}
+	if text := formatEncyclopedia(&e); !strings.Contains(text, "萧炎（又称：炎帝）：萧家少爷") {
+		t.Errorf("Unexpected prompt text: %q", text)
+	}
+}
+
+func TestNormalizeEncyclopediaRejectsInvalidOutput(t *testing.T) {
+	if _, err := normalizeEncyclopedia("本章没有新的设定。"); err == nil {
+		t.Errorf("Expected error for output without JSON")
+	}
+}
+
+func TestPendingEncyclopediaChapters(t *testing.T) {
+	chapters := []model.Chapter{
+		{Index: 0, ChapterMD5: "a"},
+		{Index: 1, ChapterMD5: "b"},
+		{Index: 2, ChapterMD5: "c"},
+		{Index: 3, ChapterMD5: "a"}, // 与第一章内容相同
+		{Index: 4, ChapterMD5: "d"},
+	}
+	md5s := func(chapters []model.Chapter) string {
+		var out []string
+		for _, c := range chapters {
+			out = append(out, c.ChapterMD5)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		name   string
+		latest *model.EncyclopediaVersion
+		want   string
+	}{
+		{"no version yet", nil, "a,b,c,d"},
+		{"late summary inside covered range", &model.EncyclopediaVersion{UpToIndex: 2, CoveredMD5s: "a,c"}, "b,d"},
+		{"legacy version covers its range", &model.EncyclopediaVersion{UpToIndex: 2}, "a,d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := md5s(pendingEncyclopediaChapters(chapters, tt.latest)); got != tt.want {
+				t.Errorf("pending = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+)
+
+const (
+	defaultGCIntervalMinutes = 60
+	defaultGCGraceHours      = 72
+	defaultGCBatchSize       = 500
+)
+
+// GCService 孤立章节内容与精简结果的标记-清除回收服务。
+// 首次发现孤立对象时只做标记，超过宽限期仍未被引用才真正删除。
+type GCService struct {
+	repo   repository.GCRepositoryInterface
+	cfg    *config.GCConfig
+	mu     sync.Mutex
+	totals GCReport
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// GCOptions 单次回收参数。
+type GCOptions struct {
+	DryRun      bool
+	GracePeriod time.Duration
+	BatchSize   int
+}
+
+// GCKindReport 单类对象的回收统计。
+type GCKindReport struct {
+	Orphans        int   `json:"orphans"`
+	OrphanBytes    int64 `json:"orphan_bytes"`
+	Pending        int   `json:"pending"` // 仍在宽限期内
+	Reclaimed      int   `json:"reclaimed"`
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+	Failed         int   `json:"failed"`
+}
+
+// GCReport 回收报告。
+type GCReport struct {
+	DryRun      bool         `json:"dry_run"`
+	Contents    GCKindReport `json:"contents"`
+	TrimResults GCKindReport `json:"trim_results"`
+	TakeTime    float64      `json:"take_time"`
+}
+
+// NewGCService 创建回收服务。
+func NewGCService(repo repository.GCRepositoryInterface, cfg *config.GCConfig) *GCService {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &GCService{repo: repo, cfg: cfg, ctx: ctx, cancel: cancel}
+}
+
+// DefaultOptions 根据配置生成回收参数。
+func (s *GCService) DefaultOptions() GCOptions {
+	graceHours := s.cfg.GraceHours
+	if graceHours <= 0 {
+		graceHours = defaultGCGraceHours
+	}
+	batchSize := s.cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultGCBatchSize
+	}
+	return GCOptions{
+		DryRun:      s.cfg.DryRun,
+		GracePeriod: time.Duration(graceHours) * time.Hour,
+		BatchSize:   batchSize,
+	}
+}
+
+// Start 按配置周期执行回收。
+func (s *GCService) Start() {
+	if !s.cfg.Enabled {
+		return
+	}
+	interval := s.cfg.IntervalMinutes
+	if interval <= 0 {
+		interval = defaultGCIntervalMinutes
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.Run(s.ctx, s.DefaultOptions()); err != nil {
+					logger.Error().Err(err).Msg("孤立内容回收失败")
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止周期回收。
+func (s *GCService) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Run 执行一次标记-清除。
+func (s *GCService) Run(ctx context.Context, opts GCOptions) (*GCReport, error) {
+	startTime := time.Now()
+	report := &GCReport{DryRun: opts.DryRun}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultGCBatchSize
+	}
+
+	contents, err := s.repo.FindOrphanContents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = s.sweep(ctx, model.GCKindChapterContent, contents, opts, &report.Contents, func(key string) (bool, error) {
+		return s.repo.DeleteOrphanContent(ctx, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	trims, err := s.repo.FindOrphanTrimResults(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = s.sweep(ctx, model.GCKindTrimResult, trims, opts, &report.TrimResults, func(key string) (bool, error) {
+		return s.repo.DeleteOrphanTrimResult(ctx, cast.ToUint(key))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.DryRun {
+		// 重启后无人续写的生成中输出，超过宽限期直接删除
+		if n, err := s.repo.DeleteStaleTrimPartials(ctx, startTime.Add(-opts.GracePeriod)); err != nil {
+			logger.Warn().Err(err).Msg("清理精简中间输出失败")
+		} else if n > 0 {
+			logger.Info().Int64("count", n).Msg("清理精简中间输出")
+		}
+	}
+
+	report.TakeTime = time.Since(startTime).Seconds()
+	if !opts.DryRun {
+		s.accumulate(report)
+	}
+
+	logger.Info().
+		Bool("dry_run", report.DryRun).
+		Int("orphan_contents", report.Contents.Orphans).
+		Int("reclaimed_contents", report.Contents.Reclaimed).
+		Int64("reclaimed_content_bytes", report.Contents.ReclaimedBytes).
+		Int("orphan_trims", report.TrimResults.Orphans).
+		Int("reclaimed_trims", report.TrimResults.Reclaimed).
+		Int64("reclaimed_trim_bytes", report.TrimResults.ReclaimedBytes).
+		Float64("take_time", report.TakeTime).
+		Msg("孤立内容回收完成")
+	return report, nil
+}
+
+// sweep 同步单类对象的标记并回收超过宽限期的对象。
+func (s *GCService) sweep(
+	ctx context.Context,
+	kind string,
+	candidates []repository.GCCandidate,
+	opts GCOptions,
+	report *GCKindReport,
+	remove func(key string) (bool, error),
+) error {
+	marks, err := s.repo.ListMarks(ctx, kind)
+	if err != nil {
+		return err
+	}
+	markedAt := make(map[string]time.Time, len(marks))
+	for _, mark := range marks {
+		markedAt[mark.RefKey] = mark.MarkedAt
+	}
+
+	now := time.Now()
+	orphanSet := make(map[string]struct{}, len(candidates))
+	newMarks := make([]model.GCMark, 0)
+	for _, c := range candidates {
+		orphanSet[c.RefKey] = struct{}{}
+		report.Orphans++
+		report.OrphanBytes += c.Size
+		if _, ok := markedAt[c.RefKey]; !ok {
+			markedAt[c.RefKey] = now
+			newMarks = append(newMarks, model.GCMark{Kind: kind, RefKey: c.RefKey, Size: c.Size, MarkedAt: now})
+		}
+	}
+
+	// 重新被引用的对象取消标记。
+	staleKeys := make([]string, 0)
+	for _, mark := range marks {
+		if _, ok := orphanSet[mark.RefKey]; !ok {
+			staleKeys = append(staleKeys, mark.RefKey)
+		}
+	}
+
+	if !opts.DryRun {
+		if err := s.repo.CreateMarks(ctx, newMarks); err != nil {
+			return err
+		}
+		if err := s.repo.DeleteMarks(ctx, kind, staleKeys); err != nil {
+			return err
+		}
+	}
+
+	deadline := now.Add(-opts.GracePeriod)
+	removedKeys := make([]string, 0)
+	for _, c := range candidates {
+		if markedAt[c.RefKey].After(deadline) {
+			report.Pending++
+			continue
+		}
+		if report.Reclaimed+report.Failed >= opts.BatchSize {
+			break
+		}
+		if opts.DryRun {
+			report.Reclaimed++
+			report.ReclaimedBytes += c.Size
+			continue
+		}
+
+		removed, err := remove(c.RefKey)
+		if err != nil {
+			report.Failed++
+			logger.Warn().Err(err).Str("kind", kind).Str("ref_key", c.RefKey).Msg("回收孤立对象失败")
+			continue
+		}
+		// 未删除说明删除前已重新被引用，同样清理标记。
+		removedKeys = append(removedKeys, c.RefKey)
+		if removed {
+			report.Reclaimed++
+			report.ReclaimedBytes += c.Size
+		}
+	}
+
+	if !opts.DryRun {
+		return s.repo.DeleteMarks(ctx, kind, removedKeys)
+	}
+	return nil
+}
+
+// accumulate 累加进程内的回收指标。
+func (s *GCService) accumulate(report *GCReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addKindReport(&s.totals.Contents, report.Contents)
+	addKindReport(&s.totals.TrimResults, report.TrimResults)
+	s.totals.TakeTime += report.TakeTime
+}
+
+func addKindReport(dst *GCKindReport, src GCKindReport) {
+	dst.Reclaimed += src.Reclaimed
+	dst.ReclaimedBytes += src.ReclaimedBytes
+	dst.Failed += src.Failed
+}
+
+// Stats 获取进程启动以来的累计回收指标。
+func (s *GCService) Stats() GCReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totals
+}
+
+// GCServiceInterface 回收服务接口。
+type GCServiceInterface interface {
+	Run(ctx context.Context, opts GCOptions) (*GCReport, error)
+	DefaultOptions() GCOptions
+	Stats() GCReport
+	Start()
+	Stop()
+}
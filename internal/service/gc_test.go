@@ -0,0 +1,138 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"gorm.io/gorm"
+)
+
+// memStorage 内存对象存储。
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memStorage) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memStorage) Exists(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.objects[key]
+	return ok, nil
+}
+
+func (m *memStorage) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// newTestDB 创建测试用的 SQLite 数据库。
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_txlock=immediate", filepath.Join(t.TempDir(), "test.db"))
+	db, err := repository.NewDB(config.DatabaseConfig{Type: "sqlite", Source: dsn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestGCService_Run(t *testing.T) {
+	db := newTestDB(t)
+	store := &memStorage{objects: make(map[string][]byte)}
+	bookRepo := repository.NewBookRepository(db, store)
+	gcRepo := repository.NewGCRepository(db, store)
+	gc := NewGCService(gcRepo, &config.GCConfig{})
+	ctx := context.Background()
+
+	// orphan 无章节引用，referenced 仍被章节引用
+	if err := bookRepo.BatchSaveRawContents(ctx, []*model.ChapterContent{
+		{ChapterMD5: "orphan", Content: "孤立内容", WordsCount: 4},
+		{ChapterMD5: "referenced", Content: "引用内容", WordsCount: 4},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&model.Chapter{BookID: 1, Index: 0, Title: "第一章", ChapterMD5: "referenced"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	contents := func() int64 {
+		var n int64
+		db.Model(&model.ChapterContent{}).Count(&n)
+		return n
+	}
+
+	tests := []struct {
+		name          string
+		opts          GCOptions
+		wantReclaimed int
+		wantPending   int
+		wantContents  int64
+		wantObjects   int
+	}{
+		{"dry run deletes nothing", GCOptions{DryRun: true}, 1, 0, 2, 2},
+		{"within grace period only marks", GCOptions{GracePeriod: time.Hour}, 0, 1, 2, 2},
+		{"past grace period reclaims orphan only", GCOptions{}, 1, 0, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := gc.Run(ctx, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Contents.Reclaimed != tt.wantReclaimed || report.Contents.Pending != tt.wantPending {
+				t.Fatalf("report = %+v", report.Contents)
+			}
+			if n := contents(); n != tt.wantContents || len(store.objects) != tt.wantObjects {
+				t.Fatalf("contents = %d, objects = %d", n, len(store.objects))
+			}
+		})
+	}
+	if ok, _ := store.Exists(ctx, "chapters/referenced.txt"); !ok {
+		t.Fatal("referenced content should be kept")
+	}
+
+	// 已标记的内容在回收前被重新上传复用，回收跳过该内容
+	if err := bookRepo.BatchSaveRawContents(ctx, []*model.ChapterContent{{ChapterMD5: "reused", Content: "复用内容", WordsCount: 4}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gcRepo.CreateMarks(ctx, []model.GCMark{{Kind: model.GCKindChapterContent, RefKey: "reused", MarkedAt: time.Now().Add(-time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bookRepo.BatchSaveRawContents(ctx, []*model.ChapterContent{{ChapterMD5: "reused", Content: "复用内容", WordsCount: 4}}); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := gcRepo.DeleteOrphanContent(ctx, "reused"); err != nil || removed {
+		t.Fatalf("removed = %v, %v, want reused content kept", removed, err)
+	}
+}
@@ -2,39 +2,305 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultEjectDuration    = time.Minute
 )
 
 type LlmService struct {
 	llm *config.LLM
+
+	governor   *llmGovernor
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	health   map[string]*providerHealth
+	limiters map[string]*providerLimiter
+	clients  map[string]*openai.Client
+}
+
+// providerHealth 供应商的健康状态。
+type providerHealth struct {
+	failures     int       // 连续失败次数
+	ejectedUntil time.Time // 剔除截止时间
 }
 
 func NewLlmService(llm *config.LLM) *LlmService {
+	governor := newLlmGovernor(llm.MaxConcurrency, llm.InteractiveReserve)
+	// 所有供应商共用连接池，空闲连接数与并发上限一致以便复用
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = governor.limit
 	return &LlmService{
-		llm: llm,
+		llm:        llm,
+		governor:   governor,
+		httpClient: &http.Client{Transport: &retryAfterTransport{base: transport}},
+		health:     make(map[string]*providerHealth),
+		limiters:   make(map[string]*providerLimiter),
+		clients:    make(map[string]*openai.Client),
 	}
 }
 
+// client 返回供应商的长连接客户端。
+func (s *LlmService) client(name string) *openai.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[name]
+	if !ok {
+		llmConfig := s.llm.LLMConfig[name]
+		conf := openai.DefaultConfig(llmConfig.APIKey)
+		conf.BaseURL = llmConfig.BaseURL
+		conf.HTTPClient = s.httpClient
+		c = openai.NewClientWithConfig(conf)
+		s.clients[name] = c
+	}
+	return c
+}
+
+// Stats 返回全局并发与排队统计。
+func (s *LlmService) Stats() *LlmStats {
+	return s.governor.stats()
+}
+
 // 百万
 var million = 1000000.0
 
-func (s *LlmService) getLlmConfig() *config.LLMConfig {
-	llmConfig := s.llm.LLMConfig[s.llm.Use]
+func (s *LlmService) getLlmConfig(name string) *config.LLMConfig {
+	llmConfig := s.llm.LLMConfig[name]
 	llmConfig.InputPrice = llmConfig.InputPrice * 100
 	llmConfig.OutputPrice = llmConfig.OutputPrice * 100
 	return &llmConfig
 }
 
-func (s *LlmService) Llm(ctx context.Context, systemPrompt string, userPrompt string) (*LlmResponse, error) {
-	llmConfig := s.getLlmConfig()
-	conf := openai.DefaultConfig(llmConfig.APIKey)
-	conf.BaseURL = llmConfig.BaseURL
-	client := openai.NewClientWithConfig(conf)
+// LlmPricing 供应商的模型与单价，单价单位为分/百万 token。
+type LlmPricing struct {
+	LlmName           string  `json:"llm_name"`
+	Model             string  `json:"model"`
+	InputMTokenPrice  float64 `json:"input_mtoken_price"`
+	OutputMTokenPrice float64 `json:"output_mtoken_price"`
+}
+
+// Pricing 返回当前会优先处理该输入的供应商及其单价，用于费用估算。
+func (s *LlmService) Pricing(promptID uint, inputChars int) *LlmPricing {
+	candidates := s.candidates(promptID, inputChars, time.Now())
+	if len(candidates) == 0 {
+		return nil
+	}
+	conf := s.getLlmConfig(candidates[0])
+	return &LlmPricing{
+		LlmName:           candidates[0],
+		Model:             conf.Model,
+		InputMTokenPrice:  conf.InputPrice,
+		OutputMTokenPrice: conf.OutputPrice,
+	}
+}
+
+// Llm 非流式调用，按调用链依次尝试供应商直到成功。未指定优先级时按后台任务排队。
+func (s *LlmService) Llm(ctx context.Context, promptID uint, systemPrompt string, userPrompt string) (*LlmResponse, error) {
+	ctx = WithLlmPriority(ctx, llmPriorityFrom(ctx, LlmPriorityBatch))
+	return s.failover(ctx, promptID, systemPrompt, userPrompt, s.complete)
+}
+
+// LlmWithStream 流式调用，仅在建立流之前失败时切换供应商，已开始输出的流不会切换。
+// 未指定优先级时按交互调用排队，并发名额在 Close 时归还。
+func (s *LlmService) LlmWithStream(ctx context.Context, promptID uint, systemPrompt string, userPrompt string) (*LlmResponse, error) {
+	ctx = WithLlmPriority(ctx, llmPriorityFrom(ctx, LlmPriorityInteractive))
+	return s.failover(ctx, promptID, systemPrompt, userPrompt, s.stream)
+}
+
+func (s *LlmService) failover(ctx context.Context, promptID uint, systemPrompt string, userPrompt string,
+	call func(ctx context.Context, name string, systemPrompt string, userPrompt string) (*LlmResponse, error)) (*LlmResponse, error) {
+	inputChars := len([]rune(systemPrompt)) + len([]rune(userPrompt))
+	candidates := s.candidates(promptID, inputChars, time.Now())
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no llm provider configured")
+	}
 
-	resp, err := client.CreateChatCompletion(
-		ctx,
+	var lastErr error
+	for _, name := range candidates {
+		resp, err := s.withRetry(ctx, name, inputChars, func(ctx context.Context) (*LlmResponse, error) {
+			return call(ctx, name, systemPrompt, userPrompt)
+		})
+		if err == nil {
+			s.markSuccess(name)
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if isProviderFailure(err) {
+			s.markFailure(name, time.Now())
+		}
+		logger.Warn().Err(err).Str("llm", name).Uint("prompt_id", promptID).Msg("LLM 调用失败，尝试下一个供应商")
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// withRetry 对单个供应商限流后发起调用，可重试的错误按退避策略重试。
+// 只有最终成功的那次响应会返回给调用方计费，失败的尝试不产生扣费与成本记录。
+func (s *LlmService) withRetry(ctx context.Context, name string, inputChars int, call func(ctx context.Context) (*LlmResponse, error)) (*LlmResponse, error) {
+	policy := newRetryPolicy(s.llm.LLMConfig[name])
+	limiter := s.limiter(name)
+	for attempt := 0; ; attempt++ {
+		// 中文文本的 token 数与字数接近，按输入字数预估
+		if err := limiter.wait(ctx, inputChars); err != nil {
+			return nil, err
+		}
+		release, err := s.governor.acquire(ctx, llmPriorityFrom(ctx, LlmPriorityBatch))
+		if err != nil {
+			return nil, err
+		}
+		resp, err := call(ctx)
+		if err == nil {
+			resp.Attempts = attempt + 1
+			if resp.Stream != nil {
+				resp.release = release
+			} else {
+				release()
+			}
+			return resp, nil
+		}
+		// 退避等待期间不占用并发名额
+		release()
+		if ctx.Err() != nil || !isRetryable(err) || attempt >= policy.maxRetries {
+			return nil, err
+		}
+		wait, ok := policy.backoff(attempt, retryAfterOf(err))
+		if !ok {
+			return nil, err
+		}
+		logger.Warn().Err(err).Str("llm", name).Int("attempt", attempt+1).Dur("wait", wait).Msg("LLM 调用失败，等待后重试")
+		if err := sleepCtx(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *LlmService) limiter(name string) *providerLimiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.limiters[name]
+	if !ok {
+		l = newProviderLimiter(s.llm.LLMConfig[name], time.Now())
+		s.limiters[name] = l
+	}
+	return l
+}
+
+// candidates 返回本次调用依次尝试的供应商：先按路由规则选出调用链，
+// 去掉未配置和输入超长的供应商，再把被剔除的供应商排到最后作为兜底。
+func (s *LlmService) candidates(promptID uint, inputChars int, now time.Time) []string {
+	chain := append([]string{s.llm.Use}, s.llm.Fallbacks...)
+	for _, route := range s.llm.Routes {
+		if len(route.PromptIDs) > 0 && !slices.Contains(route.PromptIDs, promptID) {
+			continue
+		}
+		if inputChars < route.MinChars {
+			continue
+		}
+		chain = route.Chain
+		break
+	}
+
+	var fit, oversize []string
+	seen := make(map[string]struct{}, len(chain))
+	for _, name := range chain {
+		conf, ok := s.llm.LLMConfig[name]
+		if !ok {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		if conf.MaxInputChars > 0 && inputChars > conf.MaxInputChars {
+			oversize = append(oversize, name)
+			continue
+		}
+		fit = append(fit, name)
+	}
+	// 没有供应商能容纳输入时仍然尝试，由供应商决定是否截断或报错。
+	if len(fit) == 0 {
+		fit = oversize
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	healthy := make([]string, 0, len(fit))
+	var ejected []string
+	for _, name := range fit {
+		if h, ok := s.health[name]; ok && now.Before(h.ejectedUntil) {
+			ejected = append(ejected, name)
+			continue
+		}
+		healthy = append(healthy, name)
+	}
+	return append(healthy, ejected...)
+}
+
+func (s *LlmService) markSuccess(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.health, name)
+}
+
+func (s *LlmService) markFailure(name string, now time.Time) {
+	threshold := s.llm.Health.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	eject := time.Duration(s.llm.Health.EjectSeconds) * time.Second
+	if eject <= 0 {
+		eject = defaultEjectDuration
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.health[name]
+	if !ok {
+		h = &providerHealth{}
+		s.health[name] = h
+	}
+	h.failures++
+	if h.failures >= threshold {
+		h.ejectedUntil = now.Add(eject)
+		h.failures = 0
+		logger.Warn().Str("llm", name).Dur("eject", eject).Msg("LLM 供应商连续失败，暂时剔除")
+	}
+}
+
+// isProviderFailure 判断错误是否由供应商不可用引起，请求本身的问题不计入健康状态。
+func isProviderFailure(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode != http.StatusBadRequest
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode != http.StatusBadRequest
+	}
+	return true
+}
+
+func (s *LlmService) complete(ctx context.Context, name string, systemPrompt string, userPrompt string) (*LlmResponse, error) {
+	llmConfig := s.getLlmConfig(name)
+	callCtx, cancel := context.WithTimeout(ctx, newRetryPolicy(s.llm.LLMConfig[name]).timeout)
+	defer cancel()
+	callCtx, holder := withRetryAfterHolder(callCtx)
+	resp, err := s.client(name).CreateChatCompletion(
+		callCtx,
 		openai.ChatCompletionRequest{
 			Model: llmConfig.Model,
 			Messages: []openai.ChatCompletionMessage{
@@ -50,11 +316,11 @@ func (s *LlmService) Llm(ctx context.Context, systemPrompt string, userPrompt st
 		},
 	)
 	if err != nil {
-		return nil, err
+		return nil, holder.wrap(err)
 	}
 
 	llmResponse := &LlmResponse{
-		LlmName:           s.llm.Use,
+		LlmName:           name,
 		Resp:              &resp,
 		TotalTokens:       resp.Usage.TotalTokens,
 		PromptTokens:      resp.Usage.PromptTokens,
@@ -70,14 +336,13 @@ func (s *LlmService) Llm(ctx context.Context, systemPrompt string, userPrompt st
 	return llmResponse, nil
 }
 
-func (s *LlmService) LlmWithStream(ctx context.Context, systemPrompt string, userPrompt string) (*LlmResponse, error) {
-	llmConfig := s.getLlmConfig()
-	conf := openai.DefaultConfig(llmConfig.APIKey)
-	conf.BaseURL = llmConfig.BaseURL
-	client := openai.NewClientWithConfig(conf)
-
-	stream, err := client.CreateChatCompletionStream(
-		ctx,
+func (s *LlmService) stream(ctx context.Context, name string, systemPrompt string, userPrompt string) (*LlmResponse, error) {
+	llmConfig := s.getLlmConfig(name)
+	// 流的生命周期由调用方控制，超时覆盖整个读取过程，Close 时释放
+	callCtx, cancel := context.WithTimeout(ctx, newRetryPolicy(s.llm.LLMConfig[name]).streamTimeout)
+	callCtx, holder := withRetryAfterHolder(callCtx)
+	stream, err := s.client(name).CreateChatCompletionStream(
+		callCtx,
 		openai.ChatCompletionRequest{
 			Model: llmConfig.Model,
 			Messages: []openai.ChatCompletionMessage{
@@ -98,12 +363,14 @@ func (s *LlmService) LlmWithStream(ctx context.Context, systemPrompt string, use
 		},
 	)
 	if err != nil {
-		return nil, err
+		cancel()
+		return nil, holder.wrap(err)
 	}
 
 	llmResponse := &LlmResponse{
-		LlmName:           s.llm.Use,
+		LlmName:           name,
 		Stream:            stream,
+		cancel:            cancel,
 		InputMTokenPrice:  llmConfig.InputPrice,
 		OutputMTokenPrice: llmConfig.OutputPrice,
 	}
@@ -113,6 +380,7 @@ func (s *LlmService) LlmWithStream(ctx context.Context, systemPrompt string, use
 
 type LlmResponse struct {
 	LlmName           string
+	Attempts          int // 实际请求次数（含重试），成本只按最终成功的一次计算
 	Resp              *openai.ChatCompletionResponse
 	Stream            *openai.ChatCompletionStream
 	TotalTokens       int
@@ -123,9 +391,27 @@ type LlmResponse struct {
 	InputCost         float64
 	OutputCost        float64
 	TotalCost         float64
+
+	cancel  context.CancelFunc
+	release func()
+}
+
+// Close 关闭流式响应并释放调用超时。
+func (r *LlmResponse) Close() {
+	if r.Stream != nil {
+		r.Stream.Close()
+	}
+	if r.cancel != nil {
+		r.cancel()
+	}
+	if r.release != nil {
+		r.release()
+	}
 }
 
 type LlmServiceInterface interface {
-	Llm(ctx context.Context, systemPrompt string, userPrompt string) (*LlmResponse, error)
-	LlmWithStream(ctx context.Context, systemPrompt string, userPrompt string) (*LlmResponse, error)
+	Llm(ctx context.Context, promptID uint, systemPrompt string, userPrompt string) (*LlmResponse, error)
+	LlmWithStream(ctx context.Context, promptID uint, systemPrompt string, userPrompt string) (*LlmResponse, error)
+	Stats() *LlmStats
+	Pricing(promptID uint, inputChars int) *LlmPricing
 }
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zqr233qr/story-trim/pkg/logger"
+)
+
+// LlmPriority LLM 调用的排队优先级。
+type LlmPriority int
+
+const (
+	LlmPriorityInteractive LlmPriority = iota // 用户正在等待的调用，如流式精简
+	LlmPriorityBatch                          // 后台任务，如全书精简、百科更新
+)
+
+const (
+	defaultMaxConcurrency = 16
+	slowQueueThreshold    = time.Second // 排队超过该时长记录告警
+)
+
+type llmPriorityKey struct{}
+
+// WithLlmPriority 为后续 LLM 调用指定排队优先级。
+func WithLlmPriority(ctx context.Context, priority LlmPriority) context.Context {
+	return context.WithValue(ctx, llmPriorityKey{}, priority)
+}
+
+func llmPriorityFrom(ctx context.Context, fallback LlmPriority) LlmPriority {
+	if p, ok := ctx.Value(llmPriorityKey{}).(LlmPriority); ok {
+		return p
+	}
+	return fallback
+}
+
+// LlmQueueStats 单个优先级的排队统计。
+type LlmQueueStats struct {
+	Waiting   int     `json:"waiting"`     // 当前排队数
+	InFlight  int     `json:"in_flight"`   // 当前占用的并发数
+	Acquired  int64   `json:"acquired"`    // 累计获得并发的次数
+	AvgWaitMs float64 `json:"avg_wait_ms"` // 平均排队时长
+	MaxWaitMs float64 `json:"max_wait_ms"` // 最长排队时长
+}
+
+// LlmStats LLM 全局并发与排队情况。
+type LlmStats struct {
+	MaxConcurrency     int           `json:"max_concurrency"`
+	InteractiveReserve int           `json:"interactive_reserve"`
+	InUse              int           `json:"in_use"`
+	Interactive        LlmQueueStats `json:"interactive"`
+	Batch              LlmQueueStats `json:"batch"`
+}
+
+type queueCounter struct {
+	inFlight  int
+	acquired  int64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+type governorWaiter struct {
+	ready chan struct{}
+}
+
+// llmGovernor 全局 LLM 并发控制。交互调用优先获得空闲并发，
+// 后台任务最多占用 limit-reserve 个并发，为交互调用保留余量。
+type llmGovernor struct {
+	mu       sync.Mutex
+	limit    int
+	reserve  int
+	inUse    int
+	waiters  [2]*list.List
+	counters [2]queueCounter
+}
+
+func newLlmGovernor(limit int, reserve int) *llmGovernor {
+	if limit <= 0 {
+		limit = defaultMaxConcurrency
+	}
+	reserve = min(max(reserve, 0), limit-1)
+	return &llmGovernor{
+		limit:   limit,
+		reserve: reserve,
+		waiters: [2]*list.List{list.New(), list.New()},
+	}
+}
+
+// acquire 获取一个并发名额，返回的 release 必须调用且只生效一次。
+func (g *llmGovernor) acquire(ctx context.Context, priority LlmPriority) (func(), error) {
+	start := time.Now()
+	g.mu.Lock()
+	if g.queueEmpty(priority) && g.canRun(priority) {
+		g.grant(priority)
+		g.recordWait(priority, 0)
+		g.mu.Unlock()
+		return g.releaser(priority), nil
+	}
+	w := &governorWaiter{ready: make(chan struct{})}
+	elem := g.waiters[priority].PushBack(w)
+	g.mu.Unlock()
+
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		g.mu.Lock()
+		select {
+		case <-w.ready:
+			// 取消的同时已被分配名额，归还即可
+			g.mu.Unlock()
+			g.releaser(priority)()
+		default:
+			g.waiters[priority].Remove(elem)
+			g.mu.Unlock()
+		}
+		return nil, ctx.Err()
+	}
+
+	wait := time.Since(start)
+	g.mu.Lock()
+	g.recordWait(priority, wait)
+	g.mu.Unlock()
+	if wait > slowQueueThreshold {
+		logger.Warn().Int("priority", int(priority)).Dur("wait", wait).Msg("LLM 调用排队时间过长")
+	}
+	return g.releaser(priority), nil
+}
+
+func (g *llmGovernor) releaser(priority LlmPriority) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			g.inUse--
+			g.counters[priority].inFlight--
+			g.dispatch()
+		})
+	}
+}
+
+// queueEmpty 判断是否没有同级或更高优先级的调用在排队，避免插队。
+func (g *llmGovernor) queueEmpty(priority LlmPriority) bool {
+	for p := LlmPriorityInteractive; p <= priority; p++ {
+		if g.waiters[p].Len() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *llmGovernor) canRun(priority LlmPriority) bool {
+	if g.inUse >= g.limit {
+		return false
+	}
+	if priority == LlmPriorityBatch {
+		return g.counters[LlmPriorityBatch].inFlight < g.limit-g.reserve
+	}
+	return true
+}
+
+func (g *llmGovernor) grant(priority LlmPriority) {
+	g.inUse++
+	g.counters[priority].inFlight++
+}
+
+func (g *llmGovernor) recordWait(priority LlmPriority, wait time.Duration) {
+	c := &g.counters[priority]
+	c.acquired++
+	c.totalWait += wait
+	c.maxWait = max(c.maxWait, wait)
+}
+
+// dispatch 按优先级唤醒排队的调用，需持有锁。
+func (g *llmGovernor) dispatch() {
+	for p := LlmPriorityInteractive; p <= LlmPriorityBatch; p++ {
+		for g.waiters[p].Len() > 0 && g.canRun(p) {
+			w := g.waiters[p].Remove(g.waiters[p].Front()).(*governorWaiter)
+			g.grant(p)
+			close(w.ready)
+		}
+	}
+}
+
+func (g *llmGovernor) stats() *LlmStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	queue := func(p LlmPriority) LlmQueueStats {
+		c := g.counters[p]
+		s := LlmQueueStats{
+			Waiting:   g.waiters[p].Len(),
+			InFlight:  c.inFlight,
+			Acquired:  c.acquired,
+			MaxWaitMs: float64(c.maxWait) / float64(time.Millisecond),
+		}
+		if c.acquired > 0 {
+			s.AvgWaitMs = float64(c.totalWait) / float64(c.acquired) / float64(time.Millisecond)
+		}
+		return s
+	}
+	return &LlmStats{
+		MaxConcurrency:     g.limit,
+		InteractiveReserve: g.reserve,
+		InUse:              g.inUse,
+		Interactive:        queue(LlmPriorityInteractive),
+		Batch:              queue(LlmPriorityBatch),
+	}
+}
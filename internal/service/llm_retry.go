@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/zqr233qr/story-trim/internal/config"
+)
+
+const (
+	defaultLlmTimeout       = 120 * time.Second
+	defaultLlmStreamTimeout = 600 * time.Second
+	defaultMaxRetries       = 2
+	defaultRetryBase        = 500 * time.Millisecond
+	defaultRetryMax         = 10 * time.Second
+)
+
+// retryPolicy 单个供应商的重试与超时策略。
+type retryPolicy struct {
+	maxRetries    int
+	base          time.Duration
+	max           time.Duration
+	timeout       time.Duration
+	streamTimeout time.Duration
+}
+
+func newRetryPolicy(conf config.LLMConfig) retryPolicy {
+	p := retryPolicy{
+		maxRetries:    conf.MaxRetries,
+		base:          time.Duration(conf.RetryBaseMs) * time.Millisecond,
+		max:           time.Duration(conf.RetryMaxMs) * time.Millisecond,
+		timeout:       time.Duration(conf.Timeout) * time.Second,
+		streamTimeout: time.Duration(conf.StreamTimeout) * time.Second,
+	}
+	if p.maxRetries == 0 {
+		p.maxRetries = defaultMaxRetries
+	} else if p.maxRetries < 0 {
+		p.maxRetries = 0
+	}
+	if p.base <= 0 {
+		p.base = defaultRetryBase
+	}
+	if p.max <= 0 {
+		p.max = defaultRetryMax
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultLlmTimeout
+	}
+	if p.streamTimeout <= 0 {
+		p.streamTimeout = defaultLlmStreamTimeout
+	}
+	return p
+}
+
+// backoff 返回第 attempt 次失败后的等待时长，指数退避并加入随机抖动。
+// 供应商给出 Retry-After 时以其为准，超过等待上限时返回 false，由调用方切换供应商。
+func (p retryPolicy) backoff(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if retryAfter > 0 {
+		return retryAfter, retryAfter <= p.max
+	}
+	d := p.base << attempt
+	if d <= 0 || d > p.max {
+		d = p.max
+	}
+	return d/2 + rand.N(d/2+1), true
+}
+
+// isRetryable 判断错误是否值得对同一供应商重试。
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	status := 0
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	if errors.As(err, &apiErr) {
+		status = apiErr.HTTPStatusCode
+	} else if errors.As(err, &reqErr) {
+		status = reqErr.HTTPStatusCode
+	}
+	switch status {
+	case 0, http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		// 状态码为 0 表示网络错误或单次调用超时
+		return true
+	}
+	return false
+}
+
+// retryAfterError 携带供应商返回的 Retry-After。
+type retryAfterError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+func retryAfterOf(err error) time.Duration {
+	var e *retryAfterError
+	if errors.As(err, &e) {
+		return e.retryAfter
+	}
+	return 0
+}
+
+type retryAfterKey struct{}
+
+// retryAfterHolder 接收单次调用失败响应中的 Retry-After。
+type retryAfterHolder struct {
+	retryAfter time.Duration
+}
+
+// withRetryAfterHolder 为一次调用附加 Retry-After 接收器，供共享的 transport 回填。
+func withRetryAfterHolder(ctx context.Context) (context.Context, *retryAfterHolder) {
+	h := &retryAfterHolder{}
+	return context.WithValue(ctx, retryAfterKey{}, h), h
+}
+
+// wrap 在错误上附加记录到的 Retry-After。
+func (h *retryAfterHolder) wrap(err error) error {
+	if h.retryAfter <= 0 {
+		return err
+	}
+	return &retryAfterError{err: err, retryAfter: h.retryAfter}
+}
+
+// retryAfterTransport 把失败响应中的 Retry-After 写入请求上下文里的接收器。
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		if h, ok := req.Context().Value(retryAfterKey{}).(*retryAfterHolder); ok {
+			h.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+	}
+	return resp, err
+}
+
+// parseRetryAfter 解析秒数或 HTTP 日期格式的 Retry-After。
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// tokenBucket 令牌桶，容量为每分钟额度。令牌可以透支，后来的调用按欠额排队等待。
+type tokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	rate     float64 // 每秒补充的令牌数
+	last     time.Time
+}
+
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	c := float64(perMinute)
+	return &tokenBucket{capacity: c, tokens: c, rate: c / 60, last: now}
+}
+
+// reserve 预占 n 个令牌，返回需要等待的时长。
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= min(n, b.capacity)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// providerLimiter 单个供应商的请求数与 token 数限流，未配置的维度不限。
+type providerLimiter struct {
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+func newProviderLimiter(conf config.LLMConfig, now time.Time) *providerLimiter {
+	l := &providerLimiter{}
+	if conf.RPM > 0 {
+		l.requests = newTokenBucket(conf.RPM, now)
+	}
+	if conf.TPM > 0 {
+		l.tokens = newTokenBucket(conf.TPM, now)
+	}
+	return l
+}
+
+// wait 等待到可以发起一次约 tokens 个 token 的请求。
+func (l *providerLimiter) wait(ctx context.Context, tokens int) error {
+	now := time.Now()
+	var d time.Duration
+	if l.requests != nil {
+		d = l.requests.reserve(1, now)
+	}
+	if l.tokens != nil {
+		d = max(d, l.tokens.reserve(float64(tokens), now))
+	}
+	return sleepCtx(ctx, d)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
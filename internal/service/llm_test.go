@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/zqr233qr/story-trim/internal/config"
+)
+
+func newTestLlmService() *LlmService {
+	return NewLlmService(&config.LLM{
+		Use:       "cheap",
+		Fallbacks: []string{"backup"},
+		Routes: []config.LLMRoute{
+			{PromptIDs: []uint{100}, Chain: []string{"strong", "cheap"}},
+			{MinChars: 1000, Chain: []string{"long", "strong"}},
+		},
+		Health: config.LLMHealthConfig{FailureThreshold: 2, EjectSeconds: 30},
+		LLMConfig: map[string]config.LLMConfig{
+			"cheap":  {MaxInputChars: 500},
+			"backup": {},
+			"strong": {},
+			"long":   {},
+		},
+	})
+}
+
+func TestLlmCandidates_Routes(t *testing.T) {
+	s := newTestLlmService()
+	now := time.Now()
+	tests := []struct {
+		name     string
+		promptID uint
+		chars    int
+		want     []string
+	}{
+		{"默认调用链", 1, 100, []string{"cheap", "backup"}},
+		{"按提示词路由", 100, 100, []string{"strong", "cheap"}},
+		{"按长度路由", 1, 2000, []string{"long", "strong"}},
+		{"跳过输入超长的供应商", 100, 800, []string{"strong"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.candidates(tt.promptID, tt.chars, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("candidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLlmCandidates_Eject(t *testing.T) {
+	s := newTestLlmService()
+	now := time.Now()
+
+	s.markFailure("cheap", now)
+	if got := s.candidates(1, 100, now); got[0] != "cheap" {
+		t.Fatalf("未达到阈值不应剔除: %v", got)
+	}
+	s.markFailure("cheap", now)
+	if got := s.candidates(1, 100, now); !reflect.DeepEqual(got, []string{"backup", "cheap"}) {
+		t.Fatalf("剔除后应排到最后: %v", got)
+	}
+	if got := s.candidates(1, 100, now.Add(31*time.Second)); got[0] != "cheap" {
+		t.Fatalf("剔除到期后应恢复: %v", got)
+	}
+
+	s.markFailure("cheap", now)
+	s.markSuccess("cheap")
+	s.markFailure("cheap", now)
+	if got := s.candidates(1, 100, now); got[0] != "cheap" {
+		t.Fatalf("成功后应清零连续失败次数: %v", got)
+	}
+}
+
+func TestIsProviderFailure(t *testing.T) {
+	if isProviderFailure(&openai.APIError{HTTPStatusCode: http.StatusBadRequest}) {
+		t.Error("400 不应计入供应商故障")
+	}
+	if !isProviderFailure(&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}) {
+		t.Error("429 应计入供应商故障")
+	}
+	if !isProviderFailure(errors.New("connection refused")) {
+		t.Error("网络错误应计入供应商故障")
+	}
+}
+
+func TestLlm_RetryTransientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}],"usage":{"prompt_tokens":1000000,"completion_tokens":0,"total_tokens":1000000}}`))
+	}))
+	defer server.Close()
+
+	s := NewLlmService(&config.LLM{
+		Use: "p",
+		LLMConfig: map[string]config.LLMConfig{
+			"p": {BaseURL: server.URL, InputPrice: 1, RetryBaseMs: 1, MaxRetries: 2},
+		},
+	})
+	resp, err := s.Llm(context.Background(), 0, "system", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Attempts != 3 || calls.Load() != 3 {
+		t.Fatalf("attempts = %d, calls = %d, want 3", resp.Attempts, calls.Load())
+	}
+	// 成本只按最终成功的响应计算一次
+	if resp.TotalCost != 100 {
+		t.Errorf("TotalCost = %v, want 100", resp.TotalCost)
+	}
+}
+
+func TestLlm_NoRetryOnBadRequest(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	s := NewLlmService(&config.LLM{
+		Use:       "p",
+		LLMConfig: map[string]config.LLMConfig{"p": {BaseURL: server.URL, RetryBaseMs: 1}},
+	})
+	if _, err := s.Llm(context.Background(), 0, "system", "user"); err == nil {
+		t.Fatal("expected error")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := newRetryPolicy(config.LLMConfig{RetryBaseMs: 100, RetryMaxMs: 1000})
+	for attempt := 0; attempt < 6; attempt++ {
+		d, ok := p.backoff(attempt, 0)
+		want := min(100*time.Millisecond<<attempt, time.Second)
+		if !ok || d < want/2 || d > want {
+			t.Errorf("attempt %d: backoff = %v, want within [%v, %v]", attempt, d, want/2, want)
+		}
+	}
+	if d, ok := p.backoff(0, 500*time.Millisecond); !ok || d != 500*time.Millisecond {
+		t.Errorf("Retry-After 应优先: %v %v", d, ok)
+	}
+	if _, ok := p.backoff(0, 5*time.Second); ok {
+		t.Error("Retry-After 超过上限时应放弃重试")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d := parseRetryAfter("3", now); d != 3*time.Second {
+		t.Errorf("seconds: %v", d)
+	}
+	if d := parseRetryAfter(now.Add(10*time.Second).Format(http.TimeFormat), now); d != 10*time.Second {
+		t.Errorf("http date: %v", d)
+	}
+	if d := parseRetryAfter("soon", now); d != 0 {
+		t.Errorf("invalid: %v", d)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(60, now) // 每秒补充 1 个
+	if d := b.reserve(60, now); d != 0 {
+		t.Fatalf("首次应直接通过: %v", d)
+	}
+	if d := b.reserve(2, now); d != 2*time.Second {
+		t.Fatalf("透支 2 个应等待 2s: %v", d)
+	}
+	if d := b.reserve(1, now.Add(3*time.Second)); d != 0 {
+		t.Fatalf("补充后应直接通过: %v", d)
+	}
+}
+
+func TestLlmGovernor_Priority(t *testing.T) {
+	g := newLlmGovernor(2, 1)
+	ctx := context.Background()
+
+	// 后台任务最多占用 limit-reserve 个并发
+	releaseBatch, err := g.acquire(ctx, LlmPriorityBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := g.acquire(timeout, LlmPriorityBatch); err == nil {
+		t.Fatal("后台任务不应占用保留的并发")
+	}
+	releaseInteractive, err := g.acquire(ctx, LlmPriorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发已满时，交互调用先于排在前面的后台任务获得名额
+	order := make(chan LlmPriority, 2)
+	for _, p := range []LlmPriority{LlmPriorityBatch, LlmPriorityInteractive} {
+		go func(p LlmPriority) {
+			release, err := g.acquire(ctx, p)
+			if err != nil {
+				return
+			}
+			order <- p
+			release()
+		}(p)
+		time.Sleep(10 * time.Millisecond)
+	}
+	releaseInteractive()
+	if p := <-order; p != LlmPriorityInteractive {
+		t.Errorf("first = %v, want interactive", p)
+	}
+	releaseBatch()
+	if p := <-order; p != LlmPriorityBatch {
+		t.Errorf("second = %v, want batch", p)
+	}
+
+	stats := g.stats()
+	if stats.InUse != 0 || stats.Interactive.Acquired != 2 || stats.Batch.Acquired != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
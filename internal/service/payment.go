@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+// PaymentIntent 拉起支付所需的参数，由客户端按支付方式处理。
+type PaymentIntent struct {
+	Provider string            `json:"provider"`
+	PayURL   string            `json:"pay_url,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+// PaymentNotification 验签通过的支付结果通知。
+type PaymentNotification struct {
+	OrderNo string
+	TradeNo string // 渠道交易号
+	Amount  int    // 实付金额（分）
+	PaidAt  time.Time
+}
+
+// fakeProviderName 本地模拟支付渠道名。
+const fakeProviderName = "fake"
+
+// fakeSignatureHeader 模拟支付回调携带签名的请求头。
+const fakeSignatureHeader = "X-Fake-Signature"
+
+// FakePaymentProvider 本地模拟支付渠道，用于开发与测试：不发生真实扣款，
+// 回调报文以 HMAC-SHA256 签名，验签流程与真实渠道一致。
+type FakePaymentProvider struct {
+	secret []byte
+}
+
+type fakeCallback struct {
+	OrderNo string `json:"order_no"`
+	TradeNo string `json:"trade_no"`
+	Amount  int    `json:"amount"`
+	PaidAt  int64  `json:"paid_at"`
+}
+
+// NewFakePaymentProvider 创建模拟支付渠道。
+func NewFakePaymentProvider(secret string) *FakePaymentProvider {
+	return &FakePaymentProvider{secret: []byte(secret)}
+}
+
+// Name 渠道名。
+func (p *FakePaymentProvider) Name() string {
+	return fakeProviderName
+}
+
+// CreatePayment 模拟下单，客户端调用模拟支付接口即可完成支付。
+func (p *FakePaymentProvider) CreatePayment(ctx context.Context, order *model.RechargeOrder) (*PaymentIntent, error) {
+	return &PaymentIntent{
+		Provider: fakeProviderName,
+		PayURL:   "/api/v1/payments/fake/pay",
+		Params:   map[string]string{"order_no": order.OrderNo},
+	}, nil
+}
+
+// ParseCallback 校验签名并解析回调报文。
+func (p *FakePaymentProvider) ParseCallback(ctx context.Context, header http.Header, body []byte) (*PaymentNotification, error) {
+	signature, err := hex.DecodeString(header.Get(fakeSignatureHeader))
+	if err != nil || !hmac.Equal(signature, p.sign(body)) {
+		return nil, errno.ErrRechargeSignature
+	}
+	var callback fakeCallback
+	if err := json.Unmarshal(body, &callback); err != nil || callback.OrderNo == "" {
+		return nil, errno.ErrParam
+	}
+	return &PaymentNotification{
+		OrderNo: callback.OrderNo,
+		TradeNo: callback.TradeNo,
+		Amount:  callback.Amount,
+		PaidAt:  time.Unix(callback.PaidAt, 0),
+	}, nil
+}
+
+// Refund 模拟退款，总是成功。
+func (p *FakePaymentProvider) Refund(ctx context.Context, order *model.RechargeOrder) error {
+	return nil
+}
+
+// SignCallback 生成订单已全额支付的签名回调，模拟渠道发起的支付通知。
+func (p *FakePaymentProvider) SignCallback(order *model.RechargeOrder, paidAt time.Time) (http.Header, []byte) {
+	body, _ := json.Marshal(fakeCallback{
+		OrderNo: order.OrderNo,
+		TradeNo: fmt.Sprintf("FAKE%s", order.OrderNo),
+		Amount:  order.Amount,
+		PaidAt:  paidAt.Unix(),
+	})
+	header := http.Header{}
+	header.Set(fakeSignatureHeader, hex.EncodeToString(p.sign(body)))
+	return header, body
+}
+
+func (p *FakePaymentProvider) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// PaymentProvider 支付渠道。接入新渠道时实现该接口并在 RechargeService 中注册。
+type PaymentProvider interface {
+	// Name 渠道名，与订单的 Provider 及回调路径一致。
+	Name() string
+	// CreatePayment 向渠道下单，返回客户端拉起支付所需的参数。
+	CreatePayment(ctx context.Context, order *model.RechargeOrder) (*PaymentIntent, error)
+	// ParseCallback 校验回调签名并解析支付结果，签名无效时返回 errno.ErrRechargeSignature。
+	ParseCallback(ctx context.Context, header http.Header, body []byte) (*PaymentNotification, error)
+	// Refund 全额退款，需以订单号幂等，重复调用不会重复退款。
+	Refund(ctx context.Context, order *model.RechargeOrder) error
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+func TestFakePaymentProvider_ParseCallback(t *testing.T) {
+	provider := NewFakePaymentProvider("secret")
+	order := &model.RechargeOrder{OrderNo: "R1", Amount: 600}
+	paidAt := time.Unix(1700000000, 0)
+	header, body := provider.SignCallback(order, paidAt)
+	_, otherBody := provider.SignCallback(&model.RechargeOrder{OrderNo: "R1", Amount: 1}, paidAt)
+	otherHeader, _ := NewFakePaymentProvider("other").SignCallback(order, paidAt)
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		body    []byte
+		wantErr error
+	}{
+		{"valid", header, body, nil},
+		{"tampered body", header, otherBody, errno.ErrRechargeSignature},
+		{"wrong secret", otherHeader, body, errno.ErrRechargeSignature},
+		{"missing signature", http.Header{}, body, errno.ErrRechargeSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := provider.ParseCallback(context.Background(), tt.header, tt.body)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.OrderNo != "R1" || got.Amount != 600 || !got.PaidAt.Equal(paidAt)) {
+				t.Fatalf("notification = %+v", got)
+			}
+		})
+	}
+}
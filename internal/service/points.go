@@ -3,10 +3,12 @@ package service
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"time"
 
+	"github.com/zqr233qr/story-trim/internal/config"
 	"github.com/zqr233qr/story-trim/internal/model"
 	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
 )
 
 const (
@@ -14,6 +16,9 @@ const (
 	pointsTypeSpend = "spend"
 )
 
+// holdReconcileBatch 对账时每批处理的预占数量。
+const holdReconcileBatch = 100
+
 const (
 	pointsReasonRegister = "register_bonus"
 	pointsReasonTrimUse  = "trim_use"
@@ -22,83 +27,133 @@ const (
 
 // PointsService 积分服务。
 type PointsService struct {
-	repo repository.PointsRepositoryInterface
+	repo    repository.PointsRepositoryInterface
+	pricing *config.PricingConfig
 }
 
 // PointsChangeInput 积分变更输入。
 type PointsChangeInput struct {
-	RefType string
-	RefID   string
-	Extra   map[string]string
+	Amount         int
+	RefType        string
+	RefID          string
+	Extra          map[string]string
+	IdempotencyKey string // 非空时重复提交只预占一次
 }
 
-// NewPointsService 创建积分服务。
-func NewPointsService(repo repository.PointsRepositoryInterface) *PointsService {
-	return &PointsService{repo: repo}
+type idempotencyKey struct{}
+
+// WithIdempotencyKey 携带客户端提交的幂等键，相同键的重复提交返回首次的结果。
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
 }
 
-// GrantRegisterBonus 注册赠送积分。
-func (s *PointsService) GrantRegisterBonus(ctx context.Context, userID uint, amount int) error {
-	_, err := s.repo.ChangeBalance(ctx, userID, amount, pointsTypeEarn, pointsReasonRegister, "user", fmt.Sprintf("%d", userID), nil)
-	return err
+func idempotencyKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
 }
 
-// SpendForTrim 扣除精简积分。
-func (s *PointsService) SpendForTrim(ctx context.Context, userID uint, count int, refType, refID string, extra map[string]string) error {
-	if count <= 0 {
-		return nil
-	}
-	_, err := s.repo.ChangeBalance(ctx, userID, -count, pointsTypeSpend, pointsReasonTrimUse, refType, refID, extra)
-	return err
+// NewPointsService 创建积分服务。
+func NewPointsService(repo repository.PointsRepositoryInterface, cfg *config.PointsConfig) *PointsService {
+	return &PointsService{repo: repo, pricing: &cfg.Pricing}
+}
+
+// PriceTrim 按提示词的计价规则计算精简一章所需积分。
+func (s *PointsService) PriceTrim(promptID uint, chars int) TrimPrice {
+	return priceTrim(priceRule(s.pricing, promptID), chars)
 }
 
-// SpendForTrimBatch 批量扣除精简积分。
-func (s *PointsService) SpendForTrimBatch(ctx context.Context, userID uint, entries []PointsChangeInput) error {
+// HoldForTrim 按条目的 Amount 预占精简积分，返回与 entries 顺序一致的预占 ID。
+// 预占在结算前计入冻结，超过 ttl 仍未结算时由对账释放。
+func (s *PointsService) HoldForTrim(ctx context.Context, userID uint, entries []PointsChangeInput, ttl time.Duration) ([]uint, error) {
 	if len(entries) == 0 {
-		return nil
+		return nil, nil
 	}
-	changes := make([]repository.PointsChange, 0, len(entries))
+	expiresAt := time.Now().Add(ttl)
+	reqs := make([]repository.PointsHoldRequest, 0, len(entries))
 	for _, entry := range entries {
-		changes = append(changes, repository.PointsChange{
-			Change:   -1,
-			Type:     pointsTypeSpend,
-			Reason:   pointsReasonTrimUse,
-			RefType:  entry.RefType,
-			RefID:    entry.RefID,
-			ExtraMap: entry.Extra,
+		reqs = append(reqs, repository.PointsHoldRequest{
+			Amount:         max(entry.Amount, 0),
+			Type:           pointsTypeSpend,
+			Reason:         pointsReasonTrimUse,
+			RefType:        entry.RefType,
+			RefID:          entry.RefID,
+			ExtraMap:       entry.Extra,
+			ExpiresAt:      expiresAt,
+			IdempotencyKey: entry.IdempotencyKey,
 		})
 	}
-	_, err := s.repo.ChangeBalanceBatch(ctx, userID, changes)
-	return err
+	holds, err := s.repo.CreateHolds(ctx, userID, reqs)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(holds))
+	for _, hold := range holds {
+		ids = append(ids, hold.ID)
+	}
+	return ids, nil
 }
 
-// RefundForTrim 退还精简积分。
-func (s *PointsService) RefundForTrim(ctx context.Context, userID uint, count int, refType, refID string, extra map[string]string) error {
-	if count <= 0 {
-		return nil
+// SettleHold 结算预占的积分。
+func (s *PointsService) SettleHold(ctx context.Context, holdID uint) error {
+	settled, err := s.repo.SettleHold(ctx, holdID)
+	if err != nil {
+		return err
+	}
+	if !settled {
+		// 预占已被对账释放时本次处理视为免费；重复请求复用同一预占时已由先完成的一方结算
+		logger.Warn().Uint("hold_id", holdID).Msg("积分预占已结算或已释放，跳过结算")
 	}
-	_, err := s.repo.ChangeBalance(ctx, userID, count, pointsTypeEarn, pointsReasonRefund, refType, refID, extra)
+	return nil
+}
+
+// ReleaseHold 释放预占的积分，退回可用余额。已结算或已释放的预占忽略。
+func (s *PointsService) ReleaseHold(ctx context.Context, holdID uint) error {
+	_, err := s.repo.ReleaseHold(ctx, holdID, pointsTypeEarn, pointsReasonRefund)
 	return err
 }
 
-// RefundForTrimBatch 批量退还精简积分。
-func (s *PointsService) RefundForTrimBatch(ctx context.Context, userID uint, entries []PointsChangeInput) error {
-	if len(entries) == 0 {
-		return nil
+// ReleaseHoldsByRef 释放关联对象上仍未结算的预占，返回释放数量。
+func (s *PointsService) ReleaseHoldsByRef(ctx context.Context, refType string, refIDs []string) (int, error) {
+	holds, err := s.repo.ListHeldByRef(ctx, refType, refIDs)
+	if err != nil {
+		return 0, err
 	}
-	changes := make([]repository.PointsChange, 0, len(entries))
-	for _, entry := range entries {
-		changes = append(changes, repository.PointsChange{
-			Change:   1,
-			Type:     pointsTypeEarn,
-			Reason:   pointsReasonRefund,
-			RefType:  entry.RefType,
-			RefID:    entry.RefID,
-			ExtraMap: entry.Extra,
-		})
+	return s.releaseHolds(ctx, holds), nil
+}
+
+// ExtendHoldsByRef 将关联对象上仍未结算的预占有效期顺延 ttl，用于仍在处理中的长任务，返回顺延数量。
+func (s *PointsService) ExtendHoldsByRef(ctx context.Context, refType string, refIDs []string, ttl time.Duration) (int, error) {
+	return s.repo.ExtendHeldByRef(ctx, refType, refIDs, time.Now().Add(ttl))
+}
+
+// ReleaseExpiredHolds 对账：释放已过期仍未结算的预占，用于进程崩溃等未能正常结算的情况。
+func (s *PointsService) ReleaseExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	total := 0
+	for {
+		holds, err := s.repo.ListExpiredHolds(ctx, now, holdReconcileBatch)
+		if err != nil {
+			return total, err
+		}
+		total += s.releaseHolds(ctx, holds)
+		if len(holds) < holdReconcileBatch {
+			return total, nil
+		}
 	}
-	_, err := s.repo.ChangeBalanceBatch(ctx, userID, changes)
-	return err
+}
+
+func (s *PointsService) releaseHolds(ctx context.Context, holds []model.PointsHold) int {
+	released := 0
+	for _, hold := range holds {
+		ok, err := s.repo.ReleaseHold(ctx, hold.ID, pointsTypeEarn, pointsReasonRefund)
+		if err != nil {
+			logger.Error().Err(err).Uint("hold_id", hold.ID).Msg("释放积分预占失败")
+			continue
+		}
+		if ok {
+			released++
+		}
+	}
+	return released
 }
 
 // GetBalance 获取积分余额。
@@ -115,18 +170,7 @@ func (s *PointsService) GetBalance(ctx context.Context, userID uint) (int, error
 
 // ListLedger 获取积分流水。
 func (s *PointsService) ListLedger(ctx context.Context, userID uint, page, size int) ([]PointsLedgerEntry, error) {
-	if page <= 0 {
-		page = 1
-	}
-	if size <= 0 {
-		size = 20
-	}
-	if size > 100 {
-		size = 100
-	}
-	limit := size
-	offset := (page - 1) * size
-
+	limit, offset := pageLimit(page, size)
 	ledgers, err := s.repo.ListLedger(ctx, userID, limit, offset)
 	if err != nil {
 		return nil, err
@@ -140,11 +184,13 @@ func (s *PointsService) ListLedger(ctx context.Context, userID uint, page, size
 
 // PointsServiceInterface 积分服务接口。
 type PointsServiceInterface interface {
-	GrantRegisterBonus(ctx context.Context, userID uint, amount int) error
-	SpendForTrim(ctx context.Context, userID uint, count int, refType, refID string, extra map[string]string) error
-	SpendForTrimBatch(ctx context.Context, userID uint, entries []PointsChangeInput) error
-	RefundForTrim(ctx context.Context, userID uint, count int, refType, refID string, extra map[string]string) error
-	RefundForTrimBatch(ctx context.Context, userID uint, entries []PointsChangeInput) error
+	PriceTrim(promptID uint, chars int) TrimPrice
+	HoldForTrim(ctx context.Context, userID uint, entries []PointsChangeInput, ttl time.Duration) ([]uint, error)
+	SettleHold(ctx context.Context, holdID uint) error
+	ReleaseHold(ctx context.Context, holdID uint) error
+	ReleaseHoldsByRef(ctx context.Context, refType string, refIDs []string) (int, error)
+	ExtendHoldsByRef(ctx context.Context, refType string, refIDs []string, ttl time.Duration) (int, error)
+	ReleaseExpiredHolds(ctx context.Context, now time.Time) (int, error)
 	GetBalance(ctx context.Context, userID uint) (int, error)
 	ListLedger(ctx context.Context, userID uint, page, size int) ([]PointsLedgerEntry, error)
 }
@@ -169,11 +215,9 @@ type PointsBalanceResponse struct {
 
 // BuildPointsLedgerEntry 构造积分流水返回。
 func BuildPointsLedgerEntry(item model.PointsLedger) PointsLedgerEntry {
-	extra := map[string]string{}
-	if item.Extra != "" {
-		if err := json.Unmarshal([]byte(item.Extra), &extra); err != nil {
-			extra = map[string]string{}
-		}
+	extra := parseLedgerExtra(item.Extra)
+	if extra == nil {
+		extra = map[string]string{}
 	}
 	return PointsLedgerEntry{
 		ID:           item.ID,
@@ -187,3 +231,15 @@ func BuildPointsLedgerEntry(item model.PointsLedger) PointsLedgerEntry {
 		CreatedAt:    item.CreatedAt.Format("2006-01-02 15:04:05"),
 	}
 }
+
+// parseLedgerExtra 解析流水的附加信息，为空或格式错误时返回 nil。
+func parseLedgerExtra(raw string) map[string]string {
+	if raw == "" {
+		return nil
+	}
+	extra := map[string]string{}
+	if err := json.Unmarshal([]byte(raw), &extra); err != nil {
+		return nil
+	}
+	return extra
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+	"gorm.io/gorm"
+)
+
+// 积分获取规则的默认流水原因。
+const (
+	pointsReasonCheckIn  = "check_in"
+	pointsReasonReading  = "reading_reward"
+	pointsReasonReferrer = "invite_referrer"
+	pointsReasonInvitee  = "invite_invitee"
+)
+
+const (
+	inviteCodeLen      = 8
+	inviteCodeAttempts = 5
+
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // 去掉易混淆的 I、O、0、1
+)
+
+// defaultRegisterRule 未配置注册规则时沿用注册赠送 100 积分。
+var defaultRegisterRule = config.EarnRule{Enabled: true, Points: 100}
+
+// EarningService 积分获取规则：注册赠送、每日签到、阅读奖励与邀请奖励。
+// 每次发放都带幂等键，并在加锁的余额事务内校验规则上限与每日总上限。
+type EarningService struct {
+	repo      repository.PointsRepositoryInterface
+	referrals repository.ReferralRepositoryInterface
+	reading   repository.ReadingStatsRepositoryInterface
+	cfg       *config.EarningConfig
+	now       func() time.Time
+}
+
+// NewEarningService 创建积分获取服务。
+func NewEarningService(repo repository.PointsRepositoryInterface, referrals repository.ReferralRepositoryInterface, reading repository.ReadingStatsRepositoryInterface, cfg *config.PointsConfig) *EarningService {
+	return &EarningService{repo: repo, referrals: referrals, reading: reading, cfg: &cfg.Earning, now: time.Now}
+}
+
+// EarnResult 单次积分奖励结果。
+type EarnResult struct {
+	Reason  string `json:"reason"`
+	Earned  int    `json:"earned"` // 实际发放的积分，触达上限时可能少于规则积分
+	Balance int    `json:"balance"`
+}
+
+// CheckInResult 签到结果。
+type CheckInResult struct {
+	EarnResult
+	Streak  int  `json:"streak"`  // 连续签到天数
+	Already bool `json:"already"` // 今天已签到过
+}
+
+// InviteInfo 用户的邀请码与邀请人数。
+type InviteInfo struct {
+	Code    string `json:"code"`
+	Invited int64  `json:"invited"`
+}
+
+// OnRegister 发放注册奖励；referrerID 非 0 时记录邀请关系并奖励双方，邀请奖励失败不影响注册。
+func (s *EarningService) OnRegister(ctx context.Context, userID uint, referrerID uint) error {
+	rule := s.registerRule()
+	if _, err := s.earn(ctx, userID, rule, pointsReasonRegister, "user", fmt.Sprintf("%d", userID), fmt.Sprintf("register_bonus:%d", userID), rule.Points, nil); err != nil {
+		return err
+	}
+
+	if referrerID == 0 || referrerID == userID {
+		return nil
+	}
+	if err := s.referrals.CreateReferral(ctx, &model.Referral{ReferrerID: referrerID, InviteeID: userID}); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil
+		}
+		logger.Error().Err(err).Uint("user_id", userID).Uint("referrer_id", referrerID).Msg("记录邀请关系失败")
+		return nil
+	}
+	refID := fmt.Sprintf("%d", userID)
+	if rule := s.cfg.Invitee; rule != nil {
+		if _, err := s.earn(ctx, userID, rule, pointsReasonInvitee, "user", refID, fmt.Sprintf("earn:invitee:%d", userID), rule.Points, nil); err != nil {
+			logger.Error().Err(err).Uint("user_id", userID).Msg("发放被邀请奖励失败")
+		}
+	}
+	if rule := s.cfg.Referrer; rule != nil {
+		if _, err := s.earn(ctx, referrerID, rule, pointsReasonReferrer, "user", refID, fmt.Sprintf("earn:referrer:%d", userID), rule.Points, nil); err != nil {
+			logger.Error().Err(err).Uint("user_id", referrerID).Uint("invitee_id", userID).Msg("发放邀请奖励失败")
+		}
+	}
+	return nil
+}
+
+// ResolveInviteCode 校验邀请码并返回邀请人 ID。
+func (s *EarningService) ResolveInviteCode(ctx context.Context, code string) (uint, error) {
+	invite, err := s.referrals.GetInviteCode(ctx, strings.ToUpper(strings.TrimSpace(code)))
+	if err != nil {
+		return 0, err
+	}
+	if invite == nil {
+		return 0, errno.ErrPointsInviteInvalid
+	}
+	return invite.UserID, nil
+}
+
+// GetInviteInfo 获取用户的邀请码，首次获取时生成。
+func (s *EarningService) GetInviteInfo(ctx context.Context, userID uint) (*InviteInfo, error) {
+	invite, err := s.referrals.GetInviteCodeByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; invite == nil && i < inviteCodeAttempts; i++ {
+		candidate := &model.InviteCode{UserID: userID, Code: randomCode(inviteCodeLen)}
+		err = s.referrals.CreateInviteCode(ctx, candidate)
+		if err == nil {
+			invite = candidate
+			break
+		}
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, err
+		}
+		// 邀请码撞车时重新生成；并发请求已为该用户生成时直接使用
+		if invite, err = s.referrals.GetInviteCodeByUser(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	if invite == nil {
+		return nil, errno.ErrInternalServer
+	}
+
+	invited, err := s.referrals.CountReferrals(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &InviteInfo{Code: invite.Code, Invited: invited}, nil
+}
+
+// CheckIn 每日签到，昨天签到过时连续天数加一，奖励 Points + StreakBonus*(连续天数-1)。
+func (s *EarningService) CheckIn(ctx context.Context, userID uint) (*CheckInResult, error) {
+	rule := s.cfg.CheckIn
+	if rule == nil || !rule.Enabled {
+		return nil, errno.ErrPointsRuleDisabled
+	}
+	reason := ruleReason(rule, pointsReasonCheckIn)
+	now := s.now()
+	today := dayStart(now)
+
+	last, err := s.repo.LastLedger(ctx, userID, reason)
+	if err != nil {
+		return nil, err
+	}
+	streak := 1
+	if last != nil {
+		lastStreak := 1
+		if extra := parseLedgerExtra(last.Extra); extra != nil {
+			if n, err := strconv.Atoi(extra["streak"]); err == nil && n > 0 {
+				lastStreak = n
+			}
+		}
+		lastDay := dayStart(last.CreatedAt.In(now.Location()))
+		if lastDay.Equal(today) {
+			return &CheckInResult{EarnResult: EarnResult{Reason: reason, Balance: last.BalanceAfter}, Streak: lastStreak, Already: true}, nil
+		}
+		if lastDay.Equal(today.AddDate(0, 0, -1)) {
+			streak = lastStreak + 1
+		}
+	}
+
+	bonusDays := streak - 1
+	if rule.StreakCap > 0 {
+		bonusDays = min(bonusDays, rule.StreakCap)
+	}
+	day := today.Format("20060102")
+	result, err := s.earn(ctx, userID, rule, pointsReasonCheckIn, "check_in", day, fmt.Sprintf("earn:check_in:%d:%s", userID, day),
+		rule.Points+rule.StreakBonus*bonusDays, map[string]string{"streak": strconv.Itoa(streak)})
+	if err != nil {
+		return nil, err
+	}
+	return &CheckInResult{EarnResult: *result, Streak: streak}, nil
+}
+
+// RewardReading 按当日累计阅读时长发放已达到的里程碑奖励，每个里程碑每天只发一次。
+// 多设备同时阅读的重叠时段只计一次。
+func (s *EarningService) RewardReading(ctx context.Context, userID uint) ([]EarnResult, error) {
+	rule := s.cfg.Reading
+	if rule == nil || !rule.Enabled || len(rule.Milestones) == 0 {
+		return nil, nil
+	}
+	today := dayStart(s.now())
+	sessions, err := s.reading.ListFinishedSessions(ctx, userID, today, today.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	minutes := readingMinutes(sessions)
+
+	day := today.Format("20060102")
+	var results []EarnResult
+	for _, milestone := range rule.Milestones {
+		if milestone.Minutes <= 0 || minutes < milestone.Minutes {
+			continue
+		}
+		result, err := s.earn(ctx, userID, rule, pointsReasonReading, "reading", fmt.Sprintf("%s:%d", day, milestone.Minutes),
+			fmt.Sprintf("earn:reading:%d:%s:%d", userID, day, milestone.Minutes), milestone.Points,
+			map[string]string{"minutes": strconv.Itoa(milestone.Minutes)})
+		if err != nil {
+			return results, err
+		}
+		if result.Earned > 0 {
+			results = append(results, *result)
+		}
+	}
+	return results, nil
+}
+
+// earn 按规则发放积分，规则未启用时不发放。
+func (s *EarningService) earn(ctx context.Context, userID uint, rule *config.EarnRule, defaultReason, refType, refID, key string, amount int, extra map[string]string) (*EarnResult, error) {
+	reason := ruleReason(rule, defaultReason)
+	if !rule.Enabled || amount <= 0 {
+		return &EarnResult{Reason: reason}, nil
+	}
+
+	earned, balance, err := s.repo.Earn(ctx, userID, repository.PointsChange{
+		Change:         amount,
+		Type:           pointsTypeEarn,
+		Reason:         reason,
+		RefType:        refType,
+		RefID:          refID,
+		ExtraMap:       extra,
+		IdempotencyKey: key,
+	}, s.earnLimits(rule, reason))
+	if err != nil {
+		return nil, err
+	}
+	if earned < amount {
+		logger.Info().Uint("user_id", userID).Str("reason", reason).Int("earned", earned).Int("amount", amount).Msg("积分奖励触达上限或已发放")
+	}
+	return &EarnResult{Reason: reason, Earned: earned, Balance: balance}, nil
+}
+
+// earnLimits 规则自身的上限，以及签到、阅读、邀请共享的每日总上限。
+func (s *EarningService) earnLimits(rule *config.EarnRule, reason string) []repository.EarnLimit {
+	today := dayStart(s.now())
+	limits := make([]repository.EarnLimit, 0, 3)
+	if rule.DailyCap > 0 || rule.DailyTimes > 0 {
+		limits = append(limits, repository.EarnLimit{Reasons: []string{reason}, Since: today, MaxPoints: rule.DailyCap, MaxTimes: rule.DailyTimes})
+	}
+	if rule.TotalTimes > 0 {
+		limits = append(limits, repository.EarnLimit{Reasons: []string{reason}, MaxTimes: rule.TotalTimes})
+	}
+	if s.cfg.DailyCap > 0 && reason != ruleReason(s.registerRule(), pointsReasonRegister) {
+		limits = append(limits, repository.EarnLimit{Reasons: s.dailyReasons(), Since: today, MaxPoints: s.cfg.DailyCap})
+	}
+	return limits
+}
+
+func (s *EarningService) registerRule() *config.EarnRule {
+	if s.cfg.Register == nil {
+		return &defaultRegisterRule
+	}
+	return s.cfg.Register
+}
+
+// dailyReasons 计入每日总上限的流水原因。
+func (s *EarningService) dailyReasons() []string {
+	return []string{
+		ruleReason(s.cfg.CheckIn, pointsReasonCheckIn),
+		ruleReason(s.cfg.Reading, pointsReasonReading),
+		ruleReason(s.cfg.Referrer, pointsReasonReferrer),
+		ruleReason(s.cfg.Invitee, pointsReasonInvitee),
+	}
+}
+
+func ruleReason(rule *config.EarnRule, fallback string) string {
+	if rule == nil || rule.Reason == "" {
+		return fallback
+	}
+	return rule.Reason
+}
+
+// readingMinutes 合并重叠的会话时段后计算阅读分钟数。
+func readingMinutes(sessions []model.ReadingSession) int {
+	type span struct{ start, end time.Time }
+	spans := make([]span, 0, len(sessions))
+	for _, session := range sessions {
+		if session.Duration <= 0 {
+			continue
+		}
+		spans = append(spans, span{session.StartedAt, session.StartedAt.Add(time.Duration(session.Duration) * time.Second)})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start.Before(spans[j].start) })
+
+	var total time.Duration
+	var cur span
+	for i, sp := range spans {
+		if i == 0 || sp.start.After(cur.end) {
+			total += cur.end.Sub(cur.start)
+			cur = sp
+			continue
+		}
+		if sp.end.After(cur.end) {
+			cur.end = sp.end
+		}
+	}
+	total += cur.end.Sub(cur.start)
+	return int(total / time.Minute)
+}
+
+func dayStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// randomCode 生成 n 位随机码，用于邀请码与兑换码。
+func randomCode(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	for i, b := range buf {
+		buf[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(buf)
+}
+
+// EarningServiceInterface 积分获取服务接口。
+type EarningServiceInterface interface {
+	OnRegister(ctx context.Context, userID uint, referrerID uint) error
+	ResolveInviteCode(ctx context.Context, code string) (uint, error)
+	GetInviteInfo(ctx context.Context, userID uint) (*InviteInfo, error)
+	CheckIn(ctx context.Context, userID uint) (*CheckInResult, error)
+	RewardReading(ctx context.Context, userID uint) ([]EarnResult, error)
+}
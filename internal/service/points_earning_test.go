@@ -0,0 +1,34 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+func TestReadingMinutes(t *testing.T) {
+	base := time.Date(2026, 3, 1, 20, 0, 0, 0, time.Local)
+	session := func(startMin, minutes int) model.ReadingSession {
+		return model.ReadingSession{StartedAt: base.Add(time.Duration(startMin) * time.Minute), Duration: minutes * 60}
+	}
+
+	tests := []struct {
+		name     string
+		sessions []model.ReadingSession
+		want     int
+	}{
+		{"no sessions", nil, 0},
+		{"disjoint sessions add up", []model.ReadingSession{session(0, 10), session(30, 15)}, 25},
+		{"overlapping devices count once", []model.ReadingSession{session(0, 30), session(10, 30), session(15, 5)}, 40},
+		{"unsorted input", []model.ReadingSession{session(60, 10), session(0, 20), session(5, 10)}, 30},
+		{"zero duration ignored", []model.ReadingSession{session(0, 0), session(1, 5)}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readingMinutes(tt.sessions); got != tt.want {
+				t.Errorf("minutes = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+)
+
+// TrimPrice 单章精简的积分报价明细。
+type TrimPrice struct {
+	Points     int     `json:"points"`
+	Chars      int     `json:"chars"`
+	PerChapter int     `json:"per_chapter"`
+	Per1kChars float64 `json:"per_1k_chars"`
+	Min        int     `json:"min"`
+	Max        int     `json:"max"`
+}
+
+// Extra 把计价明细写入流水的扩展信息。
+func (p TrimPrice) Extra(extra map[string]string) map[string]string {
+	if extra == nil {
+		extra = map[string]string{}
+	}
+	extra["price_points"] = strconv.Itoa(p.Points)
+	extra["price_chars"] = strconv.Itoa(p.Chars)
+	extra["price_per_chapter"] = strconv.Itoa(p.PerChapter)
+	extra["price_per_1k_chars"] = strconv.FormatFloat(p.Per1kChars, 'f', -1, 64)
+	extra["price_min"] = strconv.Itoa(p.Min)
+	extra["price_max"] = strconv.Itoa(p.Max)
+	return extra
+}
+
+// defaultPriceRule 未配置计价时沿用每章 1 积分。
+var defaultPriceRule = config.PriceRule{PerChapter: 1}
+
+// priceRule 返回提示词适用的计价规则。
+func priceRule(cfg *config.PricingConfig, promptID uint) config.PriceRule {
+	if cfg == nil {
+		return defaultPriceRule
+	}
+	for _, rule := range cfg.Rules {
+		if slices.Contains(rule.PromptIDs, promptID) {
+			return rule
+		}
+	}
+	if cfg.Default.PerChapter == 0 && cfg.Default.Per1kChars == 0 && cfg.Default.Min == 0 {
+		return defaultPriceRule
+	}
+	return cfg.Default
+}
+
+// priceTrim 按规则计算单章积分。
+func priceTrim(rule config.PriceRule, chars int) TrimPrice {
+	raw := float64(rule.PerChapter) + float64(max(chars, 0))/1000*rule.Per1kChars
+	// 避免浮点误差导致整数结果被向上取整
+	points := int(math.Ceil(raw - 1e-9))
+	points = max(points, rule.Min, 0)
+	if rule.Max > 0 {
+		points = min(points, rule.Max)
+	}
+	return TrimPrice{
+		Points:     points,
+		Chars:      chars,
+		PerChapter: rule.PerChapter,
+		Per1kChars: rule.Per1kChars,
+		Min:        rule.Min,
+		Max:        rule.Max,
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+)
+
+func TestPriceTrim(t *testing.T) {
+	cfg := &config.PricingConfig{
+		Default: config.PriceRule{Per1kChars: 0.5, Min: 1, Max: 10},
+		Rules: []config.PriceRule{
+			{PromptIDs: []uint{2}, PerChapter: 1, Per1kChars: 0.8, Min: 1, Max: 15},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		cfg      *config.PricingConfig
+		promptID uint
+		chars    int
+		want     int
+	}{
+		{"unconfigured keeps one point per chapter", &config.PricingConfig{}, 1, 15000, 1},
+		{"short chapter hits minimum", cfg, 1, 800, 1},
+		{"rounds up partial points", cfg, 1, 3100, 2},
+		{"exact thousands do not round up", cfg, 1, 4000, 2},
+		{"long chapter capped", cfg, 1, 50000, 10},
+		{"prompt rule with base", cfg, 2, 5000, 5},
+		{"prompt rule cap", cfg, 2, 30000, 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := priceTrim(priceRule(tt.cfg, tt.promptID), tt.chars)
+			if got.Points != tt.want {
+				t.Errorf("points = %d, want %d", got.Points, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimPriceExtra(t *testing.T) {
+	price := priceTrim(config.PriceRule{PerChapter: 1, Per1kChars: 0.5, Max: 10}, 3000)
+	extra := price.Extra(map[string]string{"book_title": "书"})
+	if extra["book_title"] != "书" || extra["price_points"] != "3" || extra["price_chars"] != "3000" || extra["price_per_1k_chars"] != "0.5" {
+		t.Errorf("extra = %v", extra)
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+func TestReplayLedger(t *testing.T) {
+	ledger := func(id uint, change, after int) model.PointsLedger {
+		return model.PointsLedger{ID: id, Change: change, BalanceAfter: after}
+	}
+
+	tests := []struct {
+		name          string
+		points        model.UserPoints
+		ledgers       []model.PointsLedger
+		held          int
+		wantDrift     bool
+		ledgerBalance int
+		broken        []uint
+	}{
+		{"consistent", model.UserPoints{Balance: 7, Frozen: 2}, []model.PointsLedger{ledger(1, 10, 10), ledger(2, -2, 8), ledger(3, -1, 7)}, 2, false, 0, nil},
+		{"no ledgers", model.UserPoints{}, nil, 0, false, 0, nil},
+		// 两个并发扣费都基于余额 10 计算，后写入的覆盖了前一次
+		{"lost update", model.UserPoints{Balance: 9}, []model.PointsLedger{ledger(1, 10, 10), ledger(2, -1, 9), ledger(3, -1, 9)}, 0, true, 8, []uint{3}},
+		{"frozen drift", model.UserPoints{Balance: 10, Frozen: 3}, []model.PointsLedger{ledger(1, 10, 10)}, 1, true, 10, nil},
+		{"balance edited directly", model.UserPoints{Balance: 50}, []model.PointsLedger{ledger(1, 10, 10)}, 0, true, 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drift := replayLedger(&tt.points, tt.ledgers, tt.held)
+			if (drift != nil) != tt.wantDrift {
+				t.Fatalf("drift = %+v, want drift %v", drift, tt.wantDrift)
+			}
+			if drift == nil {
+				return
+			}
+			if drift.LedgerBalance != tt.ledgerBalance || !slices.Equal(drift.BrokenLedgerIDs, tt.broken) {
+				t.Errorf("ledger balance = %d broken = %v, want %d %v", drift.LedgerBalance, drift.BrokenLedgerIDs, tt.ledgerBalance, tt.broken)
+			}
+		})
+	}
+}
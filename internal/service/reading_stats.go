@@ -0,0 +1,414 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+)
+
+const (
+	StatsPeriodDaily  = "daily"
+	StatsPeriodWeekly = "weekly"
+
+	maxSessionDuration     = 4 * time.Hour // 单次会话有效时长上限，超出视为挂机
+	defaultReadingSpeed    = 500.0 / 60    // 无有效样本时的默认阅读速度（字/秒）
+	defaultStatsDailyCount = 7
+	defaultStatsWeekCount  = 4
+	maxStatsDailyCount     = 90
+	maxStatsWeekCount      = 26
+)
+
+// ReadingStatsService 阅读会话记录与统计服务。
+type ReadingStatsService struct {
+	repo     repository.ReadingStatsRepositoryInterface
+	bookRepo repository.BookRepositoryInterface
+	earning  EarningServiceInterface
+}
+
+// NewReadingStatsService 创建阅读统计服务。
+func NewReadingStatsService(repo repository.ReadingStatsRepositoryInterface, bookRepo repository.BookRepositoryInterface, earning EarningServiceInterface) *ReadingStatsService {
+	return &ReadingStatsService{repo: repo, bookRepo: bookRepo, earning: earning}
+}
+
+// StartSessionReq 开始阅读会话参数，会话按章节记录，翻章时客户端需结束旧会话并开启新会话。
+type StartSessionReq struct {
+	BookID    uint   `json:"book_id" binding:"required"`
+	ChapterID uint   `json:"chapter_id" binding:"required"`
+	PromptID  uint   `json:"prompt_id"`
+	ViewMode  string `json:"view_mode"`
+	DeviceID  string `json:"device_id"`
+}
+
+// StopSessionReq 结束阅读会话参数。
+type StopSessionReq struct {
+	WordsRead       int  `json:"words_read"`       // 本次阅读的字数（按当前模式的文本计）
+	Duration        int  `json:"duration"`         // 有效阅读秒数，为空时按起止时间计算
+	ChapterFinished bool `json:"chapter_finished"` // 是否读完本章
+}
+
+// StatsReq 统计查询参数。
+type StatsReq struct {
+	Period string `form:"period"` // daily / weekly
+	Count  int    `form:"count"`  // 统计的天数或周数
+}
+
+// StatsBucket 单日或单周的阅读统计。
+type StatsBucket struct {
+	Start            time.Time `json:"start"`
+	Sessions         int       `json:"sessions"`
+	Duration         int       `json:"duration"` // 秒
+	WordsRead        int       `json:"words_read"`
+	SkippedWords     int       `json:"skipped_words"`
+	TimeSaved        int       `json:"time_saved"` // 秒
+	ChaptersFinished int       `json:"chapters_finished"`
+	BooksFinished    int64     `json:"books_finished"`
+}
+
+// PromptSkipStats 某个精简模式下的节省统计。
+type PromptSkipStats struct {
+	PromptID     uint   `json:"prompt_id"`
+	PromptName   string `json:"prompt_name"`
+	Sessions     int    `json:"sessions"`
+	WordsRead    int    `json:"words_read"`
+	SkippedWords int    `json:"skipped_words"`
+	TimeSaved    int    `json:"time_saved"` // 秒
+}
+
+// ReadingStatsResp 阅读统计结果。
+type ReadingStatsResp struct {
+	Period             string             `json:"period"`
+	ReadingSpeed       float64            `json:"reading_speed"` // 字/分钟
+	Buckets            []*StatsBucket     `json:"buckets"`
+	Total              StatsBucket        `json:"total"`
+	TotalBooksFinished int64              `json:"total_books_finished"` // 累计读完书籍
+	ByPrompt           []*PromptSkipStats `json:"by_prompt"`
+}
+
+// StartSession 开始一次阅读会话。
+func (s *ReadingStatsService) StartSession(ctx context.Context, userID uint, req *StartSessionReq) (*model.ReadingSession, error) {
+	book, err := s.bookRepo.GetBookByIDWithUser(ctx, userID, req.BookID)
+	if err != nil {
+		return nil, err
+	}
+	if book == nil {
+		return nil, errno.ErrBookNotFound
+	}
+	chapter, err := s.bookRepo.GetChapterByID(ctx, req.ChapterID)
+	if err != nil || chapter.BookID != req.BookID {
+		return nil, errno.ErrChapterNotFound
+	}
+
+	viewMode := req.ViewMode
+	switch viewMode {
+	case "":
+		viewMode = model.ViewModeRaw
+		if req.PromptID > 0 {
+			viewMode = model.ViewModeTrim
+		}
+	case model.ViewModeRaw:
+		req.PromptID = 0
+	case model.ViewModeTrim, model.ViewModeCompare:
+		if req.PromptID == 0 {
+			return nil, errno.ErrParam
+		}
+	default:
+		return nil, errno.ErrParam
+	}
+
+	session := &model.ReadingSession{
+		UserID:    userID,
+		BookID:    req.BookID,
+		ChapterID: req.ChapterID,
+		PromptID:  req.PromptID,
+		ViewMode:  viewMode,
+		DeviceID:  req.DeviceID,
+		StartedAt: time.Now(),
+	}
+	if err := s.repo.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// StopSession 结束阅读会话，计算有效时长与因精简少读的字数。
+func (s *ReadingStatsService) StopSession(ctx context.Context, userID uint, id uint, req *StopSessionReq) (*model.ReadingSession, error) {
+	if req.WordsRead < 0 || req.Duration < 0 {
+		return nil, errno.ErrParam
+	}
+	session, err := s.repo.GetSession(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, errno.ErrReadingSessionNotFound
+	}
+	if session.EndedAt != nil {
+		return nil, errno.ErrReadingSessionClosed
+	}
+
+	now := time.Now()
+	wall := now.Sub(session.StartedAt)
+	if wall > maxSessionDuration {
+		wall = maxSessionDuration
+	}
+	duration := int(wall.Seconds())
+	if req.Duration > 0 && req.Duration < duration {
+		duration = req.Duration
+	}
+
+	chapter, err := s.bookRepo.GetChapterByID(ctx, session.ChapterID)
+	if err != nil {
+		return nil, errno.ErrChapterNotFound
+	}
+
+	session.EndedAt = &now
+	session.Duration = duration
+	session.WordsRead = req.WordsRead
+	session.Finished = req.ChapterFinished
+	session.SkippedWords = s.skippedWords(ctx, session, chapter.ChapterMD5)
+
+	updated, err := s.repo.FinishSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, errno.ErrReadingSessionClosed
+	}
+
+	if session.Finished {
+		if err := s.markBookFinishedIfLast(ctx, session, chapter); err != nil {
+			logger.Error().Err(err).Uint("session_id", session.ID).Msg("标记书籍读完失败")
+		}
+	}
+	if session.Duration > 0 {
+		if _, err := s.earning.RewardReading(ctx, userID); err != nil {
+			logger.Error().Err(err).Uint("session_id", session.ID).Msg("发放阅读奖励失败")
+		}
+	}
+	return session, nil
+}
+
+// skippedWords 按精简比例把实际阅读字数折算为原文字数，差值即为少读的字数。
+// 原文与对照模式不计节省。
+func (s *ReadingStatsService) skippedWords(ctx context.Context, session *model.ReadingSession, chapterMD5 string) int {
+	if session.ViewMode != model.ViewModeTrim || session.PromptID == 0 || session.WordsRead == 0 {
+		return 0
+	}
+
+	trim, err := s.bookRepo.GetTrimResult(ctx, chapterMD5, session.PromptID)
+	if err != nil || trim == nil || trim.TrimContentWords <= 0 {
+		return 0
+	}
+	metas, err := s.bookRepo.GetContentMetasByMD5s(ctx, []string{chapterMD5})
+	if err != nil {
+		return 0
+	}
+	meta, ok := metas[chapterMD5]
+	if !ok || meta.WordsCount <= trim.TrimContentWords {
+		return 0
+	}
+
+	wordsRead := session.WordsRead
+	if session.Finished || wordsRead > trim.TrimContentWords {
+		wordsRead = trim.TrimContentWords
+	}
+	rawWords := wordsRead * meta.WordsCount / trim.TrimContentWords
+	return rawWords - wordsRead
+}
+
+func (s *ReadingStatsService) markBookFinishedIfLast(ctx context.Context, session *model.ReadingSession, chapter *model.Chapter) error {
+	chapters, err := s.bookRepo.GetChaptersByBookID(ctx, session.BookID)
+	if err != nil {
+		return err
+	}
+	for _, c := range chapters {
+		if c.Index > chapter.Index {
+			return nil
+		}
+	}
+
+	now := time.Now()
+	return s.repo.MarkBookFinished(ctx, &model.ReadingHistory{
+		UserID:          session.UserID,
+		BookID:          session.BookID,
+		LastChapterID:   session.ChapterID,
+		LastPromptID:    session.PromptID,
+		ViewMode:        session.ViewMode,
+		DeviceID:        session.DeviceID,
+		ClientUpdatedAt: now,
+		FinishedAt:      &now,
+	})
+}
+
+// GetStats 按日或按周汇总阅读统计，节省时间按用户读原文的速度估算。
+func (s *ReadingStatsService) GetStats(ctx context.Context, userID uint, req *StatsReq) (*ReadingStatsResp, error) {
+	period := req.Period
+	if period == "" {
+		period = StatsPeriodDaily
+	}
+	starts, to, err := statsBucketStarts(period, req.Count, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	from := starts[0]
+
+	sessions, err := s.repo.ListFinishedSessions(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	finished, err := s.repo.ListFinishedTimes(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	speed := readingSpeed(sessions)
+	resp := &ReadingStatsResp{
+		Period:       period,
+		ReadingSpeed: speed * 60,
+		Buckets:      fillStatsBuckets(starts, sessions, finished, speed),
+	}
+	for _, b := range resp.Buckets {
+		resp.Total.Sessions += b.Sessions
+		resp.Total.Duration += b.Duration
+		resp.Total.WordsRead += b.WordsRead
+		resp.Total.SkippedWords += b.SkippedWords
+		resp.Total.TimeSaved += b.TimeSaved
+		resp.Total.ChaptersFinished += b.ChaptersFinished
+		resp.Total.BooksFinished += b.BooksFinished
+	}
+	resp.Total.Start = from
+	if resp.TotalBooksFinished, err = s.repo.CountAllFinishedBooks(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	byPrompt := make(map[uint]*PromptSkipStats)
+	for _, session := range sessions {
+		if session.PromptID == 0 || session.ViewMode != model.ViewModeTrim {
+			continue
+		}
+		p, ok := byPrompt[session.PromptID]
+		if !ok {
+			p = &PromptSkipStats{PromptID: session.PromptID}
+			byPrompt[session.PromptID] = p
+		}
+		p.Sessions++
+		p.WordsRead += session.WordsRead
+		p.SkippedWords += session.SkippedWords
+	}
+	resp.ByPrompt = make([]*PromptSkipStats, 0, len(byPrompt))
+	for _, p := range byPrompt {
+		p.TimeSaved = int(float64(p.SkippedWords) / speed)
+		if prompt, err := s.bookRepo.GetPromptByID(ctx, p.PromptID); err == nil && prompt != nil {
+			p.PromptName = prompt.Name
+		}
+		resp.ByPrompt = append(resp.ByPrompt, p)
+	}
+	sort.Slice(resp.ByPrompt, func(i, j int) bool { return resp.ByPrompt[i].PromptID < resp.ByPrompt[j].PromptID })
+	return resp, nil
+}
+
+// statsBucketStarts 返回各统计区间的开始时间（升序）与统计截止时间（不含）。
+// 按周统计时区间从周一开始，最后一个区间截止到今天结束。
+func statsBucketStarts(period string, count int, now time.Time) ([]time.Time, time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var starts []time.Time
+	switch period {
+	case StatsPeriodDaily:
+		count = clampCount(count, defaultStatsDailyCount, maxStatsDailyCount)
+		for i := count - 1; i >= 0; i-- {
+			starts = append(starts, today.AddDate(0, 0, -i))
+		}
+	case StatsPeriodWeekly:
+		count = clampCount(count, defaultStatsWeekCount, maxStatsWeekCount)
+		monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		for i := count - 1; i >= 0; i-- {
+			starts = append(starts, monday.AddDate(0, 0, -7*i))
+		}
+	default:
+		return nil, time.Time{}, errno.ErrParam
+	}
+	return starts, today.AddDate(0, 0, 1), nil
+}
+
+// fillStatsBuckets 把会话与读完书籍的时间归入所属区间，并按阅读速度（字/秒）估算节省时间。
+func fillStatsBuckets(starts []time.Time, sessions []model.ReadingSession, finished []time.Time, speed float64) []*StatsBucket {
+	buckets := make([]*StatsBucket, len(starts))
+	for i, start := range starts {
+		buckets[i] = &StatsBucket{Start: start}
+	}
+	bucketOf := func(t time.Time) *StatsBucket {
+		idx := sort.Search(len(starts), func(i int) bool { return starts[i].After(t) }) - 1
+		if idx < 0 {
+			return nil
+		}
+		return buckets[idx]
+	}
+
+	for _, session := range sessions {
+		b := bucketOf(session.StartedAt)
+		if b == nil {
+			continue
+		}
+		b.Sessions++
+		b.Duration += session.Duration
+		b.WordsRead += session.WordsRead
+		b.SkippedWords += session.SkippedWords
+		if session.Finished {
+			b.ChaptersFinished++
+		}
+	}
+	for _, t := range finished {
+		if b := bucketOf(t); b != nil {
+			b.BooksFinished++
+		}
+	}
+	for _, b := range buckets {
+		b.TimeSaved = int(float64(b.SkippedWords) / speed)
+	}
+	return buckets
+}
+
+// readingSpeed 计算阅读速度（字/秒），优先采用原文模式的会话样本。
+func readingSpeed(sessions []model.ReadingSession) float64 {
+	var rawWords, rawSeconds, allWords, allSeconds int
+	for _, session := range sessions {
+		if session.Duration <= 0 || session.WordsRead <= 0 {
+			continue
+		}
+		allWords += session.WordsRead
+		allSeconds += session.Duration
+		if session.ViewMode == model.ViewModeRaw {
+			rawWords += session.WordsRead
+			rawSeconds += session.Duration
+		}
+	}
+	if rawSeconds > 0 {
+		return float64(rawWords) / float64(rawSeconds)
+	}
+	if allSeconds > 0 {
+		return float64(allWords) / float64(allSeconds)
+	}
+	return defaultReadingSpeed
+}
+
+func clampCount(count, def, max int) int {
+	if count <= 0 {
+		return def
+	}
+	if count > max {
+		return max
+	}
+	return count
+}
+
+// ReadingStatsServiceInterface 阅读统计服务接口。
+type ReadingStatsServiceInterface interface {
+	StartSession(ctx context.Context, userID uint, req *StartSessionReq) (*model.ReadingSession, error)
+	StopSession(ctx context.Context, userID uint, id uint, req *StopSessionReq) (*model.ReadingSession, error)
+	GetStats(ctx context.Context, userID uint, req *StatsReq) (*ReadingStatsResp, error)
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+func TestStatsBucketStarts(t *testing.T) {
+	// 2026-01-15 为周四
+	now := time.Date(2026, 1, 15, 20, 30, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name      string
+		period    string
+		count     int
+		wantFirst time.Time
+		wantLast  time.Time
+		wantLen   int
+		wantErr   error
+	}{
+		{"daily default", StatsPeriodDaily, 0, day(9), day(15), defaultStatsDailyCount, nil},
+		{"daily capped", StatsPeriodDaily, 1000, day(15).AddDate(0, 0, 1-maxStatsDailyCount), day(15), maxStatsDailyCount, nil},
+		{"weekly aligns to monday", StatsPeriodWeekly, 2, day(5), day(12), 2, nil},
+		{"unknown period", "monthly", 0, time.Time{}, time.Time{}, 0, errno.ErrParam},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			starts, to, err := statsBucketStarts(tt.period, tt.count, now)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(starts) != tt.wantLen || !starts[0].Equal(tt.wantFirst) || !starts[len(starts)-1].Equal(tt.wantLast) {
+				t.Fatalf("starts = %v .. %v (%d)", starts[0], starts[len(starts)-1], len(starts))
+			}
+			if !to.Equal(day(16)) {
+				t.Errorf("to = %v, want end of today", to)
+			}
+		})
+	}
+}
+
+func TestFillStatsBuckets(t *testing.T) {
+	day := func(d, hour int) time.Time { return time.Date(2026, 1, d, hour, 0, 0, 0, time.UTC) }
+	starts := []time.Time{day(5, 0), day(12, 0)}
+	sessions := []model.ReadingSession{
+		{StartedAt: day(4, 23), WordsRead: 100, SkippedWords: 100},                              // 早于首个区间
+		{StartedAt: day(5, 0), Duration: 60, WordsRead: 300, SkippedWords: 600, Finished: true}, // 区间起点归入该区间
+		{StartedAt: day(11, 23), Duration: 30, WordsRead: 200},
+		{StartedAt: day(15, 8), Duration: 90, WordsRead: 500, SkippedWords: 1000},
+	}
+	finished := []time.Time{day(6, 10), day(12, 0), day(15, 9)}
+
+	buckets := fillStatsBuckets(starts, sessions, finished, 10)
+	want := []StatsBucket{
+		{Start: day(5, 0), Sessions: 2, Duration: 90, WordsRead: 500, SkippedWords: 600, TimeSaved: 60, ChaptersFinished: 1, BooksFinished: 1},
+		{Start: day(12, 0), Sessions: 1, Duration: 90, WordsRead: 500, SkippedWords: 1000, TimeSaved: 100, BooksFinished: 2},
+	}
+	for i, b := range buckets {
+		if *b != want[i] {
+			t.Errorf("bucket %d = %+v, want %+v", i, *b, want[i])
+		}
+	}
+}
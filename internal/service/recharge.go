@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+	"gorm.io/gorm"
+)
+
+// defaultOrderExpire 未配置时待支付订单的有效期。
+const defaultOrderExpire = 30 * time.Minute
+
+// RechargeService 积分充值服务。
+type RechargeService struct {
+	repo      repository.RechargeRepositoryInterface
+	cfg       *config.PaymentConfig
+	providers map[string]PaymentProvider
+	now       func() time.Time
+}
+
+// NewRechargeService 创建充值服务，按配置注册支付渠道。
+func NewRechargeService(repo repository.RechargeRepositoryInterface, cfg *config.PaymentConfig) *RechargeService {
+	s := &RechargeService{repo: repo, cfg: cfg, providers: make(map[string]PaymentProvider), now: time.Now}
+	if cfg.Fake.Enabled {
+		// 未配置密钥时任何人都能伪造回调，拒绝启用
+		if cfg.Fake.Secret == "" {
+			logger.Warn().Msg("模拟支付未配置签名密钥，不启用")
+		} else {
+			s.register(NewFakePaymentProvider(cfg.Fake.Secret))
+		}
+	}
+	return s
+}
+
+func (s *RechargeService) register(provider PaymentProvider) {
+	s.providers[provider.Name()] = provider
+}
+
+// RechargePackage 可购买的充值套餐。
+type RechargePackage struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Amount int    `json:"amount"` // 价格（分）
+	Points int    `json:"points"`
+}
+
+// RechargeOrderView 订单及待支付时拉起支付的参数。
+type RechargeOrderView struct {
+	model.RechargeOrder
+	Payment *PaymentIntent `json:"payment,omitempty"`
+}
+
+// ListPackages 获取可购买的充值套餐。
+func (s *RechargeService) ListPackages() []RechargePackage {
+	packages := make([]RechargePackage, 0, len(s.cfg.Packages))
+	for _, p := range s.cfg.Packages {
+		packages = append(packages, RechargePackage{ID: p.ID, Name: p.Name, Amount: p.Amount, Points: p.Points})
+	}
+	return packages
+}
+
+// CreateOrder 按套餐创建充值订单并向支付渠道下单。携带幂等键的重复提交返回首次创建的订单。
+func (s *RechargeService) CreateOrder(ctx context.Context, userID uint, packageID, providerName string) (*RechargeOrderView, error) {
+	pkg, ok := s.findPackage(packageID)
+	if !ok {
+		return nil, errno.ErrRechargePackage
+	}
+	if providerName == "" {
+		providerName = s.cfg.DefaultProvider
+	}
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, errno.ErrRechargeProvider
+	}
+
+	key := idempotencyKeyFrom(ctx)
+	if key != "" {
+		key = fmt.Sprintf("recharge:%d:%s", userID, key)
+		if order, err := s.repo.GetOrderByIdempotencyKey(ctx, key); err != nil || order != nil {
+			if err != nil {
+				return nil, err
+			}
+			return s.orderView(ctx, order)
+		}
+	}
+
+	now := s.now()
+	order := &model.RechargeOrder{
+		OrderNo:   "R" + now.Format("20060102150405") + randomCode(8),
+		UserID:    userID,
+		PackageID: pkg.ID,
+		Amount:    pkg.Amount,
+		Points:    pkg.Points,
+		Provider:  provider.Name(),
+		Status:    model.RechargeOrderPending,
+		ExpiresAt: now.Add(s.orderExpire()),
+	}
+	if key != "" {
+		order.IdempotencyKey = &key
+	}
+	if err := s.repo.CreateOrder(ctx, order); err != nil {
+		if key != "" && errors.Is(err, gorm.ErrDuplicatedKey) {
+			existing, getErr := s.repo.GetOrderByIdempotencyKey(ctx, key)
+			if getErr == nil && existing != nil {
+				return s.orderView(ctx, existing)
+			}
+		}
+		return nil, err
+	}
+	logger.Info().Uint("user_id", userID).Str("order_no", order.OrderNo).Str("package_id", pkg.ID).Int("amount", pkg.Amount).Msg("创建充值订单")
+	return s.orderView(ctx, order)
+}
+
+// GetOrder 获取用户的订单。已支付未到账的订单顺带补入账，覆盖回调处理中断的情况。
+func (s *RechargeService) GetOrder(ctx context.Context, userID uint, orderNo string) (*RechargeOrderView, error) {
+	order, err := s.repo.GetOrder(ctx, orderNo)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil || order.UserID != userID {
+		return nil, errno.ErrRechargeNotFound
+	}
+	if order.Status == model.RechargeOrderPaid {
+		if order, err = s.credit(ctx, orderNo); err != nil {
+			return nil, err
+		}
+	}
+	return s.orderView(ctx, order)
+}
+
+// ListOrders 分页获取用户的订单。
+func (s *RechargeService) ListOrders(ctx context.Context, userID uint, page, size int) ([]model.RechargeOrder, error) {
+	limit, offset := pageLimit(page, size)
+	return s.repo.ListOrders(ctx, userID, limit, offset)
+}
+
+// HandleCallback 处理支付渠道的支付通知：验签、核对金额后标记已支付并入账。
+// 渠道重复通知时订单只入账一次，返回 nil 表示可以应答渠道处理成功。
+func (s *RechargeService) HandleCallback(ctx context.Context, providerName string, header http.Header, body []byte) error {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return errno.ErrRechargeProvider
+	}
+	notification, err := provider.ParseCallback(ctx, header, body)
+	if err != nil {
+		logger.Warn().Err(err).Str("provider", providerName).Msg("支付回调验签失败")
+		return err
+	}
+
+	order, err := s.repo.GetOrder(ctx, notification.OrderNo)
+	if err != nil {
+		return err
+	}
+	if order == nil {
+		return errno.ErrRechargeNotFound
+	}
+	if order.Provider != providerName || order.Amount != notification.Amount {
+		logger.Error().Str("order_no", order.OrderNo).Str("provider", providerName).Int("amount", order.Amount).Int("paid", notification.Amount).Msg("支付回调与订单不符")
+		return errno.ErrRechargeAmount
+	}
+
+	// 订单过期后才完成的支付同样入账，款项已实际到账
+	if _, err := s.repo.MarkPaid(ctx, order.OrderNo, notification.TradeNo, notification.PaidAt); err != nil {
+		return err
+	}
+	_, err = s.credit(ctx, order.OrderNo)
+	return err
+}
+
+// SimulatePayment 使用模拟支付渠道完成支付，回调经过与真实渠道相同的验签与入账流程。
+func (s *RechargeService) SimulatePayment(ctx context.Context, userID uint, orderNo string) (*RechargeOrderView, error) {
+	fake, ok := s.providers[fakeProviderName].(*FakePaymentProvider)
+	if !ok {
+		return nil, errno.ErrRechargeProvider
+	}
+	order, err := s.repo.GetOrder(ctx, orderNo)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil || order.UserID != userID {
+		return nil, errno.ErrRechargeNotFound
+	}
+	if order.Provider != fakeProviderName {
+		return nil, errno.ErrRechargeProvider
+	}
+	header, body := fake.SignCallback(order, s.now())
+	if err := s.HandleCallback(ctx, fakeProviderName, header, body); err != nil {
+		return nil, err
+	}
+	return s.GetOrder(ctx, userID, orderNo)
+}
+
+// Refund 退款：先扣回已到账的积分并标记订单已退款，再向渠道发起退款。
+// 渠道退款失败时订单保持已退款状态，重新调用会再次向渠道发起退款。
+func (s *RechargeService) Refund(ctx context.Context, orderNo string) (*model.RechargeOrder, error) {
+	order, err := s.repo.GetOrder(ctx, orderNo)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, errno.ErrRechargeNotFound
+	}
+	provider, ok := s.providers[order.Provider]
+	if !ok {
+		return nil, errno.ErrRechargeProvider
+	}
+
+	order, refunded, err := s.repo.RefundOrder(ctx, orderNo, s.now())
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Refund(ctx, order); err != nil {
+		logger.Error().Err(err).Str("order_no", orderNo).Msg("渠道退款失败，订单已标记退款，请重试")
+		return nil, err
+	}
+	if refunded {
+		logger.Info().Uint("user_id", order.UserID).Str("order_no", orderNo).Int("points", order.Points).Msg("充值订单已退款")
+	}
+	return order, nil
+}
+
+// credit 入账已支付的订单并返回最新订单。
+func (s *RechargeService) credit(ctx context.Context, orderNo string) (*model.RechargeOrder, error) {
+	credited, err := s.repo.CreditOrder(ctx, orderNo, s.now())
+	if err != nil {
+		logger.Error().Err(err).Str("order_no", orderNo).Msg("充值订单入账失败")
+		return nil, err
+	}
+	order, err := s.repo.GetOrder(ctx, orderNo)
+	if err != nil {
+		return nil, err
+	}
+	if credited {
+		logger.Info().Uint("user_id", order.UserID).Str("order_no", orderNo).Int("points", order.Points).Msg("充值积分已到账")
+	}
+	return order, nil
+}
+
+// orderView 待支付且未过期的订单附带拉起支付的参数。
+func (s *RechargeService) orderView(ctx context.Context, order *model.RechargeOrder) (*RechargeOrderView, error) {
+	view := &RechargeOrderView{RechargeOrder: *order}
+	if order.Status != model.RechargeOrderPending || !s.now().Before(order.ExpiresAt) {
+		return view, nil
+	}
+	provider, ok := s.providers[order.Provider]
+	if !ok {
+		return view, nil
+	}
+	intent, err := provider.CreatePayment(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	view.Payment = intent
+	return view, nil
+}
+
+func (s *RechargeService) findPackage(id string) (config.RechargePackage, bool) {
+	for _, p := range s.cfg.Packages {
+		if p.ID == id && p.Amount > 0 && p.Points > 0 {
+			return p, true
+		}
+	}
+	return config.RechargePackage{}, false
+}
+
+func (s *RechargeService) orderExpire() time.Duration {
+	if s.cfg.OrderExpireMinutes > 0 {
+		return time.Duration(s.cfg.OrderExpireMinutes) * time.Minute
+	}
+	return defaultOrderExpire
+}
+
+// RechargeServiceInterface 充值服务接口。
+type RechargeServiceInterface interface {
+	ListPackages() []RechargePackage
+	CreateOrder(ctx context.Context, userID uint, packageID, providerName string) (*RechargeOrderView, error)
+	GetOrder(ctx context.Context, userID uint, orderNo string) (*RechargeOrderView, error)
+	ListOrders(ctx context.Context, userID uint, page, size int) ([]model.RechargeOrder, error)
+	HandleCallback(ctx context.Context, providerName string, header http.Header, body []byte) error
+	SimulatePayment(ctx context.Context, userID uint, orderNo string) (*RechargeOrderView, error)
+	Refund(ctx context.Context, orderNo string) (*model.RechargeOrder, error)
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	redeemCodeLen       = 12
+	maxRedeemBatch      = 10000 // 单个活动最多生成的兑换码数量
+	redeemCreateRetries = 3     // 随机兑换码与已有的重复时重新生成的次数
+)
+
+// customRedeemCode 自定义兑换码的格式，便于在活动海报中直接展示。
+var customRedeemCode = regexp.MustCompile(`^[A-Z0-9-]{4,32}$`)
+
+// RedeemService 兑换码活动服务。
+type RedeemService struct {
+	repo repository.RedeemRepositoryInterface
+	now  func() time.Time
+}
+
+// NewRedeemService 创建兑换码服务。
+func NewRedeemService(repo repository.RedeemRepositoryInterface) *RedeemService {
+	return &RedeemService{repo: repo, now: time.Now}
+}
+
+// CreateRedeemCampaignReq 创建兑换码活动参数。指定 Code 时只生成这一个兑换码，
+// 通常配合多次使用用于公开发放；否则随机生成 Count 个兑换码。
+type CreateRedeemCampaignReq struct {
+	Name         string     `json:"name" binding:"required,max=64"`
+	Points       int        `json:"points" binding:"required,min=1"`
+	Count        int        `json:"count"`          // 生成数量，默认 1
+	Code         string     `json:"code"`           // 自定义兑换码
+	MaxUses      *int       `json:"max_uses"`       // 每个兑换码可用次数，默认 1，0 表示不限
+	PerUserLimit *int       `json:"per_user_limit"` // 每个用户在本活动最多兑换次数，默认 1，0 表示不限
+	StartsAt     *time.Time `json:"starts_at"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+// RedeemCampaignView 活动及其兑换统计。
+type RedeemCampaignView struct {
+	model.RedeemCampaign
+	Stats repository.RedeemStats `json:"stats"`
+	Codes []string               `json:"codes,omitempty"` // 仅创建时返回
+}
+
+// RedeemResult 兑换结果。
+type RedeemResult struct {
+	CampaignName string `json:"campaign_name"`
+	Points       int    `json:"points"`
+	Balance      int    `json:"balance"`
+}
+
+// CreateCampaign 创建兑换码活动并生成兑换码。
+func (s *RedeemService) CreateCampaign(ctx context.Context, adminID uint, req *CreateRedeemCampaignReq) (*RedeemCampaignView, error) {
+	campaign := &model.RedeemCampaign{
+		Name:         strings.TrimSpace(req.Name),
+		Points:       req.Points,
+		MaxUses:      intOr(req.MaxUses, 1),
+		PerUserLimit: intOr(req.PerUserLimit, 1),
+		StartsAt:     req.StartsAt,
+		ExpiresAt:    req.ExpiresAt,
+		CreatedBy:    adminID,
+	}
+	count := req.Count
+	if count == 0 {
+		count = 1
+	}
+	code := normalizeRedeemCode(req.Code)
+	if campaign.Name == "" || campaign.MaxUses < 0 || campaign.PerUserLimit < 0 || count < 0 || count > maxRedeemBatch {
+		return nil, errno.ErrParam
+	}
+	if code != "" && (count != 1 || !customRedeemCode.MatchString(code)) {
+		return nil, errno.ErrParam
+	}
+	if req.StartsAt != nil && req.ExpiresAt != nil && !req.ExpiresAt.After(*req.StartsAt) {
+		return nil, errno.ErrParam
+	}
+
+	var (
+		created []model.RedeemCode
+		err     error
+	)
+	for i := 0; i < redeemCreateRetries; i++ {
+		codes := []string{code}
+		if code == "" {
+			codes = randomCodes(count)
+		}
+		// 活动创建失败时整体回滚，ID 需要在重试前清空
+		campaign.ID = 0
+		created, err = s.repo.CreateCampaign(ctx, campaign, codes)
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			break
+		}
+		if code != "" {
+			return nil, errno.ErrRedeemExist
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	view := &RedeemCampaignView{
+		RedeemCampaign: *campaign,
+		Stats:          repository.RedeemStats{CampaignID: campaign.ID, Codes: int64(len(created))},
+		Codes:          make([]string, 0, len(created)),
+	}
+	for _, c := range created {
+		view.Codes = append(view.Codes, c.Code)
+	}
+	logger.Info().Uint("admin_id", adminID).Uint("campaign_id", campaign.ID).Int("codes", len(created)).Int("points", campaign.Points).Msg("创建兑换码活动")
+	return view, nil
+}
+
+// ListCampaigns 分页获取活动及兑换统计。
+func (s *RedeemService) ListCampaigns(ctx context.Context, page, size int) ([]RedeemCampaignView, error) {
+	limit, offset := pageLimit(page, size)
+	campaigns, err := s.repo.ListCampaigns(ctx, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return s.withStats(ctx, campaigns)
+}
+
+// GetCampaign 获取活动及兑换统计。
+func (s *RedeemService) GetCampaign(ctx context.Context, id uint) (*RedeemCampaignView, error) {
+	campaign, err := s.repo.GetCampaign(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if campaign == nil {
+		return nil, errno.ErrRedeemInvalid
+	}
+	views, err := s.withStats(ctx, []model.RedeemCampaign{*campaign})
+	if err != nil {
+		return nil, err
+	}
+	return &views[0], nil
+}
+
+// ListCodes 分页获取活动的兑换码及使用次数。
+func (s *RedeemService) ListCodes(ctx context.Context, campaignID uint, page, size int) ([]model.RedeemCode, error) {
+	limit, offset := pageLimit(page, size)
+	return s.repo.ListCodes(ctx, campaignID, limit, offset)
+}
+
+// SetCampaignDisabled 停用或重新启用活动，停用后未使用的兑换码不可再兑换。
+func (s *RedeemService) SetCampaignDisabled(ctx context.Context, id uint, disabled bool) error {
+	campaign, err := s.repo.GetCampaign(ctx, id)
+	if err != nil {
+		return err
+	}
+	if campaign == nil {
+		return errno.ErrRedeemInvalid
+	}
+	return s.repo.SetCampaignDisabled(ctx, id, disabled)
+}
+
+// Redeem 使用兑换码领取积分。
+func (s *RedeemService) Redeem(ctx context.Context, userID uint, code string) (*RedeemResult, error) {
+	code = normalizeRedeemCode(code)
+	if code == "" {
+		return nil, errno.ErrRedeemInvalid
+	}
+	redemption, balance, err := s.repo.Redeem(ctx, userID, code, s.now())
+	if err != nil {
+		return nil, err
+	}
+	campaign, err := s.repo.GetCampaign(ctx, redemption.CampaignID)
+	if err != nil || campaign == nil {
+		return &RedeemResult{Points: redemption.Points, Balance: balance}, nil
+	}
+	return &RedeemResult{CampaignName: campaign.Name, Points: redemption.Points, Balance: balance}, nil
+}
+
+func (s *RedeemService) withStats(ctx context.Context, campaigns []model.RedeemCampaign) ([]RedeemCampaignView, error) {
+	ids := make([]uint, 0, len(campaigns))
+	for _, campaign := range campaigns {
+		ids = append(ids, campaign.ID)
+	}
+	stats, err := s.repo.CampaignStats(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	views := make([]RedeemCampaignView, 0, len(campaigns))
+	for _, campaign := range campaigns {
+		view := RedeemCampaignView{RedeemCampaign: campaign, Stats: repository.RedeemStats{CampaignID: campaign.ID}}
+		if st, ok := stats[campaign.ID]; ok {
+			view.Stats = *st
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// normalizeRedeemCode 兑换码不区分大小写，忽略首尾空白。
+func normalizeRedeemCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// randomCodes 生成 n 个互不相同的随机兑换码。
+func randomCodes(n int) []string {
+	seen := make(map[string]struct{}, n)
+	codes := make([]string, 0, n)
+	for len(codes) < n {
+		code := randomCode(redeemCodeLen)
+		if _, ok := seen[code]; ok {
+			continue
+		}
+		seen[code] = struct{}{}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func intOr(v *int, fallback int) int {
+	if v == nil {
+		return fallback
+	}
+	return *v
+}
+
+// pageLimit 把页码转换为 limit/offset，默认每页 20 条，最多 100 条。
+func pageLimit(page, size int) (int, int) {
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 20
+	}
+	if size > 100 {
+		size = 100
+	}
+	return size, (page - 1) * size
+}
+
+// RedeemServiceInterface 兑换码服务接口。
+type RedeemServiceInterface interface {
+	CreateCampaign(ctx context.Context, adminID uint, req *CreateRedeemCampaignReq) (*RedeemCampaignView, error)
+	ListCampaigns(ctx context.Context, page, size int) ([]RedeemCampaignView, error)
+	GetCampaign(ctx context.Context, id uint) (*RedeemCampaignView, error)
+	ListCodes(ctx context.Context, campaignID uint, page, size int) ([]model.RedeemCode, error)
+	SetCampaignDisabled(ctx context.Context, id uint, disabled bool) error
+	Redeem(ctx context.Context, userID uint, code string) (*RedeemResult, error)
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+)
+
+// maxSubscriptionMonths 单次开通或续期的最多月数。
+const maxSubscriptionMonths = 36
+
+// SubscriptionService 订阅与额度服务。精简时优先消耗当前计费周期的额度，额度不足的章节再按积分计费。
+type SubscriptionService struct {
+	repo repository.SubscriptionRepositoryInterface
+	cfg  *config.SubscriptionConfig
+	now  func() time.Time
+}
+
+// NewSubscriptionService 创建订阅服务。
+func NewSubscriptionService(repo repository.SubscriptionRepositoryInterface, cfg *config.SubscriptionConfig) *SubscriptionService {
+	return &SubscriptionService{repo: repo, cfg: cfg, now: time.Now}
+}
+
+// SubscriptionPlan 订阅套餐。
+type SubscriptionPlan struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	MonthlyChapters int    `json:"monthly_chapters"` // 0 表示不限
+	PromptIDs       []uint `json:"prompt_ids"`       // 为空表示全部提示词
+}
+
+// QuotaInput 占用一章额度的输入。
+type QuotaInput struct {
+	RefType        string
+	RefID          string
+	IdempotencyKey string
+}
+
+// QuotaPeriodUsage 单个计费周期的额度使用情况。
+type QuotaPeriodUsage struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Quota       int       `json:"quota"`     // 0 表示不限
+	Used        int       `json:"used"`      // 已精简完成的章节
+	Held        int       `json:"held"`      // 精简中的章节
+	Remaining   int       `json:"remaining"` // 不限时为 -1
+	Current     bool      `json:"current"`
+}
+
+// SubscriptionUsage 当前订阅及各计费周期的用量，周期按时间倒序。
+type SubscriptionUsage struct {
+	Subscription model.Subscription `json:"subscription"`
+	Plan         SubscriptionPlan   `json:"plan"`
+	Periods      []QuotaPeriodUsage `json:"periods"`
+}
+
+// ListPlans 获取订阅套餐。
+func (s *SubscriptionService) ListPlans() []SubscriptionPlan {
+	plans := make([]SubscriptionPlan, 0, len(s.cfg.Plans))
+	for _, p := range s.cfg.Plans {
+		plans = append(plans, planView(p))
+	}
+	return plans
+}
+
+// Grant 为用户开通订阅。已有同一套餐的生效订阅时顺延到期时间，已有其他套餐时拒绝。
+func (s *SubscriptionService) Grant(ctx context.Context, adminID uint, userID uint, planID string, months int) (*model.Subscription, error) {
+	if userID == 0 || months <= 0 || months > maxSubscriptionMonths {
+		return nil, errno.ErrParam
+	}
+	if _, ok := s.findPlan(planID); !ok {
+		return nil, errno.ErrSubscriptionPlan
+	}
+
+	now := s.now().Truncate(time.Second)
+	sub, err := s.repo.GetActiveSubscription(ctx, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	if sub != nil {
+		if sub.PlanID != planID {
+			return nil, errno.ErrSubscriptionConflict
+		}
+		sub.ExpiresAt = sub.ExpiresAt.AddDate(0, months, 0)
+		if err := s.repo.ExtendSubscription(ctx, sub.ID, sub.ExpiresAt); err != nil {
+			return nil, err
+		}
+		logger.Info().Uint("admin_id", adminID).Uint("user_id", userID).Str("plan_id", planID).Int("months", months).Time("expires_at", sub.ExpiresAt).Msg("订阅已续期")
+		return sub, nil
+	}
+
+	sub = &model.Subscription{
+		UserID:    userID,
+		PlanID:    planID,
+		StartsAt:  now,
+		ExpiresAt: now.AddDate(0, months, 0),
+		CreatedBy: adminID,
+	}
+	if err := s.repo.CreateSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	logger.Info().Uint("admin_id", adminID).Uint("user_id", userID).Str("plan_id", planID).Int("months", months).Msg("开通订阅")
+	return sub, nil
+}
+
+// CoverableChapters 返回当前额度可以抵扣 n 章中的多少章，用于报价与提交前的余额校验。
+func (s *SubscriptionService) CoverableChapters(ctx context.Context, userID uint, promptID uint, n int) (int, error) {
+	sub, plan, err := s.activePlan(ctx, userID, promptID)
+	if err != nil || sub == nil {
+		return 0, err
+	}
+	if plan.MonthlyChapters == 0 {
+		return n, nil
+	}
+	used, held, err := s.repo.CountUsage(ctx, sub.ID, billingPeriod(sub, s.now()))
+	if err != nil {
+		return 0, err
+	}
+	return min(max(plan.MonthlyChapters-used-held, 0), n), nil
+}
+
+// ReserveTrim 按顺序为章节占用当前计费周期的额度，返回与 entries 顺序一致的使用记录 ID，
+// 没有适用的订阅或额度不足的章节为 0，由调用方按积分计费。超过 ttl 仍未结算时由对账释放。
+func (s *SubscriptionService) ReserveTrim(ctx context.Context, userID uint, promptID uint, entries []QuotaInput, ttl time.Duration) ([]uint, error) {
+	ids := make([]uint, len(entries))
+	if len(entries) == 0 {
+		return ids, nil
+	}
+	sub, plan, err := s.activePlan(ctx, userID, promptID)
+	if err != nil || sub == nil {
+		return ids, err
+	}
+
+	now := s.now()
+	reqs := make([]repository.QuotaUsageRequest, 0, len(entries))
+	for _, entry := range entries {
+		reqs = append(reqs, repository.QuotaUsageRequest{
+			PromptID:       promptID,
+			RefType:        entry.RefType,
+			RefID:          entry.RefID,
+			ExpiresAt:      now.Add(ttl),
+			IdempotencyKey: entry.IdempotencyKey,
+		})
+	}
+	usages, err := s.repo.ReserveQuota(ctx, sub, billingPeriod(sub, now), plan.MonthlyChapters, reqs)
+	if err != nil {
+		return nil, err
+	}
+	for i, usage := range usages {
+		ids[i] = usage.ID
+	}
+	return ids, nil
+}
+
+// SettleUsage 精简成功后结算额度。
+func (s *SubscriptionService) SettleUsage(ctx context.Context, usageID uint) error {
+	settled, err := s.repo.SettleUsage(ctx, usageID)
+	if err != nil {
+		return err
+	}
+	if !settled {
+		logger.Warn().Uint("usage_id", usageID).Msg("订阅额度已结算或已释放，跳过结算")
+	}
+	return nil
+}
+
+// ReleaseUsage 精简失败时退回额度。已结算或已释放的记录忽略。
+func (s *SubscriptionService) ReleaseUsage(ctx context.Context, usageID uint) error {
+	_, err := s.repo.ReleaseUsage(ctx, usageID)
+	return err
+}
+
+// ReleaseUsagesByRef 释放关联对象上仍未结算的额度，返回释放数量。
+func (s *SubscriptionService) ReleaseUsagesByRef(ctx context.Context, refType string, refIDs []string) (int, error) {
+	usages, err := s.repo.ListHeldUsagesByRef(ctx, refType, refIDs)
+	if err != nil {
+		return 0, err
+	}
+	return s.releaseUsages(ctx, usages), nil
+}
+
+// ExtendUsagesByRef 将关联对象上仍未结算的额度有效期顺延 ttl，用于仍在处理中的长任务，返回顺延数量。
+func (s *SubscriptionService) ExtendUsagesByRef(ctx context.Context, refType string, refIDs []string, ttl time.Duration) (int, error) {
+	return s.repo.ExtendHeldUsagesByRef(ctx, refType, refIDs, s.now().Add(ttl))
+}
+
+// ReleaseExpiredUsages 对账：释放已过期仍未结算的额度，用于进程崩溃等未能正常结算的情况。
+func (s *SubscriptionService) ReleaseExpiredUsages(ctx context.Context, now time.Time) (int, error) {
+	total := 0
+	for {
+		usages, err := s.repo.ListExpiredUsages(ctx, now, holdReconcileBatch)
+		if err != nil {
+			return total, err
+		}
+		total += s.releaseUsages(ctx, usages)
+		if len(usages) < holdReconcileBatch {
+			return total, nil
+		}
+	}
+}
+
+func (s *SubscriptionService) releaseUsages(ctx context.Context, usages []model.QuotaUsage) int {
+	released := 0
+	for _, usage := range usages {
+		ok, err := s.repo.ReleaseUsage(ctx, usage.ID)
+		if err != nil {
+			logger.Error().Err(err).Uint("usage_id", usage.ID).Msg("释放订阅额度失败")
+			continue
+		}
+		if ok {
+			released++
+		}
+	}
+	return released
+}
+
+// GetUsage 获取用户当前订阅及开通以来各计费周期的用量。
+func (s *SubscriptionService) GetUsage(ctx context.Context, userID uint) (*SubscriptionUsage, error) {
+	now := s.now()
+	sub, err := s.repo.GetActiveSubscription(ctx, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return nil, errno.ErrSubscriptionNotFound
+	}
+	plan, ok := s.findPlan(sub.PlanID)
+	if !ok {
+		// 套餐已从配置下线，订阅到期前不再提供额度
+		return nil, errno.ErrSubscriptionPlan
+	}
+
+	current := billingPeriod(sub, now)
+	periods := billingPeriods(sub, current.End)
+	report := &SubscriptionUsage{Subscription: *sub, Plan: planView(plan), Periods: make([]QuotaPeriodUsage, 0, len(periods))}
+	for i := len(periods) - 1; i >= 0; i-- {
+		period := periods[i]
+		used, held, err := s.repo.CountUsage(ctx, sub.ID, period)
+		if err != nil {
+			return nil, err
+		}
+		usage := QuotaPeriodUsage{
+			PeriodStart: period.Start,
+			PeriodEnd:   period.End,
+			Quota:       plan.MonthlyChapters,
+			Used:        used,
+			Held:        held,
+			Remaining:   -1,
+			Current:     period.Start.Equal(current.Start),
+		}
+		if plan.MonthlyChapters > 0 {
+			usage.Remaining = max(plan.MonthlyChapters-used-held, 0)
+		}
+		report.Periods = append(report.Periods, usage)
+	}
+	return report, nil
+}
+
+// activePlan 获取用户当前生效且适用于该提示词的订阅与套餐，没有时均为空。
+func (s *SubscriptionService) activePlan(ctx context.Context, userID uint, promptID uint) (*model.Subscription, config.SubscriptionPlan, error) {
+	sub, err := s.repo.GetActiveSubscription(ctx, userID, s.now())
+	if err != nil || sub == nil {
+		return nil, config.SubscriptionPlan{}, err
+	}
+	plan, ok := s.findPlan(sub.PlanID)
+	if !ok || (len(plan.PromptIDs) > 0 && !slices.Contains(plan.PromptIDs, promptID)) {
+		return nil, config.SubscriptionPlan{}, nil
+	}
+	return sub, plan, nil
+}
+
+func (s *SubscriptionService) findPlan(id string) (config.SubscriptionPlan, bool) {
+	for _, p := range s.cfg.Plans {
+		if p.ID == id && p.MonthlyChapters >= 0 {
+			return p, true
+		}
+	}
+	return config.SubscriptionPlan{}, false
+}
+
+func planView(p config.SubscriptionPlan) SubscriptionPlan {
+	return SubscriptionPlan{ID: p.ID, Name: p.Name, MonthlyChapters: p.MonthlyChapters, PromptIDs: p.PromptIDs}
+}
+
+// billingPeriod 返回 now 所在的计费周期：自订阅开始每满一个月为一期，最后一期截止到订阅到期。
+func billingPeriod(sub *model.Subscription, now time.Time) repository.QuotaPeriod {
+	start := sub.StartsAt
+	for i := 1; ; i++ {
+		// 每期都从开始时间推算，避免月末日期逐期漂移
+		next := sub.StartsAt.AddDate(0, i, 0)
+		if now.Before(next) || !next.Before(sub.ExpiresAt) {
+			return repository.QuotaPeriod{Start: start, End: minTime(next, sub.ExpiresAt)}
+		}
+		start = next
+	}
+}
+
+// billingPeriods 返回订阅开始到 until 为止的所有计费周期，按时间正序。
+func billingPeriods(sub *model.Subscription, until time.Time) []repository.QuotaPeriod {
+	var periods []repository.QuotaPeriod
+	for i := 0; ; i++ {
+		start := sub.StartsAt.AddDate(0, i, 0)
+		if !start.Before(until) || !start.Before(sub.ExpiresAt) {
+			return periods
+		}
+		end := minTime(sub.StartsAt.AddDate(0, i+1, 0), sub.ExpiresAt)
+		periods = append(periods, repository.QuotaPeriod{Start: start, End: end})
+	}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// SubscriptionServiceInterface 订阅服务接口。
+type SubscriptionServiceInterface interface {
+	ListPlans() []SubscriptionPlan
+	Grant(ctx context.Context, adminID uint, userID uint, planID string, months int) (*model.Subscription, error)
+	CoverableChapters(ctx context.Context, userID uint, promptID uint, n int) (int, error)
+	ReserveTrim(ctx context.Context, userID uint, promptID uint, entries []QuotaInput, ttl time.Duration) ([]uint, error)
+	SettleUsage(ctx context.Context, usageID uint) error
+	ReleaseUsage(ctx context.Context, usageID uint) error
+	ReleaseUsagesByRef(ctx context.Context, refType string, refIDs []string) (int, error)
+	ExtendUsagesByRef(ctx context.Context, refType string, refIDs []string, ttl time.Duration) (int, error)
+	ReleaseExpiredUsages(ctx context.Context, now time.Time) (int, error)
+	GetUsage(ctx context.Context, userID uint) (*SubscriptionUsage, error)
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+func TestBillingPeriod(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2026, month, d, 10, 0, 0, 0, time.UTC)
+	}
+	sub := &model.Subscription{StartsAt: day(1, 15), ExpiresAt: day(4, 1)}
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{"first day", day(1, 15), day(1, 15), day(2, 15)},
+		{"first period", day(2, 14), day(1, 15), day(2, 15)},
+		{"second period starts on anniversary", day(2, 15), day(2, 15), day(3, 15)},
+		{"last period ends at expiry", day(3, 20), day(3, 15), day(4, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := billingPeriod(sub, tt.now)
+			if !got.Start.Equal(tt.wantStart) || !got.End.Equal(tt.wantEnd) {
+				t.Errorf("period = [%v, %v), want [%v, %v)", got.Start, got.End, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+
+	periods := billingPeriods(sub, billingPeriod(sub, day(3, 20)).End)
+	if len(periods) != 3 || !periods[2].End.Equal(sub.ExpiresAt) {
+		t.Fatalf("periods = %+v", periods)
+	}
+}
@@ -0,0 +1,471 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+	"github.com/zqr233qr/story-trim/templates"
+)
+
+const (
+	summaryOnlyTemplate = "summaryOnlyPrompt.tmpl"
+	recapTemplate       = "recapPrompt.tmpl"
+
+	recapFanout         = 20 // 每个中间层片段合并的条目数
+	recapConcurrency    = 4  // 生成摘要与片段时的并发数
+	maxRecapGenerate    = 50 // 单次回顾最多在后台补生成的章节摘要数
+	recapRecentChapters = 3  // 回顾结果附带的最近章节摘要数
+	recapSegmentWords   = 600
+	recapFinalWords     = 1200
+)
+
+// SummaryService 章节摘要与前情回顾服务。
+type SummaryService struct {
+	bookRepo   repository.BookRepositoryInterface
+	memoryRepo repository.MemoryRepositoryInterface
+	llmService LlmServiceInterface
+	tmpl       *template.Template
+
+	mu         sync.Mutex
+	generating map[uint]struct{}   // 正在后台补生成摘要的书籍
+	recapping  map[string]struct{} // 正在生成回顾的用户与书籍
+	wg         sync.WaitGroup
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// NewSummaryService 创建摘要服务。
+func NewSummaryService(bookRepo repository.BookRepositoryInterface, memoryRepo repository.MemoryRepositoryInterface, llmService LlmServiceInterface) *SummaryService {
+	tmpl, err := template.ParseFS(templates.FS, summaryOnlyTemplate, recapTemplate)
+	if err != nil {
+		panic("failed to load templates: " + err.Error())
+	}
+	// 后台补生成摘要的 LLM 调用排在交互调用之后
+	ctx, cancel := context.WithCancel(WithLlmPriority(context.Background(), LlmPriorityBatch))
+	return &SummaryService{
+		bookRepo:   bookRepo,
+		memoryRepo: memoryRepo,
+		llmService: llmService,
+		tmpl:       tmpl,
+		generating: make(map[uint]struct{}),
+		recapping:  make(map[string]struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Stop 取消并等待后台摘要生成结束。
+func (s *SummaryService) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// RecapReq 前情回顾参数。
+type RecapReq struct {
+	UntilChapter uint `form:"until_chapter"` // 回顾截止章节 ID（含），不能超过阅读进度
+}
+
+// RecapChapter 回顾中附带的单章摘要。
+type RecapChapter struct {
+	ChapterID uint   `json:"chapter_id"`
+	Index     int    `json:"index"`
+	Title     string `json:"title"`
+	Summary   string `json:"summary"`
+}
+
+// RecapResp 前情回顾结果。
+type RecapResp struct {
+	BookID         uint           `json:"book_id"`
+	UntilChapterID uint           `json:"until_chapter_id"` // 回顾覆盖到的最后一章
+	Chapters       int            `json:"chapters"`         // 回顾覆盖的章节数
+	Missing        int            `json:"missing"`          // 因缺少摘要未纳入回顾的章节数，这些摘要在后台生成
+	Recap          string         `json:"recap"`
+	Recent         []RecapChapter `json:"recent"`
+}
+
+// recapItem 参与合并的一段摘要，覆盖 [start, end] 章节序号区间。
+type recapItem struct {
+	start int
+	end   int
+	label string
+	text  string
+}
+
+// SummarizeChapter 生成单章剧情摘要，已有摘要时直接返回。
+func (s *SummaryService) SummarizeChapter(ctx context.Context, chapter *model.Chapter) (*model.ChapterSummary, error) {
+	existing, err := s.memoryRepo.GetChapterSummaries(ctx, []string{chapter.ChapterMD5})
+	if err != nil {
+		return nil, err
+	}
+	if summary, ok := existing[chapter.ChapterMD5]; ok {
+		return summary, nil
+	}
+
+	raw, err := s.bookRepo.GetRawContent(ctx, chapter.ChapterMD5)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, errno.ErrChapterNotFound
+	}
+
+	data := struct{ SummaryPromptContent string }{SummaryPromptContent: defaultSummaryPromptContent}
+	var promptID uint
+	if p, err := s.bookRepo.GetSummaryPrompt(ctx); err == nil && p.PromptContent != "" {
+		data.SummaryPromptContent = p.PromptContent
+		promptID = p.ID
+	}
+	var systemPrompt bytes.Buffer
+	if err := s.tmpl.ExecuteTemplate(&systemPrompt, summaryOnlyTemplate, data); err != nil {
+		return nil, err
+	}
+
+	text, err := s.complete(ctx, promptID, systemPrompt.String(), raw.Content)
+	if err != nil {
+		return nil, err
+	}
+	summary := &model.ChapterSummary{
+		ChapterMD5:   chapter.ChapterMD5,
+		PromptID:     promptID,
+		Summary:      text,
+		SummaryWords: len([]rune(text)),
+	}
+	if err := s.memoryRepo.SaveChapterSummary(ctx, summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// Recap 生成截至读者当前章节之前的前情回顾，读完全书后可回顾全书。
+// 只使用已有的章节摘要，缺失的摘要提交到后台生成。章节较多时先按区间分层合并摘要，
+// 中间结果按区间缓存供后续请求复用。同一用户同一本书同时只生成一份回顾。
+func (s *SummaryService) Recap(ctx context.Context, userID uint, bookID uint, req *RecapReq) (*RecapResp, error) {
+	key := fmt.Sprintf("%d:%d", userID, bookID)
+	s.mu.Lock()
+	if _, ok := s.recapping[key]; ok {
+		s.mu.Unlock()
+		return nil, errno.ErrRecapBusy
+	}
+	s.recapping[key] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.recapping, key)
+		s.mu.Unlock()
+	}()
+
+	// 读者在等待结果，合并摘要按交互调用排队
+	ctx = WithLlmPriority(ctx, LlmPriorityInteractive)
+	book, err := s.bookRepo.GetBookByIDWithUser(ctx, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if book == nil {
+		return nil, errno.ErrBookNotFound
+	}
+
+	history, err := s.bookRepo.GetReadingHistory(ctx, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if history == nil {
+		return nil, errno.ErrRecapNoProgress
+	}
+	current, err := s.bookRepo.GetChapterByID(ctx, history.LastChapterID)
+	if err != nil || current.BookID != bookID {
+		return nil, errno.ErrRecapNoProgress
+	}
+
+	// limit 为回顾章节序号的上界（不含）
+	limit := current.Index
+	if history.FinishedAt != nil {
+		limit = math.MaxInt32
+	}
+	if req.UntilChapter > 0 {
+		until, err := s.bookRepo.GetChapterByID(ctx, req.UntilChapter)
+		if err != nil || until.BookID != bookID {
+			return nil, errno.ErrChapterNotFound
+		}
+		limit = min(limit, until.Index+1)
+	}
+
+	all, err := s.bookRepo.GetChaptersByBookID(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	chapters := make([]model.Chapter, 0, len(all))
+	for _, c := range all {
+		if c.Index < limit {
+			chapters = append(chapters, c)
+		}
+	}
+	if len(chapters) == 0 {
+		return nil, errno.ErrRecapNoProgress
+	}
+
+	summaries, missing, err := s.loadSummaries(ctx, bookID, chapters)
+	if err != nil {
+		return nil, err
+	}
+
+	last := chapters[len(chapters)-1]
+	resp := &RecapResp{BookID: bookID, UntilChapterID: last.ID, Missing: missing, Recent: []RecapChapter{}}
+	items := make([]recapItem, 0, len(chapters))
+	for _, c := range chapters {
+		summary, ok := summaries[c.ChapterMD5]
+		if !ok {
+			continue
+		}
+		items = append(items, recapItem{start: c.Index, end: c.Index, label: c.Title, text: summary.Summary})
+		resp.Recent = append(resp.Recent, RecapChapter{ChapterID: c.ID, Index: c.Index, Title: c.Title, Summary: summary.Summary})
+	}
+	resp.Chapters = len(items)
+	if len(resp.Recent) > recapRecentChapters {
+		resp.Recent = resp.Recent[len(resp.Recent)-recapRecentChapters:]
+	}
+	if len(items) == 0 {
+		return resp, nil
+	}
+
+	// 缺少摘要时区间内容不完整，不写入缓存。
+	cacheable := missing == 0
+	start, end := chapters[0].Index, last.Index
+	if cacheable {
+		cached, err := s.memoryRepo.GetRecapSegment(ctx, bookID, 0, start, end)
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			resp.Recap = cached.Content
+			return resp, nil
+		}
+	}
+
+	top, err := s.condense(ctx, bookID, items, cacheable)
+	if err != nil {
+		return nil, err
+	}
+	recap, err := s.mergeItems(ctx, top, true)
+	if err != nil {
+		return nil, err
+	}
+	if cacheable {
+		if err := s.memoryRepo.SaveRecapSegment(ctx, &model.RecapSegment{
+			BookID: bookID, Level: 0, StartIndex: start, EndIndex: end, Content: recap,
+		}); err != nil {
+			logger.Warn().Err(err).Uint("book_id", bookID).Msg("缓存前情回顾失败")
+		}
+	}
+	resp.Recap = recap
+	return resp, nil
+}
+
+// loadSummaries 加载已有的章节摘要，返回摘要与缺失的章节数。有缺失时提交后台补生成。
+func (s *SummaryService) loadSummaries(ctx context.Context, bookID uint, chapters []model.Chapter) (map[string]*model.ChapterSummary, int, error) {
+	md5s := make([]string, 0, len(chapters))
+	for _, c := range chapters {
+		md5s = append(md5s, c.ChapterMD5)
+	}
+	summaries, err := s.memoryRepo.GetChapterSummaries(ctx, md5s)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 优先补离读者进度最近的章节
+	var pending []model.Chapter
+	missing := 0
+	seen := make(map[string]struct{})
+	for i := len(chapters) - 1; i >= 0; i-- {
+		c := chapters[i]
+		if _, ok := summaries[c.ChapterMD5]; ok {
+			continue
+		}
+		missing++
+		if _, ok := seen[c.ChapterMD5]; ok || len(pending) >= maxRecapGenerate {
+			continue
+		}
+		seen[c.ChapterMD5] = struct{}{}
+		pending = append(pending, c)
+	}
+	if len(pending) > 0 {
+		s.generateSummaries(bookID, pending)
+	}
+	return summaries, missing, nil
+}
+
+// generateSummaries 在后台按批量优先级生成章节摘要，同一本书同时只有一批在生成。
+func (s *SummaryService) generateSummaries(bookID uint, chapters []model.Chapter) {
+	s.mu.Lock()
+	if _, ok := s.generating[bookID]; ok {
+		s.mu.Unlock()
+		return
+	}
+	s.generating[bookID] = struct{}{}
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.generating, bookID)
+			s.mu.Unlock()
+		}()
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, recapConcurrency)
+		for _, c := range chapters {
+			if s.ctx.Err() != nil {
+				break
+			}
+			chapter := c
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				if _, err := s.SummarizeChapter(s.ctx, &chapter); err != nil {
+					logger.Warn().Err(err).Uint("chapter_id", chapter.ID).Msg("生成章节摘要失败")
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+}
+
+// condense 分层合并摘要，直到条目数不超过 recapFanout。不足一组的尾部条目直接进入上一层。
+func (s *SummaryService) condense(ctx context.Context, bookID uint, items []recapItem, cacheable bool) ([]recapItem, error) {
+	for level := 1; len(items) > recapFanout; level++ {
+		var groups [][]recapItem
+		for i := 0; i < len(items); i += recapFanout {
+			groups = append(groups, items[i:min(i+recapFanout, len(items))])
+		}
+
+		next := make([][]recapItem, len(groups))
+		errs := make([]error, len(groups))
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, recapConcurrency)
+		for i, group := range groups {
+			if len(group) < recapFanout {
+				next[i] = group
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, group []recapItem) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				segment, err := s.segment(ctx, bookID, level, group, cacheable)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				next[i] = []recapItem{segment}
+			}(i, group)
+		}
+		wg.Wait()
+
+		items = items[:0:0]
+		for i := range groups {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			items = append(items, next[i]...)
+		}
+	}
+	return items, nil
+}
+
+// segment 合并一组摘要为中间层片段，命中缓存时直接返回。
+func (s *SummaryService) segment(ctx context.Context, bookID uint, level int, group []recapItem, cacheable bool) (recapItem, error) {
+	first, last := group[0], group[len(group)-1]
+	item := recapItem{start: first.start, end: last.end, label: first.label + " ~ " + last.label}
+
+	if cacheable {
+		cached, err := s.memoryRepo.GetRecapSegment(ctx, bookID, level, item.start, item.end)
+		if err != nil {
+			return item, err
+		}
+		if cached != nil {
+			item.text = cached.Content
+			return item, nil
+		}
+	}
+
+	text, err := s.mergeItems(ctx, group, false)
+	if err != nil {
+		return item, err
+	}
+	item.text = text
+	if cacheable {
+		if err := s.memoryRepo.SaveRecapSegment(ctx, &model.RecapSegment{
+			BookID: bookID, Level: level, StartIndex: item.start, EndIndex: item.end, Content: text,
+		}); err != nil {
+			logger.Warn().Err(err).Uint("book_id", bookID).Int("level", level).Msg("缓存回顾片段失败")
+		}
+	}
+	return item, nil
+}
+
+// mergeItems 调用模型把多段摘要合并为一段文本，final 为 true 时生成面向读者的最终回顾。
+func (s *SummaryService) mergeItems(ctx context.Context, items []recapItem, final bool) (string, error) {
+	data := struct {
+		Final    bool
+		MaxWords int
+	}{Final: final, MaxWords: recapSegmentWords}
+	if final {
+		data.MaxWords = recapFinalWords
+	}
+	var systemPrompt bytes.Buffer
+	if err := s.tmpl.ExecuteTemplate(&systemPrompt, recapTemplate, data); err != nil {
+		return "", err
+	}
+
+	var userPrompt strings.Builder
+	for _, item := range items {
+		fmt.Fprintf(&userPrompt, "【%s】%s\n", item.label, item.text)
+	}
+	return s.complete(ctx, summaryPromptID(ctx, s.bookRepo), systemPrompt.String(), userPrompt.String())
+}
+
+func (s *SummaryService) complete(ctx context.Context, promptID uint, systemPrompt string, userPrompt string) (string, error) {
+	llmResp, err := s.llmService.Llm(ctx, promptID, systemPrompt, userPrompt)
+	if err != nil {
+		return "", err
+	}
+	if len(llmResp.Resp.Choices) == 0 {
+		return "", fmt.Errorf("empty llm output")
+	}
+	text := strings.TrimSpace(llmResp.Resp.Choices[0].Message.Content)
+	if text == "" {
+		return "", fmt.Errorf("empty llm output")
+	}
+	return text, nil
+}
+
+// summaryPromptID 返回摘要提示词 ID，用于让回顾、百科等摘要类调用与章节摘要走同一条模型路由。
+func summaryPromptID(ctx context.Context, bookRepo repository.BookRepositoryInterface) uint {
+	p, err := bookRepo.GetSummaryPrompt(ctx)
+	if err != nil {
+		return 0
+	}
+	return p.ID
+}
+
+// SummaryServiceInterface 摘要服务接口。
+type SummaryServiceInterface interface {
+	SummarizeChapter(ctx context.Context, chapter *model.Chapter) (*model.ChapterSummary, error)
+	Recap(ctx context.Context, userID uint, bookID uint, req *RecapReq) (*RecapResp, error)
+	Stop()
+}
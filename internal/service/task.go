@@ -2,6 +2,9 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -11,6 +14,16 @@ import (
 	"github.com/zqr233qr/story-trim/internal/errno"
 	"github.com/zqr233qr/story-trim/internal/model"
 	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	// taskItemHoldTTL 任务章节的积分预占有效期。处理中的章节由对账定时顺延，进程异常退出时由启动对账提前释放。
+	taskItemHoldTTL       = 24 * time.Hour
+	holdReconcileInterval = 10 * time.Minute
+
+	errTaskInterrupted = "task interrupted by server restart"
 )
 
 type TaskService struct {
@@ -19,6 +32,7 @@ type TaskService struct {
 	bookRepo      repository.BookRepositoryInterface
 	trimService   TrimServiceInterface
 	pointsService PointsServiceInterface
+	subscriptions SubscriptionServiceInterface
 	jobQueue      chan Job
 	maxWorkers    int
 	wg            sync.WaitGroup
@@ -37,15 +51,18 @@ func NewTaskService(
 	bookRepo repository.BookRepositoryInterface,
 	trimService TrimServiceInterface,
 	pointsService PointsServiceInterface,
+	subscriptions SubscriptionServiceInterface,
 	maxWorkers int,
 ) *TaskService {
-	ctx, cancel := context.WithCancel(context.Background())
+	// 后台任务的 LLM 调用排在交互调用之后
+	ctx, cancel := context.WithCancel(WithLlmPriority(context.Background(), LlmPriorityBatch))
 	return &TaskService{
 		repo:          repo,
 		taskItemRepo:  taskItemRepo,
 		bookRepo:      bookRepo,
 		trimService:   trimService,
 		pointsService: pointsService,
+		subscriptions: subscriptions,
 		jobQueue:      make(chan Job, 100),
 		maxWorkers:    maxWorkers,
 		ctx:           ctx,
@@ -54,10 +71,118 @@ func NewTaskService(
 }
 
 func (s *TaskService) Start() {
+	s.recoverInterrupted(s.ctx)
 	for i := 0; i < s.maxWorkers; i++ {
 		s.wg.Add(1)
 		go s.worker(i)
 	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(holdReconcileInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				s.reconcileHolds(s.ctx)
+			}
+		}
+	}()
+}
+
+// recoverInterrupted 任务队列只保存在内存中，启动时仍未结束的任务已随上次进程退出中断：
+// 标记为失败，未完成章节的积分预占与订阅额度全部释放。
+func (s *TaskService) recoverInterrupted(ctx context.Context) {
+	tasks, err := s.repo.GetUnfinishedTasks(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to load interrupted tasks")
+		return
+	}
+	for _, task := range tasks {
+		items, err := s.taskItemRepo.GetTaskItemsByTaskID(ctx, task.ID)
+		if err != nil {
+			logger.Error().Err(err).Str("task_id", task.ID).Msg("failed to load interrupted task items")
+			continue
+		}
+		var pending []model.TaskItem
+		refIDs := make([]string, 0, len(items))
+		for _, item := range items {
+			if item.Status == "processing" {
+				pending = append(pending, item)
+			}
+			refIDs = append(refIDs, fmt.Sprintf("%d", item.ID))
+		}
+		released, err := s.pointsService.ReleaseHoldsByRef(ctx, "task_item", refIDs)
+		if err != nil {
+			logger.Error().Err(err).Str("task_id", task.ID).Msg("failed to release interrupted task holds")
+			continue
+		}
+		quotaReleased, err := s.subscriptions.ReleaseUsagesByRef(ctx, "task_item", refIDs)
+		if err != nil {
+			logger.Error().Err(err).Str("task_id", task.ID).Msg("failed to release interrupted task quota")
+			continue
+		}
+		s.failItems(ctx, pending, errTaskInterrupted)
+		task.Status = "failed"
+		task.Error = errTaskInterrupted
+		_ = s.repo.UpdateTask(ctx, task)
+		logger.Info().Str("task_id", task.ID).Int("released", released).Int("quota_released", quotaReleased).Msg("interrupted task recovered")
+	}
+	s.reconcileHolds(ctx)
+}
+
+// reconcileHolds 顺延本进程仍在排队或处理中的章节的积分预占与订阅额度，再释放其余已过期仍未结算的部分。
+func (s *TaskService) reconcileHolds(ctx context.Context) {
+	s.renewTaskHolds(ctx)
+	released, err := s.pointsService.ReleaseExpiredHolds(ctx, time.Now())
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to release expired points holds")
+	}
+	if released > 0 {
+		logger.Info().Int("released", released).Msg("expired points holds released")
+	}
+	released, err = s.subscriptions.ReleaseExpiredUsages(ctx, time.Now())
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to release expired quota usages")
+	}
+	if released > 0 {
+		logger.Info().Int("released", released).Msg("expired quota usages released")
+	}
+}
+
+// renewTaskHolds 长任务（如大书低并发全书精简）可能超过预占有效期，顺延未结束任务中处理中章节的有效期，
+// 避免对账释放后这些章节免费精简。启动时中断的任务已先被标记失败，不会被顺延。
+func (s *TaskService) renewTaskHolds(ctx context.Context) {
+	tasks, err := s.repo.GetUnfinishedTasks(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to load unfinished tasks")
+		return
+	}
+	var refIDs []string
+	for _, task := range tasks {
+		items, err := s.taskItemRepo.GetTaskItemsByTaskID(ctx, task.ID)
+		if err != nil {
+			logger.Error().Err(err).Str("task_id", task.ID).Msg("failed to load task items")
+			continue
+		}
+		for _, item := range items {
+			if item.Status == "processing" {
+				refIDs = append(refIDs, fmt.Sprintf("%d", item.ID))
+			}
+		}
+	}
+	if len(refIDs) == 0 {
+		return
+	}
+	if _, err := s.pointsService.ExtendHoldsByRef(ctx, "task_item", refIDs, taskItemHoldTTL); err != nil {
+		logger.Error().Err(err).Msg("failed to extend task points holds")
+	}
+	if _, err := s.subscriptions.ExtendUsagesByRef(ctx, "task_item", refIDs, taskItemHoldTTL); err != nil {
+		logger.Error().Err(err).Msg("failed to extend task quota usages")
+	}
 }
 
 func (s *TaskService) Stop() {
@@ -80,29 +205,175 @@ func (s *TaskService) worker(id int) {
 	}
 }
 
-func (s *TaskService) SubmitFullTrimTask(ctx context.Context, userID uint, bookID uint, promptID uint) (string, error) {
-	taskID := uuid.New().String()
-	task := &model.Task{
-		ID:       taskID,
-		UserID:   userID,
-		BookID:   bookID,
-		PromptID: promptID,
-		Type:     "full_trim",
-		Status:   "pending",
-		Progress: 0,
+// FullTrimQuote 全书精简报价。已拥有精简结果或正在其他任务中处理的章节不计费，订阅额度可抵扣的章节
+// 不消耗积分。确认提交时需回传 QuoteToken，报价变化（如期间精简了部分章节）时需重新确认。
+type FullTrimQuote struct {
+	BookID             uint   `json:"book_id"`
+	PromptID           uint   `json:"prompt_id"`
+	TotalChapters      int    `json:"total_chapters"`
+	ProcessedChapters  int    `json:"processed_chapters"`
+	ProcessingChapters int    `json:"processing_chapters"`
+	BillableChapters   int    `json:"billable_chapters"`
+	QuotaChapters      int    `json:"quota_chapters"` // 由订阅额度抵扣的章节数
+	Cost               int    `json:"cost"`
+	Balance            int    `json:"balance"`
+	Sufficient         bool   `json:"sufficient"`
+	QuoteToken         string `json:"quote_token"`
+}
+
+// fullTrimPlan 全书精简的报价与待处理章节。
+type fullTrimPlan struct {
+	quote    *FullTrimQuote
+	book     *model.Book
+	prompt   *model.Prompt
+	chapters []model.Chapter
+	prices   []TrimPrice
+}
+
+// planFullTrim 校验书籍归属并计算需要处理的章节与报价。
+func (s *TaskService) planFullTrim(ctx context.Context, userID uint, bookID uint, promptID uint) (*fullTrimPlan, error) {
+	book, err := s.bookRepo.GetBookByIDWithUser(ctx, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if book == nil {
+		return nil, errno.ErrBookNotFound
+	}
+	prompt, err := s.bookRepo.GetPromptByID(ctx, promptID)
+	if err != nil {
+		return nil, errno.ErrTrimInvalid
 	}
 
-	if err := s.repo.CreateTask(ctx, task); err != nil {
-		return "", errno.ErrInternalServer
+	chapters, err := s.bookRepo.GetChaptersByBookID(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	processedMD5s, err := s.bookRepo.GetTrimmedChapterMD5sByPrompt(ctx, userID, promptID, bookID, book.BookMD5)
+	if err != nil {
+		return nil, err
+	}
+	processedSet := make(map[string]struct{}, len(processedMD5s))
+	for _, md5 := range processedMD5s {
+		processedSet[md5] = struct{}{}
+	}
+	processingIDs, err := s.taskItemRepo.GetProcessingChapterIDs(ctx, userID, bookID, promptID)
+	if err != nil {
+		return nil, err
+	}
+	processingSet := make(map[uint]struct{}, len(processingIDs))
+	for _, id := range processingIDs {
+		processingSet[id] = struct{}{}
+	}
+
+	quote := &FullTrimQuote{BookID: bookID, PromptID: promptID, TotalChapters: len(chapters)}
+	billable := make([]model.Chapter, 0, len(chapters))
+	for _, chap := range chapters {
+		if _, ok := processedSet[chap.ChapterMD5]; ok {
+			quote.ProcessedChapters++
+			continue
+		}
+		if _, ok := processingSet[chap.ID]; ok {
+			quote.ProcessingChapters++
+			continue
+		}
+		billable = append(billable, chap)
+	}
+	prices, _, err := s.priceChapters(ctx, promptID, billable)
+	if err != nil {
+		return nil, err
+	}
+	covered, err := s.subscriptions.CoverableChapters(ctx, userID, promptID, len(billable))
+	if err != nil {
+		return nil, err
 	}
+	quote.BillableChapters = len(billable)
+	quote.QuotaChapters = covered
+	quote.Cost = pointsCost(prices[covered:])
 
-	s.jobQueue <- &FullTrimJob{
-		s:        s,
-		task:     task,
-		promptID: promptID,
+	balance, err := s.pointsService.GetBalance(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
+	quote.Balance = balance
+	quote.Sufficient = balance >= quote.Cost
+	quote.QuoteToken = fullTrimQuoteToken(userID, quote, billable)
 
-	return taskID, nil
+	return &fullTrimPlan{quote: quote, book: book, prompt: prompt, chapters: billable, prices: prices}, nil
+}
+
+// pointsCost 合计报价积分。
+func pointsCost(prices []TrimPrice) int {
+	cost := 0
+	for _, price := range prices {
+		cost += price.Points
+	}
+	return cost
+}
+
+// priceChapters 按章节字数计价，返回每章报价与合计积分。
+func (s *TaskService) priceChapters(ctx context.Context, promptID uint, chapters []model.Chapter) ([]TrimPrice, int, error) {
+	md5s := make([]string, 0, len(chapters))
+	for _, chap := range chapters {
+		md5s = append(md5s, chap.ChapterMD5)
+	}
+	metas, err := s.bookRepo.GetContentMetasByMD5s(ctx, md5s)
+	if err != nil {
+		return nil, 0, err
+	}
+	prices := make([]TrimPrice, 0, len(chapters))
+	total := 0
+	for _, chap := range chapters {
+		price := s.pointsService.PriceTrim(promptID, metas[chap.ChapterMD5].WordsCount)
+		prices = append(prices, price)
+		total += price.Points
+	}
+	return prices, total, nil
+}
+
+// fullTrimQuoteToken 由报价内容生成的确认凭证，待处理章节或费用变化时随之改变。
+func fullTrimQuoteToken(userID uint, quote *FullTrimQuote, chapters []model.Chapter) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%d:%d:%d:%d", userID, quote.BookID, quote.PromptID, quote.QuotaChapters, quote.Cost)
+	for _, chap := range chapters {
+		fmt.Fprintf(h, ":%d", chap.ID)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// QuoteFullTrim 获取全书精简报价。
+func (s *TaskService) QuoteFullTrim(ctx context.Context, userID uint, bookID uint, promptID uint) (*FullTrimQuote, error) {
+	plan, err := s.planFullTrim(ctx, userID, bookID, promptID)
+	if err != nil {
+		return nil, err
+	}
+	return plan.quote, nil
+}
+
+// SubmitFullTrimTask 按确认过的报价提交全书精简任务，逐章预占积分。
+func (s *TaskService) SubmitFullTrimTask(ctx context.Context, userID uint, bookID uint, promptID uint, quoteToken string) (string, error) {
+	if taskID, err := s.submittedTask(ctx, userID); taskID != "" || err != nil {
+		return taskID, err
+	}
+	plan, err := s.planFullTrim(ctx, userID, bookID, promptID)
+	if err != nil {
+		return "", err
+	}
+	if plan.quote.BillableChapters == 0 {
+		return "", errno.ErrTrimDuplicate
+	}
+	if quoteToken != plan.quote.QuoteToken {
+		return "", errno.ErrTaskQuoteChanged
+	}
+	if !plan.quote.Sufficient {
+		return "", errno.ErrPointsNotEnough
+	}
+
+	// 精简+摘要模式下后一章依赖前一章的摘要，需按章节顺序逐章处理。
+	concurrency := 5
+	if s.trimService.MemoryEnabled() {
+		concurrency = 1
+	}
+	return s.enqueueTrimTask(ctx, userID, "full_trim", plan.book, plan.prompt, plan.chapters, plan.prices, concurrency)
 }
 
 // SubmitChapterTrimTask 提交指定章节精简任务。
@@ -110,6 +381,9 @@ func (s *TaskService) SubmitChapterTrimTask(ctx context.Context, userID uint, bo
 	if len(chapterIDs) == 0 {
 		return "", errno.ErrParam
 	}
+	if taskID, err := s.submittedTask(ctx, userID); taskID != "" || err != nil {
+		return taskID, err
+	}
 
 	book, err := s.bookRepo.GetBookByIDWithUser(ctx, userID, bookID)
 	if err != nil {
@@ -181,55 +455,84 @@ func (s *TaskService) SubmitChapterTrimTask(ctx context.Context, userID uint, bo
 		}
 	}
 
-	taskID := uuid.New().String()
 	prompt, err := s.bookRepo.GetPromptByID(ctx, promptID)
 	if err != nil {
 		return "", err
 	}
+	prices, _, err := s.priceChapters(ctx, promptID, chapters)
+	if err != nil {
+		return "", err
+	}
+	return s.enqueueTrimTask(ctx, userID, "chapter_trim", book, prompt, chapters, prices, 5)
+}
 
-	entries := make([]PointsChangeInput, 0, len(uniqueIDs))
-	for _, chap := range chapters {
-		if _, ok := seen[chap.ID]; !ok {
-			continue
-		}
-		extra := map[string]string{
-			"book_title":    book.Title,
-			"chapter_title": chap.Title,
-			"prompt_name":   prompt.Name,
-		}
-		entries = append(entries, PointsChangeInput{
-			RefType: "chapter",
-			RefID:   fmt.Sprintf("%d", chap.ID),
-			Extra:   extra,
-		})
+// taskIdempotencyKey 客户端幂等键按用户隔离后的任务幂等键，未携带时为空。
+func taskIdempotencyKey(ctx context.Context, userID uint) string {
+	key := idempotencyKeyFrom(ctx)
+	if key == "" {
+		return ""
+	}
+	return fmt.Sprintf("task:%d:%s", userID, key)
+}
+
+// submittedTask 返回相同幂等键已提交的任务 ID，未提交过时为空。
+func (s *TaskService) submittedTask(ctx context.Context, userID uint) (string, error) {
+	key := taskIdempotencyKey(ctx, userID)
+	if key == "" {
+		return "", nil
+	}
+	task, err := s.repo.GetTaskByIdempotencyKey(ctx, key)
+	if err != nil || task == nil {
+		return "", err
 	}
+	return task.ID, nil
+}
 
-	if err := s.pointsService.SpendForTrimBatch(ctx, userID, entries); err != nil {
+// enqueueTrimTask 创建精简任务与章节记录，按章节顺序优先占用订阅额度，其余章节按报价预占积分后加入队列。
+// 章节精简成功后结算，失败或取消时释放。
+func (s *TaskService) enqueueTrimTask(ctx context.Context, userID uint, taskType string, book *model.Book, prompt *model.Prompt, chapters []model.Chapter, prices []TrimPrice, concurrency int) (string, error) {
+	covered, err := s.subscriptions.CoverableChapters(ctx, userID, prompt.ID, len(chapters))
+	if err != nil {
+		return "", err
+	}
+	cost := pointsCost(prices[covered:])
+	balance, err := s.pointsService.GetBalance(ctx, userID)
+	if err != nil {
 		return "", err
 	}
+	if balance < cost {
+		return "", errno.ErrPointsNotEnough
+	}
 
+	taskID := uuid.New().String()
 	task := &model.Task{
 		ID:       taskID,
 		UserID:   userID,
-		BookID:   bookID,
-		PromptID: promptID,
-		Type:     "chapter_trim",
+		BookID:   book.ID,
+		PromptID: prompt.ID,
+		Type:     taskType,
 		Status:   "pending",
 		Progress: 0,
 	}
+	if key := taskIdempotencyKey(ctx, userID); key != "" {
+		task.IdempotencyKey = &key
+	}
 
-	items := make([]model.TaskItem, 0, len(uniqueIDs))
-	for _, id := range uniqueIDs {
+	items := make([]model.TaskItem, 0, len(chapters))
+	for _, chap := range chapters {
 		items = append(items, model.TaskItem{
 			TaskID:    taskID,
-			ChapterID: id,
-			PromptID:  promptID,
+			ChapterID: chap.ID,
+			PromptID:  prompt.ID,
 			Status:    "processing",
 		})
 	}
 
 	if err := s.repo.CreateTask(ctx, task); err != nil {
-		_ = s.pointsService.RefundForTrimBatch(ctx, userID, entries)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			// 相同幂等键的并发提交已先一步创建任务
+			return s.submittedTask(ctx, userID)
+		}
 		return "", errno.ErrInternalServer
 	}
 
@@ -237,20 +540,86 @@ func (s *TaskService) SubmitChapterTrimTask(ctx context.Context, userID uint, bo
 		task.Status = "failed"
 		task.Error = err.Error()
 		_ = s.repo.UpdateTask(ctx, task)
-		_ = s.pointsService.RefundForTrimBatch(ctx, userID, entries)
 		return "", errno.ErrInternalServer
 	}
 
+	quotaEntries := make([]QuotaInput, 0, len(items))
+	for _, item := range items {
+		quotaEntries = append(quotaEntries, QuotaInput{RefType: "task_item", RefID: fmt.Sprintf("%d", item.ID)})
+	}
+	usageIDs, err := s.subscriptions.ReserveTrim(ctx, userID, prompt.ID, quotaEntries, taskItemHoldTTL)
+	if err != nil {
+		s.failTask(ctx, task, items, err)
+		return "", err
+	}
+	usages := make(map[uint]uint, len(items))
+	entries := make([]PointsChangeInput, 0, len(items))
+	charged := make([]model.TaskItem, 0, len(items))
+	for i, item := range items {
+		if usageIDs[i] != 0 {
+			usages[item.ID] = usageIDs[i]
+			continue
+		}
+		charged = append(charged, item)
+		entries = append(entries, PointsChangeInput{
+			Amount:  prices[i].Points,
+			RefType: "task_item",
+			RefID:   fmt.Sprintf("%d", item.ID),
+			Extra: prices[i].Extra(map[string]string{
+				"book_title":    book.Title,
+				"chapter_title": chapters[i].Title,
+				"prompt_name":   prompt.Name,
+				"task_id":       taskID,
+				"chapter_id":    fmt.Sprintf("%d", item.ChapterID),
+			}),
+		})
+	}
+	holdIDs, err := s.pointsService.HoldForTrim(ctx, userID, entries, taskItemHoldTTL)
+	if err != nil {
+		for _, usageID := range usages {
+			if releaseErr := s.subscriptions.ReleaseUsage(ctx, usageID); releaseErr != nil {
+				logger.Error().Err(releaseErr).Uint("usage_id", usageID).Msg("failed to release quota usage")
+			}
+		}
+		s.failTask(ctx, task, items, err)
+		return "", err
+	}
+	holds := make(map[uint]uint, len(charged))
+	for i, item := range charged {
+		holds[item.ID] = holdIDs[i]
+	}
+
 	s.jobQueue <- &ChapterTrimJob{
-		s:        s,
-		task:     task,
-		promptID: promptID,
-		items:    items,
+		s:           s,
+		task:        task,
+		promptID:    prompt.ID,
+		items:       items,
+		holds:       holds,
+		usages:      usages,
+		concurrency: concurrency,
 	}
 
 	return taskID, nil
 }
 
+// failTask 提交过程中扣费失败时把任务与章节记录标记为失败。
+func (s *TaskService) failTask(ctx context.Context, task *model.Task, items []model.TaskItem, err error) {
+	task.Status = "failed"
+	task.Error = err.Error()
+	_ = s.repo.UpdateTask(ctx, task)
+	s.failItems(ctx, items, err.Error())
+}
+
+// failItems 把未完成的章节记录标记为失败。
+func (s *TaskService) failItems(ctx context.Context, items []model.TaskItem, reason string) {
+	for _, item := range items {
+		item.Status = "failed"
+		item.Error = reason
+		item.UpdatedAt = time.Now()
+		_ = s.taskItemRepo.UpdateTaskItem(ctx, &item)
+	}
+}
+
 // GetChapterTrimStatus 获取指定模式的精简状态。
 func (s *TaskService) GetChapterTrimStatus(ctx context.Context, userID uint, bookID uint, promptID uint) ([]uint, []uint, error) {
 	book, err := s.bookRepo.GetBookByID(ctx, bookID)
@@ -297,109 +666,18 @@ func (s *TaskService) GetActiveTasksCount(ctx context.Context, userID uint) (int
 	return s.repo.GetActiveTasksCountByUserID(ctx, userID)
 }
 
-type FullTrimJob struct {
-	s        *TaskService
-	task     *model.Task
-	promptID uint
-}
-
-func (j *FullTrimJob) Execute(ctx context.Context) error {
-	j.task.Status = "running"
-	j.task.Progress = 0
-	j.task.Error = ""
-	_ = j.s.repo.UpdateTask(ctx, j.task)
-
-	chapters, err := j.s.bookRepo.GetChaptersByBookID(ctx, j.task.BookID)
-	if err != nil {
-		j.task.Status = "failed"
-		j.task.Error = err.Error()
-		_ = j.s.repo.UpdateTask(ctx, j.task)
-		return err
-	}
-
-	total := len(chapters)
-	if total == 0 {
-		j.task.Status = "completed"
-		j.task.Progress = 100
-		_ = j.s.repo.UpdateTask(ctx, j.task)
-		return nil
-	}
-
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, 5) // limit to 5 concurrent routines
-	errChan := make(chan error, total)
-	progressChan := make(chan int, total)
-
-	startTime := time.Now()
-
-	// Update progress routine
-	go func() {
-		completed := 0
-		for range progressChan {
-			completed++
-			progress := int(float64(completed) / float64(total) * 100)
-			// Avoid frequent updates
-			j.task.Progress = progress
-			_ = j.s.repo.UpdateTask(ctx, j.task)
-		}
-	}()
-
-f:
-	for _, chapter := range chapters {
-		select {
-		case <-ctx.Done():
-			break f
-		default:
-			wg.Add(1)
-			sem <- struct{}{}
-			go func(chap model.Chapter) {
-				defer wg.Done()
-				defer func() { <-sem }()
-
-				err := j.s.trimService.TrimChatByChapterID(ctx, j.task.UserID, chap.ID, j.promptID)
-				if err != nil {
-					errChan <- fmt.Errorf("chapter %d: %v", chap.Index, err)
-				}
-				progressChan <- 1
-			}(chapter)
-		}
-	}
-
-	wg.Wait()
-	close(progressChan)
-	close(errChan)
-
-	var errs []string
-	for err := range errChan {
-		errs = append(errs, err.Error())
-	}
-
-	j.task.TakeTime = time.Since(startTime).Seconds()
-	if len(errs) > 0 {
-		// If all failed, mark as failed
-		if len(errs) == total {
-			j.task.Status = "failed"
-		} else {
-			j.task.Status = "completed" // Partial success is still completed? Or maybe "partial"? Keeping it simple.
-		}
-		j.task.Error = strings.Join(errs, "; ")
-	} else {
-		j.task.Status = "completed"
-	}
-	j.task.Progress = 100
-
-	return j.s.repo.UpdateTask(ctx, j.task)
-}
-
-// ChapterTrimJob 指定章节精简任务。
+// ChapterTrimJob 按章节记录执行的精简任务，全书精简与指定章节精简共用。
 type ChapterTrimJob struct {
-	s        *TaskService
-	task     *model.Task
-	promptID uint
-	items    []model.TaskItem
+	s           *TaskService
+	task        *model.Task
+	promptID    uint
+	items       []model.TaskItem
+	holds       map[uint]uint // 章节记录 ID 到积分预占 ID
+	usages      map[uint]uint // 章节记录 ID 到订阅额度使用记录 ID
+	concurrency int
 }
 
-// Execute 执行指定章节精简任务。
+// Execute 执行章节精简任务。
 func (j *ChapterTrimJob) Execute(ctx context.Context) error {
 	j.task.Status = "running"
 	j.task.Progress = 0
@@ -414,7 +692,7 @@ func (j *ChapterTrimJob) Execute(ctx context.Context) error {
 	}
 
 	var wg sync.WaitGroup
-	sem := make(chan struct{}, 5)
+	sem := make(chan struct{}, max(j.concurrency, 1))
 	errChan := make(chan error, total)
 	progressChan := make(chan int, total)
 
@@ -429,6 +707,7 @@ func (j *ChapterTrimJob) Execute(ctx context.Context) error {
 		}
 	}()
 
+	started := 0
 f:
 	for _, item := range j.items {
 		select {
@@ -437,6 +716,7 @@ f:
 		default:
 			wg.Add(1)
 			sem <- struct{}{}
+			started++
 			go func(it model.TaskItem) {
 				defer wg.Done()
 				defer func() { <-sem }()
@@ -450,7 +730,8 @@ f:
 					it.Status = "success"
 				}
 				it.UpdatedAt = time.Now()
-				_ = j.s.taskItemRepo.UpdateTaskItem(ctx, &it)
+				j.finishHold(it.ID, err)
+				_ = j.s.taskItemRepo.UpdateTaskItem(context.WithoutCancel(ctx), &it)
 				progressChan <- 1
 			}(item)
 		}
@@ -460,6 +741,14 @@ f:
 	close(progressChan)
 	close(errChan)
 
+	// 任务被取消时未开始的章节释放预占
+	if pending := j.items[started:]; len(pending) > 0 {
+		for _, it := range pending {
+			j.finishHold(it.ID, context.Canceled)
+		}
+		j.s.failItems(context.WithoutCancel(ctx), pending, context.Canceled.Error())
+	}
+
 	var errs []string
 	for err := range errChan {
 		errs = append(errs, err.Error())
@@ -480,8 +769,36 @@ f:
 	return j.s.repo.UpdateTask(ctx, j.task)
 }
 
+// finishHold 章节处理结束后结算或释放其订阅额度或积分预占。
+func (j *ChapterTrimJob) finishHold(itemID uint, err error) {
+	if usageID, ok := j.usages[itemID]; ok {
+		if err != nil {
+			err = j.s.subscriptions.ReleaseUsage(context.Background(), usageID)
+		} else {
+			err = j.s.subscriptions.SettleUsage(context.Background(), usageID)
+		}
+		if err != nil {
+			logger.Error().Err(err).Uint("usage_id", usageID).Msg("failed to finish quota usage")
+		}
+		return
+	}
+	holdID, ok := j.holds[itemID]
+	if !ok {
+		return
+	}
+	if err != nil {
+		err = j.s.pointsService.ReleaseHold(context.Background(), holdID)
+	} else {
+		err = j.s.pointsService.SettleHold(context.Background(), holdID)
+	}
+	if err != nil {
+		logger.Error().Err(err).Uint("hold_id", holdID).Msg("failed to finish points hold")
+	}
+}
+
 type TaskServiceInterface interface {
-	SubmitFullTrimTask(ctx context.Context, userID uint, bookID uint, promptID uint) (string, error)
+	QuoteFullTrim(ctx context.Context, userID uint, bookID uint, promptID uint) (*FullTrimQuote, error)
+	SubmitFullTrimTask(ctx context.Context, userID uint, bookID uint, promptID uint, quoteToken string) (string, error)
 	SubmitChapterTrimTask(ctx context.Context, userID uint, bookID uint, promptID uint, chapterIDs []uint) (string, error)
 	GetChapterTrimStatus(ctx context.Context, userID uint, bookID uint, promptID uint) ([]uint, []uint, error)
 	GetTaskByIDs(ctx context.Context, ids []string) ([]*model.Task, error)
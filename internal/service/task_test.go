@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+func TestFullTrimQuoteToken(t *testing.T) {
+	chapters := []model.Chapter{{ID: 1}, {ID: 2}, {ID: 3}}
+	quote := &FullTrimQuote{BookID: 9, PromptID: 2, Cost: 3}
+	base := fullTrimQuoteToken(1, quote, chapters)
+
+	tests := []struct {
+		name     string
+		userID   uint
+		quote    FullTrimQuote
+		chapters []model.Chapter
+		same     bool
+	}{
+		{"identical quote", 1, *quote, chapters, true},
+		{"other user", 2, *quote, chapters, false},
+		{"other prompt", 1, FullTrimQuote{BookID: 9, PromptID: 3, Cost: 3}, chapters, false},
+		{"quota covers part of the book", 1, FullTrimQuote{BookID: 9, PromptID: 2, QuotaChapters: 1, Cost: 3}, chapters, false},
+		{"chapter trimmed meanwhile", 1, FullTrimQuote{BookID: 9, PromptID: 2, Cost: 2}, chapters[1:], false},
+		{"different chapters same cost", 1, *quote, []model.Chapter{{ID: 1}, {ID: 2}, {ID: 4}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fullTrimQuoteToken(tt.userID, &tt.quote, tt.chapters)
+			if (got == base) != tt.same {
+				t.Errorf("token equality = %v, want %v", got == base, tt.same)
+			}
+		})
+	}
+}
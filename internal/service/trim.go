@@ -7,7 +7,10 @@ import (
 	"strings"
 	"text/template"
 	"time"
+	"unicode/utf8"
 
+	"github.com/sashabaranov/go-openai"
+	"github.com/zqr233qr/story-trim/internal/config"
 	"github.com/zqr233qr/story-trim/internal/errno"
 	"github.com/zqr233qr/story-trim/internal/model"
 	"github.com/zqr233qr/story-trim/internal/repository"
@@ -15,20 +18,57 @@ import (
 	"github.com/zqr233qr/story-trim/templates"
 )
 
+const (
+	trimTemplate           = "trimPrompt.tmpl"
+	trimAndSummaryTemplate = "trimAndSummaryPrompt.tmpl"
+
+	defaultSummaryLimit = 5
+	// defaultSummaryPromptContent 数据库中没有摘要提示词时使用的摘要要求。
+	defaultSummaryPromptContent = "用 150-300 字概括本章的关键人物、主要事件及其结果，只陈述本章已发生的事实，不做推测。"
+
+	// trimStreamHoldTTL 流式精简的积分预占有效期，需长于模型流的超时时间。
+	trimStreamHoldTTL = 30 * time.Minute
+
+	// trimContinuePrompt 服务重启后续写未完成的精简时，附在原文之后的说明，其后为已输出的部分。
+	trimContinuePrompt = "\n\n---\n以上为原文。你此前的输出因中断停在下方文本末尾，请严格从末尾处接着输出剩余内容，不要重复已输出的文字，也不要添加任何说明：\n"
+)
+
 type TrimService struct {
 	bookRepo      repository.BookRepositoryInterface
+	memoryRepo    repository.MemoryRepositoryInterface
+	taskItemRepo  repository.TaskItemRepositoryInterface
 	pointsService PointsServiceInterface
+	subscriptions SubscriptionServiceInterface
 	tmpl          *template.Template
 	llmService    LlmServiceInterface
+	encyclopedia  EncyclopediaServiceInterface
+	memory        *config.MemoryConfig
+	flights       *trimFlightGroup
 }
 
 // NewTrimService 创建精简服务。
-func NewTrimService(bookRepo repository.BookRepositoryInterface, pointsService PointsServiceInterface, llmService LlmServiceInterface) *TrimService {
-	tmpl, err := template.ParseFS(templates.FS, "trimPrompt.tmpl")
+func NewTrimService(bookRepo repository.BookRepositoryInterface, memoryRepo repository.MemoryRepositoryInterface, taskItemRepo repository.TaskItemRepositoryInterface, pointsService PointsServiceInterface, subscriptions SubscriptionServiceInterface, llmService LlmServiceInterface, encyclopedia EncyclopediaServiceInterface, memory *config.MemoryConfig) *TrimService {
+	tmpl, err := template.ParseFS(templates.FS, trimTemplate, trimAndSummaryTemplate)
 	if err != nil {
 		panic("failed to load templates: " + err.Error())
 	}
-	return &TrimService{bookRepo: bookRepo, pointsService: pointsService, tmpl: tmpl, llmService: llmService}
+	return &TrimService{
+		bookRepo:      bookRepo,
+		memoryRepo:    memoryRepo,
+		taskItemRepo:  taskItemRepo,
+		pointsService: pointsService,
+		subscriptions: subscriptions,
+		tmpl:          tmpl,
+		llmService:    llmService,
+		encyclopedia:  encyclopedia,
+		memory:        memory,
+		flights:       newTrimFlightGroup(),
+	}
+}
+
+// MemoryEnabled 是否开启精简+摘要模式。
+func (s *TrimService) MemoryEnabled() bool {
+	return s.memory != nil && s.memory.Enabled
 }
 
 func (s *TrimService) RenderPrompt(name string, data interface{}) (string, error) {
@@ -39,8 +79,15 @@ func (s *TrimService) RenderPrompt(name string, data interface{}) (string, error
 	return buf.String(), nil
 }
 
-func (s *TrimService) TrimStreamByMD5(ctx context.Context, userID uint, chapterMD5 string, bookMD5 string, bookTitle string, chapterTitle string, rawContent string, promptID uint) (<-chan string, error) {
-	if userID > 0 {
+// TrimStreamByMD5 按章节 MD5 流式精简。
+func (s *TrimService) TrimStreamByMD5(ctx context.Context, userID uint, chapterMD5 string, bookMD5 string, bookTitle string, chapterTitle string, rawContent string, promptID uint, opts TrimStreamOptions) (*TrimStream, error) {
+	rawContent, chars, err := s.md5TrimContent(ctx, chapterMD5, rawContent)
+	if err != nil {
+		return nil, err
+	}
+
+	var charge *trimCharge
+	if userID > 0 && !s.flights.paidBy(trimFlightKey(chapterMD5, promptID), userID) {
 		extra := map[string]string{}
 		prompt, err := s.bookRepo.GetPromptByID(ctx, promptID)
 		if err == nil && prompt != nil {
@@ -50,11 +97,11 @@ func (s *TrimService) TrimStreamByMD5(ctx context.Context, userID uint, chapterM
 		extra["chapter_title"] = chapterTitle
 		extra["book_md5"] = bookMD5
 		extra["chapter_md5"] = chapterMD5
-		charged, err := s.ensureTrimPoints(ctx, userID, promptID, 0, bookMD5, chapterMD5, "chapter_md5", chapterMD5, extra)
+		charge, err = s.ensureTrimPoints(ctx, userID, promptID, 0, bookMD5, chapterMD5, chars, "chapter_md5", chapterMD5, extra)
 		if err != nil {
 			return nil, err
 		}
-		if charged {
+		if charge != nil {
 			logger.Info().Msg("points charged for md5 trim")
 		}
 	}
@@ -70,13 +117,17 @@ func (s *TrimService) TrimStreamByMD5(ctx context.Context, userID uint, chapterM
 				CreatedAt:  time.Now(),
 			})
 		}
-		return s.mockStreaming(cache.TrimContent), nil
+		if charge != nil {
+			s.settleTrim(charge)
+		}
+		return s.cachedStream(ctx, cache, charge != nil, opts), nil
 	}
 
-	return s.trimChapter(ctx, userID, 0, 0, bookMD5, chapterMD5, rawContent, promptID)
+	return s.trimChapter(ctx, userID, 0, 0, bookMD5, chapterMD5, rawContent, promptID, charge, opts)
 }
 
-func (s *TrimService) TrimStreamByChapterID(ctx context.Context, userID uint, bookID uint, chapterID uint, promptID uint) (<-chan string, error) {
+// TrimStreamByChapterID 按章节 ID 流式精简。
+func (s *TrimService) TrimStreamByChapterID(ctx context.Context, userID uint, bookID uint, chapterID uint, promptID uint, opts TrimStreamOptions) (*TrimStream, error) {
 	chap, err := s.bookRepo.GetChapterByID(ctx, chapterID)
 	if err != nil {
 		return nil, errno.ErrChapterNotFound
@@ -91,7 +142,8 @@ func (s *TrimService) TrimStreamByChapterID(ctx context.Context, userID uint, bo
 	}
 	bookMD5 := book.BookMD5
 
-	if userID > 0 {
+	var charge *trimCharge
+	if userID > 0 && !s.flights.paidBy(trimFlightKey(chap.ChapterMD5, promptID), userID) {
 		extra := map[string]string{}
 		extra["book_title"] = book.Title
 		extra["chapter_title"] = chap.Title
@@ -100,11 +152,15 @@ func (s *TrimService) TrimStreamByChapterID(ctx context.Context, userID uint, bo
 		}
 		extra["book_md5"] = bookMD5
 		extra["chapter_md5"] = chap.ChapterMD5
-		charged, err := s.ensureTrimPoints(ctx, userID, promptID, bookID, bookMD5, chap.ChapterMD5, "chapter_id", fmt.Sprintf("%d", chapterID), extra)
+		chars, err := s.chapterChars(ctx, chap.ChapterMD5)
+		if err != nil {
+			return nil, err
+		}
+		charge, err = s.ensureTrimPoints(ctx, userID, promptID, bookID, bookMD5, chap.ChapterMD5, chars, "chapter_id", fmt.Sprintf("%d", chapterID), extra)
 		if err != nil {
 			return nil, err
 		}
-		if charged {
+		if charge != nil {
 			logger.Info().Msg("points charged for chapter trim")
 		}
 	}
@@ -122,19 +178,91 @@ func (s *TrimService) TrimStreamByChapterID(ctx context.Context, userID uint, bo
 				CreatedAt:  time.Now(),
 			})
 		}
-		return s.mockStreaming(cache.TrimContent), nil
+		if charge != nil {
+			s.settleTrim(charge)
+		}
+		return s.cachedStream(ctx, cache, charge != nil, opts), nil
 	}
 
-	return s.trimChapter(ctx, userID, bookID, chapterID, bookMD5, chap.ChapterMD5, "", promptID)
+	return s.trimChapter(ctx, userID, bookID, chapterID, bookMD5, chap.ChapterMD5, "", promptID, charge, opts)
 }
 
-func (s *TrimService) trimChapter(ctx context.Context, userID uint, bookID uint, chapterID uint, bookMD5 string, chapterMD5 string, rawContent string, promptID uint) (<-chan string, error) {
+// trimChapter 流式精简章节。同一章节与提示词已在生成时直接加入，不重复调用模型。
+// 生成在后台进行，客户端断开不影响生成与落库；生成失败时退回 charge 对应的积分。
+func (s *TrimService) trimChapter(ctx context.Context, userID uint, bookID uint, chapterID uint, bookMD5 string, chapterMD5 string, rawContent string, promptID uint, charge *trimCharge, opts TrimStreamOptions) (*TrimStream, error) {
+	key := trimFlightKey(chapterMD5, promptID)
+	flight, genCtx, leader := s.flights.join(ctx, key)
+	if charge != nil && !flight.addPayer(charge) {
+		// 加入时生成恰好结束，失败则自行退款
+		if err := flight.wait(context.Background()); err != nil {
+			s.refundTrim(charge, err)
+		} else {
+			s.settleTrim(charge)
+		}
+	}
+	if leader {
+		// 查询缓存与加入之间上一次生成可能恰好落库并移出分组，以缓存结束本次，不重复生成
+		if cache, err := s.bookRepo.GetTrimResult(ctx, chapterMD5, promptID); err == nil && cache != nil {
+			flight.publish(cache.TrimContent)
+			flight.setResult(cache)
+			s.finishTrim(key, flight, nil)
+		} else if err := s.startTrimFlight(genCtx, key, flight, userID, bookID, chapterID, bookMD5, chapterMD5, rawContent, promptID); err != nil {
+			return nil, err
+		}
+	} else if err := flight.waitStarted(ctx); err != nil {
+		return nil, err
+	}
+
+	if userID > 0 {
+		go func() {
+			if flight.wait(context.Background()) != nil {
+				return
+			}
+			// 记录用户处理记录
+			if err := s.bookRepo.RecordUserTrim(context.Background(), &model.UserProcessedChapter{
+				UserID:     userID,
+				BookID:     bookID,
+				ChapterID:  chapterID,
+				PromptID:   promptID,
+				BookMD5:    bookMD5,
+				ChapterMD5: chapterMD5,
+			}); err != nil {
+				logger.Error().Err(err).Msg("failed to record user trim")
+			}
+		}()
+	}
+	return &TrimStream{
+		C:          flight.subscribe(ctx, opts.ResumeFrom),
+		ChapterMD5: chapterMD5,
+		PromptID:   promptID,
+		Charged:    charge != nil,
+		ResumeFrom: max(opts.ResumeFrom, 0),
+		err:        func() error { return flight.result(ctx) },
+		result:     flight.trimResult,
+	}, nil
+}
+
+// startTrimFlight 建立模型流并在后台生成，输出发布到 flight，结束后落库。
+func (s *TrimService) startTrimFlight(ctx context.Context, key string, flight *trimFlight, userID uint, bookID uint, chapterID uint, bookMD5 string, chapterMD5 string, rawContent string, promptID uint) (err error) {
+	// 结束时删除中间输出：成功后已有完整结果，失败时客户端丢弃已推送的部分
+	end := func(err error) {
+		if err := s.bookRepo.DeleteTrimPartials(context.Background(), chapterMD5, promptID); err != nil {
+			logger.Warn().Err(err).Str("chapter_md5", chapterMD5).Msg("failed to delete trim partials")
+		}
+		s.finishTrim(key, flight, err)
+	}
+	defer func() {
+		if err != nil {
+			end(err)
+		}
+	}()
+
 	content := ""
 
 	if chapterID > 0 {
 		raw, err := s.bookRepo.GetRawContent(ctx, chapterMD5)
-		if err != nil {
-			return nil, errno.ErrChapterNotFound
+		if err != nil || raw == nil {
+			return errno.ErrChapterNotFound
 		}
 		content = raw.Content
 	} else {
@@ -143,37 +271,73 @@ func (s *TrimService) trimChapter(ctx context.Context, userID uint, bookID uint,
 
 	prompt, err := s.bookRepo.GetPromptByID(ctx, promptID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	systemPrompt := s.buildSystemPrompt(prompt, content)
+	var memory *memoryContext
+	if s.MemoryEnabled() {
+		memory = s.loadMemoryContext(ctx, userID, bookID, chapterID, bookMD5, chapterMD5)
+	}
+	systemPrompt := s.buildSystemPrompt(prompt, content, memory)
 
-	llmResp, err := s.llmService.LlmWithStream(ctx, systemPrompt.systemPrompt, content)
+	// 服务重启前未完成的生成从已持久化的输出处续写
+	partial, seq, err := s.bookRepo.GetTrimPartial(ctx, chapterMD5, promptID)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	userPrompt := content
+	if partial != "" {
+		userPrompt = content + trimContinuePrompt + partial
 	}
 
-	// 创建流式响应 channel
-	ch := make(chan string)
-	go func() {
-		defer close(ch)
+	llmResp, err := s.llmService.LlmWithStream(ctx, promptID, systemPrompt.systemPrompt, userPrompt)
+	if err != nil {
+		return err
+	}
+
+	var fullContent strings.Builder
+	// 精简+摘要模式下只向客户端推送 <content> 内的正文
+	var parser *taggedStreamParser
+	if memory != nil {
+		parser = &taggedStreamParser{}
+	}
+	// 已持久化的部分先发布，重连的客户端按相同的字符偏移继续接收
+	fullContent.WriteString(partial)
+	if parser != nil {
+		flight.publish(parser.Feed(partial))
+	} else {
+		flight.publish(partial)
+	}
+	writer := &trimPartialWriter{repo: s.bookRepo, flight: flight, chapterMD5: chapterMD5, promptID: promptID, seq: seq, flushedAt: time.Now()}
+	flight.markStarted()
 
-		var fullContent strings.Builder
+	go func() {
+		defer llmResp.Close()
 
 		t := time.Now()
+		var streamErr error
+		var finishReason openai.FinishReason
 		// 流式读取 LLM 响应
 		for {
 			resp, err := llmResp.Stream.Recv()
 			if err != nil {
-				// 流结束或出错
+				// io.EOF 为正常结束，其余为中途出错
+				streamErr = err
 				break
 			}
 
 			if len(resp.Choices) > 0 {
-				content := resp.Choices[0].Delta.Content
-				if content != "" {
-					fullContent.WriteString(content)
-					ch <- content
+				if resp.Choices[0].FinishReason != "" {
+					finishReason = resp.Choices[0].FinishReason
+				}
+				raw := resp.Choices[0].Delta.Content
+				if raw != "" {
+					fullContent.WriteString(raw)
+					content := raw
+					if parser != nil {
+						content = parser.Feed(raw)
+					}
+					writer.write(raw, content)
 				}
 			}
 
@@ -188,58 +352,175 @@ func (s *TrimService) trimChapter(ctx context.Context, userID uint, bookID uint,
 				llmResp.TotalCost = llmResp.InputCost + llmResp.OutputCost
 			}
 		}
+		writer.flush()
+		// 输出不完整时不缓存，已推送的部分由客户端丢弃，积分退回
+		if err := checkFinish(streamErr, finishReason); err != nil {
+			logger.Warn().Err(streamErr).Str("finish_reason", string(finishReason)).Str("chapter_md5", chapterMD5).
+				Int("received", fullContent.Len()).Msg("trim stream incomplete")
+			end(err)
+			return
+		}
+		if parser != nil {
+			flight.publish(parser.Flush())
+		}
 
 		trimmedContent := fullContent.String()
-		if trimmedContent != "" {
-			// 计算字数和压缩率
-			trimWords := len([]rune(trimmedContent))
-			rawWords := len([]rune(content))
-			trimRate := ((float64(trimWords)/float64(rawWords))*10000 + 0.5) / 100.0
-			takeTime := time.Since(t).Seconds()
-
-			// 保存处理结果到缓存
-			trimResult := &model.TrimResult{
-				ChapterMD5:       chapterMD5,
-				PromptID:         promptID,
-				TrimContent:      trimmedContent,
-				TrimContentWords: trimWords,
-				WordsRange:       systemPrompt.WordsRange,
-				TrimRate:         trimRate,
-				TargetRateRange:  systemPrompt.TargetRateRange,
-				TotalCost:        llmResp.TotalCost,
-				InputCost:        llmResp.InputCost,
-				OutputCost:       llmResp.OutputCost,
-				TotalTokens:      llmResp.TotalTokens,
-				PromptTokens:     llmResp.PromptTokens,
-				CompletionTokens: llmResp.CompletionTokens,
-				TakeTime:         takeTime,
-				LlmName:          llmResp.LlmName,
-			}
+		summary := ""
+		if memory != nil {
+			trimmedContent, summary = parseTrimOutput(trimmedContent)
+		}
+		if trimmedContent == "" {
+			end(errno.ErrTrimIncomplete)
+			return
+		}
 
-			if err := s.bookRepo.SaveTrimResult(context.Background(), trimResult); err != nil {
-				logger.Error().Err(err).Msg("failed to save trim result")
-				return
-			}
+		// 计算字数和压缩率
+		trimWords := len([]rune(trimmedContent))
+		rawWords := len([]rune(content))
+		trimRate := ((float64(trimWords)/float64(rawWords))*10000 + 0.5) / 100.0
+		takeTime := time.Since(t).Seconds()
+
+		// 保存处理结果到缓存
+		trimResult := &model.TrimResult{
+			ChapterMD5:       chapterMD5,
+			PromptID:         promptID,
+			TrimContent:      trimmedContent,
+			TrimContentWords: trimWords,
+			WordsRange:       systemPrompt.WordsRange,
+			TrimRate:         trimRate,
+			TargetRateRange:  systemPrompt.TargetRateRange,
+			TotalCost:        llmResp.TotalCost,
+			InputCost:        llmResp.InputCost,
+			OutputCost:       llmResp.OutputCost,
+			TotalTokens:      llmResp.TotalTokens,
+			PromptTokens:     llmResp.PromptTokens,
+			CompletionTokens: llmResp.CompletionTokens,
+			TakeTime:         takeTime,
+			LlmName:          llmResp.LlmName,
 		}
 
-		// 记录用户处理记录
-		if userID > 0 {
-			if err := s.bookRepo.RecordUserTrim(context.Background(), &model.UserProcessedChapter{
-				UserID:     userID,
-				BookID:     bookID,
-				ChapterID:  chapterID,
-				PromptID:   promptID,
-				BookMD5:    bookMD5,
-				ChapterMD5: chapterMD5,
-			}); err != nil {
+		if err := s.bookRepo.SaveTrimResult(context.Background(), trimResult); err != nil {
+			logger.Error().Err(err).Msg("failed to save trim result")
+			end(err)
+			return
+		}
+		s.saveSummary(context.Background(), chapterMD5, promptID, summary, memory)
+		flight.setResult(trimResult)
+		end(nil)
+	}()
 
-				logger.Error().Err(err).Msg("failed to record user trim")
-				return
+	return nil
+}
+
+// memoryContext 精简+摘要模式下注入提示词的上下文。
+type memoryContext struct {
+	Summaries            string
+	Encyclopedia         string
+	SummaryPromptContent string
+
+	located      bool // 是否定位到所属书籍与章节序号
+	bookID       uint
+	chapterIndex int
+}
+
+// summaryLimit 精简时注入的前情提要章节数。
+func (s *TrimService) summaryLimit() int {
+	if s.memory == nil || s.memory.SummaryLimit <= 0 {
+		return defaultSummaryLimit
+	}
+	return s.memory.SummaryLimit
+}
+
+// loadMemoryContext 加载当前章节之前 N 章的摘要与书籍百科作为上下文。
+// 按 MD5 精简时通过用户书籍定位章节顺序，定位失败时不注入上下文。
+func (s *TrimService) loadMemoryContext(ctx context.Context, userID uint, bookID uint, chapterID uint, bookMD5 string, chapterMD5 string) *memoryContext {
+	memory := &memoryContext{Encyclopedia: "（无）", SummaryPromptContent: defaultSummaryPromptContent}
+	if p, err := s.bookRepo.GetSummaryPrompt(ctx); err == nil && p.PromptContent != "" {
+		memory.SummaryPromptContent = p.PromptContent
+	}
+
+	limit := s.summaryLimit()
+
+	var previous []model.Chapter
+	if chapterID > 0 {
+		chapter, err := s.bookRepo.GetChapterByID(ctx, chapterID)
+		if err == nil {
+			memory.located, memory.bookID, memory.chapterIndex = true, chapter.BookID, chapter.Index
+			from := max(0, chapter.Index-limit)
+			indexes := make([]int, 0, chapter.Index-from)
+			for i := from; i < chapter.Index; i++ {
+				indexes = append(indexes, i)
+			}
+			if len(indexes) > 0 {
+				previous, _ = s.bookRepo.GetChaptersByBookIDAndIndexes(ctx, chapter.BookID, indexes)
 			}
 		}
-	}()
+	} else if userID > 0 && bookMD5 != "" {
+		if book, err := s.bookRepo.GetBookByMD5(ctx, userID, bookMD5); err == nil && book != nil {
+			chapters, _ := s.bookRepo.GetChaptersByBookID(ctx, book.ID)
+			for i, c := range chapters {
+				if c.ChapterMD5 == chapterMD5 {
+					memory.located, memory.bookID, memory.chapterIndex = true, book.ID, c.Index
+					previous = chapters[max(0, i-limit):i]
+					break
+				}
+			}
+		}
+	}
+
+	memory.Summaries = s.formatSummaries(ctx, previous)
+	if memory.located {
+		memory.Encyclopedia = s.encyclopedia.PromptText(ctx, memory.bookID, memory.chapterIndex)
+	}
+	return memory
+}
+
+// formatSummaries 按章节顺序拼接摘要，缺失摘要的章节跳过。
+func (s *TrimService) formatSummaries(ctx context.Context, chapters []model.Chapter) string {
+	if len(chapters) == 0 {
+		return "（无）"
+	}
+	md5s := make([]string, 0, len(chapters))
+	for _, c := range chapters {
+		md5s = append(md5s, c.ChapterMD5)
+	}
+	summaries, err := s.memoryRepo.GetChapterSummaries(ctx, md5s)
+	if err != nil {
+		logger.Warn().Err(err).Msg("加载章节摘要失败")
+		return "（无）"
+	}
+
+	var buf strings.Builder
+	for _, c := range chapters {
+		summary, ok := summaries[c.ChapterMD5]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&buf, "【%s】%s\n", c.Title, summary.Summary)
+	}
+	if buf.Len() == 0 {
+		return "（无）"
+	}
+	return buf.String()
+}
 
-	return ch, nil
+// saveSummary 保存章节摘要，并在到达百科更新间隔时触发百科更新。
+func (s *TrimService) saveSummary(ctx context.Context, chapterMD5 string, promptID uint, summary string, memory *memoryContext) {
+	if summary == "" {
+		return
+	}
+	if err := s.memoryRepo.SaveChapterSummary(ctx, &model.ChapterSummary{
+		ChapterMD5:   chapterMD5,
+		PromptID:     promptID,
+		Summary:      summary,
+		SummaryWords: len([]rune(summary)),
+	}); err != nil {
+		logger.Error().Err(err).Str("chapter_md5", chapterMD5).Msg("failed to save chapter summary")
+		return
+	}
+	if memory != nil && memory.located {
+		s.encyclopedia.OnChapterSummarized(memory.bookID, memory.chapterIndex)
+	}
 }
 
 type systemPromptData struct {
@@ -248,25 +529,42 @@ type systemPromptData struct {
 	TargetRateRange string
 }
 
-func (s *TrimService) buildSystemPrompt(prompt *model.Prompt, rawContent string) systemPromptData {
+// buildSystemPrompt 渲染系统提示词，memory 非空时使用精简+摘要模板。
+func (s *TrimService) buildSystemPrompt(prompt *model.Prompt, rawContent string, memory *memoryContext) systemPromptData {
 	rawLen := len([]rune(rawContent))
 	minWords := int(float64(rawLen) * prompt.TargetRatioMin)
 	maxWords := int(float64(rawLen) * prompt.TargetRatioMax)
+	rateRange := fmt.Sprintf("%d-%d", int(prompt.TargetRatioMin*100), int(prompt.TargetRatioMax*100))
 
 	data := struct {
-		ModeName        string
-		WordsRange      string
-		TargetRateRange string
-		PromptContent   string
+		ModeName                string
+		WordsRange              string
+		TargetRateRange         string
+		TargetResidualRateRange string
+		PromptContent           string
+		Encyclopedia            string
+		Summaries               string
+		SummaryPromptContent    string
 	}{
-		ModeName:        prompt.Name,
-		WordsRange:      fmt.Sprintf("%d-%d", minWords, maxWords),
-		TargetRateRange: fmt.Sprintf("%d-%d%%", int(prompt.TargetRatioMin*100), int(prompt.TargetRatioMax*100)),
-		PromptContent:   prompt.PromptContent,
+		ModeName:                prompt.Name,
+		WordsRange:              fmt.Sprintf("%d-%d", minWords, maxWords),
+		TargetRateRange:         rateRange + "%",
+		TargetResidualRateRange: rateRange,
+		PromptContent:           prompt.PromptContent,
+	}
+
+	name := trimTemplate
+	if memory != nil {
+		name = trimAndSummaryTemplate
+		data.Encyclopedia = memory.Encyclopedia
+		data.Summaries = memory.Summaries
+		data.SummaryPromptContent = memory.SummaryPromptContent
 	}
 
 	var buf bytes.Buffer
-	_ = s.tmpl.Execute(&buf, data)
+	if err := s.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		logger.Error().Err(err).Str("template", name).Msg("failed to render trim prompt")
+	}
 	return systemPromptData{
 		systemPrompt:    buf.String(),
 		WordsRange:      data.WordsRange,
@@ -274,36 +572,163 @@ func (s *TrimService) buildSystemPrompt(prompt *model.Prompt, rawContent string)
 	}
 }
 
-func (s *TrimService) mockStreaming(content string) <-chan string {
+// cachedStream 推送缓存的精简结果。默认按流式分段推送以保持阅读体验，Instant 时一次性返回。
+func (s *TrimService) cachedStream(ctx context.Context, cache *model.TrimResult, charged bool, opts TrimStreamOptions) *TrimStream {
+	return &TrimStream{
+		C:          s.mockStreaming(ctx, cache.TrimContent, opts),
+		ChapterMD5: cache.ChapterMD5,
+		PromptID:   cache.PromptID,
+		Cached:     true,
+		Charged:    charged,
+		ResumeFrom: max(opts.ResumeFrom, 0),
+		result:     func() *model.TrimResult { return cache },
+	}
+}
+
+// mockStreaming 把缓存的精简结果按流式分段推送，从第 ResumeFrom 个字符开始，ctx 结束时停止。
+func (s *TrimService) mockStreaming(ctx context.Context, content string, opts TrimStreamOptions) <-chan string {
 	ch := make(chan string)
 	contentRune := []rune(content)
+	step := 10
+	if opts.Instant {
+		step = len(contentRune)
+	}
 	go func() {
 		defer close(ch)
-		for i := 0; i < len([]rune(content)); i += 10 {
-			end := i + 10
+		for i := min(max(opts.ResumeFrom, 0), len(contentRune)); i < len(contentRune); i += step {
+			end := i + step
 			if end > len(contentRune) {
 				end = len(contentRune)
 			}
-			ch <- string(contentRune[i:end])
-			time.Sleep(time.Millisecond * 100)
+			select {
+			case ch <- string(contentRune[i:end]):
+			case <-ctx.Done():
+				return
+			}
+			if !opts.Instant {
+				time.Sleep(time.Millisecond * 100)
+			}
 		}
 	}()
 	return ch
 }
 
-// ensureTrimPoints 校验并扣除积分，返回是否扣费。
-func (s *TrimService) ensureTrimPoints(ctx context.Context, userID uint, promptID uint, bookID uint, bookMD5 string, chapterMD5 string, refType, refID string, extra map[string]string) (bool, error) {
+// ensureTrimPoints 优先占用订阅额度，额度不足时按章节字数计价并预占积分，返回扣费记录，未扣费时为 nil。
+// 额度与预占在生成成功后结算，失败时释放。
+func (s *TrimService) ensureTrimPoints(ctx context.Context, userID uint, promptID uint, bookID uint, bookMD5 string, chapterMD5 string, chars int, refType, refID string, extra map[string]string) (*trimCharge, error) {
 	handled, err := s.bookRepo.HasUserProcessedChapter(ctx, userID, promptID, bookID, bookMD5, chapterMD5)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	if handled {
-		return false, nil
+		return nil, nil
+	}
+	// 同一用户对同一章节的并发或重试请求只占用一次额度或预占一次积分
+	key := fmt.Sprintf("trim:%d:%d:%s:%s", userID, promptID, bookMD5, chapterMD5)
+	usageIDs, err := s.subscriptions.ReserveTrim(ctx, userID, promptID, []QuotaInput{{
+		RefType:        refType,
+		RefID:          refID,
+		IdempotencyKey: key,
+	}}, trimStreamHoldTTL)
+	if err != nil {
+		return nil, err
+	}
+	if usageIDs[0] != 0 {
+		return &trimCharge{userID: userID, usageID: usageIDs[0], refType: refType, refID: refID}, nil
+	}
+
+	price := s.pointsService.PriceTrim(promptID, chars)
+	holdIDs, err := s.pointsService.HoldForTrim(ctx, userID, []PointsChangeInput{{
+		Amount:         price.Points,
+		RefType:        refType,
+		RefID:          refID,
+		Extra:          price.Extra(extra),
+		IdempotencyKey: key,
+	}}, trimStreamHoldTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &trimCharge{userID: userID, holdID: holdIDs[0], refType: refType, refID: refID}, nil
+}
+
+// md5TrimContent 校验客户端上传的章节原文，返回用于精简的原文及计价字数。
+// 已存储的章节按存储的字数计价，原文与 MD5 不符时改用存储的原文，避免少付积分或以错误内容污染共享缓存；
+// 未存储的章节要求原文与 MD5 一致。
+func (s *TrimService) md5TrimContent(ctx context.Context, chapterMD5 string, rawContent string) (string, int, error) {
+	metas, err := s.bookRepo.GetContentMetasByMD5s(ctx, []string{chapterMD5})
+	if err != nil {
+		return "", 0, err
+	}
+	matched := textHash(rawContent) == chapterMD5
+	meta, stored := metas[chapterMD5]
+	if !stored {
+		if !matched {
+			return "", 0, errno.ErrParam
+		}
+		return rawContent, utf8.RuneCountInString(rawContent), nil
+	}
+	if !matched {
+		raw, err := s.bookRepo.GetRawContent(ctx, chapterMD5)
+		if err != nil {
+			return "", 0, err
+		}
+		if raw == nil {
+			return "", 0, errno.ErrChapterNotFound
+		}
+		rawContent = raw.Content
+	}
+	return rawContent, meta.WordsCount, nil
+}
+
+// chapterChars 获取章节原文字数，用于计价。查询失败时返回错误，避免按最低价少扣积分。
+func (s *TrimService) chapterChars(ctx context.Context, chapterMD5 string) (int, error) {
+	metas, err := s.bookRepo.GetContentMetasByMD5s(ctx, []string{chapterMD5})
+	if err != nil {
+		logger.Error().Err(err).Str("chapter_md5", chapterMD5).Msg("failed to load chapter words count")
+		return 0, err
+	}
+	return metas[chapterMD5].WordsCount, nil
+}
+
+// settleTrim 精简成功后结算占用的额度或预占的积分。
+func (s *TrimService) settleTrim(charge *trimCharge) {
+	if charge.usageID != 0 {
+		if err := s.subscriptions.SettleUsage(context.Background(), charge.usageID); err != nil {
+			logger.Error().Err(err).Uint("user_id", charge.userID).Uint("usage_id", charge.usageID).Msg("failed to settle trim quota")
+		}
+		return
+	}
+	if err := s.pointsService.SettleHold(context.Background(), charge.holdID); err != nil {
+		logger.Error().Err(err).Uint("user_id", charge.userID).Uint("hold_id", charge.holdID).Msg("failed to settle trim points")
 	}
-	if err := s.pointsService.SpendForTrim(ctx, userID, 1, refType, refID, extra); err != nil {
-		return false, err
+}
+
+// refundTrim 生成失败时退回占用的额度或释放预占的积分。
+func (s *TrimService) refundTrim(charge *trimCharge, cause error) {
+	if charge.usageID != 0 {
+		if err := s.subscriptions.ReleaseUsage(context.Background(), charge.usageID); err != nil {
+			logger.Error().Err(err).Uint("user_id", charge.userID).Str("ref_id", charge.refID).Msg("failed to release trim quota")
+			return
+		}
+		logger.Info().Err(cause).Uint("user_id", charge.userID).Str("ref_id", charge.refID).Msg("trim quota released")
+		return
+	}
+	if err := s.pointsService.ReleaseHold(context.Background(), charge.holdID); err != nil {
+		logger.Error().Err(err).Uint("user_id", charge.userID).Str("ref_id", charge.refID).Msg("failed to refund trim points")
+		return
+	}
+	logger.Info().Err(cause).Uint("user_id", charge.userID).Str("ref_id", charge.refID).Msg("trim points refunded")
+}
+
+// finishTrim 结束进行中的精简，成功时结算所有付费用户的预占，失败时全部释放。
+func (s *TrimService) finishTrim(key string, flight *trimFlight, err error) {
+	for _, charge := range s.flights.finish(key, flight, err) {
+		if err != nil {
+			s.refundTrim(charge, err)
+		} else {
+			s.settleTrim(charge)
+		}
 	}
-	return true, nil
 }
 
 func (s *TrimService) TrimChatByChapterID(ctx context.Context, userID uint, chapterID uint, promptID uint) error {
@@ -323,27 +748,76 @@ func (s *TrimService) TrimChatByChapterID(ctx context.Context, userID uint, chap
 		return nil
 	}
 
-	prompt, err := s.bookRepo.GetPromptByID(ctx, promptID)
+	// 同一章节正在生成时等待其结果，不重复调用模型
+	key := trimFlightKey(chapter.ChapterMD5, promptID)
+	flight, _, leader := s.flights.join(ctx, key)
+	if !leader {
+		if err := flight.wait(ctx); err != nil {
+			return err
+		}
+		return s.recordChapterTrim(ctx, userID, chapter, promptID)
+	}
+	// 同一章节的流式请求可以订阅本次生成，结果在生成结束时一次性推送
+	flight.markStarted()
+	if cache, err := s.bookRepo.GetTrimResult(ctx, chapter.ChapterMD5, promptID); err == nil && cache != nil {
+		flight.publish(cache.TrimContent)
+		flight.setResult(cache)
+		s.finishTrim(key, flight, nil)
+		return s.recordChapterTrim(ctx, userID, chapter, promptID)
+	}
+	// 后台任务停止时随任务取消，不使用与请求解绑的 context
+	trimResult, err := s.trimChat(ctx, userID, chapter, chapterID, promptID)
 	if err != nil {
+		s.finishTrim(key, flight, err)
 		return err
 	}
+	flight.publish(trimResult.TrimContent)
+	flight.setResult(trimResult)
+	s.finishTrim(key, flight, nil)
+
+	return s.recordChapterTrim(ctx, userID, chapter, promptID)
+}
+
+// trimChat 非流式精简章节并落库。
+func (s *TrimService) trimChat(ctx context.Context, userID uint, chapter *model.Chapter, chapterID uint, promptID uint) (*model.TrimResult, error) {
+	prompt, err := s.bookRepo.GetPromptByID(ctx, promptID)
+	if err != nil {
+		return nil, err
+	}
 
 	rawContent, err := s.bookRepo.GetRawContent(ctx, chapter.ChapterMD5)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	systemPrompt := s.buildSystemPrompt(prompt, rawContent.Content)
+	var memory *memoryContext
+	if s.MemoryEnabled() {
+		memory = s.loadMemoryContext(ctx, userID, chapter.BookID, chapterID, "", chapter.ChapterMD5)
+	}
+	systemPrompt := s.buildSystemPrompt(prompt, rawContent.Content, memory)
 
 	t := time.Now()
-	llmResp, err := s.llmService.Llm(ctx, systemPrompt.systemPrompt, rawContent.Content)
+	llmResp, err := s.llmService.Llm(ctx, promptID, systemPrompt.systemPrompt, rawContent.Content)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	takeTime := time.Since(t)
 
+	if len(llmResp.Resp.Choices) == 0 {
+		return nil, errno.ErrTrimIncomplete
+	}
+	if err := checkFinish(nil, llmResp.Resp.Choices[0].FinishReason); err != nil {
+		return nil, err
+	}
 	trimContent := llmResp.Resp.Choices[0].Message.Content
+	summary := ""
+	if memory != nil {
+		trimContent, summary = parseTrimOutput(trimContent)
+	}
+	if trimContent == "" {
+		return nil, fmt.Errorf("empty trim output")
+	}
 	trimContentWords := len([]rune(trimContent))
 	rawContentWords := len([]rune(rawContent.Content))
 	// 保留两位小数 百分比
@@ -369,10 +843,14 @@ func (s *TrimService) TrimChatByChapterID(ctx context.Context, userID uint, chap
 
 	if err := s.bookRepo.SaveTrimResult(context.Background(), trimResult); err != nil {
 		logger.Error().Err(err).Msg("failed to save trim result")
-		return err
+		return nil, err
 	}
+	s.saveSummary(context.Background(), chapter.ChapterMD5, promptID, summary, memory)
+	return trimResult, nil
+}
 
-	// 记录用户处理记录
+// recordChapterTrim 记录用户处理记录。
+func (s *TrimService) recordChapterTrim(ctx context.Context, userID uint, chapter *model.Chapter, promptID uint) error {
 	book, err := s.bookRepo.GetBookByID(ctx, chapter.BookID)
 	if err != nil {
 		return err
@@ -384,7 +862,7 @@ func (s *TrimService) TrimChatByChapterID(ctx context.Context, userID uint, chap
 	if err := s.bookRepo.RecordUserTrim(context.Background(), &model.UserProcessedChapter{
 		UserID:     userID,
 		BookID:     chapter.BookID,
-		ChapterID:  chapterID,
+		ChapterID:  chapter.ID,
 		PromptID:   promptID,
 		BookMD5:    bookMD5,
 		ChapterMD5: chapter.ChapterMD5,
@@ -396,8 +874,45 @@ func (s *TrimService) TrimChatByChapterID(ctx context.Context, userID uint, chap
 	return nil
 }
 
+// TrimStreamOptions 流式精简选项。
+type TrimStreamOptions struct {
+	ResumeFrom int  // 断线前已收到的字符数，重连时从该位置继续推送
+	Instant    bool // 命中缓存时一次性返回全文，不模拟逐字输出
+}
+
+// TrimStream 流式精简的输出。C 关闭后调用 Err 获取结果，nil 表示正常完成。
+type TrimStream struct {
+	C          <-chan string
+	ChapterMD5 string
+	PromptID   uint
+	Cached     bool // 是否命中缓存
+	Charged    bool // 本次请求是否扣除了积分
+	ResumeFrom int
+
+	err    func() error
+	result func() *model.TrimResult
+}
+
+// Err 返回流结束的原因。
+func (t *TrimStream) Err() error {
+	if t.err == nil {
+		return nil
+	}
+	return t.err()
+}
+
+// Result 返回精简结果，正常完成后可用。
+func (t *TrimStream) Result() *model.TrimResult {
+	if t.result == nil {
+		return nil
+	}
+	return t.result()
+}
+
 type TrimServiceInterface interface {
-	TrimStreamByMD5(ctx context.Context, userID uint, chapterMD5 string, bookMD5 string, bookTitle string, chapterTitle string, rawContent string, promptID uint) (<-chan string, error)
-	TrimStreamByChapterID(ctx context.Context, userID uint, bookID uint, chapterID uint, promptID uint) (<-chan string, error)
+	TrimStreamByMD5(ctx context.Context, userID uint, chapterMD5 string, bookMD5 string, bookTitle string, chapterTitle string, rawContent string, promptID uint, opts TrimStreamOptions) (*TrimStream, error)
+	TrimStreamByChapterID(ctx context.Context, userID uint, bookID uint, chapterID uint, promptID uint, opts TrimStreamOptions) (*TrimStream, error)
+	EstimateTrim(ctx context.Context, userID uint, req *TrimEstimateReq) (*TrimEstimate, error)
 	TrimChatByChapterID(ctx context.Context, userID uint, chapterID uint, promptID uint) error
+	MemoryEnabled() bool
 }
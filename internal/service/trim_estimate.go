@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"math"
+	"unicode"
+
+	"github.com/zqr233qr/story-trim/internal/errno"
+	"github.com/zqr233qr/story-trim/internal/model"
+)
+
+const (
+	// cjkTokensPerChar 中日韩文字每字约合的 token 数，主流中文模型的分词器约为 0.6。
+	cjkTokensPerChar = 0.6
+	// asciiCharsPerToken 英文、数字等字符约每 4 个合 1 个 token。
+	asciiCharsPerToken = 4.0
+
+	// 精简+摘要模式下每章额外注入的前情提要与百科字数，以及额外输出的摘要字数。
+	memoryInputCharsPerSummary = 250
+	memoryEncyclopediaChars    = 1000
+	memoryOutputChars          = 250
+)
+
+// EstimateTokens 在本地近似估算文本的 token 数，不调用供应商的分词器。
+func EstimateTokens(text string) int {
+	var cjk, ascii, other int
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			cjk++
+		case unicode.IsSpace(r):
+		case r < unicode.MaxASCII:
+			ascii++
+		default:
+			// 全角标点等按 1 个 token 计
+			other++
+		}
+	}
+	return estimateTokensByCount(cjk, ascii, other)
+}
+
+func estimateTokensByCount(cjk, ascii, other int) int {
+	return int(math.Ceil(float64(cjk)*cjkTokensPerChar + float64(ascii)/asciiCharsPerToken + float64(other)))
+}
+
+// estimateCharsTokens 按中文正文估算字数对应的 token 数，用于只知道字数的章节。
+func estimateCharsTokens(chars int) int {
+	return estimateTokensByCount(chars, 0, 0)
+}
+
+// TrimEstimateReq 精简费用估算请求。
+type TrimEstimateReq struct {
+	BookID     uint   `json:"book_id" binding:"required"`
+	ChapterIDs []uint `json:"chapter_ids"` // 为空时估算全书
+	PromptIDs  []uint `json:"prompt_ids" binding:"required,min=1"`
+}
+
+// TrimEstimate 精简费用估算结果。
+type TrimEstimate struct {
+	BookID   uint                 `json:"book_id"`
+	Chapters int                  `json:"chapters"`
+	Balance  int                  `json:"balance"`
+	Prompts  []TrimPromptEstimate `json:"prompts"`
+}
+
+// TrimPromptEstimate 单个提示词的估算。已有精简结果的章节直接复用，不计 token 与成本。
+// 积分与提交任务时一致：跳过已拥有和任务处理中的章节，按章节顺序优先占用订阅额度。
+type TrimPromptEstimate struct {
+	PromptID           uint        `json:"prompt_id"`
+	PromptName         string      `json:"prompt_name"`
+	CachedChapters     int         `json:"cached_chapters"`     // 已有精简结果，无需调用模型
+	OwnedChapters      int         `json:"owned_chapters"`      // 用户已拥有精简结果，不扣积分
+	ProcessingChapters int         `json:"processing_chapters"` // 已在任务中处理，不重复计费
+	QuotaChapters      int         `json:"quota_chapters"`      // 由订阅额度抵扣，不扣积分
+	InputChars         int         `json:"input_chars"`         // 需调用模型的章节原文字数
+	InputTokens        int         `json:"input_tokens"`
+	OutputTokens       int         `json:"output_tokens"`
+	Cost               float64     `json:"cost"` // 分
+	Points             int         `json:"points"`
+	Pricing            *LlmPricing `json:"pricing,omitempty"`
+}
+
+// EstimateTrim 估算精简指定章节（或全书）在各提示词下的 token、成本与需扣除的积分。
+func (s *TrimService) EstimateTrim(ctx context.Context, userID uint, req *TrimEstimateReq) (*TrimEstimate, error) {
+	book, err := s.bookRepo.GetBookByIDWithUser(ctx, userID, req.BookID)
+	if err != nil {
+		return nil, err
+	}
+	if book == nil {
+		return nil, errno.ErrBookNotFound
+	}
+
+	var chapters []model.Chapter
+	if len(req.ChapterIDs) > 0 {
+		ids := make(map[uint]struct{}, len(req.ChapterIDs))
+		for _, id := range req.ChapterIDs {
+			ids[id] = struct{}{}
+		}
+		chapters, err = s.bookRepo.GetChaptersByIDs(ctx, req.ChapterIDs)
+		if err == nil && len(chapters) != len(ids) {
+			return nil, errno.ErrChapterNotFound
+		}
+	} else {
+		chapters, err = s.bookRepo.GetChaptersByBookID(ctx, book.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	md5s := make([]string, 0, len(chapters))
+	for _, chap := range chapters {
+		if chap.BookID != book.ID {
+			return nil, errno.ErrChapterNotFound
+		}
+		md5s = append(md5s, chap.ChapterMD5)
+	}
+	metas, err := s.bookRepo.GetContentMetasByMD5s(ctx, md5s)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := s.pointsService.GetBalance(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	estimate := &TrimEstimate{BookID: book.ID, Chapters: len(chapters), Balance: balance}
+	for _, promptID := range req.PromptIDs {
+		prompt, err := s.bookRepo.GetPromptByID(ctx, promptID)
+		if err != nil {
+			return nil, errno.ErrTrimInvalid
+		}
+		cached, err := s.bookRepo.GetTrimResultsByMD5s(ctx, md5s, promptID)
+		if err != nil {
+			return nil, err
+		}
+		owned, err := s.bookRepo.GetProcessedChapterMD5s(ctx, userID, promptID, book.ID, book.BookMD5, md5s)
+		if err != nil {
+			return nil, err
+		}
+		ownedSet := make(map[string]struct{}, len(owned))
+		for _, md5 := range owned {
+			ownedSet[md5] = struct{}{}
+		}
+		processingIDs, err := s.taskItemRepo.GetProcessingChapterIDs(ctx, userID, book.ID, promptID)
+		if err != nil {
+			return nil, err
+		}
+		processingSet := make(map[uint]struct{}, len(processingIDs))
+		for _, id := range processingIDs {
+			processingSet[id] = struct{}{}
+		}
+
+		e, prices := s.estimatePrompt(prompt, chapters, metas, cached, ownedSet, processingSet)
+		covered, err := s.subscriptions.CoverableChapters(ctx, userID, promptID, len(prices))
+		if err != nil {
+			return nil, err
+		}
+		e.QuotaChapters = covered
+		e.Points = pointsCost(prices[covered:])
+		estimate.Prompts = append(estimate.Prompts, e)
+	}
+	return estimate, nil
+}
+
+// estimatePrompt 估算单个提示词下需要调用模型的章节的 token 与成本，并按章节顺序返回需计费章节的报价。
+func (s *TrimService) estimatePrompt(prompt *model.Prompt, chapters []model.Chapter, metas map[string]model.ChapterContent, cached map[string]*model.TrimResult, owned map[string]struct{}, processing map[uint]struct{}) (TrimPromptEstimate, []TrimPrice) {
+	e := TrimPromptEstimate{PromptID: prompt.ID, PromptName: prompt.Name}
+	prices := make([]TrimPrice, 0, len(chapters))
+	for _, chap := range chapters {
+		if _, ok := owned[chap.ChapterMD5]; ok {
+			e.OwnedChapters++
+			continue
+		}
+		if _, ok := processing[chap.ID]; ok {
+			e.ProcessingChapters++
+			continue
+		}
+		prices = append(prices, s.pointsService.PriceTrim(prompt.ID, metas[chap.ChapterMD5].WordsCount))
+	}
+
+	var memory *memoryContext
+	if s.MemoryEnabled() {
+		memory = &memoryContext{Encyclopedia: "（无）", SummaryPromptContent: defaultSummaryPromptContent}
+	}
+	systemTokens := EstimateTokens(s.buildSystemPrompt(prompt, "", memory).systemPrompt)
+	outputRatio := (prompt.TargetRatioMin + prompt.TargetRatioMax) / 2
+
+	maxChars := 0
+	for _, chap := range chapters {
+		if _, ok := processing[chap.ID]; ok {
+			continue
+		}
+		if _, ok := cached[chap.ChapterMD5]; ok {
+			e.CachedChapters++
+			continue
+		}
+		chars := metas[chap.ChapterMD5].WordsCount
+		e.InputChars += chars
+		maxChars = max(maxChars, chars)
+		inputChars, outputChars := chars, int(float64(chars)*outputRatio)
+		if memory != nil {
+			inputChars += memoryInputCharsPerSummary*s.summaryLimit() + memoryEncyclopediaChars
+			outputChars += memoryOutputChars
+		}
+		e.InputTokens += systemTokens + estimateCharsTokens(inputChars)
+		e.OutputTokens += estimateCharsTokens(outputChars)
+	}
+
+	// 按最长章节选择供应商，与实际调用时的路由一致
+	if e.InputTokens > 0 {
+		e.Pricing = s.llmService.Pricing(prompt.ID, maxChars)
+	}
+	if e.Pricing != nil {
+		e.Cost = (float64(e.InputTokens)*e.Pricing.InputMTokenPrice + float64(e.OutputTokens)*e.Pricing.OutputMTokenPrice) / million
+	}
+	return e, prices
+}
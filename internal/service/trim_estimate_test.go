@@ -0,0 +1,55 @@
+package service
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/zqr233qr/story-trim/internal/config"
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/templates"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"chinese", "天下大势分久必合合久必分", 8}, // 12 字 * 0.6 = 7.2
+		{"english", "hello world", 3},  // 10 个字母 / 4 = 2.5
+		{"punctuation", "你好，世界。", 5},   // 4 字 * 0.6 + 2 个全角标点
+		{"mixed", "第1章 Hello", 3},      // 2 字 * 0.6 + 6 个 ASCII / 4
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateTokens(tt.text); got != tt.want {
+				t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimatePromptSkipsOwnedAndProcessing(t *testing.T) {
+	s := &TrimService{
+		pointsService: NewPointsService(nil, &config.PointsConfig{}),
+		tmpl:          template.Must(template.ParseFS(templates.FS, trimTemplate, trimAndSummaryTemplate)),
+	}
+	chapters := []model.Chapter{
+		{ID: 1, ChapterMD5: "owned"},
+		{ID: 2, ChapterMD5: "processing"},
+		{ID: 3, ChapterMD5: "c"},
+		{ID: 4, ChapterMD5: "d"},
+	}
+	cached := map[string]*model.TrimResult{"owned": {}, "processing": {}, "c": {}, "d": {}}
+	owned := map[string]struct{}{"owned": {}}
+	processing := map[uint]struct{}{2: {}}
+
+	e, prices := s.estimatePrompt(&model.Prompt{ID: 1}, chapters, nil, cached, owned, processing)
+	if e.OwnedChapters != 1 || e.ProcessingChapters != 1 || e.CachedChapters != 3 {
+		t.Fatalf("estimate = %+v", e)
+	}
+	if len(prices) != 2 {
+		t.Fatalf("billable chapters = %d, want 2", len(prices))
+	}
+}
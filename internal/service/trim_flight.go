@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/model"
+	"github.com/zqr233qr/story-trim/internal/repository"
+	"github.com/zqr233qr/story-trim/pkg/logger"
+)
+
+const (
+	trimPartialFlushBytes    = 512
+	trimPartialFlushInterval = 300 * time.Millisecond
+)
+
+// trimFlight 一次进行中的章节精简。同一章节与提示词的并发请求共享同一次生成：
+// 后加入的请求先收到已生成的文本，再接收后续的实时增量。
+// 生成与客户端连接解绑，客户端断开后仍会完成并落库；输出先由 trimPartialWriter 持久化再发布，
+// 服务重启后新的生成从已持久化的文本续写，重连的客户端按字符偏移继续接收。
+type trimFlight struct {
+	mu      sync.Mutex
+	deltas  []string
+	notify  chan struct{} // 有新增量或结束时关闭并替换
+	payers  map[uint]*trimCharge
+	ended   bool
+	err     error
+	saved   *model.TrimResult // 成功完成时落库的精简结果
+	started chan struct{}     // 生成已建立（或建立失败）时关闭
+	done    chan struct{}     // 生成结束且结果已落库时关闭
+	cancel  context.CancelFunc
+}
+
+// trimFlightGroup 按章节 MD5 与提示词管理进行中的精简。
+type trimFlightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*trimFlight
+}
+
+func newTrimFlightGroup() *trimFlightGroup {
+	return &trimFlightGroup{flights: make(map[string]*trimFlight)}
+}
+
+func trimFlightKey(chapterMD5 string, promptID uint) string {
+	return fmt.Sprintf("%s:%d", chapterMD5, promptID)
+}
+
+// join 加入进行中的精简，没有时创建并返回 leader=true，由调用方负责生成。
+// 生成使用独立于请求的 context，不随客户端断开而取消。
+func (g *trimFlightGroup) join(ctx context.Context, key string) (f *trimFlight, genCtx context.Context, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		return f, nil, false
+	}
+	genCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	f = &trimFlight{
+		notify:  make(chan struct{}),
+		payers:  make(map[uint]*trimCharge),
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+		cancel:  cancel,
+	}
+	g.flights[key] = f
+	return f, genCtx, true
+}
+
+// paidBy 判断用户是否已为进行中的精简付费，断线重连时不再重复扣费。
+func (g *trimFlightGroup) paidBy(key string, userID uint) bool {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	g.mu.Unlock()
+	if !ok {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, paid := f.payers[userID]
+	return paid
+}
+
+// trimCharge 一次精简占用的订阅额度或积分预占，生成成功时结算，失败时释放。
+type trimCharge struct {
+	userID  uint
+	holdID  uint // 积分预占，占用订阅额度时为 0
+	usageID uint // 订阅额度使用记录，按积分计费时为 0
+	refType string
+	refID   string
+}
+
+// addPayer 记录为本次精简付费的用户。精简已结束时返回 false，由调用方自行结算或退款。
+func (f *trimFlight) addPayer(charge *trimCharge) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ended {
+		return false
+	}
+	f.payers[charge.userID] = charge
+	return true
+}
+
+// finish 结束精简并移出分组，之后的请求将直接命中缓存或重新生成。
+// 返回所有付费用户的预占，由调用方按结果结算或释放。
+func (g *trimFlightGroup) finish(key string, f *trimFlight, err error) []*trimCharge {
+	g.mu.Lock()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+	g.mu.Unlock()
+
+	f.markStarted()
+	f.mu.Lock()
+	f.err = err
+	f.ended = true
+	close(f.notify)
+	charges := make([]*trimCharge, 0, len(f.payers))
+	for _, charge := range f.payers {
+		charges = append(charges, charge)
+	}
+	f.mu.Unlock()
+	close(f.done)
+	f.cancel()
+	return charges
+}
+
+// markStarted 标记生成已建立，等待中的跟随者可以开始订阅。可重复调用。
+func (f *trimFlight) markStarted() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-f.started:
+	default:
+		close(f.started)
+	}
+}
+
+// waitStarted 等待生成建立，返回建立阶段的错误。
+func (f *trimFlight) waitStarted(ctx context.Context) error {
+	select {
+	case <-f.started:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-f.done:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if len(f.deltas) == 0 {
+			return f.err
+		}
+	default:
+	}
+	return nil
+}
+
+// publish 追加一段推送给客户端的文本。
+func (f *trimFlight) publish(delta string) {
+	if delta == "" {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deltas = append(f.deltas, delta)
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// subscribe 订阅精简输出：先一次性收到已生成的文本，再接收实时增量，生成结束后关闭。
+// resumeFrom 为客户端已收到的字符数，断线重连时从该位置继续。ctx 结束时只退订，不影响生成。
+func (f *trimFlight) subscribe(ctx context.Context, resumeFrom int) <-chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		next := 0
+		skip := max(resumeFrom, 0)
+		for {
+			f.mu.Lock()
+			pending := f.deltas[next:]
+			notify := f.notify
+			f.mu.Unlock()
+
+			if len(pending) > 0 {
+				// 首次发送时合并已生成的文本
+				if next > 0 {
+					pending = pending[:1]
+				}
+				next += len(pending)
+				text := strings.Join(pending, "")
+				if skip > 0 {
+					runes := []rune(text)
+					n := min(skip, len(runes))
+					text, skip = string(runes[n:]), skip-n
+				}
+				if text == "" {
+					continue
+				}
+				select {
+				case ch <- text:
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-f.done:
+				// 结束前可能还有最后一批增量未读取
+				f.mu.Lock()
+				remaining := len(f.deltas) - next
+				f.mu.Unlock()
+				if remaining == 0 {
+					return
+				}
+			case <-notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// result 返回订阅结束的原因：生成的结果，或订阅方 ctx 的错误。订阅通道关闭后调用。
+func (f *trimFlight) result(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+		return ctx.Err()
+	}
+}
+
+// setResult 记录落库的精简结果，需在 finish 之前调用。
+func (f *trimFlight) setResult(result *model.TrimResult) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.saved = result
+}
+
+// trimResult 返回成功完成时的精简结果。
+func (f *trimFlight) trimResult() *model.TrimResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.saved
+}
+
+// wait 等待生成结束并返回结果。
+func (f *trimFlight) wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// trimPartialWriter 按批持久化模型输出，落库后再发布给客户端，
+// 保证客户端收到的文本在服务重启后都能恢复。
+type trimPartialWriter struct {
+	repo       repository.BookRepositoryInterface
+	flight     *trimFlight
+	chapterMD5 string
+	promptID   uint
+	seq        int
+	raw        strings.Builder // 待持久化的模型原始输出
+	published  strings.Builder // 待发布给客户端的文本
+	flushedAt  time.Time
+}
+
+// write 缓冲一段模型输出及其对应的客户端文本，达到批量大小或间隔时写入。
+func (w *trimPartialWriter) write(raw string, published string) {
+	w.raw.WriteString(raw)
+	w.published.WriteString(published)
+	if w.raw.Len() >= trimPartialFlushBytes || time.Since(w.flushedAt) >= trimPartialFlushInterval {
+		w.flush()
+	}
+}
+
+// flush 持久化缓冲的输出并发布。写库失败时仍发布，原始输出保留到下次重试。
+func (w *trimPartialWriter) flush() {
+	w.flushedAt = time.Now()
+	if w.raw.Len() > 0 {
+		err := w.repo.AppendTrimPartial(context.Background(), &model.TrimPartial{
+			ChapterMD5: w.chapterMD5,
+			PromptID:   w.promptID,
+			Seq:        w.seq,
+			Content:    w.raw.String(),
+		})
+		if err != nil {
+			logger.Warn().Err(err).Str("chapter_md5", w.chapterMD5).Msg("failed to save trim partial")
+		} else {
+			w.seq++
+			w.raw.Reset()
+		}
+	}
+	w.flight.publish(w.published.String())
+	w.published.Reset()
+}
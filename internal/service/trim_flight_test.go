@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zqr233qr/story-trim/internal/repository"
+)
+
+func collectStream(ch <-chan string) string {
+	var sb strings.Builder
+	for text := range ch {
+		sb.WriteString(text)
+	}
+	return sb.String()
+}
+
+func TestTrimFlight_FollowerReplaysThenStreams(t *testing.T) {
+	g := newTrimFlightGroup()
+	ctx := context.Background()
+	key := trimFlightKey("md5", 1)
+
+	leader, _, isLeader := g.join(ctx, key)
+	if !isLeader {
+		t.Fatal("first join should lead")
+	}
+	leader.markStarted()
+	first := leader.subscribe(ctx, 0)
+	leader.publish("第一段")
+	leader.publish("第二段")
+
+	follower, _, isLeader := g.join(ctx, key)
+	if isLeader || follower != leader {
+		t.Fatal("second join should attach to the running flight")
+	}
+	if err := follower.waitStarted(ctx); err != nil {
+		t.Fatal(err)
+	}
+	second := follower.subscribe(ctx, 0)
+	if got := <-second; got != "第一段第二段" {
+		t.Fatalf("replay = %q", got)
+	}
+
+	done := make(chan string)
+	go func() { done <- collectStream(second) }()
+	leader.publish("第三段")
+	g.finish(key, leader, nil)
+
+	if got := collectStream(first); got != "第一段第二段第三段" {
+		t.Errorf("leader stream = %q", got)
+	}
+	if got := <-done; got != "第三段" {
+		t.Errorf("follower live = %q", got)
+	}
+	if _, _, isLeader := g.join(ctx, key); !isLeader {
+		t.Error("finished flight should be removed from the group")
+	}
+}
+
+func TestTrimFlight_StartError(t *testing.T) {
+	g := newTrimFlightGroup()
+	ctx := context.Background()
+	key := trimFlightKey("md5", 1)
+
+	leader, _, _ := g.join(ctx, key)
+	follower, _, _ := g.join(ctx, key)
+	want := errors.New("llm down")
+	g.finish(key, leader, want)
+	if err := follower.waitStarted(ctx); !errors.Is(err, want) {
+		t.Errorf("waitStarted = %v, want %v", err, want)
+	}
+}
+
+func TestTrimFlight_ContinuesAfterSubscribersLeave(t *testing.T) {
+	g := newTrimFlightGroup()
+	key := trimFlightKey("md5", 1)
+	flight, genCtx, _ := g.join(context.Background(), key)
+	flight.markStarted()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := flight.subscribe(ctx, 0)
+	flight.publish("第一段")
+	<-ch
+	cancel()
+	collectStream(ch)
+	if genCtx.Err() != nil {
+		t.Fatal("generation should not depend on the client connection")
+	}
+
+	// 重连时从已收到的位置继续
+	flight.publish("第二段")
+	resumed := flight.subscribe(context.Background(), 4)
+	g.finish(key, flight, nil)
+	if got := collectStream(resumed); got != "二段" {
+		t.Errorf("resumed = %q, want %q", got, "二段")
+	}
+}
+
+func TestTrimFlight_PaidBy(t *testing.T) {
+	g := newTrimFlightGroup()
+	key := trimFlightKey("md5", 1)
+	flight, _, _ := g.join(context.Background(), key)
+	flight.addPayer(&trimCharge{userID: 7})
+	if !g.paidBy(key, 7) || g.paidBy(key, 8) {
+		t.Error("paidBy should only report users who paid for the running flight")
+	}
+	g.finish(key, flight, nil)
+	if g.paidBy(key, 7) {
+		t.Error("finished flights should not be consulted")
+	}
+}
+
+func TestTrimFlight_ReturnsPayersOnFinish(t *testing.T) {
+	g := newTrimFlightGroup()
+	key := trimFlightKey("md5", 1)
+	flight, _, _ := g.join(context.Background(), key)
+	flight.addPayer(&trimCharge{userID: 1, holdID: 11})
+	flight.addPayer(&trimCharge{userID: 2, holdID: 12})
+
+	charges := g.finish(key, flight, errors.New("stream interrupted"))
+	if len(charges) != 2 {
+		t.Fatalf("charges = %d, want 2", len(charges))
+	}
+	if flight.addPayer(&trimCharge{userID: 3}) {
+		t.Error("finished flights should not accept new payers")
+	}
+
+	ok, _, _ := g.join(context.Background(), key)
+	ok.addPayer(&trimCharge{userID: 1, holdID: 13})
+	if charges := g.finish(key, ok, nil); len(charges) != 1 || charges[0].holdID != 13 {
+		t.Errorf("successful flights should return payers for settlement, got %v", charges)
+	}
+}
+
+func TestMockStreaming_InstantResume(t *testing.T) {
+	s := &TrimService{}
+	ch := s.mockStreaming(context.Background(), "一二三四五六七八九十甲乙", TrimStreamOptions{ResumeFrom: 3, Instant: true})
+	var chunks []string
+	for text := range ch {
+		chunks = append(chunks, text)
+	}
+	if len(chunks) != 1 || chunks[0] != "四五六七八九十甲乙" {
+		t.Fatalf("chunks = %q", chunks)
+	}
+}
+
+func TestTrimPartialWriter_PersistsBeforePublish(t *testing.T) {
+	repo := repository.NewBookRepository(newTestDB(t), &memStorage{objects: make(map[string][]byte)})
+	ctx := context.Background()
+
+	flight, _, _ := newTrimFlightGroup().join(ctx, trimFlightKey("md5", 1))
+	w := &trimPartialWriter{repo: repo, flight: flight, chapterMD5: "md5", promptID: 1, flushedAt: time.Now()}
+	w.write("<content>第一段", "第一段")
+	if len(flight.deltas) != 0 {
+		t.Fatal("text should not be published before it is persisted")
+	}
+	w.flush()
+	if partial, seq, _ := repo.GetTrimPartial(ctx, "md5", 1); partial != "<content>第一段" || seq != 1 || len(flight.deltas) != 1 {
+		t.Fatalf("partial = %q, seq = %d, deltas = %v", partial, seq, flight.deltas)
+	}
+
+	// 重启后的生成从下一个序号继续追加
+	w = &trimPartialWriter{repo: repo, flight: flight, chapterMD5: "md5", promptID: 1, seq: 1, flushedAt: time.Now()}
+	w.write("第二段", "第二段")
+	w.flush()
+	if partial, seq, _ := repo.GetTrimPartial(ctx, "md5", 1); partial != "<content>第一段第二段" || seq != 2 {
+		t.Fatalf("partial = %q, seq = %d", partial, seq)
+	}
+
+	if err := repo.DeleteTrimPartials(ctx, "md5", 1); err != nil {
+		t.Fatal(err)
+	}
+	if partial, seq, _ := repo.GetTrimPartial(ctx, "md5", 1); partial != "" || seq != 0 {
+		t.Fatalf("partial = %q, seq = %d after delete", partial, seq)
+	}
+}
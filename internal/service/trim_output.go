@@ -0,0 +1,148 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/zqr233qr/story-trim/internal/errno"
+)
+
+const (
+	contentOpenTag  = "<content>"
+	contentCloseTag = "</content>"
+	summaryOpenTag  = "<summary>"
+	summaryCloseTag = "</summary>"
+)
+
+const (
+	tagStateDetect  = iota // 等待判断输出是否带标签
+	tagStateContent        // 位于 <content> 内
+	tagStateTail           // </content> 之后，不再向客户端输出
+	tagStatePlain          // 模型未按标签格式输出，原样透传
+)
+
+// taggedStreamParser 从精简+摘要模式的流式输出中提取 <content> 内的正文，
+// 标签与摘要部分不会推送给客户端。
+type taggedStreamParser struct {
+	state   int
+	pending strings.Builder
+	started bool // 是否已输出过正文（用于去掉正文开头的空白）
+}
+
+// Feed 写入一段模型输出，返回可以推送给客户端的正文片段。
+func (p *taggedStreamParser) Feed(delta string) string {
+	if p.state == tagStateTail {
+		return ""
+	}
+	p.pending.WriteString(delta)
+	buf := p.pending.String()
+
+	if p.state == tagStateDetect {
+		trimmed := strings.TrimLeftFunc(buf, unicode.IsSpace)
+		if idx := strings.Index(trimmed, contentOpenTag); idx >= 0 {
+			p.state = tagStateContent
+			buf = trimmed[idx+len(contentOpenTag):]
+		} else if len(trimmed) < len(contentOpenTag) && strings.HasPrefix(contentOpenTag, trimmed) {
+			return ""
+		} else {
+			p.state = tagStatePlain
+		}
+	}
+
+	if p.state == tagStatePlain {
+		p.pending.Reset()
+		return p.emit(buf)
+	}
+
+	if idx := strings.Index(buf, contentCloseTag); idx >= 0 {
+		p.state = tagStateTail
+		p.pending.Reset()
+		return p.emit(strings.TrimRightFunc(buf[:idx], unicode.IsSpace))
+	}
+
+	// 保留可能是结束标签前缀的尾部以及尾部空白，等待后续输出确认。
+	cut := len(buf) - partialSuffixLen(buf, contentCloseTag)
+	cut = len(strings.TrimRightFunc(buf[:cut], unicode.IsSpace))
+	p.pending.Reset()
+	p.pending.WriteString(buf[cut:])
+	return p.emit(buf[:cut])
+}
+
+// Flush 在流结束时输出剩余的正文。
+func (p *taggedStreamParser) Flush() string {
+	if p.state == tagStateTail || p.state == tagStateDetect {
+		return ""
+	}
+	buf := p.pending.String()
+	p.pending.Reset()
+	return p.emit(strings.TrimRightFunc(buf, unicode.IsSpace))
+}
+
+func (p *taggedStreamParser) emit(text string) string {
+	if !p.started {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if text == "" {
+			return ""
+		}
+		p.started = true
+	}
+	return text
+}
+
+// partialSuffixLen 返回 s 的尾部与 tag 前缀重合的最大长度。
+func partialSuffixLen(s, tag string) int {
+	max := len(tag) - 1
+	if max > len(s) {
+		max = len(s)
+	}
+	for n := max; n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// parseTrimOutput 解析精简+摘要模式的完整输出。
+// 未按标签格式输出时整体视为正文；正文缺少结束标签时取到末尾。
+func parseTrimOutput(output string) (content string, summary string) {
+	content = extractTag(output, contentOpenTag, contentCloseTag)
+	if content == "" && !strings.Contains(output, contentOpenTag) {
+		content = strings.TrimSpace(output)
+	}
+	// 摘要不完整时丢弃，避免把截断的摘要注入后续章节。
+	if strings.Contains(output, summaryCloseTag) {
+		summary = extractTag(output, summaryOpenTag, summaryCloseTag)
+	}
+	return content, summary
+}
+
+func extractTag(s, open, close string) string {
+	start := strings.Index(s, open)
+	if start < 0 {
+		return ""
+	}
+	s = s[start+len(open):]
+	if end := strings.Index(s, close); end >= 0 {
+		s = s[:end]
+	}
+	return strings.TrimSpace(s)
+}
+
+// checkFinish 根据流的结束方式判断输出是否完整，不完整的输出不缓存也不计费。
+// streamErr 为 Recv 返回的错误，io.EOF 表示正常结束。
+func checkFinish(streamErr error, finishReason openai.FinishReason) error {
+	if streamErr != nil && !errors.Is(streamErr, io.EOF) {
+		return errno.ErrTrimIncomplete
+	}
+	switch finishReason {
+	case openai.FinishReasonLength:
+		return errno.ErrTrimTruncated
+	case openai.FinishReasonContentFilter:
+		return errno.ErrTrimFiltered
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/zqr233qr/story-trim/internal/errno"
+)
+
+func feedByRune(p *taggedStreamParser, output string) string {
+	var streamed string
+	for _, r := range output {
+		streamed += p.Feed(string(r))
+	}
+	return streamed + p.Flush()
+}
+
+func TestTaggedStreamParserStripsTags(t *testing.T) {
+	output := "<content>\n他推门而入。\n\n屋里没人。\n</content>\n<summary>\n主角回到家中。\n</summary>"
+	streamed := feedByRune(&taggedStreamParser{}, output)
+	if streamed != "他推门而入。\n\n屋里没人。" {
+		t.Errorf("Unexpected streamed content: %q", streamed)
+	}
+
+	content, summary := parseTrimOutput(output)
+	if content != streamed {
+		t.Errorf("Expected parsed content to match streamed content, got %q", content)
+	}
+	if summary != "主角回到家中。" {
+		t.Errorf("Unexpected summary: %q", summary)
+	}
+}
+
+func TestTaggedStreamParserPlainOutput(t *testing.T) {
+	output := "他推门而入。<b>屋里没人。"
+	streamed := feedByRune(&taggedStreamParser{}, output)
+	if streamed != output {
+		t.Errorf("Expected plain output to pass through, got %q", streamed)
+	}
+
+	content, summary := parseTrimOutput(output)
+	if content != output || summary != "" {
+		t.Errorf("Unexpected parse result: %q, %q", content, summary)
+	}
+}
+
+func TestCheckFinish(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		reason openai.FinishReason
+		want   error
+	}{
+		{"正常结束", io.EOF, openai.FinishReasonStop, nil},
+		{"未返回结束原因", io.EOF, "", nil},
+		{"中途断开", errors.New("connection reset"), "", errno.ErrTrimIncomplete},
+		{"超出长度", io.EOF, openai.FinishReasonLength, errno.ErrTrimTruncated},
+		{"内容拦截", io.EOF, openai.FinishReasonContentFilter, errno.ErrTrimFiltered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkFinish(tt.err, tt.reason); got != tt.want {
+				t.Errorf("checkFinish() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}